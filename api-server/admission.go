@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	v1 "example.com/m/api/v1"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultWebhookTimeout = 10 * time.Second
+
+// admissionConfig is loaded from the file named by -admission-config:
+//
+//	defaults:
+//	  podCPU: 1
+//	limitRanges:
+//	  - namespace: prod          # omit to apply to every namespace
+//	    defaultCPU: 1
+//	    minCPU: 1
+//	    maxCPU: 4
+//	resourceQuotas:
+//	  - namespace: prod
+//	    pods: 20
+//	    cpu: 40
+//	requiredLabels:
+//	  Pod: [team]
+//	  Deployment: [team]
+//	webhooks:
+//	  - name: policy.example.com
+//	    url: https://policy.example.com/admit
+//	    type: Validating         # or Mutating
+//	    kinds: [Pod, Deployment]
+//	    operations: [CREATE]
+//	    timeoutSeconds: 5
+//	    failurePolicy: Fail      # or Ignore
+//	    caFile: /etc/kube-sim/policy-ca.pem
+type admissionConfig struct {
+	Defaults struct {
+		PodCPU int `yaml:"podCPU"`
+	} `yaml:"defaults"`
+	LimitRanges    []limitRange        `yaml:"limitRanges"`
+	ResourceQuotas []resourceQuota     `yaml:"resourceQuotas"`
+	RequiredLabels map[string][]string `yaml:"requiredLabels"`
+	Webhooks       []webhookConfig     `yaml:"webhooks"`
+}
+
+// limitRange bounds the CPU of each pod in a namespace and supplies a
+// default for pods that do not ask for any.
+type limitRange struct {
+	Namespace  string `yaml:"namespace"`
+	DefaultCPU int    `yaml:"defaultCPU"`
+	MinCPU     int    `yaml:"minCPU"`
+	MaxCPU     int    `yaml:"maxCPU"`
+}
+
+// resourceQuota caps the number of pods and their total CPU in a namespace.
+// Zero means no limit.
+type resourceQuota struct {
+	Namespace string `yaml:"namespace"`
+	Pods      int    `yaml:"pods"`
+	CPU       int    `yaml:"cpu"`
+}
+
+type webhookConfig struct {
+	Name           string   `yaml:"name"`
+	URL            string   `yaml:"url"`
+	Type           string   `yaml:"type"`
+	Kinds          []string `yaml:"kinds"`
+	Operations     []string `yaml:"operations"`
+	TimeoutSeconds int      `yaml:"timeoutSeconds"`
+	FailurePolicy  string   `yaml:"failurePolicy"`
+	CAFile         string   `yaml:"caFile"`
+
+	client *http.Client
+}
+
+// admissionRequest is one write passing through the chain. Plugins may change
+// Object; OldObject is nil on create.
+type admissionRequest struct {
+	Operation string
+	Kind      string
+	Object    v1.Object
+	OldObject v1.Object
+}
+
+// admissionPlugin inspects, and when mutating may change, a request.
+type admissionPlugin struct {
+	name  string
+	admit func(req *admissionRequest) error
+}
+
+// admissionError is a request rejected by a plugin.
+type admissionError struct {
+	plugin  string
+	code    int
+	reason  v1.StatusReason
+	message string
+}
+
+func (e *admissionError) Error() string {
+	return fmt.Sprintf("admission plugin %s denied the request: %s", e.plugin, e.message)
+}
+
+func denied(plugin string, code int, format string, args ...interface{}) error {
+	return &admissionError{plugin: plugin, code: code, reason: v1.ReasonForCode(code), message: fmt.Sprintf(format, args...)}
+}
+
+var (
+	admission admissionConfig
+	// Defaults are applied first, then mutating plugins run in order, so
+	// validating plugins see the object that will be stored.
+	mutatingPlugins   []admissionPlugin
+	validatingPlugins []admissionPlugin
+)
+
+// loadAdmissionConfig reads path, when set, and builds the plugin chain. The
+// built-in plugins run even without a config; they just have nothing to
+// enforce.
+func loadAdmissionConfig(path string) error {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(data, &admission); err != nil {
+			return fmt.Errorf("parsing %s: %v", path, err)
+		}
+	}
+
+	mutatingPlugins = nil
+	validatingPlugins = []admissionPlugin{
+		{"LimitRanger", admitLimitRanges},
+		{"RequiredLabels", admitRequiredLabels},
+		{"ResourceQuota", admitResourceQuota},
+	}
+	for i := range admission.Webhooks {
+		wh := &admission.Webhooks[i]
+		if wh.Name == "" || wh.URL == "" {
+			return fmt.Errorf("webhook %d: name and url are required", i)
+		}
+		wh.client = &http.Client{Timeout: defaultWebhookTimeout}
+		if wh.TimeoutSeconds > 0 {
+			wh.client.Timeout = time.Duration(wh.TimeoutSeconds) * time.Second
+		}
+		if wh.CAFile != "" {
+			pem, err := os.ReadFile(wh.CAFile)
+			if err != nil {
+				return fmt.Errorf("webhook %s: %v", wh.Name, err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("webhook %s: no certificates in %s", wh.Name, wh.CAFile)
+			}
+			wh.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+		}
+
+		plugin := admissionPlugin{name: wh.Name, admit: wh.admit}
+		switch wh.Type {
+		case "Mutating":
+			mutatingPlugins = append(mutatingPlugins, plugin)
+		case "Validating", "":
+			validatingPlugins = append(validatingPlugins, plugin)
+		default:
+			return fmt.Errorf("webhook %s: type must be Mutating or Validating", wh.Name)
+		}
+	}
+	if path != "" {
+		log.Printf("Admission: %d limit ranges, %d quotas, %d webhooks loaded from %s",
+			len(admission.LimitRanges), len(admission.ResourceQuotas), len(admission.Webhooks), path)
+	}
+	return nil
+}
+
+// admit runs obj through the admission chain. obj is changed in place by
+// mutating plugins; the first rejection is returned as an *admissionError.
+// admit must not be called with a store lock held, since webhooks may call
+// back into the server.
+func admit(operation string, obj, old v1.Object) error {
+	req := &admissionRequest{Operation: operation, Kind: objectKind(obj), Object: obj, OldObject: old}
+	admitDefaults(req)
+
+	meta := obj.GetObjectMeta()
+	name, namespace := meta.Name, meta.Namespace
+	for _, p := range mutatingPlugins {
+		if err := p.admit(req); err != nil {
+			return err
+		}
+		if meta.Name != name || meta.Namespace != namespace {
+			return denied(p.name, http.StatusUnprocessableEntity, "mutating plugins may not change metadata.name or metadata.namespace")
+		}
+	}
+	for _, p := range validatingPlugins {
+		if err := p.admit(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func objectKind(obj v1.Object) string {
+	switch obj.(type) {
+	case *v1.Node:
+		return "Node"
+	case *v1.Pod:
+		return "Pod"
+	case *v1.Deployment:
+		return "Deployment"
+	}
+	return ""
+}
+
+// podSpecOf returns the pod spec a request creates pods from: the pod's own,
+// or a deployment's template.
+func podSpecOf(obj v1.Object) *v1.PodSpec {
+	switch o := obj.(type) {
+	case *v1.Pod:
+		return &o.Spec
+	case *v1.Deployment:
+		return &o.Spec.Template.Spec
+	}
+	return nil
+}
+
+// admitDefaults fills in the namespace and, for pods and deployment
+// templates without a CPU request, the default of the namespace's limit
+// range or else the configured default.
+func admitDefaults(req *admissionRequest) {
+	meta := req.Object.GetObjectMeta()
+	if req.Kind != "Node" && meta.Namespace == "" {
+		meta.Namespace = defaultNamespace
+	}
+	spec := podSpecOf(req.Object)
+	if spec == nil || spec.CPURequired != 0 {
+		return
+	}
+	for _, lr := range limitRangesFor(meta.Namespace) {
+		if lr.DefaultCPU > 0 {
+			spec.CPURequired = lr.DefaultCPU
+			return
+		}
+	}
+	spec.CPURequired = admission.Defaults.PodCPU
+}
+
+// limitRangesFor returns the limit ranges that apply in namespace.
+func limitRangesFor(namespace string) []limitRange {
+	var ranges []limitRange
+	for _, lr := range admission.LimitRanges {
+		if lr.Namespace == "" || lr.Namespace == namespace {
+			ranges = append(ranges, lr)
+		}
+	}
+	return ranges
+}
+
+func admitLimitRanges(req *admissionRequest) error {
+	spec := podSpecOf(req.Object)
+	if spec == nil {
+		return nil
+	}
+	if spec.CPURequired <= 0 {
+		return denied("LimitRanger", http.StatusUnprocessableEntity, "cpuRequired must be positive")
+	}
+	namespace := req.Object.GetObjectMeta().Namespace
+	for _, lr := range limitRangesFor(namespace) {
+		if lr.MinCPU > 0 && spec.CPURequired < lr.MinCPU {
+			return denied("LimitRanger", http.StatusForbidden, "cpuRequired %d is below the minimum of %d per pod in namespace %s", spec.CPURequired, lr.MinCPU, namespace)
+		}
+		if lr.MaxCPU > 0 && spec.CPURequired > lr.MaxCPU {
+			return denied("LimitRanger", http.StatusForbidden, "cpuRequired %d exceeds the maximum of %d per pod in namespace %s", spec.CPURequired, lr.MaxCPU, namespace)
+		}
+	}
+	return nil
+}
+
+// admitRequiredLabels enforces requiredLabels. A deployment must also stamp
+// the labels required of pods onto its template, or none of its pods could
+// be created.
+func admitRequiredLabels(req *admissionRequest) error {
+	check := func(kind string, labels map[string]string, what string) error {
+		var missing []string
+		for _, key := range admission.RequiredLabels[kind] {
+			if _, ok := labels[key]; !ok {
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) > 0 {
+			return denied("RequiredLabels", http.StatusForbidden, "%s is missing required labels: %s", what, strings.Join(missing, ", "))
+		}
+		return nil
+	}
+	if err := check(req.Kind, req.Object.GetObjectMeta().Labels, strings.ToLower(req.Kind)); err != nil {
+		return err
+	}
+	if d, ok := req.Object.(*v1.Deployment); ok {
+		return check("Pod", d.Spec.Template.Metadata.Labels, "pod template")
+	}
+	return nil
+}
+
+// admitResourceQuota checks a new pod, or a pod asking for more CPU, against
+// its namespace's quota. Usage is read at admission time, so two pods
+// admitted concurrently may together overshoot by one pod's worth.
+func admitResourceQuota(req *admissionRequest) error {
+	pod, ok := req.Object.(*v1.Pod)
+	if !ok {
+		return nil
+	}
+	namespace := pod.Metadata.Namespace
+	for _, q := range admission.ResourceQuotas {
+		if q.Namespace != namespace {
+			continue
+		}
+		podCount, cpu := namespaceUsage(namespace)
+		addPods, addCPU := 1, pod.Spec.CPURequired
+		if old, ok := req.OldObject.(*v1.Pod); ok {
+			addPods, addCPU = 0, pod.Spec.CPURequired-old.Spec.CPURequired
+		}
+		if q.Pods > 0 && podCount+addPods > q.Pods {
+			return denied("ResourceQuota", http.StatusForbidden, "exceeded quota in namespace %s: %d of %d pods in use", namespace, podCount, q.Pods)
+		}
+		if q.CPU > 0 && addCPU > 0 && cpu+addCPU > q.CPU {
+			return denied("ResourceQuota", http.StatusForbidden, "exceeded quota in namespace %s: requested %d CPU, %d of %d in use", namespace, addCPU, cpu, q.CPU)
+		}
+	}
+	return nil
+}
+
+func namespaceUsage(namespace string) (podCount, cpu int) {
+	podsMu.Lock()
+	defer podsMu.Unlock()
+	for _, pod := range pods {
+		if pod.Namespace == namespace {
+			podCount++
+			cpu += pod.CPURequired
+		}
+	}
+	return podCount, cpu
+}
+
+// admit sends the request to the webhook. A mutating webhook's patch is
+// applied to the object; a webhook that cannot be reached or answers
+// nonsense fails the request unless its failurePolicy is Ignore.
+func (wh *webhookConfig) admit(req *admissionRequest) error {
+	if len(wh.Kinds) > 0 && !slices.Contains(wh.Kinds, req.Kind) {
+		return nil
+	}
+	if len(wh.Operations) > 0 && !slices.Contains(wh.Operations, req.Operation) {
+		return nil
+	}
+
+	resp, err := wh.call(req)
+	if err != nil {
+		if wh.FailurePolicy == "Ignore" {
+			log.Printf("Admission webhook %s failed, ignoring: %v", wh.Name, err)
+			return nil
+		}
+		return denied(wh.Name, http.StatusInternalServerError, "failed calling webhook: %v", err)
+	}
+	if !resp.Allowed {
+		code, message := http.StatusForbidden, "denied by webhook"
+		if resp.Status != nil {
+			if resp.Status.Code != 0 {
+				code = resp.Status.Code
+			}
+			if resp.Status.Message != "" {
+				message = resp.Status.Message
+			}
+		}
+		return denied(wh.Name, code, "%s", message)
+	}
+	if len(resp.Patch) == 0 {
+		return nil
+	}
+	if wh.Type != "Mutating" {
+		return denied(wh.Name, http.StatusInternalServerError, "validating webhook returned a patch")
+	}
+	return applyAdmissionPatch(req.Object, resp.Patch, wh.Name)
+}
+
+func (wh *webhookConfig) call(req *admissionRequest) (*v1.AdmissionResponse, error) {
+	object, err := json.Marshal(req.Object)
+	if err != nil {
+		return nil, err
+	}
+	meta := req.Object.GetObjectMeta()
+	review := v1.AdmissionReview{
+		TypeMeta: typeMeta("AdmissionReview"),
+		Request: &v1.AdmissionRequest{
+			UID:       uuid.New().String(),
+			Kind:      req.Kind,
+			Namespace: meta.Namespace,
+			Name:      meta.Name,
+			Operation: req.Operation,
+			Object:    object,
+		},
+	}
+	if req.OldObject != nil {
+		if review.Request.OldObject, err = json.Marshal(req.OldObject); err != nil {
+			return nil, err
+		}
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), wh.client.Timeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpResp, err := wh.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("webhook answered %s", httpResp.Status)
+	}
+
+	var answer v1.AdmissionReview
+	if err := json.NewDecoder(httpResp.Body).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("decoding response: %v", err)
+	}
+	if answer.Response == nil || answer.Response.UID != review.Request.UID {
+		return nil, errors.New("response does not answer the request")
+	}
+	return answer.Response, nil
+}
+
+// applyAdmissionPatch applies a webhook's JSON patch to obj in place.
+func applyAdmissionPatch(obj v1.Object, patch json.RawMessage, plugin string) error {
+	doc, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	patched, err := applyJSONPatch(doc, patch)
+	if err != nil {
+		return denied(plugin, http.StatusInternalServerError, "%v", err)
+	}
+	// Decode into a zeroed object so fields the patch removed are gone.
+	switch o := obj.(type) {
+	case *v1.Node:
+		*o = v1.Node{}
+	case *v1.Pod:
+		*o = v1.Pod{}
+	case *v1.Deployment:
+		*o = v1.Deployment{}
+	}
+	if err := json.Unmarshal(patched, obj); err != nil {
+		return denied(plugin, http.StatusInternalServerError, "patched object is invalid: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "example.com/m/api/v1"
+)
+
+// useAdmissionConfig loads config as the -admission-config file for the
+// rest of the test.
+func useAdmissionConfig(t *testing.T, config string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "admission.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admission = admissionConfig{}
+		loadAdmissionConfig("")
+	})
+	if err := loadAdmissionConfig(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// deniedBy reports whether err is a rejection by plugin with code.
+func deniedBy(err error, plugin string, code int) bool {
+	var denial *admissionError
+	return errors.As(err, &denial) && denial.plugin == plugin && denial.code == code
+}
+
+func TestAdmissionDefaultsAndLimitRanges(t *testing.T) {
+	useAdmissionConfig(t, `
+defaults:
+  podCPU: 1
+limitRanges:
+  - namespace: prod
+    defaultCPU: 2
+    minCPU: 2
+    maxCPU: 4
+`)
+	pod := &v1.Pod{}
+	if err := admit(v1.OperationCreate, pod, nil); err != nil {
+		t.Fatal(err)
+	}
+	if pod.Metadata.Namespace != "default" || pod.Spec.CPURequired != 1 {
+		t.Errorf("defaulted pod is in %q with %d CPU, want default with 1", pod.Metadata.Namespace, pod.Spec.CPURequired)
+	}
+	deployment := &v1.Deployment{Metadata: v1.ObjectMeta{Namespace: "prod"}}
+	if err := admit(v1.OperationCreate, deployment, nil); err != nil {
+		t.Fatal(err)
+	}
+	if cpu := deployment.Spec.Template.Spec.CPURequired; cpu != 2 {
+		t.Errorf("prod template got %d CPU, want the limit range's 2", cpu)
+	}
+
+	for cpu, plugin := range map[int]string{1: "LimitRanger", 5: "LimitRanger", 3: ""} {
+		err := admit(v1.OperationCreate, &v1.Pod{Metadata: v1.ObjectMeta{Namespace: "prod"}, Spec: v1.PodSpec{CPURequired: cpu}}, nil)
+		if plugin == "" && err != nil {
+			t.Errorf("%d CPU in prod: %v", cpu, err)
+		}
+		if plugin != "" && !deniedBy(err, plugin, http.StatusForbidden) {
+			t.Errorf("%d CPU in prod: got %v, want a %s denial", cpu, err, plugin)
+		}
+	}
+	err := admit(v1.OperationCreate, &v1.Pod{Spec: v1.PodSpec{CPURequired: -1}}, nil)
+	if !deniedBy(err, "LimitRanger", http.StatusUnprocessableEntity) {
+		t.Errorf("negative CPU: got %v", err)
+	}
+}
+
+func TestAdmissionRequiredLabels(t *testing.T) {
+	useAdmissionConfig(t, `
+requiredLabels:
+  Pod: [team]
+  Deployment: [team]
+`)
+	err := admit(v1.OperationCreate, &v1.Pod{Spec: v1.PodSpec{CPURequired: 1}}, nil)
+	if !deniedBy(err, "RequiredLabels", http.StatusForbidden) || !strings.Contains(err.Error(), "team") {
+		t.Errorf("unlabeled pod: got %v", err)
+	}
+
+	// A labeled deployment whose template would make unlabeled pods is
+	// turned away too.
+	deployment := &v1.Deployment{Metadata: v1.ObjectMeta{Labels: map[string]string{"team": "a"}}}
+	deployment.Spec.Template.Spec.CPURequired = 1
+	if err := admit(v1.OperationCreate, deployment, nil); !deniedBy(err, "RequiredLabels", http.StatusForbidden) {
+		t.Errorf("unlabeled template: got %v", err)
+	}
+	deployment.Spec.Template.Metadata.Labels = map[string]string{"team": "a"}
+	if err := admit(v1.OperationCreate, deployment, nil); err != nil {
+		t.Errorf("labeled deployment: %v", err)
+	}
+}
+
+func TestAdmissionResourceQuota(t *testing.T) {
+	resetState(t)
+	useAdmissionConfig(t, `
+resourceQuotas:
+  - namespace: default
+    pods: 2
+    cpu: 3
+`)
+	registerTestNode(t, "node-0001", "worker-1", 8)
+	if _, err := createPod(&Pod{Name: "a", CPURequired: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createPod(&Pod{Name: "b", CPURequired: 2}); !deniedBy(err, "ResourceQuota", http.StatusForbidden) {
+		t.Fatalf("going over the CPU quota: got %v", err)
+	}
+	b, err := createPod(&Pod{Name: "b", CPURequired: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createPod(&Pod{Name: "c", CPURequired: 1}); !deniedBy(err, "ResourceQuota", http.StatusForbidden) {
+		t.Fatalf("going over the pod quota: got %v", err)
+	}
+
+	// Updates count only the CPU they add.
+	old := toV1Pod(b)
+	bigger, smaller := old, old
+	bigger.Spec.CPURequired, smaller.Spec.CPURequired = 2, 1
+	if err := admit(v1.OperationUpdate, &bigger, &old); !deniedBy(err, "ResourceQuota", http.StatusForbidden) {
+		t.Errorf("growing past the CPU quota: got %v", err)
+	}
+	if err := admit(v1.OperationUpdate, &smaller, &old); err != nil {
+		t.Errorf("updating within the quota: %v", err)
+	}
+}
+
+// webhookServer answers admission reviews with respond.
+func webhookServer(t *testing.T, respond func(req *v1.AdmissionRequest) *v1.AdmissionResponse) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var review v1.AdmissionReview
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Request == nil {
+			t.Errorf("webhook got %v", err)
+			return
+		}
+		resp := respond(review.Request)
+		resp.UID = review.Request.UID
+		json.NewEncoder(w).Encode(v1.AdmissionReview{Response: resp})
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestAdmissionWebhooks(t *testing.T) {
+	mutating := webhookServer(t, func(req *v1.AdmissionRequest) *v1.AdmissionResponse {
+		return &v1.AdmissionResponse{Allowed: true, Patch: json.RawMessage(`[{"op":"add","path":"/metadata/labels","value":{"injected":"true"}}]`)}
+	})
+	validating := webhookServer(t, func(req *v1.AdmissionRequest) *v1.AdmissionResponse {
+		var pod v1.Pod
+		json.Unmarshal(req.Object, &pod)
+		if pod.Metadata.Labels["injected"] != "true" {
+			return &v1.AdmissionResponse{Allowed: false, Status: &v1.Status{Message: "not injected"}}
+		}
+		if pod.Spec.CPURequired > 2 {
+			return &v1.AdmissionResponse{Allowed: false, Status: &v1.Status{Code: http.StatusUnprocessableEntity, Message: "too big"}}
+		}
+		return &v1.AdmissionResponse{Allowed: true}
+	})
+	useAdmissionConfig(t, `
+webhooks:
+  - name: inject.example.com
+    url: `+mutating+`
+    type: Mutating
+    kinds: [Pod]
+  - name: policy.example.com
+    url: `+validating+`
+    kinds: [Pod]
+    operations: [CREATE]
+  - name: down.example.com
+    url: http://127.0.0.1:1/admit
+    failurePolicy: Ignore
+`)
+
+	// Validating webhooks see what mutating ones made of the object.
+	pod := &v1.Pod{Spec: v1.PodSpec{CPURequired: 1}}
+	if err := admit(v1.OperationCreate, pod, nil); err != nil {
+		t.Fatal(err)
+	}
+	if pod.Metadata.Labels["injected"] != "true" {
+		t.Errorf("labels after the mutating webhook: %v", pod.Metadata.Labels)
+	}
+	err := admit(v1.OperationCreate, &v1.Pod{Spec: v1.PodSpec{CPURequired: 3}}, nil)
+	if !deniedBy(err, "policy.example.com", http.StatusUnprocessableEntity) || !strings.Contains(err.Error(), "too big") {
+		t.Errorf("denied by the validating webhook: got %v", err)
+	}
+	// Operations and kinds a webhook does not ask for skip it.
+	if err := admit(v1.OperationUpdate, &v1.Pod{Spec: v1.PodSpec{CPURequired: 3}}, pod); err != nil {
+		t.Errorf("update skipping the CREATE-only webhook: %v", err)
+	}
+	if err := admit(v1.OperationCreate, &v1.Node{}, nil); err != nil {
+		t.Errorf("node skipping the pod webhooks: %v", err)
+	}
+}
+
+func TestAdmissionWebhookFailurePolicy(t *testing.T) {
+	renaming := webhookServer(t, func(req *v1.AdmissionRequest) *v1.AdmissionResponse {
+		return &v1.AdmissionResponse{Allowed: true, Patch: json.RawMessage(`[{"op":"replace","path":"/metadata/name","value":"other"}]`)}
+	})
+	useAdmissionConfig(t, `
+webhooks:
+  - name: down.example.com
+    url: http://127.0.0.1:1/admit
+    kinds: [Node]
+  - name: rename.example.com
+    url: `+renaming+`
+    type: Mutating
+    kinds: [Pod]
+`)
+	if err := admit(v1.OperationCreate, &v1.Node{}, nil); !deniedBy(err, "down.example.com", http.StatusInternalServerError) {
+		t.Errorf("unreachable webhook without Ignore: got %v", err)
+	}
+	pod := &v1.Pod{Metadata: v1.ObjectMeta{Name: "web"}, Spec: v1.PodSpec{CPURequired: 1}}
+	if err := admit(v1.OperationCreate, pod, nil); !deniedBy(err, "rename.example.com", http.StatusUnprocessableEntity) {
+		t.Errorf("renaming webhook: got %v", err)
+	}
+
+	if err := loadAdmissionConfig(""); err != nil {
+		t.Fatal(err)
+	}
+	admission.Webhooks = []webhookConfig{{Name: "bad", URL: "http://localhost", Type: "Auditing"}}
+	if err := loadAdmissionConfig(""); err == nil {
+		t.Error("loaded a webhook of an unknown type")
+	}
+}
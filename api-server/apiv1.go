@@ -0,0 +1,682 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	v1 "example.com/m/api/v1"
+)
+
+// registerV1Routes serves the typed /api/v1 surface. Nodes are cluster scoped
+// and addressed by name; pods and deployments live in namespaces and can be
+// listed across all of them. The legacy unversioned paths stay registered as
+// compatibility aliases in main.
+func registerV1Routes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/nodes", enableCORS(handleV1Nodes))
+	mux.HandleFunc("/api/v1/nodes/{name}", enableCORS(handleV1Node))
+	mux.HandleFunc("/api/v1/nodes/{name}/{action}", enableCORS(handleV1NodeAction))
+	mux.HandleFunc("/api/v1/pods", enableCORS(handleV1Pods))
+	mux.HandleFunc("/api/v1/namespaces/{namespace}/pods", enableCORS(handleV1Pods))
+	mux.HandleFunc("/api/v1/namespaces/{namespace}/pods/{name}", enableCORS(handleV1Pod))
+	mux.HandleFunc("/api/v1/namespaces/{namespace}/pods/{name}/{action}", enableCORS(handleV1PodAction))
+	mux.HandleFunc("/api/v1/deployments", enableCORS(handleV1Deployments))
+	mux.HandleFunc("/api/v1/namespaces/{namespace}/deployments", enableCORS(handleV1Deployments))
+	mux.HandleFunc("/api/v1/namespaces/{namespace}/deployments/{name}", enableCORS(handleV1Deployment))
+	mux.HandleFunc("/api/v1/events", enableCORS(handleV1Events))
+	mux.HandleFunc("/api/v1/namespaces/{namespace}/events", enableCORS(handleV1Events))
+	mux.HandleFunc("/api/v1/namespaces/{namespace}/serviceaccounts/{name}/token", enableCORS(handleServiceAccountToken))
+	mux.HandleFunc("/api/v1/whoami", enableCORS(handleWhoAmI))
+	mux.HandleFunc(caBundlePath, enableCORS(handleCABundle))
+	mux.HandleFunc("/api/v1/selfsubjectaccessreviews", enableCORS(handleSelfSubjectAccessReview))
+	mux.HandleFunc("/api/v1/clusterroles", enableCORS(handleRBACCollection(clusterRoleResource)))
+	mux.HandleFunc("/api/v1/clusterroles/{name}", enableCORS(handleRBACObject(clusterRoleResource)))
+	mux.HandleFunc("/api/v1/clusterrolebindings", enableCORS(handleRBACCollection(clusterRoleBindingResource)))
+	mux.HandleFunc("/api/v1/clusterrolebindings/{name}", enableCORS(handleRBACObject(clusterRoleBindingResource)))
+	mux.HandleFunc("/api/v1/roles", enableCORS(handleRBACCollection(roleResource)))
+	mux.HandleFunc("/api/v1/namespaces/{namespace}/roles", enableCORS(handleRBACCollection(roleResource)))
+	mux.HandleFunc("/api/v1/namespaces/{namespace}/roles/{name}", enableCORS(handleRBACObject(roleResource)))
+	mux.HandleFunc("/api/v1/rolebindings", enableCORS(handleRBACCollection(roleBindingResource)))
+	mux.HandleFunc("/api/v1/namespaces/{namespace}/rolebindings", enableCORS(handleRBACCollection(roleBindingResource)))
+	mux.HandleFunc("/api/v1/namespaces/{namespace}/rolebindings/{name}", enableCORS(handleRBACObject(roleBindingResource)))
+	mux.HandleFunc("/api/v1/leases", enableCORS(handleV1Leases))
+	mux.HandleFunc("/api/v1/namespaces/{namespace}/leases", enableCORS(handleV1Leases))
+	mux.HandleFunc("/api/v1/namespaces/{namespace}/leases/{name}", enableCORS(handleV1Lease))
+	mux.HandleFunc("/api/v1/heartbeat", enableCORS(handleHeartbeat))
+	mux.HandleFunc("/api/v1/registration", enableCORS(handleRegistration))
+	mux.HandleFunc("/api/v1/scheduler", enableCORS(handleScheduler))
+	mux.HandleFunc("/api/v1/apply", enableCORS(handleApply))
+	mux.HandleFunc("/api/", enableCORS(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, fmt.Sprintf("the server could not find the requested resource %s", r.URL.Path), http.StatusNotFound)
+	}))
+}
+
+func typeMeta(kind string) v1.TypeMeta {
+	return v1.TypeMeta{APIVersion: v1.APIVersion, Kind: kind}
+}
+
+// toV1Node converts a stored node. Callers must hold nodesMu unless node is a
+// private copy.
+func toV1Node(node *Node) v1.Node {
+	return v1.Node{
+		TypeMeta: typeMeta("Node"),
+		Metadata: v1.ObjectMeta{
+			Name:              node.Name,
+			UID:               node.ID,
+			ResourceVersion:   node.ResourceVersion,
+			Generation:        node.Generation,
+			CreationTimestamp: node.CreatedAt,
+			Labels:            copyLabels(node.Labels),
+			Annotations:       copyLabels(node.Annotations),
+		},
+		Spec: v1.NodeSpec{CPUCores: node.CPUCores},
+		Status: v1.NodeStatus{
+			AvailableCPU:       node.AvailableCPU,
+			Pods:               append([]string{}, node.Pods...),
+			HealthStatus:       node.HealthStatus,
+			LastHeartbeat:      node.LastHeartbeat,
+			HeartbeatCount:     node.HeartbeatCount,
+			ObservedGeneration: node.ObservedGeneration,
+			AgentVersion:       node.AgentVersion,
+			Conditions:         append([]v1.NodeCondition(nil), node.Conditions...),
+			Usage:              copyUsage(node.Usage),
+		},
+	}
+}
+
+// toV1Pod converts a stored pod. Callers must hold podsMu unless pod is a
+// private copy.
+func toV1Pod(pod *Pod) v1.Pod {
+	return v1.Pod{
+		TypeMeta: typeMeta("Pod"),
+		Metadata: v1.ObjectMeta{
+			Name:              pod.Name,
+			Namespace:         pod.Namespace,
+			UID:               pod.ID,
+			ResourceVersion:   pod.ResourceVersion,
+			Generation:        pod.Generation,
+			CreationTimestamp: pod.CreatedAt,
+			Labels:            copyLabels(pod.Labels),
+			Annotations:       copyLabels(pod.Annotations),
+			Owner:             pod.Owner,
+		},
+		Spec: v1.PodSpec{
+			CPURequired: pod.CPURequired,
+			NodeID:      pod.NodeID,
+			Command:     copyStrings(pod.Command),
+			Env:         copyEnv(pod.Env),
+			Priority:    pod.Priority,
+		},
+		Status: v1.PodStatus{
+			Phase:              pod.Status,
+			ObservedGeneration: pod.ObservedGeneration,
+			RestartCount:       pod.RestartCount,
+			Process:            copyPod(pod).Process,
+		},
+	}
+}
+
+// toV1Deployment converts a stored deployment. Callers must hold
+// deploymentsMu unless d is a private copy.
+func toV1Deployment(d *Deployment) v1.Deployment {
+	return v1.Deployment{
+		TypeMeta: typeMeta("Deployment"),
+		Metadata: v1.ObjectMeta{
+			Name:              d.Name,
+			Namespace:         d.Namespace,
+			ResourceVersion:   d.ResourceVersion,
+			Generation:        d.Generation,
+			CreationTimestamp: d.CreatedAt,
+			Labels:            copyLabels(d.Labels),
+			Annotations:       copyLabels(d.Annotations),
+		},
+		Spec: v1.DeploymentSpec{
+			Replicas: d.Replicas,
+			Selector: copyLabels(d.Selector),
+			Template: v1.PodTemplateSpec{
+				Metadata: v1.TemplateMeta{Labels: copyLabels(d.Template.Labels)},
+				Spec: v1.PodSpec{
+					CPURequired: d.Template.CPURequired,
+					Command:     copyStrings(d.Template.Command),
+					Env:         copyEnv(d.Template.Env),
+					Priority:    d.Template.Priority,
+				},
+			},
+		},
+		Status: v1.DeploymentStatus{
+			ReadyReplicas:      d.ReadyReplicas,
+			UpdatedReplicas:    d.UpdatedReplicas,
+			ObservedGeneration: d.ObservedGeneration,
+		},
+	}
+}
+
+func templateFromV1(t v1.PodTemplateSpec) PodTemplate {
+	return PodTemplate{
+		Labels:      copyLabels(t.Metadata.Labels),
+		CPURequired: t.Spec.CPURequired,
+		Command:     copyStrings(t.Spec.Command),
+		Env:         copyEnv(t.Spec.Env),
+		Priority:    t.Spec.Priority,
+	}
+}
+
+// writeList sends a list, mirroring its metadata in the headers the legacy
+// endpoints use.
+func writeList(w http.ResponseWriter, meta v1.ListMeta, list interface{}) {
+	w.Header().Set("X-Resource-Version", fmt.Sprint(meta.ResourceVersion))
+	if meta.Continue != "" {
+		w.Header().Set("X-Continue", meta.Continue)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func deletedStatus(kind, namespace, name string) v1.Status {
+	return v1.Status{
+		TypeMeta: typeMeta("Status"),
+		Status:   v1.StatusSuccess,
+		Details:  &v1.StatusDetails{Kind: kind, Namespace: namespace, Name: name},
+		Code:     http.StatusOK,
+	}
+}
+
+// decodeBody reads a JSON request body into v, answering 400 on failure.
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// checkObjectMeta validates the metadata of a create or update against the
+// name and namespace in the URL; empty fields in the body take the URL's.
+func checkObjectMeta(w http.ResponseWriter, meta *v1.ObjectMeta, namespace, name string) bool {
+	if meta.Namespace != "" && meta.Namespace != namespace {
+		writeError(w, fmt.Sprintf("metadata.namespace %q does not match the namespace in the URL", meta.Namespace), http.StatusBadRequest)
+		return false
+	}
+	if name != "" && meta.Name != "" && meta.Name != name {
+		writeError(w, fmt.Sprintf("metadata.name %q does not match the name in the URL", meta.Name), http.StatusBadRequest)
+		return false
+	}
+	if err := validateLabels(meta.Labels); err != nil {
+		writeError(w, err.Error(), http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
+
+func conflictMessage(kind, name string, current, expected uint64) string {
+	return fmt.Sprintf("%s %s has been modified (resourceVersion %d, expected %d)", kind, name, current, expected)
+}
+
+func conflictError(kind, name string, current, expected uint64) error {
+	return &statusError{sentinel: errConflict, message: conflictMessage(kind, name, current, expected)}
+}
+
+func handleV1Nodes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		opts, err := parseListOptions(r, nodeFieldNames)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		match := func(node *Node) bool { return opts.matches(node.Labels, nodeFields(node)) }
+
+		if isWatchRequest(r) {
+			serveWatch(w, r, "Node", &nodesMu, nodeSnapshots, func(obj interface{}) bool {
+				node := obj.(Node)
+				return match(&node)
+			}, func(obj interface{}) interface{} {
+				node := obj.(Node)
+				return toV1Node(&node)
+			})
+			return
+		}
+
+		list := v1.NodeList{TypeMeta: typeMeta("NodeList"), Items: []v1.Node{}}
+		nodesMu.Lock()
+		ids := make([]string, 0, len(nodes))
+		for id := range nodes {
+			ids = append(ids, id)
+		}
+		selected, next := opts.page(ids, func(id string) bool { return match(nodes[id]) })
+		for _, id := range selected {
+			list.Items = append(list.Items, toV1Node(nodes[id]))
+		}
+		list.Metadata = v1.ListMeta{ResourceVersion: watches.currentResourceVersion(), Continue: next}
+		nodesMu.Unlock()
+		writeList(w, list.Metadata, list)
+
+	case "POST":
+		var req v1.Node
+		if !decodeBody(w, r, &req) || !checkObjectMeta(w, &req.Metadata, "", "") {
+			return
+		}
+		if req.Spec.CPUCores <= 0 {
+			writeError(w, "spec.cpuCores must be positive", http.StatusUnprocessableEntity)
+			return
+		}
+
+		node, err := createNode(req.Metadata.Name, req.Spec.CPUCores, req.Metadata.Labels, req.Metadata.Annotations)
+		if err != nil {
+			writeErrorFor(w, err, http.StatusInternalServerError)
+			return
+		}
+		nodesMu.Lock()
+		created := toV1Node(node)
+		nodesMu.Unlock()
+		writeJSON(w, http.StatusCreated, created)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// nodeIDForName resolves a node name to its ID.
+func nodeIDForName(name string) (string, error) {
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	node := findNodeByName(name)
+	if node == nil {
+		return "", fmt.Errorf("node %q: %w", name, errNotFound)
+	}
+	return node.ID, nil
+}
+
+func handleV1Node(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	switch r.Method {
+	case "GET":
+		nodesMu.Lock()
+		defer nodesMu.Unlock()
+		node := findNodeByName(name)
+		if node == nil {
+			writeErrorFor(w, fmt.Errorf("node %q: %w", name, errNotFound), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, toV1Node(node))
+
+	case "PUT":
+		var req v1.Node
+		if !decodeBody(w, r, &req) || !checkObjectMeta(w, &req.Metadata, "", name) {
+			return
+		}
+		if req.Metadata.ResourceVersion == 0 {
+			writeError(w, "metadata.resourceVersion is required", http.StatusUnprocessableEntity)
+			return
+		}
+		if req.Spec.CPUCores <= 0 {
+			writeError(w, "spec.cpuCores must be positive", http.StatusUnprocessableEntity)
+			return
+		}
+
+		node, err := updateNode(name, req.Metadata.ResourceVersion, func(node *v1.Node) {
+			node.Spec = req.Spec
+			node.Metadata.Labels = req.Metadata.Labels
+			node.Metadata.Annotations = req.Metadata.Annotations
+		})
+		if err != nil {
+			writeErrorFor(w, err, http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusOK, node)
+
+	case "DELETE":
+		nodeID, err := nodeIDForName(name)
+		if err == nil {
+			err = deleteNode(nodeID)
+		}
+		if err != nil {
+			writeErrorFor(w, err, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, deletedStatus("Node", "", name))
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleV1NodeAction serves POST /api/v1/nodes/{name}/stop and .../restart.
+func handleV1NodeAction(w http.ResponseWriter, r *http.Request) {
+	name, action := r.PathValue("name"), r.PathValue("action")
+	if action == "token" {
+		handleNodeToken(w, r, name)
+		return
+	}
+	if action != "stop" && action != "restart" {
+		writeError(w, fmt.Sprintf("unknown node action %q", action), http.StatusNotFound)
+		return
+	}
+	if r.Method != "POST" {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	nodeID, err := nodeIDForName(name)
+	if err == nil {
+		if action == "stop" {
+			err = stopNode(nodeID)
+		} else {
+			err = restartNode(nodeID)
+		}
+	}
+	if err != nil {
+		writeErrorFor(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	node, exists := nodes[nodeID]
+	if !exists {
+		writeErrorFor(w, fmt.Errorf("node %q: %w", name, errNotFound), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, toV1Node(node))
+}
+
+func handleV1Pods(w http.ResponseWriter, r *http.Request) {
+	namespace := r.PathValue("namespace")
+
+	switch r.Method {
+	case "GET":
+		opts, err := parseListOptions(r, podFieldNames)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		match := func(pod *Pod) bool {
+			return (namespace == "" || pod.Namespace == namespace) && opts.matches(pod.Labels, podFields(pod))
+		}
+
+		if isWatchRequest(r) {
+			serveWatch(w, r, "Pod", &podsMu, podSnapshots, func(obj interface{}) bool {
+				pod := obj.(Pod)
+				return match(&pod)
+			}, func(obj interface{}) interface{} {
+				pod := obj.(Pod)
+				return toV1Pod(&pod)
+			})
+			return
+		}
+
+		list := v1.PodList{TypeMeta: typeMeta("PodList"), Items: []v1.Pod{}}
+		podsMu.Lock()
+		ids := make([]string, 0, len(pods))
+		for id := range pods {
+			ids = append(ids, id)
+		}
+		selected, next := opts.page(ids, func(id string) bool { return match(pods[id]) })
+		for _, id := range selected {
+			list.Items = append(list.Items, toV1Pod(pods[id]))
+		}
+		list.Metadata = v1.ListMeta{ResourceVersion: watches.currentResourceVersion(), Continue: next}
+		podsMu.Unlock()
+		writeList(w, list.Metadata, list)
+
+	case "POST":
+		if namespace == "" {
+			writeError(w, "pods are created in a namespace: POST /api/v1/namespaces/{namespace}/pods", http.StatusMethodNotAllowed)
+			return
+		}
+		var req v1.Pod
+		if !decodeBody(w, r, &req) || !checkObjectMeta(w, &req.Metadata, namespace, "") {
+			return
+		}
+		if req.Spec.CPURequired < 0 {
+			writeError(w, "spec.cpuRequired must not be negative", http.StatusUnprocessableEntity)
+			return
+		}
+
+		pod, err := createPod(&Pod{
+			Name:        req.Metadata.Name,
+			Namespace:   namespace,
+			CPURequired: req.Spec.CPURequired,
+			Command:     req.Spec.Command,
+			Env:         req.Spec.Env,
+			Priority:    req.Spec.Priority,
+			Labels:      req.Metadata.Labels,
+			Annotations: req.Metadata.Annotations,
+		})
+		if err != nil {
+			writeErrorFor(w, err, http.StatusBadRequest)
+			return
+		}
+		podsMu.Lock()
+		created := toV1Pod(pod)
+		podsMu.Unlock()
+		writeJSON(w, http.StatusCreated, created)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// podIDForName resolves a namespaced pod name to its ID.
+func podIDForName(namespace, name string) (string, error) {
+	podsMu.Lock()
+	defer podsMu.Unlock()
+	pod := findPod(namespace, name)
+	if pod == nil {
+		return "", fmt.Errorf("pod %s/%s: %w", namespace, name, errNotFound)
+	}
+	return pod.ID, nil
+}
+
+func handleV1Pod(w http.ResponseWriter, r *http.Request) {
+	namespace, name := r.PathValue("namespace"), r.PathValue("name")
+
+	switch r.Method {
+	case "GET":
+		podsMu.Lock()
+		defer podsMu.Unlock()
+		pod := findPod(namespace, name)
+		if pod == nil {
+			writeErrorFor(w, fmt.Errorf("pod %s/%s: %w", namespace, name, errNotFound), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, toV1Pod(pod))
+
+	case "PUT":
+		var req v1.Pod
+		if !decodeBody(w, r, &req) || !checkObjectMeta(w, &req.Metadata, namespace, name) {
+			return
+		}
+		if req.Metadata.ResourceVersion == 0 {
+			writeError(w, "metadata.resourceVersion is required", http.StatusUnprocessableEntity)
+			return
+		}
+		if req.Spec.CPURequired <= 0 {
+			writeError(w, "spec.cpuRequired must be positive", http.StatusUnprocessableEntity)
+			return
+		}
+
+		pod, err := updatePod(namespace, name, req.Metadata.ResourceVersion, func(pod *v1.Pod) {
+			pod.Spec.CPURequired = req.Spec.CPURequired
+			pod.Spec.Command = req.Spec.Command
+			pod.Spec.Env = req.Spec.Env
+			pod.Spec.Priority = req.Spec.Priority
+			pod.Metadata.Labels = req.Metadata.Labels
+			pod.Metadata.Annotations = req.Metadata.Annotations
+		})
+		if err != nil {
+			writeErrorFor(w, err, http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusOK, pod)
+
+	case "DELETE":
+		podID, err := podIDForName(namespace, name)
+		if err == nil {
+			err = deletePod(podID)
+		}
+		if err != nil {
+			writeErrorFor(w, err, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, deletedStatus("Pod", namespace, name))
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleV1PodAction serves POST /api/v1/namespaces/{namespace}/pods/{name}/restart.
+func handleV1PodAction(w http.ResponseWriter, r *http.Request) {
+	namespace, name, action := r.PathValue("namespace"), r.PathValue("name"), r.PathValue("action")
+	if action != "restart" {
+		writeError(w, fmt.Sprintf("unknown pod action %q", action), http.StatusNotFound)
+		return
+	}
+	if r.Method != "POST" {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	podID, err := podIDForName(namespace, name)
+	if err == nil {
+		err = restartPod(podID)
+	}
+	if err != nil {
+		writeErrorFor(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	podsMu.Lock()
+	defer podsMu.Unlock()
+	pod, exists := pods[podID]
+	if !exists {
+		writeErrorFor(w, fmt.Errorf("pod %s/%s: %w", namespace, name, errNotFound), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, toV1Pod(pod))
+}
+
+func handleV1Deployments(w http.ResponseWriter, r *http.Request) {
+	namespace := r.PathValue("namespace")
+
+	switch r.Method {
+	case "GET":
+		opts, err := parseListOptions(r, deploymentFieldNames)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		match := func(d *Deployment) bool {
+			return (namespace == "" || d.Namespace == namespace) && opts.matches(d.Labels, deploymentFields(d))
+		}
+
+		if isWatchRequest(r) {
+			serveWatch(w, r, "Deployment", &deploymentsMu, deploymentSnapshots, func(obj interface{}) bool {
+				d := obj.(Deployment)
+				return match(&d)
+			}, func(obj interface{}) interface{} {
+				d := obj.(Deployment)
+				return toV1Deployment(&d)
+			})
+			return
+		}
+
+		list := v1.DeploymentList{TypeMeta: typeMeta("DeploymentList"), Items: []v1.Deployment{}}
+		deploymentsMu.Lock()
+		keys := make([]string, 0, len(deployments))
+		for key := range deployments {
+			keys = append(keys, key)
+		}
+		selected, next := opts.page(keys, func(key string) bool { return match(deployments[key]) })
+		for _, key := range selected {
+			list.Items = append(list.Items, toV1Deployment(deployments[key]))
+		}
+		list.Metadata = v1.ListMeta{ResourceVersion: watches.currentResourceVersion(), Continue: next}
+		deploymentsMu.Unlock()
+		writeList(w, list.Metadata, list)
+
+	case "POST":
+		if namespace == "" {
+			writeError(w, "deployments are created in a namespace: POST /api/v1/namespaces/{namespace}/deployments", http.StatusMethodNotAllowed)
+			return
+		}
+		var req v1.Deployment
+		if !decodeBody(w, r, &req) || !checkObjectMeta(w, &req.Metadata, namespace, "") {
+			return
+		}
+
+		d := &Deployment{
+			Name:        req.Metadata.Name,
+			Namespace:   namespace,
+			Replicas:    req.Spec.Replicas,
+			Selector:    copyLabels(req.Spec.Selector),
+			Template:    templateFromV1(req.Spec.Template),
+			Labels:      copyLabels(req.Metadata.Labels),
+			Annotations: copyLabels(req.Metadata.Annotations),
+		}
+		if err := createDeployment(d); err != nil {
+			writeErrorFor(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+		deploymentsMu.Lock()
+		created := toV1Deployment(d)
+		deploymentsMu.Unlock()
+		writeJSON(w, http.StatusCreated, created)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleV1Deployment(w http.ResponseWriter, r *http.Request) {
+	namespace, name := r.PathValue("namespace"), r.PathValue("name")
+	key := deploymentKey(namespace, name)
+
+	switch r.Method {
+	case "GET":
+		deploymentsMu.Lock()
+		defer deploymentsMu.Unlock()
+		d, exists := deployments[key]
+		if !exists {
+			writeErrorFor(w, fmt.Errorf("deployment %s: %w", key, errNotFound), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, toV1Deployment(d))
+
+	case "PUT":
+		var req v1.Deployment
+		if !decodeBody(w, r, &req) || !checkObjectMeta(w, &req.Metadata, namespace, name) {
+			return
+		}
+		if req.Metadata.ResourceVersion == 0 {
+			writeError(w, "metadata.resourceVersion is required", http.StatusUnprocessableEntity)
+			return
+		}
+
+		d, err := updateDeployment(namespace, name, req.Metadata.ResourceVersion, func(d *v1.Deployment) {
+			d.Spec.Replicas = req.Spec.Replicas
+			d.Spec.Template = req.Spec.Template
+			if req.Spec.Selector != nil {
+				d.Spec.Selector = req.Spec.Selector
+			}
+			d.Metadata.Labels = req.Metadata.Labels
+			d.Metadata.Annotations = req.Metadata.Annotations
+		})
+		if err != nil {
+			writeErrorFor(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+		writeJSON(w, http.StatusOK, d)
+
+	case "DELETE":
+		if err := deleteDeployment(namespace, name); err != nil {
+			writeErrorFor(w, fmt.Errorf("deployment %s: %w", key, err), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, deletedStatus("Deployment", namespace, name))
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,671 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	v1 "example.com/m/api/v1"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	lastAppliedAnnotation = "kube-sim.io/last-applied-configuration"
+	maxManifestBytes      = 10 << 20
+)
+
+type manifestMetadata struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// manifest is the declarative form of a Node, Pod or Deployment. Only the
+// fields here are managed by apply; everything else is left to the server.
+// Spec decodes into the matching v1 spec type. Roles and bindings are
+// decoded whole by applyRBAC.
+type manifest struct {
+	APIVersion string           `json:"apiVersion,omitempty"`
+	Kind       string           `json:"kind"`
+	Metadata   manifestMetadata `json:"metadata"`
+	Spec       json.RawMessage  `json:"spec,omitempty"`
+}
+
+func handleApply(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxManifestBytes))
+	if err != nil {
+		writeError(w, "Failed to read manifest", http.StatusBadRequest)
+		return
+	}
+	docs, err := decodeManifests(body, strings.Contains(r.Header.Get("Content-Type"), "json"))
+	if err != nil {
+		writeError(w, fmt.Sprintf("Invalid manifest: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	prune := r.URL.Query().Get("prune") == "true"
+	pruneSelector, err := parseLabelSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, v1.ApplyResponse{Results: applyManifests(requestUser(r), docs, prune, pruneSelector)})
+}
+
+// applyManifests applies every document in order on behalf of user and, with
+// prune, deletes managed objects matching pruneSelector that none of them
+// named. Each object is authorized on its own, so a manifest can partly fail.
+func applyManifests(user *userInfo, docs []map[string]interface{}, prune bool, pruneSelector labelSelector) []v1.ApplyResult {
+	results := []v1.ApplyResult{}
+	applied := make(map[string]bool)
+	for _, doc := range docs {
+		result := applyObject(user, doc)
+		applied[objectKey(result.Kind, result.Namespace, result.Name)] = true
+		results = append(results, result)
+	}
+	if prune {
+		results = append(results, pruneObjects(user, applied, pruneSelector)...)
+	}
+	return results
+}
+
+// decodeManifests splits a YAML or JSON stream into documents. YAML documents
+// are separated by "---", JSON objects may simply be concatenated, and a
+// document of kind List contributes its items.
+func decodeManifests(data []byte, isJSON bool) ([]map[string]interface{}, error) {
+	var raw []interface{}
+	if isJSON {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var doc interface{}
+			if err := dec.Decode(&doc); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			raw = append(raw, doc)
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		for {
+			var doc interface{}
+			if err := dec.Decode(&doc); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			if doc != nil {
+				raw = append(raw, doc)
+			}
+		}
+	}
+
+	var docs []map[string]interface{}
+	for len(raw) > 0 {
+		next := raw[0]
+		raw = raw[1:]
+		if list, ok := next.([]interface{}); ok {
+			raw = append(list, raw...)
+			continue
+		}
+		doc, err := toConfigMap(next)
+		if err != nil {
+			return nil, err
+		}
+		if doc["kind"] == "List" {
+			items, _ := doc["items"].([]interface{})
+			raw = append(items, raw...)
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// toConfigMap normalises any JSON-compatible value to a generic map, so that
+// manifests, last-applied configuration and live objects compare equal
+// regardless of where they came from.
+func toConfigMap(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("expected an object: %v", err)
+	}
+	return m, nil
+}
+
+func objectKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// threeWayMerge applies the change from lastApplied to desired on top of live.
+// Fields set in desired win, fields that were applied before but are now
+// gone from desired are removed, and fields nobody applied are kept.
+func threeWayMerge(live, lastApplied, desired map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(live))
+	for k, v := range live {
+		result[k] = v
+	}
+	for k, v := range desired {
+		desiredMap, desiredIsMap := v.(map[string]interface{})
+		liveMap, liveIsMap := result[k].(map[string]interface{})
+		if desiredIsMap && liveIsMap {
+			lastMap, _ := lastApplied[k].(map[string]interface{})
+			result[k] = threeWayMerge(liveMap, lastMap, desiredMap)
+		} else {
+			result[k] = v
+		}
+	}
+	for k := range lastApplied {
+		if _, ok := desired[k]; !ok {
+			delete(result, k)
+		}
+	}
+	return result
+}
+
+// mergeManifest computes the object apply should converge to from its live
+// manifest, the stored last-applied configuration and the desired document.
+func mergeManifest(live manifest, lastApplied string, desired map[string]interface{}) (manifest, error) {
+	liveMap, err := toConfigMap(live)
+	if err != nil {
+		return manifest{}, err
+	}
+	lastMap := map[string]interface{}{}
+	if lastApplied != "" {
+		if err := json.Unmarshal([]byte(lastApplied), &lastMap); err != nil {
+			return manifest{}, fmt.Errorf("corrupt last-applied configuration: %v", err)
+		}
+	}
+	data, err := json.Marshal(threeWayMerge(liveMap, lastMap, desired))
+	if err != nil {
+		return manifest{}, err
+	}
+	var merged manifest
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return manifest{}, err
+	}
+	return merged, nil
+}
+
+func decodeSpec(m manifest, spec interface{}) error {
+	if len(m.Spec) == 0 {
+		return fmt.Errorf("spec is required")
+	}
+	if err := json.Unmarshal(m.Spec, spec); err != nil {
+		return fmt.Errorf("invalid spec: %v", err)
+	}
+	return nil
+}
+
+func mustRawJSON(v interface{}) json.RawMessage {
+	data, _ := json.Marshal(v)
+	return data
+}
+
+// withAnnotation returns a copy of annotations with key set to value.
+func withAnnotation(annotations map[string]string, key, value string) map[string]string {
+	c := copyLabels(annotations)
+	if c == nil {
+		c = make(map[string]string)
+	}
+	c[key] = value
+	return c
+}
+
+// kindResources maps the kinds apply understands to the resources they are
+// authorized as.
+var kindResources = map[string]string{
+	"Node":               "nodes",
+	"Pod":                "pods",
+	"Deployment":         "deployments",
+	"Role":               "roles",
+	"ClusterRole":        "clusterroles",
+	"RoleBinding":        "rolebindings",
+	"ClusterRoleBinding": "clusterrolebindings",
+}
+
+// applyExists reports whether the object apply is about to write already
+// exists, which decides whether it is authorized as a create or an update.
+func applyExists(kind, namespace, name string) bool {
+	switch kind {
+	case "Node":
+		nodesMu.Lock()
+		defer nodesMu.Unlock()
+		return findNodeByName(name) != nil
+	case "Pod":
+		podsMu.Lock()
+		defer podsMu.Unlock()
+		return findPod(namespace, name) != nil
+	case "Deployment":
+		deploymentsMu.Lock()
+		defer deploymentsMu.Unlock()
+		_, exists := deployments[deploymentKey(namespace, name)]
+		return exists
+	}
+	res := rbacResources[kind]
+	rbacMu.Lock()
+	defer rbacMu.Unlock()
+	_, exists := res.objects[res.key(namespace, name)]
+	return exists
+}
+
+func applyObject(user *userInfo, doc map[string]interface{}) v1.ApplyResult {
+	var m manifest
+	data, _ := json.Marshal(doc)
+	if err := json.Unmarshal(data, &m); err != nil {
+		return v1.ApplyResult{Kind: fmt.Sprint(doc["kind"]), Error: fmt.Sprintf("invalid manifest: %v", err)}
+	}
+	result := v1.ApplyResult{Kind: m.Kind, Name: m.Metadata.Name}
+	if m.Metadata.Name == "" {
+		result.Error = "metadata.name is required"
+		return result
+	}
+	if err := validateLabels(m.Metadata.Labels); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	// Default the namespace in the document itself so it is part of the
+	// recorded last-applied configuration.
+	metadata, _ := doc["metadata"].(map[string]interface{})
+	switch m.Kind {
+	case "Pod", "Deployment", "Role", "RoleBinding":
+		if m.Metadata.Namespace == "" {
+			m.Metadata.Namespace = defaultNamespace
+			metadata["namespace"] = defaultNamespace
+		}
+		result.Namespace = m.Metadata.Namespace
+	case "Node", "ClusterRole", "ClusterRoleBinding":
+		if m.Metadata.Namespace != "" {
+			result.Error = fmt.Sprintf("%s are not namespaced", kindResources[m.Kind])
+			return result
+		}
+	default:
+		result.Error = fmt.Sprintf("unsupported kind %q, expected Node, Pod, Deployment, Role, ClusterRole, RoleBinding or ClusterRoleBinding", m.Kind)
+		return result
+	}
+
+	attrs := v1.ResourceAttributes{Verb: "create", Resource: kindResources[m.Kind], Namespace: m.Metadata.Namespace, Name: m.Metadata.Name}
+	if applyExists(m.Kind, m.Metadata.Namespace, m.Metadata.Name) {
+		attrs.Verb = "update"
+	}
+	if allowed, reason := authorize(user, attrs); !allowed {
+		result.Error = reason
+		return result
+	}
+
+	desiredJSON, _ := json.Marshal(doc)
+	var err error
+	switch m.Kind {
+	case "Node":
+		result.Action, err = applyNode(m, doc, string(desiredJSON))
+	case "Pod":
+		result.Action, err = applyPod(m, doc, string(desiredJSON))
+	case "Deployment":
+		result.Action, err = applyDeployment(m, doc, string(desiredJSON))
+	default:
+		result.Action, err = applyRBAC(user, rbacResources[m.Kind], m, doc, string(desiredJSON))
+	}
+	if err != nil {
+		result.Action = ""
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func applyNode(m manifest, desired map[string]interface{}, desiredJSON string) (string, error) {
+	nodesMu.Lock()
+	node := findNodeByName(m.Metadata.Name)
+	if node == nil {
+		nodesMu.Unlock()
+		var spec v1.NodeSpec
+		if err := decodeSpec(m, &spec); err != nil {
+			return "", err
+		}
+		if spec.CPUCores <= 0 {
+			return "", fmt.Errorf("spec.cpuCores must be positive")
+		}
+		annotations := map[string]string{lastAppliedAnnotation: desiredJSON}
+		if _, err := createNode(m.Metadata.Name, spec.CPUCores, m.Metadata.Labels, annotations); err != nil {
+			return "", err
+		}
+		return "created", nil
+	}
+
+	live := manifest{
+		Kind:     "Node",
+		Metadata: manifestMetadata{Name: node.Name, Labels: node.Labels},
+		Spec:     mustRawJSON(v1.NodeSpec{CPUCores: node.CPUCores}),
+	}
+	merged, err := mergeManifest(live, node.Annotations[lastAppliedAnnotation], desired)
+	if err != nil {
+		nodesMu.Unlock()
+		return "", err
+	}
+	var spec v1.NodeSpec
+	if err := decodeSpec(merged, &spec); err != nil {
+		nodesMu.Unlock()
+		return "", err
+	}
+	if spec.CPUCores <= 0 {
+		nodesMu.Unlock()
+		return "", fmt.Errorf("spec.cpuCores must be positive")
+	}
+
+	specChanged := spec.CPUCores != node.CPUCores
+	metaChanged := !labelsEqual(merged.Metadata.Labels, node.Labels) ||
+		node.Annotations[lastAppliedAnnotation] != desiredJSON
+	name, rv := node.Name, node.ResourceVersion
+	nodesMu.Unlock()
+	if !specChanged && !metaChanged {
+		return "unchanged", nil
+	}
+
+	_, err = updateNode(name, rv, func(node *v1.Node) {
+		node.Spec.CPUCores = spec.CPUCores
+		node.Metadata.Labels = merged.Metadata.Labels
+		node.Metadata.Annotations = withAnnotation(node.Metadata.Annotations, lastAppliedAnnotation, desiredJSON)
+	})
+	if err != nil {
+		return "", err
+	}
+	return "configured", nil
+}
+
+func applyPod(m manifest, desired map[string]interface{}, desiredJSON string) (string, error) {
+	podsMu.Lock()
+	pod := findPod(m.Metadata.Namespace, m.Metadata.Name)
+	if pod == nil {
+		podsMu.Unlock()
+		var spec v1.PodSpec
+		if err := decodeSpec(m, &spec); err != nil {
+			return "", err
+		}
+		if spec.CPURequired < 0 {
+			return "", fmt.Errorf("spec.cpuRequired must not be negative")
+		}
+		_, err := createPod(&Pod{
+			Name:        m.Metadata.Name,
+			Namespace:   m.Metadata.Namespace,
+			CPURequired: spec.CPURequired,
+			Command:     spec.Command,
+			Env:         spec.Env,
+			Priority:    spec.Priority,
+			Labels:      m.Metadata.Labels,
+			Annotations: map[string]string{lastAppliedAnnotation: desiredJSON},
+		})
+		if err != nil {
+			return "", err
+		}
+		return "created", nil
+	}
+
+	live := manifest{
+		Kind:     "Pod",
+		Metadata: manifestMetadata{Name: pod.Name, Namespace: pod.Namespace, Labels: pod.Labels},
+		Spec:     mustRawJSON(v1.PodSpec{CPURequired: pod.CPURequired, Command: pod.Command, Env: pod.Env, Priority: pod.Priority}),
+	}
+	merged, err := mergeManifest(live, pod.Annotations[lastAppliedAnnotation], desired)
+	if err != nil {
+		podsMu.Unlock()
+		return "", err
+	}
+	var spec v1.PodSpec
+	if err := decodeSpec(merged, &spec); err != nil {
+		podsMu.Unlock()
+		return "", err
+	}
+	if spec.CPURequired <= 0 {
+		podsMu.Unlock()
+		return "", fmt.Errorf("spec.cpuRequired must be positive")
+	}
+
+	specChanged := spec.CPURequired != pod.CPURequired || !workloadEqual(spec.Command, spec.Env, pod.Command, pod.Env) ||
+		spec.Priority != pod.Priority
+	metaChanged := !labelsEqual(merged.Metadata.Labels, pod.Labels) ||
+		pod.Annotations[lastAppliedAnnotation] != desiredJSON
+	namespace, name, rv := pod.Namespace, pod.Name, pod.ResourceVersion
+	podsMu.Unlock()
+	if !specChanged && !metaChanged {
+		return "unchanged", nil
+	}
+
+	_, err = updatePod(namespace, name, rv, func(pod *v1.Pod) {
+		pod.Spec.CPURequired = spec.CPURequired
+		pod.Spec.Command = spec.Command
+		pod.Spec.Env = spec.Env
+		pod.Spec.Priority = spec.Priority
+		pod.Metadata.Labels = merged.Metadata.Labels
+		pod.Metadata.Annotations = withAnnotation(pod.Metadata.Annotations, lastAppliedAnnotation, desiredJSON)
+	})
+	if err != nil {
+		return "", err
+	}
+	return "configured", nil
+}
+
+func deploymentFromManifest(m manifest) (*Deployment, error) {
+	var spec v1.DeploymentSpec
+	if err := decodeSpec(m, &spec); err != nil {
+		return nil, err
+	}
+	return &Deployment{
+		Name:      m.Metadata.Name,
+		Namespace: m.Metadata.Namespace,
+		Labels:    m.Metadata.Labels,
+		Replicas:  spec.Replicas,
+		Selector:  spec.Selector,
+		Template:  templateFromV1(spec.Template),
+	}, nil
+}
+
+func applyDeployment(m manifest, desired map[string]interface{}, desiredJSON string) (string, error) {
+	deploymentsMu.Lock()
+	d, exists := deployments[deploymentKey(m.Metadata.Namespace, m.Metadata.Name)]
+	if !exists {
+		deploymentsMu.Unlock()
+		created, err := deploymentFromManifest(m)
+		if err != nil {
+			return "", err
+		}
+		created.Annotations = map[string]string{lastAppliedAnnotation: desiredJSON}
+		if err := createDeployment(created); err != nil {
+			return "", err
+		}
+		return "created", nil
+	}
+
+	var liveSpec v1.DeploymentSpec
+	liveSpec.Replicas = d.Replicas
+	liveSpec.Selector = d.Selector
+	liveSpec.Template.Metadata.Labels = d.Template.Labels
+	liveSpec.Template.Spec.CPURequired = d.Template.CPURequired
+	liveSpec.Template.Spec.Command = d.Template.Command
+	liveSpec.Template.Spec.Env = d.Template.Env
+	liveSpec.Template.Spec.Priority = d.Template.Priority
+	live := manifest{
+		Kind:     "Deployment",
+		Metadata: manifestMetadata{Name: d.Name, Namespace: d.Namespace, Labels: d.Labels},
+		Spec:     mustRawJSON(liveSpec),
+	}
+	merged, err := mergeManifest(live, d.Annotations[lastAppliedAnnotation], desired)
+	if err != nil {
+		deploymentsMu.Unlock()
+		return "", err
+	}
+	target, err := deploymentFromManifest(merged)
+	if err != nil {
+		deploymentsMu.Unlock()
+		return "", err
+	}
+	if !labelsEqual(target.Selector, d.Selector) {
+		deploymentsMu.Unlock()
+		return "", fmt.Errorf("spec.selector is immutable")
+	}
+
+	specChanged := target.Replicas != d.Replicas || target.Template.hash() != d.Template.hash()
+	metaChanged := !labelsEqual(target.Labels, d.Labels) ||
+		d.Annotations[lastAppliedAnnotation] != desiredJSON
+	namespace, name, rv := d.Namespace, d.Name, d.ResourceVersion
+	deploymentsMu.Unlock()
+	if !specChanged && !metaChanged {
+		return "unchanged", nil
+	}
+
+	_, err = updateDeployment(namespace, name, rv, func(d *v1.Deployment) {
+		d.Spec.Replicas = target.Replicas
+		d.Spec.Template.Metadata.Labels = target.Template.Labels
+		d.Spec.Template.Spec.CPURequired = target.Template.CPURequired
+		d.Spec.Template.Spec.Command = target.Template.Command
+		d.Spec.Template.Spec.Env = target.Template.Env
+		d.Spec.Template.Spec.Priority = target.Template.Priority
+		d.Metadata.Labels = target.Labels
+		d.Metadata.Annotations = withAnnotation(d.Metadata.Annotations, lastAppliedAnnotation, desiredJSON)
+	})
+	if err != nil {
+		return "", err
+	}
+	return "configured", nil
+}
+
+// applyRBAC creates or replaces a role or binding. Unlike the workload kinds
+// these are taken whole from the manifest: rules, subjects and roleRef are
+// never changed by anyone but their owner, so there is nothing to merge.
+func applyRBAC(user *userInfo, res *rbacResource, m manifest, desired map[string]interface{}, desiredJSON string) (string, error) {
+	obj := res.newObject()
+	data, _ := json.Marshal(desired)
+	if err := json.Unmarshal(data, obj); err != nil {
+		return "", fmt.Errorf("invalid %s: %v", res.kind, err)
+	}
+	meta := obj.GetObjectMeta()
+	*meta = v1.ObjectMeta{Name: m.Metadata.Name, Namespace: m.Metadata.Namespace, Labels: m.Metadata.Labels}
+
+	rbacMu.Lock()
+	live, exists := res.objects[res.key(meta.Namespace, meta.Name)]
+	if !exists {
+		rbacMu.Unlock()
+		meta.Annotations = map[string]string{lastAppliedAnnotation: desiredJSON}
+		if _, err := createRBACObject(user, res, obj); err != nil {
+			return "", err
+		}
+		return "created", nil
+	}
+	liveMeta := live.GetObjectMeta()
+	unchanged := sameRBACContent(live, obj) && labelsEqual(liveMeta.Labels, meta.Labels) &&
+		liveMeta.Annotations[lastAppliedAnnotation] == desiredJSON
+	meta.Annotations = withAnnotation(liveMeta.Annotations, lastAppliedAnnotation, desiredJSON)
+	rv := liveMeta.ResourceVersion
+	rbacMu.Unlock()
+	if unchanged {
+		return "unchanged", nil
+	}
+
+	if _, err := updateRBACObject(user, res, obj, rv); err != nil {
+		return "", err
+	}
+	return "configured", nil
+}
+
+// pruneObjects deletes objects that were created by apply, match selector and
+// were not part of this apply, as far as user may delete them.
+func pruneObjects(user *userInfo, applied map[string]bool, selector labelSelector) []v1.ApplyResult {
+	prunable := func(labels, annotations map[string]string, key string) bool {
+		_, managed := annotations[lastAppliedAnnotation]
+		return managed && !applied[key] && selector.matches(labels)
+	}
+
+	type target struct {
+		result v1.ApplyResult
+		id     string
+	}
+	var targets []target
+
+	nodesMu.Lock()
+	for id, node := range nodes {
+		if prunable(node.Labels, node.Annotations, objectKey("Node", "", node.Name)) {
+			targets = append(targets, target{v1.ApplyResult{Kind: "Node", Name: node.Name}, id})
+		}
+	}
+	nodesMu.Unlock()
+	podsMu.Lock()
+	for id, pod := range pods {
+		if prunable(pod.Labels, pod.Annotations, objectKey("Pod", pod.Namespace, pod.Name)) {
+			targets = append(targets, target{v1.ApplyResult{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name}, id})
+		}
+	}
+	podsMu.Unlock()
+	deploymentsMu.Lock()
+	for _, d := range deployments {
+		if prunable(d.Labels, d.Annotations, objectKey("Deployment", d.Namespace, d.Name)) {
+			targets = append(targets, target{v1.ApplyResult{Kind: "Deployment", Namespace: d.Namespace, Name: d.Name}, ""})
+		}
+	}
+	deploymentsMu.Unlock()
+	rbacMu.Lock()
+	for _, res := range rbacResources {
+		for _, obj := range res.objects {
+			meta := obj.GetObjectMeta()
+			if prunable(meta.Labels, meta.Annotations, objectKey(res.kind, meta.Namespace, meta.Name)) {
+				targets = append(targets, target{v1.ApplyResult{Kind: res.kind, Namespace: meta.Namespace, Name: meta.Name}, ""})
+			}
+		}
+	}
+	rbacMu.Unlock()
+
+	// Delete workloads before nodes so pruned nodes are empty by the time we
+	// get to them, and bindings before the roles they refer to.
+	var results []v1.ApplyResult
+	for _, kind := range []string{"Deployment", "Pod", "Node", "RoleBinding", "ClusterRoleBinding", "Role", "ClusterRole"} {
+		for _, t := range targets {
+			if t.result.Kind != kind {
+				continue
+			}
+			attrs := v1.ResourceAttributes{Verb: "delete", Resource: kindResources[kind], Namespace: t.result.Namespace, Name: t.result.Name}
+			if allowed, reason := authorize(user, attrs); !allowed {
+				t.result.Error = reason
+				results = append(results, t.result)
+				continue
+			}
+			var err error
+			switch kind {
+			case "Deployment":
+				err = deleteDeployment(t.result.Namespace, t.result.Name)
+			case "Pod":
+				err = deletePod(t.id)
+			case "Node":
+				err = deleteNode(t.id)
+			default:
+				err = deleteRBACObject(rbacResources[kind], t.result.Namespace, t.result.Name)
+			}
+			if err != nil && !errors.Is(err, errNotFound) {
+				t.result.Error = err.Error()
+			} else {
+				t.result.Action = "pruned"
+			}
+			results = append(results, t.result)
+		}
+	}
+	return results
+}
+
+func labelsEqual(a, b map[string]string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "example.com/m/api/v1"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// maxAuditBodyBytes caps how much of a request or response body is
+	// recorded; longer bodies are truncated to a string.
+	maxAuditBodyBytes = 64 << 10
+
+	auditWebhookBatchSize   = 100
+	auditWebhookBatchPeriod = time.Second
+	auditWebhookBufferSize  = 10000
+	auditWebhookTimeout     = 10 * time.Second
+)
+
+// auditLevels orders the levels so policies can be compared.
+var auditLevels = map[string]int{
+	v1.AuditLevelNone:            0,
+	v1.AuditLevelMetadata:        1,
+	v1.AuditLevelRequest:         2,
+	v1.AuditLevelRequestResponse: 3,
+}
+
+// auditPolicy is loaded from the file named by -audit-policy-file. The first
+// rule matching a request decides its level; requests no rule matches are
+// recorded at Metadata.
+//
+//	rules:
+//	  - level: None
+//	    resources: [heartbeats]
+//	  - level: None
+//	    resources: [events]
+//	    verbs: [get, list, watch]
+//	  - level: RequestResponse
+//	    resources: [roles, rolebindings, clusterroles, clusterrolebindings]
+//	  - level: Request
+//	    verbs: [create, update, delete]
+//	    namespaces: [prod]
+//	  - level: Metadata
+//	    users: [admin]
+type auditPolicy struct {
+	Rules []auditRule `yaml:"rules"`
+}
+
+// auditRule matches requests by verb, resource, namespace and user; an empty
+// list matches anything.
+type auditRule struct {
+	Level      string   `yaml:"level"`
+	Verbs      []string `yaml:"verbs"`
+	Resources  []string `yaml:"resources"`
+	Namespaces []string `yaml:"namespaces"`
+	Users      []string `yaml:"users"`
+}
+
+func (rule *auditRule) matches(user *userInfo, attrs v1.ResourceAttributes) bool {
+	return (len(rule.Verbs) == 0 || matchesWildcard(rule.Verbs, attrs.Verb)) &&
+		(len(rule.Resources) == 0 || matchesWildcard(rule.Resources, attrs.Resource)) &&
+		(len(rule.Namespaces) == 0 || containsString(rule.Namespaces, attrs.Namespace)) &&
+		(len(rule.Users) == 0 || containsString(rule.Users, user.Name))
+}
+
+// level returns the level for a request.
+func (p *auditPolicy) level(user *userInfo, attrs v1.ResourceAttributes) string {
+	for i := range p.Rules {
+		if p.Rules[i].matches(user, attrs) {
+			return p.Rules[i].Level
+		}
+	}
+	return v1.AuditLevelMetadata
+}
+
+// maxLevel is the highest level any request can get, which decides whether
+// bodies have to be captured before the user is known.
+func (p *auditPolicy) maxLevel() int {
+	max := auditLevels[v1.AuditLevelMetadata]
+	for _, rule := range p.Rules {
+		if auditLevels[rule.Level] > max {
+			max = auditLevels[rule.Level]
+		}
+	}
+	return max
+}
+
+// auditSink receives finished audit events. write must not block the
+// request for long.
+type auditSink interface {
+	write(ev *v1.AuditEvent)
+}
+
+var (
+	audit      auditPolicy
+	auditSinks []auditSink
+)
+
+// configureAudit loads the policy and opens the sinks. Without a log path or
+// webhook URL nothing is audited.
+func configureAudit(policyFile, logPath string, maxSizeMB, maxBackups int, webhookURL string) error {
+	if policyFile != "" {
+		data, err := os.ReadFile(policyFile)
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(data, &audit); err != nil {
+			return fmt.Errorf("parsing %s: %v", policyFile, err)
+		}
+		for i, rule := range audit.Rules {
+			if _, ok := auditLevels[rule.Level]; !ok {
+				return fmt.Errorf("rule %d: level must be None, Metadata, Request or RequestResponse", i)
+			}
+		}
+	}
+	if logPath != "" {
+		sink, err := newAuditFileSink(logPath, int64(maxSizeMB)<<20, maxBackups)
+		if err != nil {
+			return err
+		}
+		auditSinks = append(auditSinks, sink)
+	}
+	if webhookURL != "" {
+		sink := &auditWebhookSink{
+			url:    webhookURL,
+			client: &http.Client{Timeout: auditWebhookTimeout},
+			events: make(chan *v1.AuditEvent, auditWebhookBufferSize),
+		}
+		go sink.run()
+		auditSinks = append(auditSinks, sink)
+	}
+	if len(auditSinks) > 0 {
+		log.Printf("Audit: %d policy rules, writing to %d sinks", len(audit.Rules), len(auditSinks))
+	}
+	return nil
+}
+
+// auditContext lets withAuthentication, which runs inside withAudit, report
+// who the request was authenticated as.
+type auditContext struct {
+	user *userInfo
+}
+
+type auditContextKey struct{}
+
+// setAuditUser records the authenticated user for the audit event of r.
+func setAuditUser(r *http.Request, user *userInfo) {
+	if ac, ok := r.Context().Value(auditContextKey{}).(*auditContext); ok {
+		ac.user = user
+	}
+}
+
+// auditResponseWriter records the status code and, when asked to, the body.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	code        int
+	captureBody bool
+	body        bytes.Buffer
+}
+
+func (w *auditResponseWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *auditResponseWriter) Write(data []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if w.captureBody && w.body.Len() < maxAuditBodyBytes {
+		w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush keeps watch streams working through the wrapper.
+func (w *auditResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// withAudit records every request that reaches the server, including those
+// authentication rejects, once it has been answered. Watches are recorded
+// when they end.
+func withAudit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(auditSinks) == 0 || r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		received := time.Now()
+		auditID := uuid.New().String()
+		w.Header().Set("Audit-ID", auditID)
+
+		captureBodies := audit.maxLevel() >= auditLevels[v1.AuditLevelRequest]
+		var requestBody []byte
+		if captureBodies && r.Body != nil {
+			// Only the audit copy is cut short; the handler reads the whole
+			// body, the captured prefix followed by the rest.
+			requestBody, _ = io.ReadAll(io.LimitReader(r.Body, maxAuditBodyBytes+1))
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(requestBody), r.Body), r.Body}
+		}
+		attrs, _ := requestAttributes(r)
+		ac := &auditContext{}
+		rw := &auditResponseWriter{
+			ResponseWriter: w,
+			captureBody:    audit.maxLevel() >= auditLevels[v1.AuditLevelRequestResponse] && !isWatchRequest(r),
+		}
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), auditContextKey{}, ac)))
+
+		user := ac.user
+		if user == nil {
+			user = &userInfo{}
+		}
+		level := audit.level(user, attrs)
+		if level == v1.AuditLevelNone {
+			return
+		}
+		if rw.code == 0 {
+			rw.code = http.StatusOK
+		}
+		done := time.Now()
+		ev := &v1.AuditEvent{
+			TypeMeta:                 typeMeta("AuditEvent"),
+			Level:                    level,
+			AuditID:                  auditID,
+			RequestURI:               r.URL.RequestURI(),
+			Verb:                     attrs.Verb,
+			User:                     v1.UserInfo{Username: user.Name, UID: user.UID, Groups: user.Groups},
+			SourceIPs:                sourceIPs(r),
+			UserAgent:                r.UserAgent(),
+			ResponseCode:             rw.code,
+			RequestReceivedTimestamp: received,
+			StageTimestamp:           done,
+			LatencyMs:                float64(done.Sub(received).Microseconds()) / 1000,
+		}
+		if attrs.Resource != "" {
+			ev.ObjectRef = &v1.AuditObjectRef{Resource: attrs.Resource, Namespace: attrs.Namespace, Name: attrs.Name}
+		}
+		if auditLevels[level] >= auditLevels[v1.AuditLevelRequest] && len(requestBody) > 0 {
+			ev.RequestObject = auditBody(requestBody)
+		}
+		if auditLevels[level] >= auditLevels[v1.AuditLevelRequestResponse] && rw.body.Len() > 0 {
+			ev.ResponseObject = auditBody(rw.body.Bytes())
+		}
+		for _, sink := range auditSinks {
+			sink.write(ev)
+		}
+	})
+}
+
+// auditBody returns a body as JSON, quoting it as a string when it is not
+// JSON or was cut short.
+func auditBody(body []byte) json.RawMessage {
+	if len(body) > maxAuditBodyBytes {
+		body = body[:maxAuditBodyBytes]
+	} else if json.Valid(body) {
+		return json.RawMessage(bytes.TrimSpace(body))
+	}
+	quoted, _ := json.Marshal(string(body))
+	return quoted
+}
+
+// sourceIPs lists the addresses in X-Forwarded-For followed by the peer.
+func sourceIPs(r *http.Request) []string {
+	var ips []string
+	for _, ip := range strings.Split(r.Header.Get("X-Forwarded-For"), ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return append(ips, host)
+}
+
+// auditFileSink appends JSON lines to a file, rotating it to path.1,
+// path.2 and so on once it grows past maxSize. "-" writes to stdout.
+type auditFileSink struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	out        *bufio.Writer
+	size       int64
+}
+
+func newAuditFileSink(path string, maxSize int64, maxBackups int) (*auditFileSink, error) {
+	s := &auditFileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if path == "-" {
+		s.out = bufio.NewWriter(os.Stdout)
+		return s, nil
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *auditFileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening audit log: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file, s.out, s.size = f, bufio.NewWriter(f), info.Size()
+	return nil
+}
+
+func (s *auditFileSink) write(ev *v1.AuditEvent) {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("Audit: encoding event %s: %v", ev.AuditID, err)
+		return
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file != nil && s.maxSize > 0 && s.size+int64(len(line)) > s.maxSize && s.size > 0 {
+		if err := s.rotate(); err != nil {
+			log.Printf("Audit: rotating %s: %v", s.path, err)
+		}
+	}
+	if s.out == nil {
+		return
+	}
+	n, _ := s.out.Write(line)
+	s.size += int64(n)
+	if err := s.out.Flush(); err != nil {
+		log.Printf("Audit: writing %s: %v", s.path, err)
+	}
+}
+
+// rotate shifts the backups up by one, dropping the oldest, and starts a new
+// file. Callers must hold s.mu.
+func (s *auditFileSink) rotate() error {
+	s.out.Flush()
+	s.file.Close()
+	s.file, s.out = nil, nil
+	if s.maxBackups > 0 {
+		for i := s.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+		}
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+// auditWebhookSink posts events in batches. When the webhook cannot keep up
+// and the buffer fills, events are dropped rather than delaying requests.
+type auditWebhookSink struct {
+	url    string
+	client *http.Client
+	events chan *v1.AuditEvent
+}
+
+func (s *auditWebhookSink) write(ev *v1.AuditEvent) {
+	select {
+	case s.events <- ev:
+	default:
+		log.Printf("Audit: webhook buffer full, dropping event %s", ev.AuditID)
+	}
+}
+
+func (s *auditWebhookSink) run() {
+	ticker := time.NewTicker(auditWebhookBatchPeriod)
+	defer ticker.Stop()
+	var batch []v1.AuditEvent
+	for {
+		select {
+		case ev := <-s.events:
+			batch = append(batch, *ev)
+			if len(batch) < auditWebhookBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := s.send(batch); err != nil {
+			log.Printf("Audit: webhook %s: dropping %d events: %v", s.url, len(batch), err)
+		}
+		batch = nil
+	}
+}
+
+func (s *auditWebhookSink) send(batch []v1.AuditEvent) error {
+	body, err := json.Marshal(v1.AuditEventList{TypeMeta: typeMeta("AuditEventList"), Items: batch})
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	v1 "example.com/m/api/v1"
+)
+
+type recordingSink struct {
+	events []*v1.AuditEvent
+}
+
+func (s *recordingSink) write(ev *v1.AuditEvent) {
+	s.events = append(s.events, ev)
+}
+
+func TestAuditKeepsWholeRequestBody(t *testing.T) {
+	sink := &recordingSink{}
+	oldAudit, oldSinks := audit, auditSinks
+	audit = auditPolicy{Rules: []auditRule{{Level: v1.AuditLevelRequest}}}
+	auditSinks = []auditSink{sink}
+	defer func() { audit, auditSinks = oldAudit, oldSinks }()
+
+	body := strings.Repeat("x", maxManifestBytes+maxAuditBodyBytes)
+	var seen string
+	handler := withAudit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading body: %v", err)
+		}
+		seen = string(data)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/pods", strings.NewReader(body)))
+
+	if seen != body {
+		t.Errorf("handler read %d bytes, want %d", len(seen), len(body))
+	}
+	if len(sink.events) != 1 {
+		t.Fatalf("got %d audit events, want 1", len(sink.events))
+	}
+	// The recorded copy is the quoted prefix.
+	if got := len(sink.events[0].RequestObject); got != maxAuditBodyBytes+2 {
+		t.Errorf("audited body is %d bytes, want %d", got, maxAuditBodyBytes+2)
+	}
+}
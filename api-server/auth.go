@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	v1 "example.com/m/api/v1"
+	"github.com/google/uuid"
+)
+
+// Well-known users and groups.
+const (
+	anonymousUser        = "system:anonymous"
+	groupUnauthenticated = "system:unauthenticated"
+	groupAuthenticated   = "system:authenticated"
+	groupNodes           = "system:nodes"
+	groupServiceAccounts = "system:serviceaccounts"
+	groupMonitoring      = "system:monitoring"
+	nodeUserPrefix       = "system:node:"
+	serviceAccountPrefix = "system:serviceaccount:"
+)
+
+// signedTokenPrefix marks tokens the server signed itself, as opposed to
+// tokens from the static token file.
+const signedTokenPrefix = "kst1."
+
+// userInfo is who a request was authenticated as.
+type userInfo struct {
+	Name   string
+	UID    string
+	Groups []string
+}
+
+func (u *userInfo) inGroup(group string) bool {
+	for _, g := range u.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticator recognises one kind of credential. authenticate returns nil
+// and no error when the request does not carry that kind of credential, so
+// the next authenticator gets a look.
+type authenticator struct {
+	name         string
+	authenticate func(r *http.Request) (*userInfo, error)
+}
+
+var (
+	authenticators []authenticator
+	allowAnonymous = true
+	// tokenSigningKey signs service account and node tokens with
+	// HMAC-SHA256.
+	tokenSigningKey []byte
+
+	errUnauthorized = errors.New("unauthorized")
+)
+
+type userContextKey struct{}
+
+// configureAuthentication sets up the authenticators: client certificates,
+// which only appear when the server verifies them against a client CA, then
+// bearer tokens from tokenFile, then tokens signed with the key in keyFile.
+// Without a key file a random key is generated, so signed tokens do not
+// survive a restart.
+func configureAuthentication(tokenFile, keyFile string, anonymous bool) error {
+	allowAnonymous = anonymous
+	authenticators = []authenticator{{name: "x509", authenticate: authenticateClientCert}}
+
+	if tokenFile != "" {
+		tokens, err := loadTokenFile(tokenFile)
+		if err != nil {
+			return err
+		}
+		authenticators = append(authenticators, authenticator{name: "token-file", authenticate: func(r *http.Request) (*userInfo, error) {
+			token, ok := bearerToken(r)
+			if !ok || strings.HasPrefix(token, signedTokenPrefix) {
+				return nil, nil
+			}
+			for known, user := range tokens {
+				if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+					return user, nil
+				}
+			}
+			return nil, fmt.Errorf("%w: unknown bearer token", errUnauthorized)
+		}})
+	}
+
+	if keyFile != "" {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return fmt.Errorf("reading service account key: %v", err)
+		}
+		tokenSigningKey = []byte(strings.TrimSpace(string(key)))
+		if len(tokenSigningKey) < 32 {
+			return fmt.Errorf("service account key in %s must be at least 32 bytes", keyFile)
+		}
+	} else {
+		tokenSigningKey = make([]byte, 32)
+		if _, err := rand.Read(tokenSigningKey); err != nil {
+			return err
+		}
+	}
+	authenticators = append(authenticators, authenticator{name: "signed-token", authenticate: func(r *http.Request) (*userInfo, error) {
+		token, ok := bearerToken(r)
+		if !ok {
+			return nil, nil
+		}
+		if !strings.HasPrefix(token, signedTokenPrefix) {
+			return nil, fmt.Errorf("%w: unknown bearer token", errUnauthorized)
+		}
+		user, err := verifyToken(token)
+		if err != nil {
+			return nil, err
+		}
+		if user.inGroup(groupNodes) {
+			// The token of a node the server no longer knows still lets its
+			// agent register the node again, under the same ID and name.
+			nodesMu.Lock()
+			node, exists := nodes[user.UID]
+			stale := exists && nodeUserPrefix+node.Name != user.Name
+			nodesMu.Unlock()
+			if stale {
+				return nil, fmt.Errorf("%w: token belongs to a node that no longer exists", errUnauthorized)
+			}
+		}
+		return user, nil
+	}})
+	return nil
+}
+
+// loadTokenFile reads a CSV file of token,user,uid[,"group1,group2"] lines.
+func loadTokenFile(path string) (map[string]*userInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading token file: %v", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	tokens := make(map[string]*userInfo)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return tokens, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("token file %s: %v", path, err)
+		}
+		line, _ := r.FieldPos(0)
+		if len(record) < 3 || record[0] == "" || record[1] == "" {
+			return nil, fmt.Errorf("token file %s line %d: expected token,user,uid[,groups]", path, line)
+		}
+		if strings.HasPrefix(record[0], signedTokenPrefix) {
+			return nil, fmt.Errorf("token file %s line %d: tokens may not start with %q", path, line, signedTokenPrefix)
+		}
+		user := &userInfo{Name: record[1], UID: record[2]}
+		if len(record) > 3 && record[3] != "" {
+			for _, g := range strings.Split(record[3], ",") {
+				user.Groups = append(user.Groups, strings.TrimSpace(g))
+			}
+		}
+		tokens[record[0]] = user
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	token = strings.TrimSpace(token)
+	return token, ok && token != ""
+}
+
+// authenticateClientCert takes the user from a verified client certificate:
+// the common name is the user name and the organizations are the groups.
+func authenticateClientCert(r *http.Request) (*userInfo, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if cert.Subject.CommonName == "" {
+		return nil, fmt.Errorf("%w: client certificate has no common name", errUnauthorized)
+	}
+	user := &userInfo{Name: cert.Subject.CommonName, Groups: append([]string{}, cert.Subject.Organization...)}
+	if strings.HasPrefix(user.Name, nodeUserPrefix) {
+		// Node certificates carry the node's name; heartbeats name the node
+		// by ID, so look it up.
+		nodesMu.Lock()
+		if node := findNodeByName(strings.TrimPrefix(user.Name, nodeUserPrefix)); node != nil {
+			user.UID = node.ID
+		}
+		nodesMu.Unlock()
+	}
+	return user, nil
+}
+
+// tokenClaims is the signed payload of a server-issued token.
+type tokenClaims struct {
+	Subject   string   `json:"sub"`
+	UID       string   `json:"uid,omitempty"`
+	Groups    []string `json:"groups,omitempty"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+}
+
+// signToken issues a token for claims: the prefix, the base64url JSON claims
+// and their base64url HMAC-SHA256.
+func signToken(claims tokenClaims) string {
+	payload, _ := json.Marshal(claims)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, tokenSigningKey)
+	mac.Write([]byte(encoded))
+	return signedTokenPrefix + encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func verifyToken(token string) (*userInfo, error) {
+	encoded, sig, ok := strings.Cut(strings.TrimPrefix(token, signedTokenPrefix), ".")
+	if !ok {
+		return nil, fmt.Errorf("%w: malformed token", errUnauthorized)
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed token", errUnauthorized)
+	}
+	mac := hmac.New(sha256.New, tokenSigningKey)
+	mac.Write([]byte(encoded))
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return nil, fmt.Errorf("%w: invalid token signature", errUnauthorized)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed token", errUnauthorized)
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" {
+		return nil, fmt.Errorf("%w: malformed token", errUnauthorized)
+	}
+	if claims.ExpiresAt != 0 && time.Now().Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("%w: token has expired", errUnauthorized)
+	}
+	return &userInfo{Name: claims.Subject, UID: claims.UID, Groups: claims.Groups}, nil
+}
+
+// nodeToken issues the token the agent of the node named name, registered
+// under id, authenticates its registration and heartbeats with. It does not
+// expire.
+func nodeToken(name, id string) string {
+	return signToken(tokenClaims{
+		Subject:  nodeUserPrefix + name,
+		UID:      id,
+		Groups:   []string{groupNodes},
+		IssuedAt: time.Now().Unix(),
+	})
+}
+
+// serviceAccountToken issues a token for the service account name in
+// namespace, valid for ttl or forever when ttl is zero.
+func serviceAccountToken(namespace, name string, ttl time.Duration) (string, time.Time) {
+	now := time.Now()
+	claims := tokenClaims{
+		Subject:  serviceAccountPrefix + namespace + ":" + name,
+		Groups:   []string{groupServiceAccounts, groupServiceAccounts + ":" + namespace},
+		IssuedAt: now.Unix(),
+	}
+	var expires time.Time
+	if ttl > 0 {
+		expires = now.Add(ttl).Truncate(time.Second)
+		claims.ExpiresAt = expires.Unix()
+	}
+	return signToken(claims), expires
+}
+
+// authenticate runs the authenticators in order. The first to recognise the
+// request's credentials decides; a request with none is anonymous if that is
+// allowed.
+func authenticate(r *http.Request) (*userInfo, error) {
+	for _, a := range authenticators {
+		user, err := a.authenticate(r)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil {
+			groups := append(append([]string{}, user.Groups...), groupAuthenticated)
+			return &userInfo{Name: user.Name, UID: user.UID, Groups: groups}, nil
+		}
+	}
+	if !allowAnonymous {
+		return nil, fmt.Errorf("%w: no credentials provided", errUnauthorized)
+	}
+	return &userInfo{Name: anonymousUser, Groups: []string{groupUnauthenticated}}, nil
+}
+
+// withAuthentication authenticates every request before it reaches next and
+// answers 401 when that fails. CORS preflights, /health and the CA bundle are
+// let through, since browsers, probes and bootstrapping clients send them
+// without credentials.
+func withAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" || r.URL.Path == "/health" || r.URL.Path == caBundlePath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, err := authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kube-sim"`)
+			writeError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		setAuditUser(r, user)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	})
+}
+
+// requestUser returns who r was authenticated as.
+func requestUser(r *http.Request) *userInfo {
+	if user, ok := r.Context().Value(userContextKey{}).(*userInfo); ok {
+		return user
+	}
+	return &userInfo{Name: anonymousUser, Groups: []string{groupUnauthenticated}}
+}
+
+// loadClientCAs reads the PEM bundle client certificates are verified
+// against.
+func loadClientCAs(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading client CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", path)
+	}
+	return pool, nil
+}
+
+func handleWhoAmI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := requestUser(r)
+	writeJSON(w, http.StatusOK, v1.UserInfo{
+		TypeMeta: typeMeta("UserInfo"),
+		Username: user.Name,
+		UID:      user.UID,
+		Groups:   user.Groups,
+	})
+}
+
+// handleServiceAccountToken issues a token for a service account. Service
+// accounts exist implicitly: any name in any namespace can have tokens.
+// Anonymous callers cannot ask for one.
+func handleServiceAccountToken(w http.ResponseWriter, r *http.Request) {
+	namespace, name := r.PathValue("namespace"), r.PathValue("name")
+	if r.Method != "POST" {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if requestUser(r).Name == anonymousUser {
+		writeError(w, "anonymous users cannot request service account tokens", http.StatusForbidden)
+		return
+	}
+
+	var req v1.TokenRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, fmt.Sprintf("invalid token request: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Spec.ExpirationSeconds < 0 {
+		writeErrorFor(w, invalidError("spec.expirationSeconds must not be negative"), http.StatusBadRequest)
+		return
+	}
+
+	token, expires := serviceAccountToken(namespace, name, time.Duration(req.Spec.ExpirationSeconds)*time.Second)
+	writeJSON(w, http.StatusCreated, v1.TokenRequest{
+		TypeMeta: typeMeta("TokenRequest"),
+		Metadata: v1.ObjectMeta{Name: name, Namespace: namespace, CreationTimestamp: time.Now()},
+		Spec:     req.Spec,
+		Status:   v1.TokenRequestStatus{Token: token, ExpirationTimestamp: expires},
+	})
+}
+
+// handleNodeToken issues the token for the agent of the node named name, so
+// that agents run outside the server, such as hollow ones, are each their own
+// client. A node not registered yet gets a new ID, returned as the token's
+// metadata.uid, which its agent must register it under.
+func handleNodeToken(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != "POST" {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if requestUser(r).Name == anonymousUser {
+		writeError(w, "anonymous users cannot request node tokens", http.StatusForbidden)
+		return
+	}
+	nodesMu.Lock()
+	id := uuid.New().String()
+	if node := findNodeByName(name); node != nil {
+		id = node.ID
+	}
+	nodesMu.Unlock()
+	writeJSON(w, http.StatusCreated, v1.TokenRequest{
+		TypeMeta: typeMeta("TokenRequest"),
+		Metadata: v1.ObjectMeta{Name: name, UID: id, CreationTimestamp: time.Now()},
+		Status:   v1.TokenRequestStatus{Token: nodeToken(name, id)},
+	})
+}
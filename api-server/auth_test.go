@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "example.com/m/api/v1"
+)
+
+// useAuthentication configures authentication for the rest of the test.
+func useAuthentication(t *testing.T, tokenFile, keyFile string, anonymous bool) {
+	t.Helper()
+	t.Cleanup(func() { configureAuthentication("", "", true) })
+	if err := configureAuthentication(tokenFile, keyFile, anonymous); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestFile writes content to name in a temporary directory.
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// authenticateToken authenticates a request bearing token.
+func authenticateToken(token string) (*userInfo, error) {
+	r := httptest.NewRequest("GET", "/api/v1/pods", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return authenticate(r)
+}
+
+func TestSignedTokens(t *testing.T) {
+	useAuthentication(t, "", writeTestFile(t, "sa.key", strings.Repeat("k", 32)+"\n"), false)
+
+	token, expires := serviceAccountToken("prod", "deployer", 0)
+	if !expires.IsZero() {
+		t.Errorf("a token without a TTL expires at %v", expires)
+	}
+	user, err := authenticateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "system:serviceaccount:prod:deployer" {
+		t.Errorf("token is for %q", user.Name)
+	}
+	for _, group := range []string{groupServiceAccounts, "system:serviceaccounts:prod", groupAuthenticated} {
+		if !user.inGroup(group) {
+			t.Errorf("%s is not in %s: %v", user.Name, group, user.Groups)
+		}
+	}
+
+	// Changing the claims, the signature or the key invalidates the token.
+	encoded, sig, _ := strings.Cut(strings.TrimPrefix(token, signedTokenPrefix), ".")
+	claims, _ := json.Marshal(tokenClaims{Subject: "admin", Groups: []string{"system:masters"}})
+	forged := signedTokenPrefix + base64.RawURLEncoding.EncodeToString(claims) + "." + sig
+	for name, bad := range map[string]string{
+		"forged claims":     forged,
+		"another signature": signedTokenPrefix + encoded + "." + base64.RawURLEncoding.EncodeToString([]byte("not the mac")),
+		"no signature":      signedTokenPrefix + encoded,
+		"bad encoding":      signedTokenPrefix + "!!." + sig,
+		"unsigned token":    "some-static-token",
+	} {
+		if _, err := authenticateToken(bad); !errors.Is(err, errUnauthorized) {
+			t.Errorf("%s: got %v, want unauthorized", name, err)
+		}
+	}
+	useAuthentication(t, "", writeTestFile(t, "other.key", strings.Repeat("o", 32)), false)
+	if _, err := authenticateToken(token); !errors.Is(err, errUnauthorized) || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("token signed with another key: got %v", err)
+	}
+}
+
+func TestSignedTokenExpiry(t *testing.T) {
+	useAuthentication(t, "", "", false)
+	token, expires := serviceAccountToken("default", "ci", time.Hour)
+	if d := time.Until(expires); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("token expires in %v, want an hour", d)
+	}
+	if _, err := authenticateToken(token); err != nil {
+		t.Errorf("unexpired token: %v", err)
+	}
+
+	expired := signToken(tokenClaims{Subject: "system:serviceaccount:default:ci", IssuedAt: time.Now().Add(-2 * time.Hour).Unix(), ExpiresAt: time.Now().Add(-time.Hour).Unix()})
+	if _, err := authenticateToken(expired); !errors.Is(err, errUnauthorized) || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expired token: got %v", err)
+	}
+	if _, err := authenticateToken(signToken(tokenClaims{IssuedAt: time.Now().Unix()})); !errors.Is(err, errUnauthorized) {
+		t.Errorf("token without a subject: got %v", err)
+	}
+}
+
+func TestSigningKeyMustBeLongEnough(t *testing.T) {
+	t.Cleanup(func() { configureAuthentication("", "", true) })
+	if err := configureAuthentication("", writeTestFile(t, "sa.key", "short"), true); err == nil {
+		t.Error("accepted a 5-byte signing key")
+	}
+}
+
+func TestStaticTokensAndAnonymous(t *testing.T) {
+	tokens := writeTestFile(t, "tokens.csv", `# token,user,uid,groups
+admin-token,admin,1,"system:masters,ops"
+viewer-token,viewer,2
+`)
+	useAuthentication(t, tokens, "", false)
+	user, err := authenticateToken("admin-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "admin" || user.UID != "1" || !user.inGroup("system:masters") || !user.inGroup("ops") || !user.inGroup(groupAuthenticated) {
+		t.Errorf("admin-token is %+v", user)
+	}
+	if _, err := authenticateToken("wrong-token"); !errors.Is(err, errUnauthorized) {
+		t.Errorf("unknown token: got %v", err)
+	}
+	if _, err := authenticateToken(""); !errors.Is(err, errUnauthorized) {
+		t.Errorf("no credentials without anonymous auth: got %v", err)
+	}
+
+	useAuthentication(t, tokens, "", true)
+	if user, err := authenticateToken(""); err != nil || user.Name != anonymousUser || !user.inGroup(groupUnauthenticated) {
+		t.Errorf("no credentials with anonymous auth: %+v, %v", user, err)
+	}
+	// Bad credentials are refused even when anonymous requests are allowed.
+	if _, err := authenticateToken("wrong-token"); !errors.Is(err, errUnauthorized) {
+		t.Errorf("unknown token with anonymous auth: got %v", err)
+	}
+
+	for name, content := range map[string]string{
+		"too few fields":  "token,user\n",
+		"no user":         "token,,1\n",
+		"a signed prefix": signedTokenPrefix + "x,user,1\n",
+	} {
+		if _, err := loadTokenFile(writeTestFile(t, "bad.csv", content)); err == nil {
+			t.Errorf("token file with %s was loaded", name)
+		}
+	}
+}
+
+func TestWithAuthentication(t *testing.T) {
+	useAuthentication(t, writeTestFile(t, "tokens.csv", "admin-token,admin,1\n"), "", false)
+	var got *userInfo
+	handler := withAuthentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = requestUser(r)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/pods", nil))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("request without credentials: got %d, WWW-Authenticate %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	if got == nil {
+		t.Error("/health needs credentials")
+	}
+
+	r := httptest.NewRequest("GET", "/api/v1/whoami", nil)
+	r.Header.Set("Authorization", "Bearer admin-token")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if got == nil || got.Name != "admin" {
+		t.Errorf("handler saw %+v, want admin", got)
+	}
+}
+
+func TestServiceAccountTokenRequests(t *testing.T) {
+	useAuthentication(t, "", "", true)
+	request := func(user *userInfo, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/v1/namespaces/prod/serviceaccounts/ci/token", strings.NewReader(body))
+		r.SetPathValue("namespace", "prod")
+		r.SetPathValue("name", "ci")
+		r = r.WithContext(context.WithValue(r.Context(), userContextKey{}, user))
+		w := httptest.NewRecorder()
+		handleServiceAccountToken(w, r)
+		return w
+	}
+	admin := &userInfo{Name: "admin", Groups: []string{"system:masters"}}
+
+	if w := request(&userInfo{Name: anonymousUser}, ""); w.Code != http.StatusForbidden {
+		t.Errorf("anonymous request: got %d", w.Code)
+	}
+	if w := request(admin, `{"spec":{"expirationSeconds":-1}}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("negative expiry: got %d", w.Code)
+	}
+	w := request(admin, `{"spec":{"expirationSeconds":600}}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("token request: got %d: %s", w.Code, w.Body)
+	}
+	var resp v1.TokenRequest
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status.ExpirationTimestamp.IsZero() {
+		t.Error("a 10-minute token has no expiry")
+	}
+	if user := tokenUser(t, resp.Status.Token); user.Name != "system:serviceaccount:prod:ci" {
+		t.Errorf("issued token is for %q", user.Name)
+	}
+}
+
+func TestTokenOfReplacedNodeIsRefused(t *testing.T) {
+	resetState(t)
+	useAuthentication(t, "", "", true)
+	registerTestNode(t, "node-0001", "worker-1", 4)
+	if user := tokenUser(t, nodeToken("worker-1", "node-0001")); user.UID != "node-0001" || !user.inGroup(groupNodes) {
+		t.Errorf("node token is for %+v", user)
+	}
+
+	// The ID now belongs to a node of another name.
+	if _, err := authenticateToken(nodeToken("worker-2", "node-0001")); !errors.Is(err, errUnauthorized) {
+		t.Errorf("token of a replaced node: got %v", err)
+	}
+	// A node the server lost may register again with its token.
+	if _, err := authenticateToken(nodeToken("worker-3", "node-0003")); err != nil {
+		t.Errorf("token of an unknown node: %v", err)
+	}
+}
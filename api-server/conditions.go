@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "example.com/m/api/v1"
+)
+
+// parseSimulatedUsage parses the value of the simulated-usage annotation.
+// Resources left out are at zero usage and the network is up unless it is
+// "down".
+func parseSimulatedUsage(value string) (*v1.NodeUsage, error) {
+	usage := &v1.NodeUsage{}
+	for _, field := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not key=value", field)
+		}
+		if key == "network" {
+			switch val {
+			case "up":
+				usage.NetworkUnavailable = false
+			case "down":
+				usage.NetworkUnavailable = true
+			default:
+				return nil, fmt.Errorf("network must be up or down, not %q", val)
+			}
+			continue
+		}
+		percent, err := strconv.ParseFloat(val, 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("%s must be a percentage between 0 and 100, not %q", key, val)
+		}
+		switch key {
+		case "memory":
+			usage.MemoryPercent = percent
+		case "disk":
+			usage.DiskPercent = percent
+		case "pids":
+			usage.PIDPercent = percent
+		default:
+			return nil, fmt.Errorf("unknown resource %q; want memory, disk, pids or network", key)
+		}
+	}
+	return usage, nil
+}
+
+func validateSimulatedUsage(annotations map[string]string) error {
+	value, ok := annotations[v1.SimulatedUsageAnnotation]
+	if !ok {
+		return nil
+	}
+	if _, err := parseSimulatedUsage(value); err != nil {
+		return invalidError("annotation %s: %v", v1.SimulatedUsageAnnotation, err)
+	}
+	return nil
+}
+
+// simulatedUsage returns the usage node's agent should act on instead of its
+// real usage, or nil. Callers must hold nodesMu.
+func simulatedUsage(node *Node) *v1.NodeUsage {
+	value, ok := node.Annotations[v1.SimulatedUsageAnnotation]
+	if !ok {
+		return nil
+	}
+	usage, err := parseSimulatedUsage(value)
+	if err != nil {
+		return nil
+	}
+	return usage
+}
+
+func findCondition(conditions []v1.NodeCondition, conditionType string) *v1.NodeCondition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// conditionAbnormal tells whether c describes a node that should get no new
+// pods: Ready not True, or a pressure condition True.
+func conditionAbnormal(c v1.NodeCondition) bool {
+	if c.Type == v1.NodeReady {
+		return c.Status == v1.ConditionFalse
+	}
+	return c.Status == v1.ConditionTrue
+}
+
+// nodeSchedulable tells whether the scheduler may place pods on node: it is
+// Healthy, not reported unready and under no pressure. Callers must hold
+// nodesMu.
+func nodeSchedulable(node *Node) bool {
+	if node.HealthStatus != "Healthy" {
+		return false
+	}
+	for _, c := range node.Conditions {
+		if conditionAbnormal(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// setCondition stores c on node, keeping its transition time unless its
+// status changed, and reports whether it did. Callers must hold nodesMu.
+func setCondition(node *Node, c v1.NodeCondition, now time.Time) (previous v1.NodeCondition, transitioned bool) {
+	c.LastHeartbeatTime = now
+	c.LastTransitionTime = now
+	old := findCondition(node.Conditions, c.Type)
+	if old == nil {
+		node.Conditions = append(node.Conditions, c)
+		return v1.NodeCondition{}, true
+	}
+	previous = *old
+	if old.Status == c.Status {
+		c.LastTransitionTime = old.LastTransitionTime
+	}
+	*old = c
+	return previous, previous.Status != c.Status
+}
+
+// recordConditions stores the conditions and usage node's agent reported.
+// The API server keeps the times. A condition turning abnormal records a
+// Warning event and one recovering a Normal event; a first report that all
+// is well records none. Callers must hold nodesMu.
+func recordConditions(node *Node, conditions []v1.NodeCondition, usage *v1.NodeUsage) {
+	if usage != nil {
+		node.Usage = copyUsage(usage)
+	}
+	now := time.Now()
+	for _, c := range conditions {
+		previous, transitioned := setCondition(node, c, now)
+		switch {
+		case !transitioned:
+		case conditionAbnormal(c):
+			recordEvent(nodeRef(node), sourceNodeAgent, v1.EventTypeWarning, c.Reason, "%s is %s: %s", c.Type, c.Status, c.Message)
+		case previous.Type != "" && conditionAbnormal(previous) && c.Status != v1.ConditionUnknown:
+			recordEvent(nodeRef(node), sourceNodeAgent, v1.EventTypeNormal, c.Reason, "%s is %s: %s", c.Type, c.Status, c.Message)
+		}
+	}
+}
+
+// markConditionsUnknown sets every condition node has to Unknown, once its
+// agent can no longer tell. Callers must hold nodesMu.
+func markConditionsUnknown(node *Node, reason, message string) {
+	now := time.Now()
+	for _, c := range node.Conditions {
+		setCondition(node, v1.NodeCondition{Type: c.Type, Status: v1.ConditionUnknown, Reason: reason, Message: message}, now)
+	}
+}
+
+// recordEvictions takes the pods node's agent evicted under pressure off the
+// node and reschedules them elsewhere. Callers must hold nodesMu.
+func recordEvictions(node *Node, evictions map[string]string) {
+	if len(evictions) == 0 {
+		return
+	}
+	var evicted []string
+	podsMu.Lock()
+	for podID, reason := range evictions {
+		pod, exists := pods[podID]
+		if !exists || pod.NodeID != node.ID || !containsString(node.Pods, podID) ||
+			pod.Status == podSucceeded || pod.Status == podFailed {
+			continue
+		}
+		pod.Status = "Rescheduling"
+		pod.Process = nil
+		if err := podChanged(EventModified, pod); err != nil {
+			// The pod stays on the node, which reports it again with its
+			// next heartbeat.
+			log.Printf("Evicting pod %s: %v", podID, err)
+			continue
+		}
+		node.Pods = removeFromSlice(node.Pods, podID)
+		node.AvailableCPU += pod.CPURequired
+		recordEvent(podRef(pod), sourceNodeAgent, v1.EventTypeWarning, "Evicted", "Evicted from node %s: %s", node.Name, reason)
+		podEvictions.inc(node.Name)
+		fmt.Printf("%s%s[!] %sPod %s evicted from node %s: %s%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, shortID(podID), node.Name, reason, NC)
+		evicted = append(evicted, podID)
+	}
+	podsMu.Unlock()
+
+	// The node is locked until the heartbeat is answered, by which time its
+	// conditions keep the scheduler from placing the pods back on it.
+	for _, podID := range evicted {
+		go reschedulePod(podID, fmt.Sprintf("after eviction from node %s", node.Name))
+	}
+}
+
+func copyUsage(usage *v1.NodeUsage) *v1.NodeUsage {
+	if usage == nil {
+		return nil
+	}
+	c := *usage
+	return &c
+}
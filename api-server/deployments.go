@@ -0,0 +1,554 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "example.com/m/api/v1"
+	"example.com/m/client/informer"
+	"github.com/google/uuid"
+)
+
+const (
+	deploymentSyncInterval = 2 * time.Second
+	templateHashAnnotation = "kube-sim.io/template-hash"
+)
+
+// Deployment keeps Replicas pods built from Template running. Pods created
+// from an older template are replaced one at a time, surging by one pod.
+type Deployment struct {
+	Name               string
+	Namespace          string
+	Replicas           int
+	Selector           map[string]string
+	Template           PodTemplate
+	Labels             map[string]string
+	Annotations        map[string]string
+	ReadyReplicas      int
+	UpdatedReplicas    int
+	CreatedAt          time.Time
+	ResourceVersion    uint64
+	Generation         int64
+	ObservedGeneration int64
+}
+
+type PodTemplate struct {
+	Labels      map[string]string
+	CPURequired int
+	// Command, Env and Priority are left out of the hash when empty, so
+	// templates from before they existed keep their hash.
+	Command  []string    `json:",omitempty"`
+	Env      []v1.EnvVar `json:",omitempty"`
+	Priority int         `json:",omitempty"`
+}
+
+// copy returns a copy of t that shares nothing with it.
+func (t PodTemplate) copy() PodTemplate {
+	return PodTemplate{
+		Labels:      copyLabels(t.Labels),
+		CPURequired: t.CPURequired,
+		Command:     copyStrings(t.Command),
+		Env:         copyEnv(t.Env),
+		Priority:    t.Priority,
+	}
+}
+
+var (
+	deployments   = make(map[string]*Deployment)
+	deploymentsMu sync.Mutex
+)
+
+func deploymentKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+func (d *Deployment) ownerRef() string {
+	return "Deployment/" + deploymentKey(d.Namespace, d.Name)
+}
+
+// hash identifies the pod template so pods from an older one can be
+// told apart from current ones.
+func (t PodTemplate) hash() string {
+	data, _ := json.Marshal(t)
+	h := fnv.New32a()
+	h.Write(data)
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+func validateDeployment(d *Deployment) error {
+	if d.Name == "" {
+		return fmt.Errorf("deployment name is required")
+	}
+	if d.Replicas < 0 {
+		return fmt.Errorf("replicas must not be negative")
+	}
+	if d.Template.CPURequired <= 0 {
+		return fmt.Errorf("template CPU required must be positive")
+	}
+	if len(d.Selector) == 0 {
+		return fmt.Errorf("selector must not be empty")
+	}
+	for k, v := range d.Selector {
+		if d.Template.Labels[k] != v {
+			return fmt.Errorf("selector %s=%s does not match the template labels", k, v)
+		}
+	}
+	for _, labels := range []map[string]string{d.Selector, d.Template.Labels, d.Labels} {
+		if err := validateLabels(labels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createDeployment validates and stores d; the controller creates its pods.
+func createDeployment(d *Deployment) error {
+	desired := toV1Deployment(d)
+	if err := admit(v1.OperationCreate, &desired, nil); err != nil {
+		return err
+	}
+	d.Namespace = desired.Metadata.Namespace
+	d.Labels = desired.Metadata.Labels
+	d.Annotations = desired.Metadata.Annotations
+	d.Replicas = desired.Spec.Replicas
+	d.Selector = desired.Spec.Selector
+	d.Template = templateFromV1(desired.Spec.Template)
+	if err := validateDeployment(d); err != nil {
+		return err
+	}
+
+	deploymentsMu.Lock()
+	defer deploymentsMu.Unlock()
+	key := deploymentKey(d.Namespace, d.Name)
+	if _, exists := deployments[key]; exists {
+		return fmt.Errorf("%w: deployment %s", errNameTaken, key)
+	}
+	d.CreatedAt = time.Now()
+	d.Generation = 1
+	deployments[key] = d
+	if err := deploymentChanged(EventAdded, d); err != nil {
+		return err
+	}
+	fmt.Printf("%s%s[✓] %sDeployment %s created with %d replicas%s\n", NEON_GREEN, BOLD, NEON_CYAN, key, d.Replicas, NC)
+	return nil
+}
+
+// updateDeploymentSpec changes replicas and template, bumping the generation
+// when either differs. Callers must hold deploymentsMu.
+func updateDeploymentSpec(d *Deployment, replicas int, template PodTemplate) error {
+	updated := *d
+	updated.Replicas = replicas
+	updated.Template = template
+	if err := validateDeployment(&updated); err != nil {
+		return invalidError("%v", err)
+	}
+	if replicas == d.Replicas && template.hash() == d.Template.hash() {
+		return nil
+	}
+	d.Replicas = replicas
+	d.Template = template.copy()
+	d.Generation++
+	if err := deploymentChanged(EventModified, d); err != nil {
+		return err
+	}
+	log.Printf("Deployment %s updated to %d replicas (generation %d)\n", deploymentKey(d.Namespace, d.Name), d.Replicas, d.Generation)
+	return nil
+}
+
+// updateDeployment is updateNode for deployments. The selector cannot be
+// changed.
+func updateDeployment(namespace, name string, expectRV uint64, change func(d *v1.Deployment)) (*v1.Deployment, error) {
+	key := deploymentKey(namespace, name)
+	deploymentsMu.Lock()
+	d, exists := deployments[key]
+	if !exists {
+		deploymentsMu.Unlock()
+		return nil, fmt.Errorf("deployment %s: %w", key, errNotFound)
+	}
+	if expectRV != 0 && d.ResourceVersion != expectRV {
+		deploymentsMu.Unlock()
+		return nil, conflictError("Deployment", key, d.ResourceVersion, expectRV)
+	}
+	old, desired := toV1Deployment(d), toV1Deployment(d)
+	deploymentsMu.Unlock()
+
+	change(&desired)
+	if desired.Spec.Selector != nil && !labelsEqual(desired.Spec.Selector, old.Spec.Selector) {
+		return nil, invalidError("spec.selector is immutable")
+	}
+	if err := admit(v1.OperationUpdate, &desired, &old); err != nil {
+		return nil, err
+	}
+
+	deploymentsMu.Lock()
+	defer deploymentsMu.Unlock()
+	d, exists = deployments[key]
+	if !exists {
+		return nil, fmt.Errorf("deployment %s: %w", key, errNotFound)
+	}
+	if d.ResourceVersion != old.Metadata.ResourceVersion {
+		return nil, conflictError("Deployment", key, d.ResourceVersion, old.Metadata.ResourceVersion)
+	}
+	if err := updateDeploymentSpec(d, desired.Spec.Replicas, templateFromV1(desired.Spec.Template)); err != nil {
+		return nil, err
+	}
+	if !labelsEqual(d.Labels, desired.Metadata.Labels) || !labelsEqual(d.Annotations, desired.Metadata.Annotations) {
+		d.Labels = copyLabels(desired.Metadata.Labels)
+		d.Annotations = copyLabels(desired.Metadata.Annotations)
+		if err := deploymentChanged(EventModified, d); err != nil {
+			return nil, err
+		}
+	}
+	updated := toV1Deployment(d)
+	return &updated, nil
+}
+
+// deleteDeployment removes the deployment and every pod it owns.
+func deleteDeployment(namespace, name string) error {
+	deploymentsMu.Lock()
+	key := deploymentKey(namespace, name)
+	d, exists := deployments[key]
+	if !exists {
+		deploymentsMu.Unlock()
+		return errNotFound
+	}
+	delete(deployments, key)
+	if err := deploymentChanged(EventDeleted, d); err != nil {
+		deploymentsMu.Unlock()
+		return err
+	}
+	owner := d.ownerRef()
+	deploymentsMu.Unlock()
+
+	podsMu.Lock()
+	var owned []string
+	for id, pod := range pods {
+		if pod.Owner == owner {
+			owned = append(owned, id)
+		}
+	}
+	podsMu.Unlock()
+	for _, id := range owned {
+		deletePod(id)
+	}
+
+	fmt.Printf("%s%s[✓] %sDeployment %s deleted with %d pods%s\n", NEON_GREEN, BOLD, NEON_CYAN, key, len(owned), NC)
+	return nil
+}
+
+// deploymentController syncs a deployment whenever it, or one of the pods it
+// owns, changes. The deployment informer resyncs every
+// deploymentSyncInterval, so deployments that could not converge, for lack
+// of node capacity say, are retried.
+func deploymentController() {
+	queue := newWorkQueue()
+	deploymentInformer.AddEventHandler(informer.HandlerFuncs{
+		OnAdd:    func(obj v1.Object) { queue.add(informer.Key(obj)) },
+		OnUpdate: func(_, obj v1.Object) { queue.add(informer.Key(obj)) },
+	})
+	enqueueOwner := func(obj v1.Object) {
+		if key, ok := strings.CutPrefix(obj.GetObjectMeta().Owner, "Deployment/"); ok {
+			queue.add(key)
+		}
+	}
+	podInformer.AddEventHandler(informer.HandlerFuncs{
+		OnUpdate: func(_, obj v1.Object) { enqueueOwner(obj) },
+		OnDelete: enqueueOwner,
+	})
+
+	for {
+		key := queue.get()
+		// Only the lease holder acts; the others' informers still resync,
+		// so a replica that takes over picks every deployment up.
+		if controllersActive() {
+			syncDeployment(key)
+		}
+	}
+}
+
+// syncDeployment moves one deployment a step closer to its spec: missing pods
+// are created, surplus pods deleted (outdated ones first), and while pods
+// from an older template remain one new pod is surged in per sync.
+func syncDeployment(key string) {
+	deploymentsMu.Lock()
+	d, exists := deployments[key]
+	if !exists {
+		deploymentsMu.Unlock()
+		return
+	}
+	replicas := d.Replicas
+	template := d.Template.copy()
+	hash := template.hash()
+	owner := d.ownerRef()
+	namespace, name, generation := d.Namespace, d.Name, d.Generation
+	ref := deploymentRef(d)
+	deploymentsMu.Unlock()
+
+	type ownedPod struct {
+		id        string
+		current   bool
+		createdAt time.Time
+	}
+	var owned []ownedPod
+	podsMu.Lock()
+	for id, pod := range pods {
+		if pod.Owner == owner {
+			owned = append(owned, ownedPod{
+				id:        id,
+				current:   pod.Annotations[templateHashAnnotation] == hash,
+				createdAt: pod.CreatedAt,
+			})
+		}
+	}
+	podsMu.Unlock()
+
+	// Outdated pods first, then newest first, so deletions remove the pods we
+	// want to get rid of most.
+	sort.Slice(owned, func(i, j int) bool {
+		if owned[i].current != owned[j].current {
+			return !owned[i].current
+		}
+		return owned[i].createdAt.After(owned[j].createdAt)
+	})
+	outdated := 0
+	for _, p := range owned {
+		if !p.current {
+			outdated++
+		}
+	}
+
+	create := 0
+	var remove []string
+	switch {
+	case len(owned) < replicas:
+		create = replicas - len(owned)
+	case len(owned) > replicas:
+		for _, p := range owned[:len(owned)-replicas] {
+			remove = append(remove, p.id)
+		}
+	case outdated > 0:
+		create = 1
+	}
+
+	for i := 0; i < create; i++ {
+		pod, err := createPod(&Pod{
+			Name:        fmt.Sprintf("%s-%s", name, uuid.New().String()[:5]),
+			Namespace:   namespace,
+			CPURequired: template.CPURequired,
+			Command:     template.Command,
+			Env:         template.Env,
+			Priority:    template.Priority,
+			Labels:      template.Labels,
+			Annotations: map[string]string{templateHashAnnotation: hash},
+			Owner:       owner,
+		})
+		if err != nil {
+			fmt.Printf("%s%s[✗] %sDeployment %s failed to create pod: %v%s\n", NEON_RED, BOLD, NEON_PINK, key, err, NC)
+			recordEvent(ref, sourceDeploymentController, v1.EventTypeWarning, "FailedCreate", "Error creating pod: %v", err)
+			break
+		}
+		recordEvent(ref, sourceDeploymentController, v1.EventTypeNormal, "SuccessfulCreate", "Created pod %s", pod.Name)
+	}
+	for _, id := range remove {
+		podsMu.Lock()
+		podName := ""
+		if pod, ok := pods[id]; ok {
+			podName = pod.Name
+		}
+		podsMu.Unlock()
+		if deletePod(id) == nil {
+			recordEvent(ref, sourceDeploymentController, v1.EventTypeNormal, "SuccessfulDelete", "Deleted pod %s", podName)
+		}
+	}
+
+	ready, updated := 0, 0
+	podsMu.Lock()
+	for _, pod := range pods {
+		if pod.Owner == owner && pod.Annotations[templateHashAnnotation] == hash {
+			updated++
+			if pod.Status == "Running" {
+				ready++
+			}
+		}
+	}
+	podsMu.Unlock()
+
+	deploymentsMu.Lock()
+	defer deploymentsMu.Unlock()
+	if d, exists := deployments[key]; exists {
+		if d.ReadyReplicas != ready || d.UpdatedReplicas != updated || d.ObservedGeneration != generation {
+			d.ReadyReplicas = ready
+			d.UpdatedReplicas = updated
+			d.ObservedGeneration = generation
+			deploymentChanged(EventModified, d)
+		}
+	}
+}
+
+func handleDeployments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		opts, err := parseListOptions(r, deploymentFieldNames)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if isWatchRequest(r) {
+			serveWatch(w, r, "Deployment", &deploymentsMu, deploymentSnapshots, func(obj interface{}) bool {
+				d := obj.(Deployment)
+				return opts.matches(d.Labels, deploymentFields(&d))
+			}, nil)
+			return
+		}
+
+		deploymentsMu.Lock()
+		defer deploymentsMu.Unlock()
+		keys := make([]string, 0, len(deployments))
+		for key := range deployments {
+			keys = append(keys, key)
+		}
+		selected, next := opts.page(keys, func(key string) bool {
+			return opts.matches(deployments[key].Labels, deploymentFields(deployments[key]))
+		})
+		result := make(map[string]*Deployment, len(selected))
+		for _, key := range selected {
+			result[key] = deployments[key]
+		}
+
+		w.Header().Set("X-Resource-Version", fmt.Sprint(watches.currentResourceVersion()))
+		if next != "" {
+			w.Header().Set("X-Continue", next)
+		}
+		json.NewEncoder(w).Encode(result)
+
+	case "POST":
+		var req struct {
+			Name      string            `json:"name"`
+			Namespace string            `json:"namespace"`
+			Replicas  int               `json:"replicas"`
+			Selector  map[string]string `json:"selector"`
+			Labels    map[string]string `json:"labels"`
+			Template  struct {
+				Labels      map[string]string `json:"labels"`
+				CPURequired int               `json:"cpuRequired"`
+			} `json:"template"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		d := &Deployment{
+			Name:      req.Name,
+			Namespace: req.Namespace,
+			Replicas:  req.Replicas,
+			Selector:  req.Selector,
+			Labels:    req.Labels,
+			Template:  PodTemplate{Labels: req.Template.Labels, CPURequired: req.Template.CPURequired},
+		}
+		err := createDeployment(d)
+		if errors.Is(err, errNameTaken) {
+			writeStatus(w, http.StatusConflict, v1.StatusReasonAlreadyExists, err.Error())
+			return
+		}
+		if err != nil {
+			writeErrorFor(w, err, http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": fmt.Sprintf("Deployment %s created with %d replicas", deploymentKey(d.Namespace, d.Name), d.Replicas),
+			"name":    d.Name,
+		})
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleDeploymentOperations serves /deployments/{namespace}/{name}.
+func handleDeploymentOperations(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) != 4 {
+		writeError(w, "Invalid URL, expected /deployments/{namespace}/{name}", http.StatusBadRequest)
+		return
+	}
+	namespace, name := parts[2], parts[3]
+	key := deploymentKey(namespace, name)
+
+	switch r.Method {
+	case "GET":
+		deploymentsMu.Lock()
+		defer deploymentsMu.Unlock()
+		d, exists := deployments[key]
+		if !exists {
+			writeError(w, "Deployment not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+
+	case "PUT":
+		var req struct {
+			Replicas        int    `json:"replicas"`
+			ResourceVersion uint64 `json:"resourceVersion"`
+			Template        *struct {
+				Labels      map[string]string `json:"labels"`
+				CPURequired int               `json:"cpuRequired"`
+			} `json:"template"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.ResourceVersion == 0 {
+			writeError(w, "resourceVersion is required", http.StatusBadRequest)
+			return
+		}
+
+		_, err := updateDeployment(namespace, name, req.ResourceVersion, func(d *v1.Deployment) {
+			d.Spec.Replicas = req.Replicas
+			if req.Template != nil {
+				d.Spec.Template.Metadata.Labels = req.Template.Labels
+				d.Spec.Template.Spec.CPURequired = req.Template.CPURequired
+			}
+		})
+		if err != nil {
+			writeErrorFor(w, err, http.StatusBadRequest)
+			return
+		}
+		deploymentsMu.Lock()
+		defer deploymentsMu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(deployments[key])
+
+	case "DELETE":
+		if err := deleteDeployment(namespace, name); errors.Is(err, errNotCommitted) {
+			writeErrorFor(w, err, http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			writeError(w, "Deployment not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Deployment deleted successfully",
+			"name":    name,
+		})
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "example.com/m/api/v1"
+)
+
+// maxEvents bounds how many events are kept; the least recently seen are
+// dropped first.
+const maxEvents = 1000
+
+// Event sources, naming the component that reported an event.
+const (
+	sourceAPIServer            = "api-server"
+	sourceScheduler            = "scheduler"
+	sourceHealthMonitor        = "health-monitor"
+	sourceDeploymentController = "deployment-controller"
+	sourceNodeAgent            = "node-agent"
+)
+
+// Event is the stored form of a v1.Event. Events are named after the object
+// they are about plus a unique suffix, and live in that object's namespace,
+// or in the default namespace for nodes.
+type Event struct {
+	Name            string
+	Namespace       string
+	InvolvedObject  v1.ObjectReference
+	Reason          string
+	Message         string
+	Type            string
+	Count           int
+	FirstTimestamp  time.Time
+	LastTimestamp   time.Time
+	Source          string
+	ResourceVersion uint64
+}
+
+var (
+	events   = make(map[string]*Event)
+	eventsMu sync.Mutex
+	// eventsByKey finds the event a repeat should be folded into.
+	eventsByKey = make(map[string]*Event)
+	// eventSeq keeps event names unique within the same nanosecond.
+	eventSeq uint64
+
+	eventFieldNames = []string{
+		"metadata.name", "metadata.namespace", "involvedObject.kind", "involvedObject.name",
+		"involvedObject.namespace", "involvedObject.uid", "reason", "type", "source",
+	}
+)
+
+func (e *Event) dedupKey() string {
+	ref := e.InvolvedObject
+	return ref.Kind + "/" + ref.Namespace + "/" + ref.Name + "/" + ref.UID + "\x00" + e.Type + "\x00" + e.Reason + "\x00" + e.Message + "\x00" + e.Source
+}
+
+func nodeRef(node *Node) v1.ObjectReference {
+	return v1.ObjectReference{Kind: "Node", Name: node.Name, UID: node.ID}
+}
+
+func podRef(pod *Pod) v1.ObjectReference {
+	return v1.ObjectReference{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name, UID: pod.ID}
+}
+
+func deploymentRef(d *Deployment) v1.ObjectReference {
+	return v1.ObjectReference{Kind: "Deployment", Namespace: d.Namespace, Name: d.Name}
+}
+
+// recordEvent records that something happened to the object ref points at.
+// An identical event already on record has its count and last timestamp
+// bumped instead. It may be called with any store lock held.
+func recordEvent(ref v1.ObjectReference, source, eventType, reason, format string, args ...interface{}) {
+	now := time.Now()
+	e := &Event{
+		Namespace:      ref.Namespace,
+		InvolvedObject: ref,
+		Reason:         reason,
+		Message:        fmt.Sprintf(format, args...),
+		Type:           eventType,
+		Count:          1,
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Source:         source,
+	}
+	if e.Namespace == "" {
+		e.Namespace = defaultNamespace
+	}
+	key := e.dedupKey()
+
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+
+	if existing, ok := eventsByKey[key]; ok {
+		existing.Count++
+		existing.LastTimestamp = now
+		eventChanged(EventModified, existing)
+		return
+	}
+
+	eventSeq++
+	e.Name = fmt.Sprintf("%s.%x%04x", ref.Name, now.UnixNano(), eventSeq%0x10000)
+	events[e.Name] = e
+	eventsByKey[key] = e
+	eventChanged(EventAdded, e)
+
+	if len(events) > maxEvents {
+		var oldest *Event
+		for _, candidate := range events {
+			if oldest == nil || candidate.LastTimestamp.Before(oldest.LastTimestamp) {
+				oldest = candidate
+			}
+		}
+		delete(events, oldest.Name)
+		delete(eventsByKey, oldest.dedupKey())
+		eventChanged(EventDeleted, oldest)
+	}
+}
+
+func toV1Event(e *Event) v1.Event {
+	return v1.Event{
+		TypeMeta: typeMeta("Event"),
+		Metadata: v1.ObjectMeta{
+			Name:              e.Name,
+			Namespace:         e.Namespace,
+			ResourceVersion:   e.ResourceVersion,
+			CreationTimestamp: e.FirstTimestamp,
+		},
+		InvolvedObject: e.InvolvedObject,
+		Reason:         e.Reason,
+		Message:        e.Message,
+		Type:           e.Type,
+		Count:          e.Count,
+		FirstTimestamp: e.FirstTimestamp,
+		LastTimestamp:  e.LastTimestamp,
+		Source:         e.Source,
+	}
+}
+
+func eventFields(e *Event) map[string]string {
+	return map[string]string{
+		"metadata.name":            e.Name,
+		"metadata.namespace":       e.Namespace,
+		"involvedObject.kind":      e.InvolvedObject.Kind,
+		"involvedObject.name":      e.InvolvedObject.Name,
+		"involvedObject.namespace": e.InvolvedObject.Namespace,
+		"involvedObject.uid":       e.InvolvedObject.UID,
+		"reason":                   e.Reason,
+		"type":                     e.Type,
+		"source":                   e.Source,
+	}
+}
+
+// handleV1Events lists and watches events. Events are written only by the
+// server itself, so the collection is read-only. Lists are ordered by last
+// occurrence, oldest first.
+func handleV1Events(w http.ResponseWriter, r *http.Request) {
+	namespace := r.PathValue("namespace")
+	if r.Method != "GET" {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	opts, err := parseListOptions(r, eventFieldNames)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	match := func(e *Event) bool {
+		return (namespace == "" || e.Namespace == namespace) && opts.matches(nil, eventFields(e))
+	}
+
+	if isWatchRequest(r) {
+		serveWatch(w, r, "Event", &eventsMu, eventSnapshots, func(obj interface{}) bool {
+			e := obj.(Event)
+			return match(&e)
+		}, func(obj interface{}) interface{} {
+			e := obj.(Event)
+			return toV1Event(&e)
+		})
+		return
+	}
+
+	list := v1.EventList{TypeMeta: typeMeta("EventList"), Items: []v1.Event{}}
+	eventsMu.Lock()
+	names := make([]string, 0, len(events))
+	for name := range events {
+		names = append(names, name)
+	}
+	selected, next := opts.page(names, func(name string) bool { return match(events[name]) })
+	for _, name := range selected {
+		list.Items = append(list.Items, toV1Event(events[name]))
+	}
+	list.Metadata = v1.ListMeta{ResourceVersion: watches.currentResourceVersion(), Continue: next}
+	eventsMu.Unlock()
+
+	sort.SliceStable(list.Items, func(i, j int) bool {
+		return list.Items[i].LastTimestamp.Before(list.Items[j].LastTimestamp)
+	})
+	writeList(w, list.Metadata, list)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// flowQueueLength is how many requests one flow may have waiting at a
+	// priority level before further ones are rejected.
+	flowQueueLength = 50
+	// flowQueueWait is how long a request waits for a seat before it is
+	// rejected.
+	flowQueueWait = 10 * time.Second
+	// bucketIdleTimeout is how long an unused client rate limit is kept.
+	bucketIdleTimeout = 5 * time.Minute
+)
+
+// Priority levels. Requests from system:masters, probes and CORS preflights
+// are exempt; nodes get their own level so heartbeats are never starved by
+// users; service accounts in kube-system are the system controllers; all
+// other traffic, authenticated or not, shares the workload level.
+const (
+	priorityExempt   = "exempt"
+	priorityNodeHigh = "node-high"
+	prioritySystem   = "system"
+	priorityWorkload = "workload"
+)
+
+// priorityShares divides -max-requests-inflight between the levels.
+var priorityShares = []struct {
+	name   string
+	shares int
+}{
+	{priorityNodeHigh, 40},
+	{prioritySystem, 30},
+	{priorityWorkload, 100},
+}
+
+// priorityLevel limits how many requests of one level run at once. Requests
+// beyond that wait in a queue per flow (the requesting user), and a freed
+// seat goes to the flows in turn, so one busy client cannot crowd out the
+// others at its level.
+type priorityLevel struct {
+	name  string
+	seats int
+
+	mu       sync.Mutex
+	inFlight int
+	queues   map[string][]chan struct{}
+	// order lists the flows with waiting requests; next is the one served
+	// when a seat frees.
+	order []string
+	next  int
+}
+
+var (
+	priorityLevels map[string]*priorityLevel
+
+	clientQPS   float64
+	clientBurst int
+
+	bucketsMu sync.Mutex
+	buckets   = make(map[string]*tokenBucket)
+)
+
+// configureFlowControl sets up the priority levels and client rate limits.
+// maxInflight zero disables the levels and qps zero the rate limits.
+func configureFlowControl(maxInflight int, qps float64, burst int) {
+	clientQPS, clientBurst = qps, burst
+	if clientQPS > 0 {
+		go sweepBuckets()
+	}
+	if maxInflight <= 0 {
+		return
+	}
+	total := 0
+	for _, p := range priorityShares {
+		total += p.shares
+	}
+	priorityLevels = make(map[string]*priorityLevel)
+	for _, p := range priorityShares {
+		seats := maxInflight * p.shares / total
+		if seats < 1 {
+			seats = 1
+		}
+		priorityLevels[p.name] = &priorityLevel{name: p.name, seats: seats, queues: make(map[string][]chan struct{})}
+	}
+}
+
+// classify picks the priority level of a request.
+func classify(r *http.Request, user *userInfo) string {
+	switch {
+	case r.Method == "OPTIONS" || r.URL.Path == "/health" || user.inGroup("system:masters"):
+		return priorityExempt
+	case user.inGroup(groupNodes):
+		return priorityNodeHigh
+	case user.inGroup(groupServiceAccounts + ":kube-system"):
+		return prioritySystem
+	}
+	return priorityWorkload
+}
+
+// flowKey identifies the client a request is fair-queued and rate limited
+// as: the user, or the source address for anonymous requests.
+func flowKey(r *http.Request, user *userInfo) string {
+	if user.Name == anonymousUser {
+		ips := sourceIPs(r)
+		return anonymousUser + "@" + ips[len(ips)-1]
+	}
+	return user.Name
+}
+
+// acquire waits for a seat at the level. It returns false when the flow's
+// queue is full or no seat freed up in time.
+func (pl *priorityLevel) acquire(ctx context.Context, flow string) bool {
+	pl.mu.Lock()
+	if pl.inFlight < pl.seats && len(pl.order) == 0 {
+		pl.inFlight++
+		pl.mu.Unlock()
+		return true
+	}
+	queue := pl.queues[flow]
+	if len(queue) >= flowQueueLength {
+		pl.mu.Unlock()
+		return false
+	}
+	granted := make(chan struct{})
+	if len(queue) == 0 {
+		pl.order = append(pl.order, flow)
+	}
+	pl.queues[flow] = append(queue, granted)
+	pl.mu.Unlock()
+
+	timer := time.NewTimer(flowQueueWait)
+	defer timer.Stop()
+	select {
+	case <-granted:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	select {
+	case <-granted:
+		// The seat arrived while giving up; hand it on.
+		pl.releaseLocked()
+	default:
+		pl.dequeueLocked(flow, granted)
+	}
+	return false
+}
+
+func (pl *priorityLevel) release() {
+	pl.mu.Lock()
+	pl.releaseLocked()
+	pl.mu.Unlock()
+}
+
+// releaseLocked gives the freed seat to the next flow in turn, or returns it
+// when nobody is waiting. Callers must hold pl.mu.
+func (pl *priorityLevel) releaseLocked() {
+	if len(pl.order) == 0 {
+		pl.inFlight--
+		return
+	}
+	if pl.next >= len(pl.order) {
+		pl.next = 0
+	}
+	flow := pl.order[pl.next]
+	queue := pl.queues[flow]
+	close(queue[0])
+	if len(queue) == 1 {
+		delete(pl.queues, flow)
+		pl.order = append(pl.order[:pl.next], pl.order[pl.next+1:]...)
+	} else {
+		pl.queues[flow] = queue[1:]
+		pl.next++
+	}
+}
+
+// dequeueLocked removes a waiter that gave up. Callers must hold pl.mu.
+func (pl *priorityLevel) dequeueLocked(flow string, waiter chan struct{}) {
+	queue := pl.queues[flow]
+	for i, ch := range queue {
+		if ch == waiter {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) > 0 {
+		pl.queues[flow] = queue
+		return
+	}
+	delete(pl.queues, flow)
+	for i, f := range pl.order {
+		if f == flow {
+			pl.order = append(pl.order[:i], pl.order[i+1:]...)
+			if pl.next > i {
+				pl.next--
+			}
+			break
+		}
+	}
+}
+
+// tokenBucket is one client's rate limit: it refills at clientQPS up to
+// clientBurst tokens, and each request takes one.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allowRequest takes a token from key's bucket, or reports how long until
+// one is available.
+func allowRequest(key string) (bool, time.Duration) {
+	now := time.Now()
+	bucketsMu.Lock()
+	defer bucketsMu.Unlock()
+	b, ok := buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(clientBurst), last: now}
+		buckets[key] = b
+	}
+	b.tokens = math.Min(float64(clientBurst), b.tokens+now.Sub(b.last).Seconds()*clientQPS)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / clientQPS * float64(time.Second))
+}
+
+// sweepBuckets forgets clients that have been idle long enough for their
+// bucket to be full again.
+func sweepBuckets() {
+	for range time.Tick(time.Minute) {
+		bucketsMu.Lock()
+		for key, b := range buckets {
+			if time.Since(b.last) > bucketIdleTimeout {
+				delete(buckets, key)
+			}
+		}
+		bucketsMu.Unlock()
+	}
+}
+
+// tooManyRequests sheds a request with 429 and a whole-second Retry-After.
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration, format string, args ...interface{}) {
+	secs := int(math.Ceil(retryAfter.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	writeError(w, fmt.Sprintf(format, args...), http.StatusTooManyRequests)
+}
+
+// withFlowControl applies the client rate limits and priority levels to
+// authenticated requests. Watches are rate limited but hold no seat, since
+// they stay open.
+func withFlowControl(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		level := classify(r, user)
+		w.Header().Set("X-Priority-Level", level)
+		if level == priorityExempt {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		flow := flowKey(r, user)
+		if clientQPS > 0 {
+			if ok, wait := allowRequest(flow); !ok {
+				tooManyRequests(w, wait, "client %s exceeded its rate limit of %g requests per second", flow, clientQPS)
+				return
+			}
+		}
+		pl := priorityLevels[level]
+		if pl == nil || isWatchRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !pl.acquire(r.Context(), flow) {
+			tooManyRequests(w, time.Second, "too many requests at priority level %s, try again later", level)
+			return
+		}
+		defer pl.release()
+		next.ServeHTTP(w, r)
+	})
+}
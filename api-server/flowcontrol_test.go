@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		method, path string
+		user         *userInfo
+		want         string
+	}{
+		{"GET", "/api/v1/pods", &userInfo{Name: "root", Groups: []string{"system:masters"}}, priorityExempt},
+		{"GET", "/health", &userInfo{Name: anonymousUser}, priorityExempt},
+		{"OPTIONS", "/api/v1/pods", &userInfo{Name: anonymousUser}, priorityExempt},
+		{"POST", "/api/v1/heartbeat", &userInfo{Name: "system:node:worker-1", Groups: []string{groupNodes}}, priorityNodeHigh},
+		{"GET", "/api/v1/pods", &userInfo{Name: "system:serviceaccount:kube-system:controller", Groups: []string{groupServiceAccounts + ":kube-system"}}, prioritySystem},
+		{"GET", "/api/v1/pods", &userInfo{Name: "system:serviceaccount:dev:ci", Groups: []string{groupServiceAccounts + ":dev"}}, priorityWorkload},
+		{"GET", "/api/v1/pods", &userInfo{Name: anonymousUser}, priorityWorkload},
+	} {
+		if got := classify(httptest.NewRequest(tc.method, tc.path, nil), tc.user); got != tc.want {
+			t.Errorf("%s %s as %s: %s, want %s", tc.method, tc.path, tc.user.Name, got, tc.want)
+		}
+	}
+
+	// Anonymous clients are told apart by address.
+	r := httptest.NewRequest("GET", "/api/v1/pods", nil)
+	r.RemoteAddr = "10.0.0.7:5555"
+	if got := flowKey(r, &userInfo{Name: anonymousUser}); got != anonymousUser+"@10.0.0.7" {
+		t.Errorf("anonymous flow is %q", got)
+	}
+}
+
+func TestConfigureFlowControlSharesSeats(t *testing.T) {
+	t.Cleanup(func() { priorityLevels = nil })
+	configureFlowControl(170, 0, 0)
+	for level, want := range map[string]int{priorityNodeHigh: 40, prioritySystem: 30, priorityWorkload: 100} {
+		if got := priorityLevels[level].seats; got != want {
+			t.Errorf("%s has %d seats, want %d", level, got, want)
+		}
+	}
+	configureFlowControl(1, 0, 0)
+	for level, pl := range priorityLevels {
+		if pl.seats != 1 {
+			t.Errorf("%s has %d seats with 1 in flight, want 1", level, pl.seats)
+		}
+	}
+}
+
+// waiting counts the requests of flow queued at pl.
+func waiting(pl *priorityLevel, flow string) int {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	return len(pl.queues[flow])
+}
+
+// queue starts a request of flow waiting at pl and waits until it is
+// queued. Its flow name is sent on granted once it gets a seat.
+func queue(t *testing.T, ctx context.Context, pl *priorityLevel, flow string, granted chan<- string) {
+	t.Helper()
+	before := waiting(pl, flow)
+	go func() {
+		if pl.acquire(ctx, flow) {
+			granted <- flow
+		} else {
+			granted <- flow + " rejected"
+		}
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for waiting(pl, flow) == before {
+		if time.Now().After(deadline) {
+			t.Fatalf("request of %s was not queued", flow)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPriorityLevelServesFlowsInTurn(t *testing.T) {
+	pl := &priorityLevel{name: priorityWorkload, seats: 1, queues: make(map[string][]chan struct{})}
+	if !pl.acquire(context.Background(), "busy") {
+		t.Fatal("no seat at an idle level")
+	}
+
+	// The busy client queues three more, then a quiet one asks once: it is
+	// served second, not last.
+	granted := make(chan string, 10)
+	for i := 0; i < 3; i++ {
+		queue(t, context.Background(), pl, "busy", granted)
+	}
+	queue(t, context.Background(), pl, "quiet", granted)
+	var order []string
+	for i := 0; i < 4; i++ {
+		pl.release()
+		select {
+		case flow := <-granted:
+			order = append(order, flow)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no seat handed on after %v", order)
+		}
+	}
+	if fmt.Sprint(order) != "[busy quiet busy busy]" {
+		t.Errorf("seats went to %v, want [busy quiet busy busy]", order)
+	}
+	pl.release()
+	if pl.inFlight != 0 || len(pl.order) != 0 {
+		t.Errorf("after every release: %d in flight, %v waiting", pl.inFlight, pl.order)
+	}
+}
+
+func TestPriorityLevelRejectsFullQueues(t *testing.T) {
+	pl := &priorityLevel{name: priorityWorkload, seats: 1, queues: make(map[string][]chan struct{})}
+	pl.acquire(context.Background(), "busy")
+	ctx, cancel := context.WithCancel(context.Background())
+	granted := make(chan string, flowQueueLength+1)
+	for i := 0; i < flowQueueLength; i++ {
+		queue(t, ctx, pl, "busy", granted)
+	}
+	if pl.acquire(context.Background(), "busy") {
+		t.Error("a request was queued beyond the flow's limit")
+	}
+
+	// Requests that give up leave the queue, and the seat is not lost.
+	cancel()
+	for i := 0; i < flowQueueLength; i++ {
+		if flow := <-granted; flow != "busy rejected" {
+			t.Fatalf("cancelled request: %s", flow)
+		}
+	}
+	if waiting(pl, "busy") != 0 || len(pl.order) != 0 {
+		t.Errorf("%d cancelled requests still queued", waiting(pl, "busy"))
+	}
+	pl.release()
+	if !pl.acquire(context.Background(), "quiet") {
+		t.Error("the seat was lost")
+	}
+}
+
+func TestClientRateLimit(t *testing.T) {
+	clientQPS, clientBurst = 10, 3
+	t.Cleanup(func() {
+		clientQPS, clientBurst = 0, 0
+		bucketsMu.Lock()
+		buckets = make(map[string]*tokenBucket)
+		bucketsMu.Unlock()
+	})
+	for i := 0; i < 3; i++ {
+		if ok, _ := allowRequest("alice"); !ok {
+			t.Fatalf("request %d within the burst was refused", i+1)
+		}
+	}
+	ok, wait := allowRequest("alice")
+	if ok || wait <= 0 || wait > 100*time.Millisecond {
+		t.Errorf("request beyond the burst: allowed %v, wait %v", ok, wait)
+	}
+	if ok, _ := allowRequest("bob"); !ok {
+		t.Error("another client was limited")
+	}
+	time.Sleep(110 * time.Millisecond)
+	if ok, _ := allowRequest("alice"); !ok {
+		t.Error("no token after the refill")
+	}
+
+	handler := withFlowControl(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(user *userInfo) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/v1/pods", nil)
+		r = r.WithContext(context.WithValue(r.Context(), userContextKey{}, user))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	w := serve(&userInfo{Name: "alice"})
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" || w.Header().Get("X-Priority-Level") != priorityWorkload {
+		t.Errorf("limited request: got %d, Retry-After %q, level %q", w.Code, w.Header().Get("Retry-After"), w.Header().Get("X-Priority-Level"))
+	}
+	root := &userInfo{Name: "alice", Groups: []string{"system:masters"}}
+	if w := serve(root); w.Code != http.StatusOK {
+		t.Errorf("exempt request: got %d", w.Code)
+	}
+}
@@ -2,4 +2,10 @@ module api-server
 
 go 1.23.4
 
-require github.com/google/uuid v1.6.0 // indirect
+require github.com/google/uuid v1.6.0
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require example.com/m v0.0.0-00010101000000-000000000000
+
+replace example.com/m => ../
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Hollow nodes are nodes whose agents are goroutines of the API server,
+// created in bulk to load the scheduler and health monitor at scale. They
+// are ordinary nodes otherwise, labelled hollowNodeLabel.
+const (
+	hollowNodePrefix = "hollow-node-"
+	hollowNodeLabel  = "kube-sim.io/hollow"
+)
+
+func hollowNodeName(i int) string {
+	return fmt.Sprintf("%s%05d", hollowNodePrefix, i)
+}
+
+// ensureHollowNodes creates whichever of the count hollow nodes do not exist
+// yet. Those restored from storage already have their agents relaunched.
+func ensureHollowNodes(count, cpuCores int) {
+	start := time.Now()
+	created := 0
+	for i := 1; i <= count; i++ {
+		name := hollowNodeName(i)
+		nodesMu.Lock()
+		exists := findNodeByName(name) != nil
+		nodesMu.Unlock()
+		if exists {
+			continue
+		}
+		_, err := createNode(name, cpuCores, map[string]string{hollowNodeLabel: "true"}, nil)
+		switch {
+		case errors.Is(err, errNameTaken):
+		case err != nil:
+			log.Printf("Creating hollow node %s: %v", name, err)
+		default:
+			created++
+		}
+	}
+	fmt.Printf("%s%s[✓] %sCreated %d of %d hollow nodes in %s%s\n",
+		NEON_GREEN, BOLD, NEON_CYAN, created, count, time.Since(start).Round(time.Millisecond), NC)
+}
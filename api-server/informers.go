@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	v1 "example.com/m/api/v1"
+	"example.com/m/client"
+	"example.com/m/client/informer"
+)
+
+// informerResync is how often the server's own informers replay their cache
+// to handlers, so controllers recheck objects nothing has changed.
+const informerResync = 30 * time.Second
+
+var (
+	nodeInformer       = informer.New(localListWatch("Node", &nodesMu, nodeSnapshots), informerResync)
+	podInformer        = informer.New(localListWatch("Pod", &podsMu, podSnapshots), informerResync)
+	deploymentInformer = informer.New(localListWatch("Deployment", &deploymentsMu, deploymentSnapshots), deploymentSyncInterval)
+)
+
+// localListWatch feeds an informer straight from the store and the watch
+// cache, without going through HTTP. snapshots is called with mu held, like
+// the list of serveWatch.
+func localListWatch(kind string, mu *sync.Mutex, snapshots func() []interface{}) *informer.ListWatch {
+	return &informer.ListWatch{
+		ListFunc: func(ctx context.Context) ([]v1.Object, uint64, error) {
+			mu.Lock()
+			objs := snapshots()
+			rv := watches.currentResourceVersion()
+			mu.Unlock()
+
+			list := make([]v1.Object, len(objs))
+			for i, obj := range objs {
+				list[i] = v1Object(obj)
+			}
+			return list, rv, nil
+		},
+		WatchFunc: func(ctx context.Context, resourceVersion uint64, handle func(client.Event)) error {
+			wt, backlog, err := watches.watch(kind, resourceVersion, nil)
+			if errors.Is(err, errResourceVersionTooOld) {
+				return &v1.Status{
+					TypeMeta: typeMeta("Status"),
+					Status:   v1.StatusFailure,
+					Message:  fmt.Sprintf("resourceVersion %d is too old", resourceVersion),
+					Reason:   v1.StatusReasonExpired,
+					Code:     http.StatusGone,
+				}
+			}
+			defer watches.stop(wt)
+
+			for _, ev := range backlog {
+				handle(client.Event{Type: ev.Type, ResourceVersion: ev.ResourceVersion, Object: v1Object(ev.Object)})
+			}
+			for {
+				select {
+				case ev, ok := <-wt.ch:
+					if !ok {
+						// Fell behind; the informer resumes from the last event.
+						return nil
+					}
+					handle(client.Event{Type: ev.Type, ResourceVersion: ev.ResourceVersion, Object: v1Object(ev.Object)})
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		},
+	}
+}
+
+// v1Object converts a snapshot from the watch cache.
+func v1Object(obj interface{}) v1.Object {
+	switch o := obj.(type) {
+	case Node:
+		n := toV1Node(&o)
+		return &n
+	case Pod:
+		p := toV1Pod(&o)
+		return &p
+	case Deployment:
+		d := toV1Deployment(&o)
+		return &d
+	case Event:
+		e := toV1Event(&o)
+		return &e
+	}
+	panic(fmt.Sprintf("unexpected object %T", obj))
+}
+
+// startInformers runs the server's informers and waits for their first
+// list. Handlers registered afterwards are replayed the cached objects.
+func startInformers(ctx context.Context) {
+	for _, inf := range []*informer.Informer{nodeInformer, podInformer, deploymentInformer} {
+		go inf.Run(ctx)
+		inf.WaitForSync(ctx)
+	}
+}
+
+// workQueue hands keys to a controller's sync loop. A key added again before
+// it is taken is only synced once.
+type workQueue struct {
+	mu      sync.Mutex
+	keys    []string
+	pending map[string]bool
+	ready   chan struct{}
+}
+
+func newWorkQueue() *workQueue {
+	return &workQueue{pending: make(map[string]bool), ready: make(chan struct{}, 1)}
+}
+
+func (q *workQueue) add(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending[key] {
+		return
+	}
+	q.pending[key] = true
+	q.keys = append(q.keys, key)
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// get blocks until a key is queued and removes it.
+func (q *workQueue) get() string {
+	for {
+		q.mu.Lock()
+		if len(q.keys) > 0 {
+			key := q.keys[0]
+			q.keys = q.keys[1:]
+			delete(q.pending, key)
+			q.mu.Unlock()
+			return key
+		}
+		q.mu.Unlock()
+		<-q.ready
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// jsonPatchOp is one operation of a JSON Patch (RFC 6902).
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// applyJSONPatch applies the JSON Patch in patch to the document doc and
+// returns the patched document. Operations apply in order; if one fails the
+// whole patch is rejected.
+func applyJSONPatch(doc, patch []byte) ([]byte, error) {
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("invalid JSON patch: %v", err)
+	}
+	var root interface{}
+	if err := json.Unmarshal(doc, &root); err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		var err error
+		switch op.Op {
+		case "add", "replace", "test":
+			var value interface{}
+			if len(op.Value) == 0 {
+				err = fmt.Errorf("missing value")
+				break
+			}
+			if err = json.Unmarshal(op.Value, &value); err != nil {
+				break
+			}
+			switch op.Op {
+			case "add":
+				root, err = patchAdd(root, op.Path, value)
+			case "replace":
+				if _, err = patchGet(root, op.Path); err == nil {
+					root, err = patchSet(root, op.Path, value)
+				}
+			case "test":
+				var current interface{}
+				if current, err = patchGet(root, op.Path); err == nil && !reflect.DeepEqual(current, value) {
+					err = fmt.Errorf("test failed")
+				}
+			}
+		case "remove":
+			root, _, err = patchRemove(root, op.Path)
+		case "move":
+			var value interface{}
+			if root, value, err = patchRemove(root, op.From); err == nil {
+				root, err = patchAdd(root, op.Path, value)
+			}
+		case "copy":
+			var value interface{}
+			if value, err = patchGet(root, op.From); err == nil {
+				root, err = patchAdd(root, op.Path, deepCopyJSON(value))
+			}
+		default:
+			err = fmt.Errorf("unknown op %q", op.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("JSON patch operation %d (%s %s): %v", i, op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(root)
+}
+
+// splitPointer parses a JSON Pointer (RFC 6901) into its reference tokens.
+func splitPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	return tokens, nil
+}
+
+func patchGet(root interface{}, pointer string) (interface{}, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	current := root
+	for _, t := range tokens {
+		switch c := current.(type) {
+		case map[string]interface{}:
+			v, ok := c[t]
+			if !ok {
+				return nil, fmt.Errorf("%q not found", t)
+			}
+			current = v
+		case []interface{}:
+			i, err := strconv.Atoi(t)
+			if err != nil || i < 0 || i >= len(c) {
+				return nil, fmt.Errorf("index %q out of range", t)
+			}
+			current = c[i]
+		default:
+			return nil, fmt.Errorf("cannot descend into %q", t)
+		}
+	}
+	return current, nil
+}
+
+// patchParent resolves everything but the last token of pointer.
+func patchParent(root interface{}, pointer string) (interface{}, string, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(tokens) == 0 {
+		return nil, "", nil
+	}
+	parentPointer := ""
+	for _, t := range tokens[:len(tokens)-1] {
+		parentPointer += "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(t)
+	}
+	parent, err := patchGet(root, parentPointer)
+	return parent, tokens[len(tokens)-1], err
+}
+
+// patchAdd inserts value at pointer: into an object, or before an array
+// index, with "-" appending.
+func patchAdd(root interface{}, pointer string, value interface{}) (interface{}, error) {
+	if pointer == "" {
+		return value, nil
+	}
+	parent, last, err := patchParent(root, pointer)
+	if err != nil {
+		return nil, err
+	}
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[last] = value
+		return root, nil
+	case []interface{}:
+		i := len(p)
+		if last != "-" {
+			if i, err = strconv.Atoi(last); err != nil || i < 0 || i > len(p) {
+				return nil, fmt.Errorf("index %q out of range", last)
+			}
+		}
+		grown := append(p[:i:i], append([]interface{}{value}, p[i:]...)...)
+		return patchReplaceParent(root, pointer, grown)
+	}
+	return nil, fmt.Errorf("cannot add to a %T", parent)
+}
+
+// patchSet replaces the existing value at pointer.
+func patchSet(root interface{}, pointer string, value interface{}) (interface{}, error) {
+	if pointer == "" {
+		return value, nil
+	}
+	parent, last, err := patchParent(root, pointer)
+	if err != nil {
+		return nil, err
+	}
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[last] = value
+	case []interface{}:
+		i, _ := strconv.Atoi(last)
+		p[i] = value
+	}
+	return root, nil
+}
+
+func patchRemove(root interface{}, pointer string) (interface{}, interface{}, error) {
+	value, err := patchGet(root, pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pointer == "" {
+		return nil, value, nil
+	}
+	parent, last, _ := patchParent(root, pointer)
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		delete(p, last)
+		return root, value, nil
+	case []interface{}:
+		i, _ := strconv.Atoi(last)
+		shrunk := append(p[:i:i], p[i+1:]...)
+		root, err = patchReplaceParent(root, pointer, shrunk)
+		return root, value, err
+	}
+	return nil, nil, fmt.Errorf("cannot remove from a %T", parent)
+}
+
+// patchReplaceParent stores a resized array in place of the array that holds
+// the element pointer refers to.
+func patchReplaceParent(root interface{}, pointer string, array []interface{}) (interface{}, error) {
+	return patchSet(root, pointer[:strings.LastIndex(pointer, "/")], array)
+}
+
+func deepCopyJSON(v interface{}) interface{} {
+	data, _ := json.Marshal(v)
+	var c interface{}
+	json.Unmarshal(data, &c)
+	return c
+}
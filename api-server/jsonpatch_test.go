@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplyJSONPatch(t *testing.T) {
+	const doc = `{"metadata":{"name":"web","labels":{"app":"web"}},"spec":{"command":["sleep","60"]}}`
+	for _, tc := range []struct {
+		name, patch, want string
+	}{
+		{"add to an object", `[{"op":"add","path":"/metadata/labels/team","value":"a"}]`,
+			`{"metadata":{"labels":{"app":"web","team":"a"},"name":"web"},"spec":{"command":["sleep","60"]}}`},
+		{"add before an index", `[{"op":"add","path":"/spec/command/1","value":"-v"}]`,
+			`{"metadata":{"labels":{"app":"web"},"name":"web"},"spec":{"command":["sleep","-v","60"]}}`},
+		{"append", `[{"op":"add","path":"/spec/command/-","value":"now"}]`,
+			`{"metadata":{"labels":{"app":"web"},"name":"web"},"spec":{"command":["sleep","60","now"]}}`},
+		{"remove", `[{"op":"remove","path":"/spec/command/0"},{"op":"remove","path":"/metadata/labels"}]`,
+			`{"metadata":{"name":"web"},"spec":{"command":["60"]}}`},
+		{"replace", `[{"op":"replace","path":"/spec/command","value":["true"]}]`,
+			`{"metadata":{"labels":{"app":"web"},"name":"web"},"spec":{"command":["true"]}}`},
+		{"move", `[{"op":"move","from":"/metadata/labels/app","path":"/metadata/labels/role"}]`,
+			`{"metadata":{"labels":{"role":"web"},"name":"web"},"spec":{"command":["sleep","60"]}}`},
+		{"copy", `[{"op":"copy","from":"/spec/command","path":"/spec/args"},{"op":"add","path":"/spec/args/-","value":"x"}]`,
+			`{"metadata":{"labels":{"app":"web"},"name":"web"},"spec":{"args":["sleep","60","x"],"command":["sleep","60"]}}`},
+		{"test then change", `[{"op":"test","path":"/metadata/name","value":"web"},{"op":"replace","path":"/metadata/name","value":"api"}]`,
+			`{"metadata":{"labels":{"app":"web"},"name":"api"},"spec":{"command":["sleep","60"]}}`},
+		{"escaped pointer", `[{"op":"add","path":"/metadata/labels/example.com~1tier","value":"x~y"},{"op":"add","path":"/metadata/labels/a~0b","value":"1"}]`,
+			`{"metadata":{"labels":{"app":"web","a~b":"1","example.com/tier":"x~y"},"name":"web"},"spec":{"command":["sleep","60"]}}`},
+		{"whole document", `[{"op":"replace","path":"","value":{"kind":"Pod"}}]`, `{"kind":"Pod"}`},
+	} {
+		got, err := applyJSONPatch([]byte(doc), []byte(tc.patch))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if string(got) != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestApplyJSONPatchRejects(t *testing.T) {
+	const doc = `{"metadata":{"name":"web"},"spec":{"command":["sleep","60"]}}`
+	for _, tc := range []struct {
+		name, patch, want string
+	}{
+		{"a failed test", `[{"op":"replace","path":"/metadata/name","value":"api"},{"op":"test","path":"/metadata/name","value":"web"}]`, "test failed"},
+		{"replacing what is missing", `[{"op":"replace","path":"/metadata/labels","value":{}}]`, `"labels" not found`},
+		{"removing what is missing", `[{"op":"remove","path":"/spec/command/2"}]`, "out of range"},
+		{"adding past the end", `[{"op":"add","path":"/spec/command/3","value":"x"}]`, "out of range"},
+		{"adding under a missing parent", `[{"op":"add","path":"/status/phase","value":"x"}]`, `"status" not found`},
+		{"a path without /", `[{"op":"add","path":"metadata","value":1}]`, "must start with /"},
+		{"a missing value", `[{"op":"add","path":"/metadata/uid"}]`, "missing value"},
+		{"an unknown op", `[{"op":"merge","path":"/metadata"}]`, `unknown op "merge"`},
+		{"a malformed patch", `{"op":"add"}`, "invalid JSON patch"},
+	} {
+		_, err := applyJSONPatch([]byte(doc), []byte(tc.patch))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.name, err, tc.want)
+		}
+	}
+}
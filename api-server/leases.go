@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	v1 "example.com/m/api/v1"
+	"example.com/m/client/leaderelection"
+	"github.com/google/uuid"
+)
+
+// The lease the server's own controllers run under: the health monitor, the
+// scheduler loop and the deployment controller act only on the replica
+// holding it.
+const (
+	controllerLeaseNamespace = "kube-system"
+	controllerLeaseName      = "kube-sim-controllers"
+	controllerLeaseDuration  = 15 * time.Second
+	controllerRenewDeadline  = 10 * time.Second
+	controllerRetryPeriod    = 2 * time.Second
+)
+
+var (
+	// leases is keyed by namespace/name.
+	leases   = make(map[string]*v1.Lease)
+	leasesMu sync.Mutex
+
+	leaseFieldNames = []string{"metadata.name", "metadata.namespace", "spec.holderIdentity"}
+
+	// controllerElector campaigns for the controller lease; it is nil until
+	// startControllerElection.
+	controllerElector *leaderelection.LeaderElector
+)
+
+func leaseKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+func copyLease(l *v1.Lease) *v1.Lease {
+	data, _ := json.Marshal(l)
+	c := &v1.Lease{}
+	json.Unmarshal(data, c)
+	return c
+}
+
+// leaseChanged bumps the lease's resourceVersion and records the change.
+// Callers must hold leasesMu.
+func leaseChanged(eventType string, l *v1.Lease) error {
+	return watches.notify("Lease", eventType, l.Metadata.ResourceVersion, func(rv uint64) interface{} {
+		l.Metadata.ResourceVersion = rv
+		return copyLease(l)
+	})
+}
+
+func validateLease(l *v1.Lease) error {
+	if l.Spec.LeaseDurationSeconds <= 0 {
+		return invalidError("spec.leaseDurationSeconds must be positive")
+	}
+	return nil
+}
+
+// createLease stores l, returning a copy of what was stored.
+func createLease(l *v1.Lease) (*v1.Lease, error) {
+	if err := validateLease(l); err != nil {
+		return nil, err
+	}
+	key := leaseKey(l.Metadata.Namespace, l.Metadata.Name)
+	leasesMu.Lock()
+	defer leasesMu.Unlock()
+	if _, exists := leases[key]; exists {
+		return nil, fmt.Errorf("%w: Lease %s", errNameTaken, key)
+	}
+	l.TypeMeta = typeMeta("Lease")
+	l.Metadata.UID = uuid.New().String()
+	l.Metadata.CreationTimestamp = time.Now()
+	l.Metadata.Generation = 1
+	leases[key] = l
+	if err := leaseChanged(EventAdded, l); err != nil {
+		return nil, err
+	}
+	return copyLease(l), nil
+}
+
+// updateLease replaces the stored lease named like l, which must still be at
+// expectedRV: of two candidates racing to take a lease over, only one wins.
+func updateLease(l *v1.Lease, expectedRV uint64) (*v1.Lease, error) {
+	if err := validateLease(l); err != nil {
+		return nil, err
+	}
+	key := leaseKey(l.Metadata.Namespace, l.Metadata.Name)
+	leasesMu.Lock()
+	defer leasesMu.Unlock()
+	cur, exists := leases[key]
+	if !exists {
+		return nil, fmt.Errorf("Lease %s: %w", key, errNotFound)
+	}
+	if cur.Metadata.ResourceVersion != expectedRV {
+		return nil, conflictError("Lease", key, cur.Metadata.ResourceVersion, expectedRV)
+	}
+	l.TypeMeta = typeMeta("Lease")
+	l.Metadata.UID = cur.Metadata.UID
+	l.Metadata.CreationTimestamp = cur.Metadata.CreationTimestamp
+	l.Metadata.Generation = cur.Metadata.Generation
+	// Renewals are routine; only a new holder or term is a change of spec.
+	if cur.Spec.HolderIdentity != l.Spec.HolderIdentity || cur.Spec.LeaseDurationSeconds != l.Spec.LeaseDurationSeconds {
+		l.Metadata.Generation++
+	}
+	leases[key] = l
+	if err := leaseChanged(EventModified, l); err != nil {
+		return nil, err
+	}
+	return copyLease(l), nil
+}
+
+func deleteLease(namespace, name string) error {
+	key := leaseKey(namespace, name)
+	leasesMu.Lock()
+	defer leasesMu.Unlock()
+	l, exists := leases[key]
+	if !exists {
+		return fmt.Errorf("Lease %s: %w", key, errNotFound)
+	}
+	delete(leases, key)
+	return leaseChanged(EventDeleted, l)
+}
+
+// leaseSnapshots copies every lease for serveWatch. It is called with
+// leasesMu held.
+func leaseSnapshots() []interface{} {
+	objs := make([]interface{}, 0, len(leases))
+	for _, l := range leases {
+		objs = append(objs, copyLease(l))
+	}
+	return objs
+}
+
+// handleV1Leases serves list, watch and create of leases, across all
+// namespaces when the path has none.
+func handleV1Leases(w http.ResponseWriter, r *http.Request) {
+	namespace := r.PathValue("namespace")
+
+	switch r.Method {
+	case "GET":
+		opts, err := parseListOptions(r, leaseFieldNames)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		match := func(l *v1.Lease) bool {
+			fields := map[string]string{
+				"metadata.name":       l.Metadata.Name,
+				"metadata.namespace":  l.Metadata.Namespace,
+				"spec.holderIdentity": l.Spec.HolderIdentity,
+			}
+			return (namespace == "" || l.Metadata.Namespace == namespace) && opts.matches(l.Metadata.Labels, fields)
+		}
+
+		if isWatchRequest(r) {
+			serveWatch(w, r, "Lease", &leasesMu, leaseSnapshots, func(obj interface{}) bool {
+				return match(obj.(*v1.Lease))
+			}, func(obj interface{}) interface{} { return obj })
+			return
+		}
+
+		list := v1.LeaseList{TypeMeta: typeMeta("LeaseList"), Items: []v1.Lease{}}
+		leasesMu.Lock()
+		keys := make([]string, 0, len(leases))
+		for key := range leases {
+			keys = append(keys, key)
+		}
+		selected, next := opts.page(keys, func(key string) bool { return match(leases[key]) })
+		for _, key := range selected {
+			list.Items = append(list.Items, *copyLease(leases[key]))
+		}
+		list.Metadata = v1.ListMeta{ResourceVersion: watches.currentResourceVersion(), Continue: next}
+		leasesMu.Unlock()
+		writeList(w, list.Metadata, list)
+
+	case "POST":
+		if namespace == "" {
+			writeError(w, "leases are created in a namespace: POST /api/v1/namespaces/{namespace}/leases", http.StatusMethodNotAllowed)
+			return
+		}
+		var l v1.Lease
+		if !decodeBody(w, r, &l) || !checkObjectMeta(w, &l.Metadata, namespace, "") {
+			return
+		}
+		l.Metadata.Namespace = namespace
+		created, err := createLease(&l)
+		if err != nil {
+			writeErrorFor(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+		writeJSON(w, http.StatusCreated, created)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleV1Lease serves get, update and delete of one lease.
+func handleV1Lease(w http.ResponseWriter, r *http.Request) {
+	namespace, name := r.PathValue("namespace"), r.PathValue("name")
+
+	switch r.Method {
+	case "GET":
+		leasesMu.Lock()
+		defer leasesMu.Unlock()
+		l, exists := leases[leaseKey(namespace, name)]
+		if !exists {
+			writeErrorFor(w, fmt.Errorf("Lease %s: %w", leaseKey(namespace, name), errNotFound), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, l)
+
+	case "PUT":
+		var l v1.Lease
+		if !decodeBody(w, r, &l) || !checkObjectMeta(w, &l.Metadata, namespace, name) {
+			return
+		}
+		if l.Metadata.ResourceVersion == 0 {
+			writeError(w, "metadata.resourceVersion is required", http.StatusUnprocessableEntity)
+			return
+		}
+		l.Metadata.Namespace, l.Metadata.Name = namespace, name
+		updated, err := updateLease(&l, l.Metadata.ResourceVersion)
+		if err != nil {
+			writeErrorFor(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+		writeJSON(w, http.StatusOK, updated)
+
+	case "DELETE":
+		if err := deleteLease(namespace, name); err != nil {
+			writeErrorFor(w, err, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, deletedStatus("Lease", namespace, name))
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// localLeaseLock keeps a lease in this server's own store, for the server's
+// controllers. Only a server that may make changes of its own, the Raft
+// leader when replicated, can write it, so the lease follows the Raft
+// leadership once the previous holder's lease runs out.
+type localLeaseLock struct {
+	namespace, name string
+}
+
+func (l *localLeaseLock) Get(ctx context.Context) (*v1.Lease, error) {
+	leasesMu.Lock()
+	defer leasesMu.Unlock()
+	lease, exists := leases[leaseKey(l.namespace, l.name)]
+	if !exists {
+		// The elector creates the lease on the NotFound a client would get.
+		return nil, &v1.Status{
+			TypeMeta: typeMeta("Status"),
+			Status:   v1.StatusFailure,
+			Message:  fmt.Sprintf("Lease %s: %v", leaseKey(l.namespace, l.name), errNotFound),
+			Reason:   v1.StatusReasonNotFound,
+			Code:     http.StatusNotFound,
+		}
+	}
+	return copyLease(lease), nil
+}
+
+func (l *localLeaseLock) Create(ctx context.Context, lease *v1.Lease) (*v1.Lease, error) {
+	if !isLeader() {
+		return nil, errNotLeader
+	}
+	lease.Metadata.Namespace, lease.Metadata.Name = l.namespace, l.name
+	return createLease(lease)
+}
+
+func (l *localLeaseLock) Update(ctx context.Context, lease *v1.Lease) (*v1.Lease, error) {
+	if !isLeader() {
+		return nil, errNotLeader
+	}
+	return updateLease(copyLease(lease), lease.Metadata.ResourceVersion)
+}
+
+// controllerIdentity names this server as a lease holder: its replica ID
+// when replicated, otherwise its hostname, so that a restarted server takes
+// its own lease straight back.
+func controllerIdentity() string {
+	if replication != nil {
+		return replication.id
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		return hostname
+	}
+	return uuid.New().String()
+}
+
+// startControllerElection campaigns for the controller lease for as long as
+// the server runs.
+func startControllerElection() {
+	identity := controllerIdentity()
+	elector, err := leaderelection.New(leaderelection.Config{
+		Lock:          &localLeaseLock{namespace: controllerLeaseNamespace, name: controllerLeaseName},
+		Identity:      identity,
+		LeaseDuration: controllerLeaseDuration,
+		RenewDeadline: controllerRenewDeadline,
+		RetryPeriod:   controllerRetryPeriod,
+		Callbacks: leaderelection.Callbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				fmt.Printf("%s%s[*] %sHolding lease %s/%s as %s; controllers active%s\n",
+					NEON_BLUE, BOLD, NEON_CYAN, controllerLeaseNamespace, controllerLeaseName, identity, NC)
+			},
+			OnStoppedLeading: func() {
+				log.Printf("Lost lease %s/%s; controllers paused", controllerLeaseNamespace, controllerLeaseName)
+			},
+		},
+	})
+	if err != nil {
+		log.Fatal("Configuring leader election: ", err)
+	}
+	controllerElector = elector
+	go elector.Run(context.Background())
+}
+
+// controllersActive reports whether this server's controllers may act: it
+// holds the controller lease and can still make changes.
+func controllersActive() bool {
+	return controllerElector != nil && controllerElector.IsLeader() && isLeader()
+}
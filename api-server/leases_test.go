@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "example.com/m/api/v1"
+	"example.com/m/client/leaderelection"
+)
+
+func testLease(holder string) *v1.Lease {
+	return &v1.Lease{
+		Metadata: v1.ObjectMeta{Namespace: "kube-system", Name: "scheduler"},
+		Spec:     v1.LeaseSpec{HolderIdentity: holder, LeaseDurationSeconds: 15},
+	}
+}
+
+func TestLeaseUpdatesNeedTheCurrentVersion(t *testing.T) {
+	resetState(t)
+	created, err := createLease(testLease("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createLease(testLease("b")); !errors.Is(err, errNameTaken) {
+		t.Errorf("second create: got %v", err)
+	}
+	bad := testLease("a")
+	bad.Metadata.Name = "other"
+	bad.Spec.LeaseDurationSeconds = 0
+	if _, err := createLease(bad); !errors.Is(err, errInvalid) {
+		t.Errorf("lease without a duration: got %v", err)
+	}
+
+	// A renewal by the holder is not a change of spec; a new holder is.
+	renewed, err := updateLease(testLease("a"), created.Metadata.ResourceVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.Metadata.Generation != 1 || renewed.Metadata.UID != created.Metadata.UID {
+		t.Errorf("renewal: generation %d, UID %q", renewed.Metadata.Generation, renewed.Metadata.UID)
+	}
+	taken, err := updateLease(testLease("b"), renewed.Metadata.ResourceVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taken.Metadata.Generation != 2 {
+		t.Errorf("takeover: generation %d, want 2", taken.Metadata.Generation)
+	}
+
+	// Of two candidates that read the same version, the second loses.
+	if _, err := updateLease(testLease("c"), renewed.Metadata.ResourceVersion); !errors.Is(err, errConflict) {
+		t.Errorf("update at a stale version: got %v", err)
+	}
+	if l := leases[leaseKey("kube-system", "scheduler")]; l.Spec.HolderIdentity != "b" {
+		t.Errorf("held by %q after the conflict", l.Spec.HolderIdentity)
+	}
+}
+
+func TestHandleV1Lease(t *testing.T) {
+	resetState(t)
+	created, err := createLease(testLease("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(method, name, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/api/v1/namespaces/kube-system/leases/"+name, strings.NewReader(body))
+		r.SetPathValue("namespace", "kube-system")
+		r.SetPathValue("name", name)
+		w := httptest.NewRecorder()
+		handleV1Lease(w, r)
+		return w
+	}
+	if w := serve("GET", "missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET of a missing lease: got %d", w.Code)
+	}
+	if w := serve("PUT", "scheduler", `{"spec":{"holderIdentity":"b","leaseDurationSeconds":15}}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("PUT without a resourceVersion: got %d", w.Code)
+	}
+	if _, err := updateLease(testLease("a"), created.Metadata.ResourceVersion); err != nil {
+		t.Fatal(err)
+	}
+	stale := fmt.Sprintf(`{"metadata":{"resourceVersion":%d},"spec":{"holderIdentity":"b","leaseDurationSeconds":15}}`, created.Metadata.ResourceVersion)
+	if w := serve("PUT", "scheduler", stale); w.Code != http.StatusConflict {
+		t.Errorf("PUT at a stale version: got %d: %s", w.Code, w.Body)
+	}
+	if w := serve("DELETE", "scheduler", ""); w.Code != http.StatusOK {
+		t.Errorf("DELETE: got %d", w.Code)
+	}
+	if w := serve("DELETE", "scheduler", ""); w.Code != http.StatusNotFound {
+		t.Errorf("second DELETE: got %d", w.Code)
+	}
+}
+
+// campaign runs an elector for identity on the controller lease until the
+// test ends.
+func campaign(t *testing.T, identity string) (*leaderelection.LeaderElector, context.CancelFunc) {
+	t.Helper()
+	le, err := leaderelection.New(leaderelection.Config{
+		Lock:            &localLeaseLock{namespace: controllerLeaseNamespace, name: controllerLeaseName},
+		Identity:        identity,
+		LeaseDuration:   time.Second,
+		RenewDeadline:   500 * time.Millisecond,
+		RetryPeriod:     20 * time.Millisecond,
+		ReleaseOnCancel: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		le.Run(ctx)
+		close(done)
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return le, stop
+}
+
+// eventually waits up to five seconds for cond.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+	}
+}
+
+func TestControllerLeaseChangesHands(t *testing.T) {
+	resetState(t)
+	first, stopFirst := campaign(t, "replica-1")
+	eventually(t, "replica-1 leads", first.IsLeader)
+	second, _ := campaign(t, "replica-2")
+	eventually(t, "replica-2 sees replica-1", func() bool { return second.GetLeader() == "replica-1" })
+	time.Sleep(100 * time.Millisecond)
+	if second.IsLeader() || !first.IsLeader() {
+		t.Fatal("replica-2 took a lease that is being renewed")
+	}
+
+	// Released on shutdown, the lease passes on without waiting it out.
+	stopFirst()
+	eventually(t, "replica-2 leads", second.IsLeader)
+	leasesMu.Lock()
+	defer leasesMu.Unlock()
+	if l := leases[leaseKey(controllerLeaseNamespace, controllerLeaseName)]; l.Spec.HolderIdentity != "replica-2" || l.Spec.LeaseTransitions != 1 {
+		t.Errorf("controller lease after the handover: %+v", l.Spec)
+	}
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"os/exec"
+	"os"
 	"strings"
 	"sync"
 	"time"
 
+	v1 "example.com/m/api/v1"
 	"github.com/google/uuid"
 )
 
@@ -25,22 +30,50 @@ const (
 	NC          = "\033[0m"
 )
 
+// Every stored object carries the resourceVersion of its last change, which
+// updates must echo back, and a Generation that only moves when the spec
+// changes. ObservedGeneration is the generation the status reflects.
 type Node struct {
-	ID             string
-	CPUCores       int
-	AvailableCPU   int
-	Pods           []string
-	HealthStatus   string
-	LastHeartbeat  time.Time
-	HeartbeatCount int
+	ID                 string
+	Name               string
+	CPUCores           int
+	AvailableCPU       int
+	Pods               []string
+	HealthStatus       string
+	LastHeartbeat      time.Time
+	HeartbeatCount     int
+	CreatedAt          time.Time
+	Labels             map[string]string
+	Annotations        map[string]string
+	ResourceVersion    uint64
+	Generation         int64
+	ObservedGeneration int64
+	// AgentVersion is set when the node's agent registers it.
+	AgentVersion string
+	// Conditions and Usage are what the node's agent last reported.
+	Conditions []v1.NodeCondition
+	Usage      *v1.NodeUsage
 }
 
 type Pod struct {
-	ID          string
-	CPURequired int
-	NodeID      string
-	Status      string
-	CreatedAt   time.Time
+	ID                 string
+	Name               string
+	Namespace          string
+	CPURequired        int
+	NodeID             string
+	Status             string
+	CreatedAt          time.Time
+	Labels             map[string]string
+	Annotations        map[string]string
+	Owner              string
+	ResourceVersion    uint64
+	Generation         int64
+	ObservedGeneration int64
+	Command            []string
+	Env                []v1.EnvVar
+	RestartCount       int
+	Process            *v1.ProcessStatus
+	Priority           int
 }
 
 type Scheduler struct {
@@ -48,6 +81,26 @@ type Scheduler struct {
 	Nodes     map[string]*Node
 }
 
+var (
+	errNotFound    = errors.New("not found")
+	errNameTaken   = errors.New("name already in use")
+	errNodeHasPods = errors.New("cannot delete node with running pods")
+	errConflict    = errors.New("object has been modified")
+	errNodeStopped = errors.New("node is already stopped")
+	errInvalid     = errors.New("invalid")
+	errForbidden   = errors.New("forbidden")
+	// errNotCommitted is a change the replicated log did not take; the
+	// client should retry, against the new leader if there is one.
+	errNotCommitted = errors.New("change was not committed")
+)
+
+const (
+	defaultNamespace = "default"
+	// nodeHeartbeatTimeout is how long a node may go without a heartbeat
+	// before the health monitor marks it Failed.
+	nodeHeartbeatTimeout = 15 * time.Second
+)
+
 var (
 	nodes     = make(map[string]*Node)
 	pods      = make(map[string]*Pod)
@@ -57,13 +110,20 @@ var (
 		Algorithm: "first-fit",
 		Nodes:     make(map[string]*Node),
 	}
+	validAlgorithms = map[string]bool{
+		"first-fit":   true,
+		"best-fit":    true,
+		"worst-fit":   true,
+		"round-robin": true,
+	}
 )
 
 func enableCORS(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Resource-Version, X-Continue")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -75,8 +135,198 @@ func enableCORS(next http.HandlerFunc) http.HandlerFunc {
 }
 
 func main() {
+	admissionConfig := flag.String("admission-config", "", "YAML file configuring admission defaults, limit ranges, quotas, required labels and webhooks")
+	tokenAuthFile := flag.String("token-auth-file", "", "CSV file of static bearer tokens: token,user,uid[,\"group1,group2\"]")
+	serviceAccountKeyFile := flag.String("service-account-key-file", "", "secret (at least 32 bytes) signing service account and node tokens; kept in -data-dir, or random, when unset")
+	anonymousAuth := flag.Bool("anonymous-auth", true, "treat requests without credentials as system:anonymous instead of rejecting them")
+	tlsCertFile := flag.String("tls-cert-file", "", "serve HTTPS with this certificate")
+	tlsKeyFile := flag.String("tls-private-key-file", "", "private key for -tls-cert-file")
+	clientCAFile := flag.String("client-ca-file", "", "authenticate client certificates signed by these CAs (requires -tls-cert-file or -tls-cert-dir)")
+	tlsCertDir := flag.String("tls-cert-dir", "", "serve HTTPS with a self-signed CA and serving certificate kept in this directory, generating them on first start")
+	tlsSANs := flag.String("tls-san", "", "comma-separated extra hostnames and IPs for the generated serving certificate")
+	caBundleFile := flag.String("tls-ca-bundle-file", "", "PEM bundle handed to nodes and clients to verify the server; defaults to the generated CA with -tls-cert-dir")
+	auditPolicyFile := flag.String("audit-policy-file", "", "YAML file of rules choosing the audit level per verb, resource, namespace and user")
+	auditLogPath := flag.String("audit-log-path", "", "append audit events as JSON lines to this file, or - for stdout")
+	auditLogMaxSize := flag.Int("audit-log-maxsize", 100, "rotate the audit log when it reaches this many megabytes")
+	auditLogMaxBackups := flag.Int("audit-log-maxbackups", 5, "how many rotated audit logs to keep")
+	auditWebhookURL := flag.String("audit-webhook-url", "", "POST batches of audit events to this URL")
+	maxInflight := flag.Int("max-requests-inflight", 400, "requests served at once, shared between priority levels; 0 disables priority and fairness")
+	clientQPSFlag := flag.Float64("client-qps", 50, "requests per second each client may make; 0 disables rate limiting")
+	clientBurstFlag := flag.Int("client-burst", 100, "requests a client may make at once above -client-qps")
+	dataDir := flag.String("data-dir", "", "persist cluster state in this directory and restore it on startup; state is kept in memory only when unset")
+	storageBackend := flag.String("storage-backend", "", "memory, file (write-ahead log and snapshots) or btree (single-file B-tree); file when -data-dir is set, memory otherwise")
+	snapshotEvery := flag.Int("snapshot-every", 1000, "with the file backend, write a snapshot and start a new write-ahead log after this many changes")
+	bindAddress := flag.String("bind-address", ":8080", "address the API server listens on")
+	replicaID := flag.String("replica-id", "", "this replica's ID in -raft-peers")
+	raftPeers := flag.String("raft-peers", "", "run as one replica of a cluster: comma-separated id=host:port Raft addresses of every replica, this one included")
+	advertiseURL := flag.String("advertise-url", "", "URL other replicas send writes to while this one leads; defaults to localhost on the -bind-address port")
+	flag.StringVar(&replicaWriteMode, "replica-write-mode", writeModeForward, "how followers hand writes to the leader: forward, or redirect with 307")
+	nodeProviderKind := flag.String("node-provider", nodeProviderDocker, "how node agents run: docker (a container each), process (a local process each), inprocess (a goroutine each) or none (run elsewhere, such as by the hollow node binary)")
+	nodeImage := flag.String("node-image", "node-image", "with -node-provider=docker, the image node containers run")
+	nodeAgentPath := flag.String("node-agent-path", "kube-sim-node", "with -node-provider=process, the node agent binary")
+	nodeLogDir := flag.String("node-log-dir", "", "with -node-provider=process, write each agent's output to node-<id>.log here; discarded when unset")
+	nodeHeartbeatJitter := flag.Float64("node-heartbeat-jitter", 0, "spread each agent's heartbeats by up to this fraction of the interval, between 0 and 1")
+	allowPodCommands := flag.Bool("allow-pod-commands", false, "with -node-provider=docker or process, let agents run each pod's command instead of a stand-in; refused with -anonymous-auth and -authorization-mode=AlwaysAllow")
+	hollowNodes := flag.Int("hollow-nodes", 0, "with -node-provider=inprocess, keep this many hollow nodes, named hollow-node-<n>, for scale testing")
+	hollowNodeCPU := flag.Int("hollow-node-cpu", 4, "CPU cores of each hollow node")
+	nodeAPIServer := flag.String("node-api-server-url", "", "URL node agents reach this server at; defaults to host.docker.internal with -node-provider=docker and localhost otherwise, on the -bind-address port")
+	flag.StringVar(&authorizationMode, "authorization-mode", authorizationAlwaysAllow, "AlwaysAllow, or RBAC to check every request against roles and bindings")
+	flag.Parse()
+
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
 	fmt.Printf("%s%s[*] %sStarting Kube-Sim API Server...%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	if err := loadAdmissionConfig(*admissionConfig); err != nil {
+		log.Fatal("Loading admission config: ", err)
+	}
+	keyFile := *serviceAccountKeyFile
+	if keyFile == "" && *dataDir != "" {
+		// Restored nodes keep heartbeating with the tokens they were given.
+		var err error
+		if keyFile, err = ensureSigningKey(*dataDir); err != nil {
+			log.Fatal("Creating service account key: ", err)
+		}
+	}
+	if err := configureAuthentication(*tokenAuthFile, keyFile, *anonymousAuth); err != nil {
+		log.Fatal("Configuring authentication: ", err)
+	}
+	if authorizationMode != authorizationAlwaysAllow && authorizationMode != authorizationRBAC {
+		log.Fatalf("-authorization-mode must be %s or %s", authorizationAlwaysAllow, authorizationRBAC)
+	}
+	if err := checkPodCommands(*allowPodCommands); err != nil {
+		log.Fatal(err)
+	}
+	// Replicas serve Raft with the serving certificate, so it is loaded
+	// before replication starts.
+	certFile, keyFile, bundleFile := *tlsCertFile, *tlsKeyFile, *caBundleFile
+	if *tlsCertDir != "" {
+		if certFile != "" {
+			log.Fatal("-tls-cert-dir and -tls-cert-file are mutually exclusive")
+		}
+		hosts := append([]string{}, defaultServingHosts...)
+		if hostname, err := os.Hostname(); err == nil {
+			hosts = append(hosts, hostname)
+		}
+		for _, san := range strings.Split(*tlsSANs, ",") {
+			if san = strings.TrimSpace(san); san != "" {
+				hosts = append(hosts, san)
+			}
+		}
+		files, err := ensureSelfSignedCerts(*tlsCertDir, hosts)
+		if err != nil {
+			log.Fatal("Generating TLS certificates: ", err)
+		}
+		certFile, keyFile = files.cert, files.key
+		if bundleFile == "" {
+			bundleFile = files.caCert
+		}
+	}
+	if *clientCAFile != "" && certFile == "" {
+		log.Fatal("-client-ca-file requires -tls-cert-file or -tls-cert-dir")
+	}
+	if certFile != "" {
+		reloader, err := newCertReloader(certFile, keyFile, *clientCAFile, bundleFile)
+		if err != nil {
+			log.Fatal("Loading TLS credentials: ", err)
+		}
+		servingCerts = reloader
+		if bundle := reloader.bundle(); bundle != nil {
+			fmt.Printf("%s%s[*] %sCA bundle %s, SHA-256 fingerprint %s%s\n", NEON_BLUE, BOLD, NEON_CYAN, bundleFile, bundleFingerprint(bundle), NC)
+		}
+	}
+
+	_, port, err := net.SplitHostPort(*bindAddress)
+	if err != nil {
+		log.Fatal("Parsing -bind-address: ", err)
+	}
+	backend := *storageBackend
+	if *raftPeers != "" {
+		backend = "raft"
+	} else if backend == "" {
+		backend = storageMemory
+		if *dataDir != "" {
+			backend = storageFile
+		}
+	}
+	if *raftPeers == "" && backend != storageMemory && *dataDir == "" {
+		log.Fatalf("-storage-backend=%s requires -data-dir", backend)
+	}
+	if *raftPeers != "" {
+		switch {
+		case *storageBackend != "" && *storageBackend != storageMemory:
+			log.Fatal("-raft-peers keeps state in the replicated log; -storage-backend does not apply")
+		case *serviceAccountKeyFile == "":
+			log.Fatal("-raft-peers requires -service-account-key-file, shared by every replica")
+		case replicaWriteMode != writeModeForward && replicaWriteMode != writeModeRedirect:
+			log.Fatalf("-replica-write-mode must be %s or %s", writeModeForward, writeModeRedirect)
+		}
+		url := *advertiseURL
+		if url == "" {
+			scheme := "http"
+			if *tlsCertFile != "" || *tlsCertDir != "" {
+				scheme = "https"
+			}
+			url = scheme + "://localhost:" + port
+		}
+		store, err = startReplication(*replicaID, *raftPeers, url, *dataDir, *snapshotEvery)
+		if err != nil {
+			log.Fatal("Starting replication: ", err)
+		}
+	} else if store, err = openStorage(backend, *dataDir, *snapshotEvery); err != nil {
+		log.Fatal("Opening storage: ", err)
+	}
+	restored, err := loadStores()
+	if err != nil {
+		log.Fatal("Loading state from storage: ", err)
+	}
+	if restored > 0 {
+		fmt.Printf("%s%s[*] %sRestored %d objects at resourceVersion %d from %s storage in %s%s\n",
+			NEON_BLUE, BOLD, NEON_CYAN, restored, store.Revision(), backend, *dataDir, NC)
+		if replication == nil {
+			reconcileRestoredNodes()
+		}
+	}
+	go closeStorageOnSignal()
+	// A new leader cannot tell how long ago the nodes last reported to its
+	// predecessor, so it waits for each to report again.
+	if replication != nil {
+		onLeading(reconcileRestoredNodes)
+	}
+	onLeading(bootstrapRBAC)
+	if *clientQPSFlag > 0 && *clientBurstFlag < 1 {
+		log.Fatal("-client-burst must be at least 1")
+	}
+	configureFlowControl(*maxInflight, *clientQPSFlag, *clientBurstFlag)
+	if err := configureAudit(*auditPolicyFile, *auditLogPath, *auditLogMaxSize, *auditLogMaxBackups, *auditWebhookURL); err != nil {
+		log.Fatal("Configuring audit: ", err)
+	}
+	agentURL := *nodeAPIServer
+	if agentURL == "" {
+		host := "localhost"
+		if *nodeProviderKind == nodeProviderDocker {
+			host = "host.docker.internal"
+		}
+		agentURL = nodeAPIServerURL(host, port)
+	}
+	if *nodeHeartbeatJitter < 0 || *nodeHeartbeatJitter > 1 {
+		log.Fatal("-node-heartbeat-jitter must be between 0 and 1")
+	}
+	env := agentEnv{apiServer: agentURL, caData: nodeCABundle, heartbeatJitter: *nodeHeartbeatJitter, allowPodCommands: *allowPodCommands}
+	if nodeProvider, err = newNodeProvider(*nodeProviderKind, *nodeImage, *nodeAgentPath, *nodeLogDir, env); err != nil {
+		log.Fatal("Configuring the node provider: ", err)
+	}
+	fmt.Printf("%s%s[*] %sNode agents run by the %s provider and reach the API server at %s%s\n", NEON_BLUE, BOLD, NEON_CYAN, *nodeProviderKind, agentURL, NC)
+	if *nodeProviderKind == nodeProviderInProcess && replication == nil {
+		relaunchRestoredAgents()
+	}
+	if *hollowNodes > 0 {
+		if *nodeProviderKind != nodeProviderInProcess {
+			log.Fatal("-hollow-nodes requires -node-provider=inprocess")
+		}
+		if *hollowNodeCPU <= 0 {
+			log.Fatal("-hollow-node-cpu must be positive")
+		}
+		onLeading(func() { go ensureHollowNodes(*hollowNodes, *hollowNodeCPU) })
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/nodes", enableCORS(handleNodes))
@@ -85,19 +335,45 @@ func main() {
 	mux.HandleFunc("/pods/", enableCORS(handlePodOperations))
 	mux.HandleFunc("/heartbeat", enableCORS(handleHeartbeat))
 	mux.HandleFunc("/scheduler", enableCORS(handleScheduler))
+	mux.HandleFunc("/deployments", enableCORS(handleDeployments))
+	mux.HandleFunc("/deployments/", enableCORS(handleDeploymentOperations))
+	mux.HandleFunc("/apply", enableCORS(handleApply))
+	mux.HandleFunc("/events", enableCORS(handleV1Events))
+	registerV1Routes(mux)
+	mux.HandleFunc(snapshotPath, enableCORS(handleSnapshot))
+	mux.HandleFunc(restorePath, enableCORS(handleRestore))
+	mux.HandleFunc(metricsPath, handleMetrics)
+	if replication != nil {
+		mux.HandleFunc(replicationPath, handleReplication)
+	}
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
 
+	startInformers(context.Background())
+	startControllerElection()
 	go healthMonitor()
-
-	fmt.Printf("%s%s[*] %sAPI Server listening on :8080%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
-	if err := http.ListenAndServe(":8080", mux); err != nil {
+	go schedulerLoop()
+	go deploymentController()
+
+	server := &http.Server{Addr: *bindAddress, Handler: withAudit(withMetrics(mux, withAuthentication(withReplication(withFlowControl(withAuthorization(mux))))))}
+	if servingCerts != nil {
+		// Certificates come from the reloader, so renewed files are picked
+		// up by new connections without a restart.
+		server.TLSConfig = servingCerts.tlsConfig()
+		go servingCerts.run(context.Background())
+		fmt.Printf("%s%s[*] %sAPI Server listening on %s (HTTPS)%s\n", NEON_BLUE, BOLD, NEON_CYAN, *bindAddress, NC)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		fmt.Printf("%s%s[*] %sAPI Server listening on %s%s\n", NEON_BLUE, BOLD, NEON_CYAN, *bindAddress, NC)
+		err = server.ListenAndServe()
+	}
+	if err != nil {
 		fmt.Printf("%s%s[✗] %sServer failed: %v%s\n", NEON_RED, BOLD, NEON_PINK, err, NC)
 		log.Fatal("Server failed:", err)
 	}
@@ -107,41 +383,30 @@ func handleNodes(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "POST":
 		var req struct {
-			CPUCores int `json:"cpuCores"`
+			Name     string            `json:"name"`
+			CPUCores int               `json:"cpuCores"`
+			Labels   map[string]string `json:"labels"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request", http.StatusBadRequest)
+			writeError(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
 		if req.CPUCores <= 0 {
-			http.Error(w, "CPU cores must be positive", http.StatusBadRequest)
+			writeError(w, "CPU cores must be positive", http.StatusBadRequest)
 			return
 		}
-
-		nodeID := uuid.New().String()
-		cmd := exec.Command("docker", "run", "-d", "--name", "node-"+nodeID,
-			"-e", "NODE_ID="+nodeID,
-			"-e", "API_SERVER=http://host.docker.internal:8080",
-			"node-image")
-		if err := cmd.Run(); err != nil {
-			fmt.Printf("%s%s[✗] %sError launching node container: %v%s\n", NEON_RED, BOLD, NEON_PINK, err, NC)
-			http.Error(w, "Failed to launch node container", http.StatusInternalServerError)
+		if err := validateLabels(req.Labels); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		nodesMu.Lock()
-		nodes[nodeID] = &Node{
-			ID:             nodeID,
-			CPUCores:       req.CPUCores,
-			AvailableCPU:   req.CPUCores,
-			Pods:           []string{},
-			HealthStatus:   "Healthy",
-			LastHeartbeat:  time.Now(),
-			HeartbeatCount: 0,
+		node, err := createNode(req.Name, req.CPUCores, req.Labels, nil)
+		if err != nil {
+			writeErrorFor(w, err, http.StatusInternalServerError)
+			return
 		}
-		nodesMu.Unlock()
+		nodeID := node.ID
 
-		fmt.Printf("%s%s[✓] %sNode %s added with %d CPU cores%s\n", NEON_GREEN, BOLD, NEON_CYAN, nodeID, req.CPUCores, NC)
 		w.WriteHeader(http.StatusCreated)
 		response := map[string]string{
 			"message": fmt.Sprintf("Node %s added with %d CPU cores", nodeID, req.CPUCores),
@@ -150,26 +415,162 @@ func handleNodes(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(response)
 
 	case "GET":
+		opts, err := parseListOptions(r, nodeFieldNames)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if isWatchRequest(r) {
+			serveWatch(w, r, "Node", &nodesMu, nodeSnapshots, func(obj interface{}) bool {
+				node := obj.(Node)
+				return opts.matches(node.Labels, nodeFields(&node))
+			}, nil)
+			return
+		}
+
 		nodesMu.Lock()
 		defer nodesMu.Unlock()
-		if err := json.NewEncoder(w).Encode(nodes); err != nil {
-			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		ids := make([]string, 0, len(nodes))
+		for id := range nodes {
+			ids = append(ids, id)
+		}
+		selected, next := opts.page(ids, func(id string) bool {
+			return opts.matches(nodes[id].Labels, nodeFields(nodes[id]))
+		})
+		result := make(map[string]*Node, len(selected))
+		for _, id := range selected {
+			result[id] = nodes[id]
+		}
+
+		w.Header().Set("X-Resource-Version", fmt.Sprint(watches.currentResourceVersion()))
+		if next != "" {
+			w.Header().Set("X-Continue", next)
+		}
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			writeError(w, "Failed to encode response", http.StatusInternalServerError)
 		}
 
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
+// createNode launches a node agent and registers the node. An empty name
+// defaults to the generated node ID.
+func createNode(name string, cpuCores int, labels, annotations map[string]string) (*Node, error) {
+	desired := v1.Node{
+		Metadata: v1.ObjectMeta{Name: name, Labels: labels, Annotations: annotations},
+		Spec:     v1.NodeSpec{CPUCores: cpuCores},
+	}
+	return addNode(desired, "")
+}
+
+// addNode stores the node desired describes, after admission. A node created
+// through the API gets a new ID, and its agent is launched by the node
+// provider; a node its agent registers keeps the ID the agent declared, if
+// any, and records agentVersion.
+func addNode(desired v1.Node, agentVersion string) (*Node, error) {
+	desired.TypeMeta = typeMeta("Node")
+	desired.Metadata.Labels = copyLabels(desired.Metadata.Labels)
+	desired.Metadata.Annotations = copyLabels(desired.Metadata.Annotations)
+	if err := admit(v1.OperationCreate, &desired, nil); err != nil {
+		return nil, err
+	}
+	if err := validateSimulatedUsage(desired.Metadata.Annotations); err != nil {
+		return nil, err
+	}
+	name, cpuCores := desired.Metadata.Name, desired.Spec.CPUCores
+	if cpuCores <= 0 {
+		return nil, invalidError("cpuCores must be positive")
+	}
+
+	registered := agentVersion != ""
+	nodeID := desired.Metadata.UID
+	if !registered || nodeID == "" {
+		nodeID = uuid.New().String()
+	}
+	if name == "" {
+		name = nodeID
+	}
+
+	nodesMu.Lock()
+	taken := findNodeByName(name) != nil
+	nodesMu.Unlock()
+	if taken {
+		return nil, fmt.Errorf("%w: node %q", errNameTaken, name)
+	}
+
+	node := &Node{
+		ID:             nodeID,
+		Name:           name,
+		CPUCores:       cpuCores,
+		AvailableCPU:   cpuCores,
+		Pods:           []string{},
+		HealthStatus:   "Healthy",
+		LastHeartbeat:  time.Now(),
+		HeartbeatCount: 0,
+		CreatedAt:      time.Now(),
+		Labels:         desired.Metadata.Labels,
+		Annotations:    desired.Metadata.Annotations,
+		Generation:     1,
+		AgentVersion:   agentVersion,
+	}
+	source := sourceNodeAgent
+	if !registered {
+		source = sourceAPIServer
+		if err := launchNodeAgent(node); err != nil {
+			fmt.Printf("%s%s[✗] %sError launching node agent: %v%s\n", NEON_RED, BOLD, NEON_PINK, err, NC)
+			return nil, fmt.Errorf("failed to launch node agent: %v", err)
+		}
+	}
+	nodesMu.Lock()
+	if findNodeByName(name) != nil || nodes[nodeID] != nil {
+		// Another node took the name or ID meanwhile.
+		nodesMu.Unlock()
+		if !registered {
+			nodeProvider.Remove(nodeID)
+		}
+		return nil, fmt.Errorf("%w: node %q", errNameTaken, name)
+	}
+	nodes[nodeID] = node
+	if err := nodeChanged(EventAdded, node); err != nil {
+		nodesMu.Unlock()
+		if !registered {
+			nodeProvider.Remove(nodeID)
+		}
+		return nil, err
+	}
+	nodesMu.Unlock()
+	recordEvent(nodeRef(node), source, v1.EventTypeNormal, "Registered", "Node %s registered with %d CPU cores", name, cpuCores)
+
+	fmt.Printf("%s%s[✓] %sNode %s added with %d CPU cores%s\n", NEON_GREEN, BOLD, NEON_CYAN, nodeID, cpuCores, NC)
+	return node, nil
+}
+
+// findNodeByName returns the node called name. Callers must hold nodesMu.
+func findNodeByName(name string) *Node {
+	for _, node := range nodes {
+		if node.Name == name {
+			return node
+		}
+	}
+	return nil
+}
+
 func handleNodeOperations(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(r.URL.Path, "/")
 	if len(parts) < 3 {
-		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		writeError(w, "Invalid URL", http.StatusBadRequest)
 		return
 	}
 	nodeID := parts[2]
 
 	switch {
+	case r.Method == "GET" && len(parts) == 3:
+		handleGetNode(w, r, nodeID)
+	case r.Method == "PUT" && len(parts) == 3:
+		handleUpdateNode(w, r, nodeID)
 	case r.Method == "POST" && len(parts) == 4 && parts[3] == "stop":
 		handleStopNode(w, r, nodeID)
 	case r.Method == "POST" && len(parts) == 4 && parts[3] == "restart":
@@ -177,93 +578,271 @@ func handleNodeOperations(w http.ResponseWriter, r *http.Request) {
 	case r.Method == "DELETE":
 		handleDeleteNode(w, r, nodeID)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
+func handleGetNode(w http.ResponseWriter, r *http.Request, nodeID string) {
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	node, exists := nodes[nodeID]
+	if !exists {
+		writeError(w, "Node not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(node)
+}
+
+func handleUpdateNode(w http.ResponseWriter, r *http.Request, nodeID string) {
+	var req struct {
+		CPUCores        int    `json:"cpuCores"`
+		ResourceVersion uint64 `json:"resourceVersion"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.CPUCores <= 0 {
+		writeError(w, "CPU cores must be positive", http.StatusBadRequest)
+		return
+	}
+	if req.ResourceVersion == 0 {
+		writeError(w, "resourceVersion is required", http.StatusBadRequest)
+		return
+	}
+
+	nodesMu.Lock()
+	node, exists := nodes[nodeID]
+	var name string
+	if exists {
+		name = node.Name
+	}
+	nodesMu.Unlock()
+	if !exists {
+		writeError(w, "Node not found", http.StatusNotFound)
+		return
+	}
+
+	_, err := updateNode(name, req.ResourceVersion, func(node *v1.Node) {
+		node.Spec.CPUCores = req.CPUCores
+	})
+	if err != nil {
+		writeErrorFor(w, err, http.StatusBadRequest)
+		return
+	}
+
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(nodes[nodeID])
+}
+
+// updateNode stores the spec, labels and annotations that change makes of
+// the node called name, once admission allows them. expectRV, when non-zero,
+// must match the node's resourceVersion; the node must also stay unchanged
+// while admission runs, which happens without nodesMu held.
+func updateNode(name string, expectRV uint64, change func(node *v1.Node)) (*v1.Node, error) {
+	nodesMu.Lock()
+	node := findNodeByName(name)
+	if node == nil {
+		nodesMu.Unlock()
+		return nil, fmt.Errorf("node %q: %w", name, errNotFound)
+	}
+	if expectRV != 0 && node.ResourceVersion != expectRV {
+		nodesMu.Unlock()
+		return nil, conflictError("Node", name, node.ResourceVersion, expectRV)
+	}
+	old, desired := toV1Node(node), toV1Node(node)
+	nodesMu.Unlock()
+
+	change(&desired)
+	if err := admit(v1.OperationUpdate, &desired, &old); err != nil {
+		return nil, err
+	}
+	if err := validateSimulatedUsage(desired.Metadata.Annotations); err != nil {
+		return nil, err
+	}
+
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	node = findNodeByName(name)
+	if node == nil {
+		return nil, fmt.Errorf("node %q: %w", name, errNotFound)
+	}
+	if node.ResourceVersion != old.Metadata.ResourceVersion {
+		return nil, conflictError("Node", name, node.ResourceVersion, old.Metadata.ResourceVersion)
+	}
+	if desired.Spec.CPUCores <= 0 {
+		return nil, invalidError("cpuCores must be positive")
+	}
+	resized, err := resizeNode(node, desired.Spec.CPUCores)
+	if err != nil {
+		return nil, err
+	}
+	relabeled := !labelsEqual(node.Labels, desired.Metadata.Labels) || !labelsEqual(node.Annotations, desired.Metadata.Annotations)
+	if relabeled {
+		node.Labels = copyLabels(desired.Metadata.Labels)
+		node.Annotations = copyLabels(desired.Metadata.Annotations)
+	}
+	// Capacity and labels change together, at a single resourceVersion.
+	if resized || relabeled {
+		if err := nodeChanged(EventModified, node); err != nil {
+			return nil, err
+		}
+	}
+	if resized {
+		recordEvent(nodeRef(node), sourceAPIServer, v1.EventTypeNormal, "Resized", "Node resized to %d CPU cores", node.CPUCores)
+		log.Printf("Node %s resized to %d CPU cores (generation %d)\n", node.ID, node.CPUCores, node.Generation)
+	}
+	updated := toV1Node(node)
+	return &updated, nil
+}
+
+// resizeNode changes a node's capacity, keeping what is already allocated,
+// and reports whether it changed. The caller records the change. Callers
+// must hold nodesMu.
+func resizeNode(node *Node, cpuCores int) (bool, error) {
+	if cpuCores == node.CPUCores {
+		return false, nil
+	}
+	allocated := node.CPUCores - node.AvailableCPU
+	if cpuCores < allocated {
+		return false, fmt.Errorf("cannot shrink node below its %d allocated CPU cores", allocated)
+	}
+	node.CPUCores = cpuCores
+	node.AvailableCPU = cpuCores - allocated
+	node.Generation++
+	return true, nil
+}
+
 func handleStopNode(w http.ResponseWriter, r *http.Request, nodeID string) {
+	if err := stopNode(nodeID); err != nil {
+		writeErrorFor(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Node stopped successfully",
+		"nodeId":  nodeID,
+	})
+}
+
+// launchNodeAgent starts the agent of a node through the node provider, with
+// a fresh token to register and heartbeat with. The agent registers the node
+// with its capacity and labels when it starts. node must be a private copy
+// or not yet stored.
+func launchNodeAgent(node *Node) error {
+	reg := v1.NodeRegistration{NodeID: node.ID, Name: node.Name, CPUCores: node.CPUCores, Labels: copyLabels(node.Labels)}
+	return nodeProvider.Launch(reg, nodeToken(node.Name, node.ID))
+}
+
+// stopNode stops a node's agent and marks it Stopped.
+func stopNode(nodeID string) error {
 	nodesMu.Lock()
 	_, exists := nodes[nodeID]
 	nodesMu.Unlock()
 
 	if !exists {
-		http.Error(w, "Node not found", http.StatusNotFound)
-		return
+		return fmt.Errorf("node %s: %w", nodeID, errNotFound)
 	}
 
 	// Check if node is already stopped
-	cmd := exec.Command("docker", "inspect", "-f", "{{.State.Running}}", "node-"+nodeID)
-	output, err := cmd.CombinedOutput()
-	if err == nil && strings.TrimSpace(string(output)) == "false" {
-		http.Error(w, "Node is already stopped", http.StatusBadRequest)
-		return
+	if running, err := nodeProvider.Running(nodeID); err == nil && !running {
+		return errNodeStopped
 	}
 
-	// Stop the node container
-	cmd = exec.Command("docker", "stop", "node-"+nodeID)
-	if err := cmd.Run(); err != nil {
+	// Stop the node agent
+	if err := nodeProvider.Stop(nodeID); err != nil {
 		log.Printf("Error stopping node %s: %v\n", nodeID, err)
-		http.Error(w, fmt.Sprintf("Failed to stop node: %v", err), http.StatusInternalServerError)
-		return
+		return fmt.Errorf("failed to stop node: %v", err)
 	}
 
 	nodesMu.Lock()
 	if node, ok := nodes[nodeID]; ok {
 		node.HealthStatus = "Stopped"
 		node.LastHeartbeat = time.Now()
+		setCondition(node, v1.NodeCondition{
+			Type: v1.NodeReady, Status: v1.ConditionFalse, Reason: "AgentStopped", Message: "Node agent stopped",
+		}, node.LastHeartbeat)
+		if err := nodeChanged(EventModified, node); err != nil {
+			nodesMu.Unlock()
+			return err
+		}
+		recordEvent(nodeRef(node), sourceAPIServer, v1.EventTypeNormal, "Stopped", "Node agent stopped")
 	}
 	nodesMu.Unlock()
 
-	fmt.Printf("%s%s[✓] %sNode %s stopped successfully%s\n", NEON_GREEN, BOLD, NEON_CYAN, nodeID[:8], NC)
+	fmt.Printf("%s%s[✓] %sNode %s stopped successfully%s\n", NEON_GREEN, BOLD, NEON_CYAN, shortID(nodeID), NC)
+	return nil
+}
+
+func handleDeleteNode(w http.ResponseWriter, r *http.Request, nodeID string) {
+	err := deleteNode(nodeID)
+	switch {
+	case errors.Is(err, errNotFound):
+		writeError(w, "Node not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errNodeHasPods):
+		writeError(w, "Cannot delete node with running pods", http.StatusBadRequest)
+		return
+	case errors.Is(err, errNotCommitted):
+		writeErrorFor(w, err, http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		writeError(w, fmt.Sprintf("Failed to delete node: %v", err), http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Node stopped successfully",
+		"message": "Node deleted successfully",
 		"nodeId":  nodeID,
 	})
 }
 
-func handleDeleteNode(w http.ResponseWriter, r *http.Request, nodeID string) {
+// deleteNode removes an empty node and its agent.
+func deleteNode(nodeID string) error {
 	nodesMu.Lock()
 	node, exists := nodes[nodeID]
 	if !exists {
 		nodesMu.Unlock()
-		http.Error(w, "Node not found", http.StatusNotFound)
-		return
+		return errNotFound
 	}
 
 	// Check for running pods
 	if len(node.Pods) > 0 {
 		nodesMu.Unlock()
-		http.Error(w, "Cannot delete node with running pods", http.StatusBadRequest)
-		return
+		return errNodeHasPods
 	}
 	nodesMu.Unlock()
 
-	// Stop the container first
-	cmd := exec.Command("docker", "stop", "node-"+nodeID)
-	if err := cmd.Run(); err != nil {
+	// Stop the agent first
+	if err := nodeProvider.Stop(nodeID); err != nil {
 		log.Printf("Error stopping node %s before deletion: %v\n", nodeID, err)
-		// Continue anyway as the container might already be stopped
+		// Continue anyway as the agent might already be stopped
 	}
 
-	// Remove the container
-	cmd = exec.Command("docker", "rm", "-f", "node-"+nodeID)
-	if err := cmd.Run(); err != nil {
-		log.Printf("Error removing node container %s: %v\n", nodeID, err)
-		http.Error(w, fmt.Sprintf("Failed to delete node: %v", err), http.StatusInternalServerError)
-		return
+	// Remove the agent
+	if err := nodeProvider.Remove(nodeID); err != nil {
+		log.Printf("Error removing node agent %s: %v\n", nodeID, err)
+		return err
 	}
 
 	nodesMu.Lock()
-	delete(nodes, nodeID)
+	if node, ok := nodes[nodeID]; ok {
+		delete(nodes, nodeID)
+		if err := nodeChanged(EventDeleted, node); err != nil {
+			nodesMu.Unlock()
+			return err
+		}
+		recordEvent(nodeRef(node), sourceAPIServer, v1.EventTypeNormal, "Deleted", "Node deleted")
+	}
 	nodesMu.Unlock()
 
-	fmt.Printf("%s%s[✓] %sNode %s deleted successfully%s\n", NEON_GREEN, BOLD, NEON_CYAN, nodeID[:8], NC)
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Node deleted successfully",
-		"nodeId":  nodeID,
-	})
+	fmt.Printf("%s%s[✓] %sNode %s deleted successfully%s\n", NEON_GREEN, BOLD, NEON_CYAN, shortID(nodeID), NC)
+	return nil
 }
 
 func handlePods(w http.ResponseWriter, r *http.Request) {
@@ -271,76 +850,114 @@ func handlePods(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case "GET":
+		opts, err := parseListOptions(r, podFieldNames)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if isWatchRequest(r) {
+			serveWatch(w, r, "Pod", &podsMu, podSnapshots, func(obj interface{}) bool {
+				pod := obj.(Pod)
+				return opts.matches(pod.Labels, podFields(&pod))
+			}, nil)
+			return
+		}
+
 		podsMu.Lock()
 		defer podsMu.Unlock()
 
-		podsWithFormattedTime := make(map[string]struct {
-			ID          string `json:"ID"`
-			CPURequired int    `json:"CPURequired"`
-			NodeID      string `json:"NodeID"`
-			Status      string `json:"Status"`
-			CreatedAt   string `json:"CreatedAt"`
+		type formattedPod struct {
+			ID                 string            `json:"ID"`
+			Name               string            `json:"Name"`
+			Namespace          string            `json:"Namespace"`
+			CPURequired        int               `json:"CPURequired"`
+			NodeID             string            `json:"NodeID"`
+			Status             string            `json:"Status"`
+			CreatedAt          string            `json:"CreatedAt"`
+			Labels             map[string]string `json:"Labels"`
+			Annotations        map[string]string `json:"Annotations"`
+			Owner              string            `json:"Owner"`
+			ResourceVersion    uint64            `json:"ResourceVersion"`
+			Generation         int64             `json:"Generation"`
+			ObservedGeneration int64             `json:"ObservedGeneration"`
+		}
+
+		ids := make([]string, 0, len(pods))
+		for id := range pods {
+			ids = append(ids, id)
+		}
+		selected, next := opts.page(ids, func(id string) bool {
+			return opts.matches(pods[id].Labels, podFields(pods[id]))
 		})
 
-		for id, pod := range pods {
-			podsWithFormattedTime[id] = struct {
-				ID          string `json:"ID"`
-				CPURequired int    `json:"CPURequired"`
-				NodeID      string `json:"NodeID"`
-				Status      string `json:"Status"`
-				CreatedAt   string `json:"CreatedAt"`
-			}{
-				ID:          pod.ID,
-				CPURequired: pod.CPURequired,
-				NodeID:      pod.NodeID,
-				Status:      pod.Status,
-				CreatedAt:   pod.CreatedAt.Format(time.RFC3339),
+		podsWithFormattedTime := make(map[string]formattedPod, len(selected))
+		for _, id := range selected {
+			pod := pods[id]
+			podsWithFormattedTime[id] = formattedPod{
+				ID:                 pod.ID,
+				Name:               pod.Name,
+				Namespace:          pod.Namespace,
+				CPURequired:        pod.CPURequired,
+				NodeID:             pod.NodeID,
+				Status:             pod.Status,
+				CreatedAt:          pod.CreatedAt.Format(time.RFC3339),
+				Labels:             pod.Labels,
+				Annotations:        pod.Annotations,
+				Owner:              pod.Owner,
+				ResourceVersion:    pod.ResourceVersion,
+				Generation:         pod.Generation,
+				ObservedGeneration: pod.ObservedGeneration,
 			}
 		}
 
+		w.Header().Set("X-Resource-Version", fmt.Sprint(watches.currentResourceVersion()))
+		if next != "" {
+			w.Header().Set("X-Continue", next)
+		}
 		w.WriteHeader(http.StatusOK)
 		if err := json.NewEncoder(w).Encode(podsWithFormattedTime); err != nil {
 			log.Printf("Error encoding pods response: %v", err)
-			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			writeError(w, "Failed to encode response", http.StatusInternalServerError)
 			return
 		}
 
 	case "POST":
 		var req struct {
-			CPURequired int `json:"cpuRequired"`
+			Name        string            `json:"name"`
+			Namespace   string            `json:"namespace"`
+			CPURequired int               `json:"cpuRequired"`
+			Labels      map[string]string `json:"labels"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request", http.StatusBadRequest)
+			writeError(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
-		if req.CPURequired <= 0 {
-			http.Error(w, "CPU required must be positive", http.StatusBadRequest)
+		if req.CPURequired < 0 {
+			writeError(w, "CPU required must not be negative", http.StatusBadRequest)
 			return
 		}
-
-		podID := uuid.New().String()
-		nodeID, err := schedulePod(req.CPURequired)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if err := validateLabels(req.Labels); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		podsMu.Lock()
-		pods[podID] = &Pod{
-			ID:          podID,
+		pod, err := createPod(&Pod{
+			Name:        req.Name,
+			Namespace:   req.Namespace,
 			CPURequired: req.CPURequired,
-			NodeID:      nodeID,
-			Status:      "Running",
-			CreatedAt:   time.Now(),
+			Labels:      req.Labels,
+		})
+		if errors.Is(err, errNameTaken) {
+			writeStatus(w, http.StatusConflict, v1.StatusReasonAlreadyExists, err.Error())
+			return
 		}
-		podsMu.Unlock()
-
-		nodesMu.Lock()
-		nodes[nodeID].Pods = append(nodes[nodeID].Pods, podID)
-		nodes[nodeID].AvailableCPU -= req.CPURequired
-		nodesMu.Unlock()
+		if err != nil {
+			writeErrorFor(w, err, http.StatusBadRequest)
+			return
+		}
+		podID, nodeID := pod.ID, pod.NodeID
 
-		log.Printf("Pod %s launched on node %s with %d CPU\n", podID, nodeID, req.CPURequired)
 		w.WriteHeader(http.StatusCreated)
 		response := map[string]string{
 			"message": fmt.Sprintf("Pod %s launched on node %s with %d CPU", podID, nodeID, req.CPURequired),
@@ -350,23 +967,124 @@ func handlePods(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(response)
 
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
+// maxBindAttempts is how many times createPod schedules a pod whose node
+// changed before it could be bound.
+const maxBindAttempts = 3
+
+// createPod schedules and stores a pod built from the spec fields of tmpl
+// (Name, Namespace, CPURequired, Command, Env, Priority, Labels, Annotations,
+// Owner). An empty name defaults to the generated pod ID and an empty
+// namespace to "default".
+func createPod(tmpl *Pod) (*Pod, error) {
+	desired := toV1Pod(tmpl)
+	if err := admit(v1.OperationCreate, &desired, nil); err != nil {
+		return nil, err
+	}
+	if err := validateWorkload(desired.Spec.Command, desired.Spec.Env); err != nil {
+		return nil, err
+	}
+
+	podID := uuid.New().String()
+	pod := &Pod{
+		ID:                 podID,
+		Name:               desired.Metadata.Name,
+		Namespace:          desired.Metadata.Namespace,
+		CPURequired:        desired.Spec.CPURequired,
+		Command:            desired.Spec.Command,
+		Env:                desired.Spec.Env,
+		Priority:           desired.Spec.Priority,
+		Status:             "Running",
+		CreatedAt:          time.Now(),
+		Labels:             desired.Metadata.Labels,
+		Annotations:        desired.Metadata.Annotations,
+		Owner:              tmpl.Owner,
+		Generation:         1,
+		ObservedGeneration: 1,
+	}
+	if pod.Name == "" {
+		pod.Name = podID
+	}
+
+	podsMu.Lock()
+	taken := findPod(pod.Namespace, pod.Name) != nil
+	podsMu.Unlock()
+	if taken {
+		return nil, fmt.Errorf("%w: pod %s/%s", errNameTaken, pod.Namespace, pod.Name)
+	}
+
+	var nodeID string
+	for attempt := 1; ; attempt++ {
+		var err error
+		nodeID, err = schedulePod(pod.CPURequired)
+		if err != nil {
+			recordEvent(podRef(pod), sourceScheduler, v1.EventTypeWarning, "FailedScheduling", "%v", err)
+			return nil, err
+		}
+		// The node may have failed, gone or filled up since it was picked;
+		// both are checked again under the locks the pod is stored with.
+		nodesMu.Lock()
+		podsMu.Lock()
+		if findPod(pod.Namespace, pod.Name) != nil {
+			podsMu.Unlock()
+			nodesMu.Unlock()
+			return nil, fmt.Errorf("%w: pod %s/%s", errNameTaken, pod.Namespace, pod.Name)
+		}
+		if node, ok := nodes[nodeID]; ok && nodeSchedulable(node) && node.AvailableCPU >= pod.CPURequired {
+			break
+		}
+		podsMu.Unlock()
+		nodesMu.Unlock()
+		if attempt == maxBindAttempts {
+			return nil, fmt.Errorf("%w: node %s no longer fits pod %s/%s", errConflict, nodeID, pod.Namespace, pod.Name)
+		}
+	}
+	pod.NodeID = nodeID
+
+	// The pod and the CPU it takes from its node are stored together.
+	pods[podID] = pod
+	nodes[nodeID].Pods = append(nodes[nodeID].Pods, podID)
+	nodes[nodeID].AvailableCPU -= pod.CPURequired
+	if _, err := watches.commit(podChange(EventAdded, pod), nodeChange(EventModified, nodes[nodeID])); err != nil {
+		podsMu.Unlock()
+		nodesMu.Unlock()
+		return nil, err
+	}
+	podsMu.Unlock()
+	recordEvent(podRef(pod), sourceScheduler, v1.EventTypeNormal, "Scheduled", "Assigned %s/%s to node %s using %s", pod.Namespace, pod.Name, nodes[nodeID].Name, scheduler.Algorithm)
+	nodesMu.Unlock()
+
+	log.Printf("Pod %s launched on node %s with %d CPU\n", podID, nodeID, pod.CPURequired)
+	return pod, nil
+}
+
+// findPod returns the pod called name in namespace. Callers must hold podsMu.
+func findPod(namespace, name string) *Pod {
+	for _, pod := range pods {
+		if pod.Namespace == namespace && pod.Name == name {
+			return pod
+		}
+	}
+	return nil
+}
+
 func handleHeartbeat(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var hb struct {
-		NodeID string   `json:"nodeID"`
-		Status string   `json:"status"`
-		Pods   []string `json:"pods"`
-	}
+	var hb v1.HeartbeatRequest
 	if err := json.NewDecoder(r.Body).Decode(&hb); err != nil {
-		http.Error(w, "Invalid heartbeat", http.StatusBadRequest)
+		writeError(w, "Invalid heartbeat", http.StatusBadRequest)
+		return
+	}
+	// Nodes authenticate as themselves and may not speak for other nodes.
+	if user := requestUser(r); user.inGroup(groupNodes) && user.UID != hb.NodeID {
+		writeError(w, fmt.Sprintf("%s may only post heartbeats for itself", user.Name), http.StatusForbidden)
 		return
 	}
 
@@ -374,57 +1092,85 @@ func handleHeartbeat(w http.ResponseWriter, r *http.Request) {
 	defer nodesMu.Unlock()
 	node, exists := nodes[hb.NodeID]
 	if !exists {
-		http.Error(w, "Node not found", http.StatusNotFound)
+		writeStatus(w, http.StatusConflict, v1.StatusReasonNotRegistered,
+			fmt.Sprintf("node %s is not registered; register it again with POST /api/v1/registration", hb.NodeID))
 		return
 	}
 
+	recovered := node.HealthStatus == "Failed"
+	if node.HeartbeatCount > 0 {
+		heartbeatInterval.observe(time.Since(node.LastHeartbeat).Seconds())
+	}
 	node.LastHeartbeat = time.Now()
 	node.HeartbeatCount++
 	node.HealthStatus = hb.Status
+	node.ObservedGeneration = node.Generation
+	recordConditions(node, hb.Conditions, hb.Usage)
+	recordEvictions(node, hb.Evictions)
+	if err := nodeChanged(EventModified, node); err != nil {
+		writeErrorFor(w, err, http.StatusServiceUnavailable)
+		return
+	}
+	if recovered && hb.Status == "Healthy" {
+		recordEvent(nodeRef(node), sourceHealthMonitor, v1.EventTypeNormal, "NodeReady", "Node is sending heartbeats again")
+	}
+	recordProcesses(node, hb.Processes)
 	fmt.Printf("%s%s[*] %sHeartbeat received from node %s (count: %d, status: %s)%s\n",
-		NEON_BLUE, BOLD, NEON_CYAN, hb.NodeID[:8], node.HeartbeatCount, hb.Status, NC)
+		NEON_BLUE, BOLD, NEON_CYAN, shortID(hb.NodeID), node.HeartbeatCount, hb.Status, NC)
 
-	if err := json.NewEncoder(w).Encode(map[string][]string{"pods": node.Pods}); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	resp := v1.HeartbeatResponse{Pods: node.Pods, Workloads: nodeWorkloads(node), SimulatedUsage: simulatedUsage(node)}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, "Failed to encode response", http.StatusInternalServerError)
 	}
 }
 
 func handleScheduler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, v1.SchedulerConfig{Algorithm: scheduler.Algorithm})
+		return
+	case "POST", "PUT":
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var req struct {
-		Algorithm string `json:"algorithm"`
-	}
+	var req v1.SchedulerConfig
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		writeError(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	validAlgorithms := map[string]bool{
-		"first-fit":   true,
-		"best-fit":    true,
-		"worst-fit":   true,
-		"round-robin": true,
-	}
-
 	if !validAlgorithms[req.Algorithm] {
-		http.Error(w, "Invalid algorithm", http.StatusBadRequest)
+		writeError(w, "Invalid algorithm", http.StatusBadRequest)
 		return
 	}
 
 	scheduler.Algorithm = req.Algorithm
+	if err := persistSchedulerConfig(req); err != nil {
+		writeErrorFor(w, err, http.StatusServiceUnavailable)
+		return
+	}
 	log.Printf("Scheduler algorithm changed to %s", scheduler.Algorithm)
-	w.WriteHeader(http.StatusOK)
+	writeJSON(w, http.StatusOK, req)
 }
 
-func schedulePod(cpuRequired int) (string, error) {
+func schedulePod(cpuRequired int) (nodeID string, err error) {
 	nodesMu.Lock()
 	defer nodesMu.Unlock()
 
-	switch scheduler.Algorithm {
+	algorithm := scheduler.Algorithm
+	start := time.Now()
+	defer func() {
+		result := "scheduled"
+		if err != nil {
+			result = "unschedulable"
+		}
+		schedulingAttempts.inc(algorithm, result)
+		schedulingDuration.observe(time.Since(start).Seconds(), algorithm)
+	}()
+
+	switch algorithm {
 	case "first-fit":
 		return firstFitScheduling(cpuRequired)
 	case "best-fit":
@@ -443,7 +1189,7 @@ func firstFitScheduling(cpuRequired int) (string, error) {
 
 	// Simply iterate through nodes and return the first one with enough CPU
 	for nodeID, node := range nodes {
-		if node.HealthStatus == "Healthy" && node.AvailableCPU >= cpuRequired {
+		if nodeSchedulable(node) && node.AvailableCPU >= cpuRequired {
 			log.Printf("First-Fit: Selected node %s with %d available CPU cores",
 				nodeID, node.AvailableCPU)
 			return nodeID, nil
@@ -462,7 +1208,7 @@ func bestFitScheduling(cpuRequired int) (string, error) {
 
 	// Find the node with minimum sufficient available CPU
 	for nodeID, node := range nodes {
-		if node.HealthStatus == "Healthy" &&
+		if nodeSchedulable(node) &&
 			node.AvailableCPU >= cpuRequired &&
 			node.AvailableCPU < minAvailableCPU {
 			selectedNode = nodeID
@@ -488,7 +1234,7 @@ func worstFitScheduling(cpuRequired int) (string, error) {
 
 	// Find the node with maximum available CPU
 	for nodeID, node := range nodes {
-		if node.HealthStatus == "Healthy" &&
+		if nodeSchedulable(node) &&
 			node.AvailableCPU >= cpuRequired &&
 			node.AvailableCPU > maxAvailableCPU {
 			selectedNode = nodeID
@@ -509,10 +1255,10 @@ func worstFitScheduling(cpuRequired int) (string, error) {
 func roundRobinScheduling(cpuRequired int) (string, error) {
 	log.Printf("Round-Robin scheduling: Looking for node with %d CPU cores", cpuRequired)
 
-	// Get all healthy nodes with sufficient CPU
+	// Get all schedulable nodes with sufficient CPU
 	var eligibleNodes []string
 	for nodeID, node := range nodes {
-		if node.HealthStatus == "Healthy" && node.AvailableCPU >= cpuRequired {
+		if nodeSchedulable(node) && node.AvailableCPU >= cpuRequired {
 			eligibleNodes = append(eligibleNodes, nodeID)
 		}
 	}
@@ -535,182 +1281,498 @@ func roundRobinScheduling(cpuRequired int) (string, error) {
 	return selectedNode, nil
 }
 
+// healthMonitor scans the node informer's cache for nodes whose heartbeats
+// stopped. Heartbeats update the node, so the cache always holds the latest
+// LastHeartbeat; failNode rechecks against the store before acting.
 func healthMonitor() {
 	for {
 		time.Sleep(5 * time.Second)
-		nodesMu.Lock()
-		for nodeID, node := range nodes {
-			timeSinceLastHeartbeat := time.Since(node.LastHeartbeat)
-			if timeSinceLastHeartbeat > 15*time.Second && node.HealthStatus != "Failed" {
-				fmt.Printf("%s%s[!] %sNode %s marked as Failed (Last heartbeat: %.1f seconds ago)%s\n",
-					NEON_YELLOW, BOLD, NEON_ORANGE, nodeID[:8], timeSinceLastHeartbeat.Seconds(), NC)
-				node.HealthStatus = "Failed"
-				podsToReschedule := node.Pods
-				node.Pods = []string{}
-				nodesMu.Unlock()
-				for _, podID := range podsToReschedule {
-					podsMu.Lock()
-					pod := pods[podID]
-					pod.Status = "Rescheduling"
-					podsMu.Unlock()
-
-					newNodeID, err := schedulePod(pod.CPURequired)
-					if err != nil {
-						fmt.Printf("%s%s[✗] %sFailed to reschedule pod %s: %v%s\n", NEON_RED, BOLD, NEON_PINK, podID[:8], err, NC)
-						continue
-					}
-
-					nodesMu.Lock()
-					podsMu.Lock()
-					pod.NodeID = newNodeID
-					pod.Status = "Running"
-					nodes[newNodeID].Pods = append(nodes[newNodeID].Pods, podID)
-					nodes[newNodeID].AvailableCPU -= pod.CPURequired
-					podsMu.Unlock()
-					nodesMu.Unlock()
-
-					fmt.Printf("%s%s[✓] %sPod %s rescheduled to node %s%s\n", NEON_GREEN, BOLD, NEON_CYAN, podID[:8], newNodeID[:8], NC)
-				}
-				nodesMu.Lock()
+		if !controllersActive() {
+			continue
+		}
+		for _, obj := range nodeInformer.List() {
+			node := obj.(*v1.Node)
+			if node.Status.HealthStatus != "Failed" && time.Since(node.Status.LastHeartbeat) > nodeHeartbeatTimeout {
+				failNode(node.Metadata.UID)
 			}
 		}
+	}
+}
+
+// failNode marks a node whose heartbeats stopped as Failed and reschedules
+// its pods onto the remaining nodes.
+func failNode(nodeID string) {
+	nodesMu.Lock()
+	node, exists := nodes[nodeID]
+	if !exists || node.HealthStatus == "Failed" {
 		nodesMu.Unlock()
+		return
 	}
+	timeSinceLastHeartbeat := time.Since(node.LastHeartbeat)
+	if timeSinceLastHeartbeat <= nodeHeartbeatTimeout {
+		nodesMu.Unlock()
+		return
+	}
+	node.HealthStatus = "Failed"
+	markConditionsUnknown(node, "NodeStatusUnknown", "Node stopped sending heartbeats")
+	podsToReschedule := node.Pods
+	node.Pods = []string{}
+	// The node fails together with its pods being released, so that a change
+	// that does not commit leaves no pod bound to a node that has let go of
+	// it; the next check tries again.
+	podsMu.Lock()
+	changes := []objectChange{nodeChange(EventModified, node)}
+	for _, podID := range podsToReschedule {
+		if pod, exists := pods[podID]; exists {
+			pod.Status = "Rescheduling"
+			changes = append(changes, podChange(EventModified, pod))
+		}
+	}
+	_, err := watches.commit(changes...)
+	podsMu.Unlock()
+	if err != nil {
+		nodesMu.Unlock()
+		log.Printf("Failing node %s: %v", node.Name, err)
+		return
+	}
+	fmt.Printf("%s%s[!] %sNode %s marked as Failed (Last heartbeat: %.1f seconds ago)%s\n",
+		NEON_YELLOW, BOLD, NEON_ORANGE, shortID(nodeID), timeSinceLastHeartbeat.Seconds(), NC)
+	nodeFailures.inc(node.Name)
+	recordEvent(nodeRef(node), sourceHealthMonitor, v1.EventTypeWarning, "NodeNotReady",
+		"Node stopped sending heartbeats; rescheduling %d pods", len(podsToReschedule))
+	nodesMu.Unlock()
+
+	for _, podID := range podsToReschedule {
+		reschedulePod(podID, fmt.Sprintf("after node %s failed", node.Name))
+	}
+}
+
+// schedulerLoop retries the pods left Rescheduling because no node could
+// take them when theirs failed.
+func schedulerLoop() {
+	for {
+		time.Sleep(5 * time.Second)
+		if !controllersActive() {
+			continue
+		}
+		for _, obj := range podInformer.List() {
+			pod := obj.(*v1.Pod)
+			if pod.Status.Phase == "Rescheduling" {
+				reschedulePod(pod.Metadata.UID, "by the scheduler loop")
+			}
+		}
+	}
+}
+
+// reschedulePod binds a Rescheduling pod to a node with room for it. The
+// pod and the node are rechecked under their locks, so the health monitor
+// and the scheduler loop never both bind it.
+func reschedulePod(podID, why string) {
+	podsMu.Lock()
+	pod, exists := pods[podID]
+	if !exists || pod.Status != "Rescheduling" {
+		podsMu.Unlock()
+		return
+	}
+	cpuRequired := pod.CPURequired
+	podsMu.Unlock()
+
+	newNodeID, err := schedulePod(cpuRequired)
+	if err != nil {
+		podsMu.Lock()
+		fmt.Printf("%s%s[✗] %sFailed to reschedule pod %s: %v%s\n", NEON_RED, BOLD, NEON_PINK, shortID(podID), err, NC)
+		recordEvent(podRef(pod), sourceScheduler, v1.EventTypeWarning, "FailedScheduling", "Cannot reschedule: %v", err)
+		podsMu.Unlock()
+		return
+	}
+
+	nodesMu.Lock()
+	podsMu.Lock()
+	defer nodesMu.Unlock()
+	defer podsMu.Unlock()
+	pod, exists = pods[podID]
+	newNode, nodeExists := nodes[newNodeID]
+	if !exists || pod.Status != "Rescheduling" || !nodeExists || newNode.AvailableCPU < pod.CPURequired {
+		return
+	}
+	pod.NodeID = newNodeID
+	pod.Status = "Running"
+	// The new node starts the process afresh.
+	pod.Process = nil
+	newNode.Pods = append(newNode.Pods, podID)
+	newNode.AvailableCPU -= pod.CPURequired
+	if _, err := watches.commit(podChange(EventModified, pod), nodeChange(EventModified, newNode)); err != nil {
+		// The pod is Rescheduling again once the cache is reloaded, for the
+		// scheduler loop to retry.
+		log.Printf("Rescheduling pod %s: %v", pod.ID, err)
+		return
+	}
+	recordEvent(podRef(pod), sourceScheduler, v1.EventTypeNormal, "Rescheduled", "Moved to node %s %s", newNode.Name, why)
+
+	fmt.Printf("%s%s[✓] %sPod %s rescheduled to node %s%s\n", NEON_GREEN, BOLD, NEON_CYAN, shortID(podID), shortID(newNodeID), NC)
 }
 
 func handlePodOperations(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(r.URL.Path, "/")
 	if len(parts) < 3 {
-		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		writeError(w, "Invalid URL", http.StatusBadRequest)
 		return
 	}
 	podID := parts[2]
 
 	switch {
+	case r.Method == "GET" && len(parts) == 3:
+		handleGetPod(w, r, podID)
+	case r.Method == "PUT" && len(parts) == 3:
+		handleUpdatePod(w, r, podID)
 	case r.Method == "DELETE":
 		handleDeletePod(w, r, podID)
 	case r.Method == "POST" && len(parts) == 4 && parts[3] == "restart":
 		handleRestartPod(w, r, podID)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func handleDeletePod(w http.ResponseWriter, r *http.Request, podID string) {
+func handleGetPod(w http.ResponseWriter, r *http.Request, podID string) {
 	podsMu.Lock()
+	defer podsMu.Unlock()
 	pod, exists := pods[podID]
 	if !exists {
-		podsMu.Unlock()
-		http.Error(w, "Pod not found", http.StatusNotFound)
+		writeError(w, "Pod not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pod)
+}
+
+// handleUpdatePod changes a pod's CPU request in place. The node's allocation
+// is adjusted immediately and the pod restarts to pick up the new spec, so
+// ObservedGeneration trails Generation until the restart completes.
+func handleUpdatePod(w http.ResponseWriter, r *http.Request, podID string) {
+	var req struct {
+		CPURequired     int    `json:"cpuRequired"`
+		ResourceVersion uint64 `json:"resourceVersion"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.CPURequired <= 0 {
+		writeError(w, "CPU required must be positive", http.StatusBadRequest)
+		return
+	}
+	if req.ResourceVersion == 0 {
+		writeError(w, "resourceVersion is required", http.StatusBadRequest)
+		return
+	}
+
+	podsMu.Lock()
+	pod, exists := pods[podID]
+	var namespace, name string
+	if exists {
+		namespace, name = pod.Namespace, pod.Name
+	}
+	podsMu.Unlock()
+	if !exists {
+		writeError(w, "Pod not found", http.StatusNotFound)
+		return
+	}
+
+	_, err := updatePod(namespace, name, req.ResourceVersion, func(pod *v1.Pod) {
+		pod.Spec.CPURequired = req.CPURequired
+	})
+	if err != nil {
+		writeErrorFor(w, err, http.StatusBadRequest)
 		return
 	}
 
+	podsMu.Lock()
+	defer podsMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pods[podID])
+}
+
+// updatePod is updateNode for pods.
+func updatePod(namespace, name string, expectRV uint64, change func(pod *v1.Pod)) (*v1.Pod, error) {
+	key := namespace + "/" + name
+	podsMu.Lock()
+	pod := findPod(namespace, name)
+	if pod == nil {
+		podsMu.Unlock()
+		return nil, fmt.Errorf("pod %s: %w", key, errNotFound)
+	}
+	if expectRV != 0 && pod.ResourceVersion != expectRV {
+		podsMu.Unlock()
+		return nil, conflictError("Pod", key, pod.ResourceVersion, expectRV)
+	}
+	old, desired := toV1Pod(pod), toV1Pod(pod)
+	podsMu.Unlock()
+
+	change(&desired)
+	if err := admit(v1.OperationUpdate, &desired, &old); err != nil {
+		return nil, err
+	}
+	if err := validateWorkload(desired.Spec.Command, desired.Spec.Env); err != nil {
+		return nil, err
+	}
+	if desired.Spec.Priority != old.Spec.Priority {
+		return nil, invalidError("spec.priority cannot be changed")
+	}
+
+	// Resizing changes the pod's node too, so take nodesMu first like every
+	// other path that locks both.
 	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	podsMu.Lock()
+	defer podsMu.Unlock()
+	pod = findPod(namespace, name)
+	if pod == nil {
+		return nil, fmt.Errorf("pod %s: %w", key, errNotFound)
+	}
+	if pod.ResourceVersion != old.Metadata.ResourceVersion {
+		return nil, conflictError("Pod", key, pod.ResourceVersion, old.Metadata.ResourceVersion)
+	}
+	resizedNode, err := resizePod(pod, desired.Spec.CPURequired)
+	if err != nil {
+		return nil, err
+	}
+	rewired := changeWorkload(pod, desired.Spec.Command, desired.Spec.Env)
+	relabeled := !labelsEqual(pod.Labels, desired.Metadata.Labels) || !labelsEqual(pod.Annotations, desired.Metadata.Annotations)
+	if relabeled {
+		pod.Labels = copyLabels(desired.Metadata.Labels)
+		pod.Annotations = copyLabels(desired.Metadata.Annotations)
+	}
+	restart := resizedNode != nil || rewired
+	if restart {
+		pod.Generation++
+		pod.Status = "Restarting"
+		pod.RestartCount++
+		pod.Process = nil
+	}
+	// The new spec, labels and the node's allocation change together, at a
+	// single resourceVersion.
+	if restart || relabeled {
+		changes := []objectChange{podChange(EventModified, pod)}
+		if resizedNode != nil {
+			changes = append(changes, nodeChange(EventModified, resizedNode))
+		}
+		if _, err := watches.commit(changes...); err != nil {
+			return nil, err
+		}
+	}
+	if resizedNode != nil {
+		recordEvent(podRef(pod), sourceAPIServer, v1.EventTypeNormal, "Resized", "Pod resized to %d CPU; restarting", pod.CPURequired)
+		log.Printf("Pod %s updated to %d CPU (generation %d)\n", pod.ID, pod.CPURequired, pod.Generation)
+	}
+	if rewired {
+		recordEvent(podRef(pod), sourceAPIServer, v1.EventTypeNormal, "WorkloadChanged", "Pod command or environment changed; restarting")
+		log.Printf("Pod %s workload changed (generation %d)\n", pod.ID, pod.Generation)
+	}
+	if restart {
+		go completePodRestart(pod.ID, pod.RestartCount)
+	}
+	updated := toV1Pod(pod)
+	return &updated, nil
+}
+
+// resizePod changes a pod's CPU request and its node's allocation, and
+// returns the node when they changed. The caller restarts the pod and
+// records the change. Callers must hold nodesMu and podsMu.
+func resizePod(pod *Pod, cpuRequired int) (*Node, error) {
+	if cpuRequired == pod.CPURequired {
+		return nil, nil
+	}
+	delta := cpuRequired - pod.CPURequired
 	node, nodeExists := nodes[pod.NodeID]
-	if nodeExists {
+	if !nodeExists || node.AvailableCPU < delta {
+		return nil, fmt.Errorf("insufficient CPU on the pod's node")
+	}
+	node.AvailableCPU -= delta
+	pod.CPURequired = cpuRequired
+	return node, nil
+}
+
+func handleDeletePod(w http.ResponseWriter, r *http.Request, podID string) {
+	if err := deletePod(podID); errors.Is(err, errNotCommitted) {
+		writeErrorFor(w, err, http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		writeError(w, "Pod not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Pod deleted successfully",
+		"podId":   podID,
+	})
+}
+
+// deletePod removes a pod and returns its CPU to the node it ran on.
+func deletePod(podID string) error {
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	podsMu.Lock()
+	defer podsMu.Unlock()
+	pod, exists := pods[podID]
+	if !exists {
+		return errNotFound
+	}
+
+	changes := []objectChange{podChange(EventDeleted, pod)}
+	if node, ok := nodes[pod.NodeID]; ok {
 		node.Pods = removeFromSlice(node.Pods, podID)
 		node.AvailableCPU += pod.CPURequired
 		log.Printf("Updated node %s: Available CPU now %d, Pods: %v\n",
 			node.ID, node.AvailableCPU, node.Pods)
+		changes = append(changes, nodeChange(EventModified, node))
 	}
-	nodesMu.Unlock()
 	delete(pods, podID)
-	podsMu.Unlock()
+	if _, err := watches.commit(changes...); err != nil {
+		return err
+	}
+	recordEvent(podRef(pod), sourceAPIServer, v1.EventTypeNormal, "Killing", "Pod deleted; released %d CPU", pod.CPURequired)
 
 	log.Printf("Pod %s deleted\n", podID)
+	return nil
+}
+
+func handleRestartPod(w http.ResponseWriter, r *http.Request, podID string) {
+	if err := restartPod(podID); err != nil {
+		writeErrorFor(w, err, http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Pod deleted successfully",
+		"message": "Pod restart initiated",
 		"podId":   podID,
 	})
 }
 
-func handleRestartPod(w http.ResponseWriter, r *http.Request, podID string) {
+// restartPod marks a pod Restarting; it comes back Running shortly after.
+func restartPod(podID string) error {
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
 	podsMu.Lock()
+	defer podsMu.Unlock()
+
 	pod, exists := pods[podID]
 	if !exists {
-		podsMu.Unlock()
-		http.Error(w, "Pod not found", http.StatusNotFound)
-		return
+		return fmt.Errorf("pod %s: %w", podID, errNotFound)
 	}
-	podsMu.Unlock()
-
-	nodesMu.Lock()
-	_, nodeExists := nodes[pod.NodeID]
-	if !nodeExists {
-		nodesMu.Unlock()
-		http.Error(w, "Node not found", http.StatusNotFound)
-		return
+	if _, nodeExists := nodes[pod.NodeID]; !nodeExists {
+		return fmt.Errorf("node %s: %w", pod.NodeID, errNotFound)
 	}
 
-	podsMu.Lock()
 	pod.Status = "Restarting"
-	podsMu.Unlock()
+	pod.RestartCount++
+	pod.Process = nil
+	if err := podChanged(EventModified, pod); err != nil {
+		return err
+	}
+	recordEvent(podRef(pod), sourceAPIServer, v1.EventTypeNormal, "Restarting", "Pod restart requested")
+
+	go completePodRestart(pod.ID, pod.RestartCount)
 
-	go func() {
+	log.Printf("Pod %s restart initiated\n", podID)
+	return nil
+}
+
+// completePodRestart brings a restarting pod back to Running after a short
+// delay, recording that its status now reflects the latest spec. A change
+// that does not commit is retried for as long as this server leads and the
+// pod is still on the same restart.
+func completePodRestart(podID string, restartCount int) {
+	for {
 		time.Sleep(2 * time.Second)
+		if !isLeader() {
+			return
+		}
 		podsMu.Lock()
+		pod, exists := pods[podID]
+		if !exists || pod.Status != "Restarting" || pod.RestartCount != restartCount {
+			podsMu.Unlock()
+			return
+		}
 		pod.Status = "Running"
+		pod.ObservedGeneration = pod.Generation
+		err := podChanged(EventModified, pod)
 		podsMu.Unlock()
-		log.Printf("Pod %s restarted\n", podID)
-	}()
+		if err == nil {
+			log.Printf("Pod %s restarted\n", podID)
+			return
+		}
+		log.Printf("Completing the restart of pod %s: %v", podID, err)
+	}
+}
 
-	nodesMu.Unlock()
+func handleRestartNode(w http.ResponseWriter, r *http.Request, nodeID string) {
+	if err := restartNode(nodeID); err != nil {
+		writeErrorFor(w, err, http.StatusInternalServerError)
+		return
+	}
 
-	log.Printf("Pod %s restart initiated\n", podID)
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Pod restart initiated",
-		"podId":   podID,
+		"message": "Node restarted successfully",
+		"nodeId":  nodeID,
 	})
 }
 
-func handleRestartNode(w http.ResponseWriter, r *http.Request, nodeID string) {
+// restartNode restarts a node's agent; the node reports Healthy again
+// with its next heartbeat.
+func restartNode(nodeID string) error {
 	nodesMu.Lock()
 	_, exists := nodes[nodeID]
 	nodesMu.Unlock()
 
 	if !exists {
-		http.Error(w, "Node not found", http.StatusNotFound)
-		return
+		return fmt.Errorf("node %s: %w", nodeID, errNotFound)
 	}
 
-	// Check if container exists
-	cmd := exec.Command("docker", "inspect", "node-"+nodeID)
-	if err := cmd.Run(); err != nil {
-		log.Printf("Container for node %s not found: %v\n", nodeID, err)
-		http.Error(w, "Node container not found", http.StatusNotFound)
-		return
+	// Check if the agent exists
+	if _, err := nodeProvider.Running(nodeID); err != nil {
+		log.Printf("Agent for node %s not found: %v\n", nodeID, err)
+		return err
 	}
 
-	// Stop the container first
-	cmd = exec.Command("docker", "stop", "node-"+nodeID)
-	if err := cmd.Run(); err != nil {
+	// Stop the agent first
+	if err := nodeProvider.Stop(nodeID); err != nil {
 		log.Printf("Error stopping node %s: %v\n", nodeID, err)
-		// Continue anyway as the container might already be stopped
+		// Continue anyway as the agent might already be stopped
 	}
 
-	// Start the container
-	cmd = exec.Command("docker", "start", "node-"+nodeID)
-	if err := cmd.Run(); err != nil {
+	// Start the agent
+	if err := nodeProvider.Start(nodeID); err != nil {
 		log.Printf("Error starting node %s: %v\n", nodeID, err)
-		http.Error(w, fmt.Sprintf("Failed to restart node: %v", err), http.StatusInternalServerError)
-		return
+		return fmt.Errorf("failed to restart node: %v", err)
 	}
 
 	nodesMu.Lock()
 	if node, ok := nodes[nodeID]; ok {
 		node.HealthStatus = "Starting"
 		node.LastHeartbeat = time.Now()
+		if err := nodeChanged(EventModified, node); err != nil {
+			nodesMu.Unlock()
+			return err
+		}
+		recordEvent(nodeRef(node), sourceAPIServer, v1.EventTypeNormal, "Restarted", "Node agent restarted")
 	}
 	nodesMu.Unlock()
 
-	fmt.Printf("%s%s[✓] %sNode %s restarted successfully%s\n", NEON_GREEN, BOLD, NEON_CYAN, nodeID[:8], NC)
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Node restarted successfully",
-		"nodeId":  nodeID,
-	})
+	fmt.Printf("%s%s[✓] %sNode %s restarted successfully%s\n", NEON_GREEN, BOLD, NEON_CYAN, shortID(nodeID), NC)
+	return nil
+}
+
+// shortID abbreviates an ID for log lines. Registered node IDs may be
+// shorter than the UUIDs the server generates.
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
 }
 
 func removeFromSlice(slice []string, item string) []string {
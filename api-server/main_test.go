@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "example.com/m/api/v1"
+)
+
+// resetState starts a test from an empty cluster kept in memory.
+func resetState(t *testing.T) {
+	t.Helper()
+	lockStores()
+	clearStores()
+	leases = make(map[string]*v1.Lease)
+	unlockStores()
+	store = newKVStore(newMemoryEngine())
+	watches = &watchCache{watchers: make(map[*watcher]struct{})}
+}
+
+// registerTestNode stores a node as its agent would register it, without
+// launching anything.
+func registerTestNode(t *testing.T, id, name string, cpuCores int) *Node {
+	t.Helper()
+	node, err := addNode(v1.Node{
+		Metadata: v1.ObjectMeta{Name: name, UID: id},
+		Spec:     v1.NodeSpec{CPUCores: cpuCores},
+	}, "test")
+	if err != nil {
+		t.Fatalf("adding node %s: %v", name, err)
+	}
+	return node
+}
+
+// commitsOf counts the changes to objects of kind published after rv.
+func commitsOf(kind string, rv uint64) int {
+	watches.mu.Lock()
+	defer watches.mu.Unlock()
+	n := 0
+	for _, ev := range watches.history {
+		if ev.Kind == kind && ev.ResourceVersion > rv {
+			n++
+		}
+	}
+	return n
+}
+
+func TestUpdateNodeCommitsOnce(t *testing.T) {
+	resetState(t)
+	registerTestNode(t, "node-0001", "worker-1", 4)
+	nodesMu.Lock()
+	before := findNodeByName("worker-1").ResourceVersion
+	nodesMu.Unlock()
+
+	updated, err := updateNode("worker-1", before, func(node *v1.Node) {
+		node.Spec.CPUCores = 8
+		node.Metadata.Labels = map[string]string{"tier": "web"}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Spec.CPUCores != 8 || updated.Metadata.Labels["tier"] != "web" {
+		t.Errorf("update not applied: %+v", updated)
+	}
+	if n := commitsOf("Node", before); n != 1 {
+		t.Errorf("resizing and relabeling a node published %d node changes, want 1", n)
+	}
+}
+
+func TestUpdatePodCommitsOnce(t *testing.T) {
+	resetState(t)
+	registerTestNode(t, "node-0001", "worker-1", 4)
+	pod, err := createPod(&Pod{Name: "web", Namespace: "default", CPURequired: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := pod.ResourceVersion
+
+	// Too big for the node: nothing changes, the labels included.
+	_, err = updatePod("default", "web", before, func(p *v1.Pod) {
+		p.Spec.CPURequired = 8
+		p.Metadata.Labels = map[string]string{"tier": "web"}
+	})
+	if err == nil {
+		t.Fatal("resized a pod beyond its node")
+	}
+	if n := commitsOf("Pod", before); n != 0 {
+		t.Errorf("a failed update published %d pod changes", n)
+	}
+
+	updated, err := updatePod("default", "web", before, func(p *v1.Pod) {
+		p.Spec.CPURequired = 2
+		p.Spec.Command = []string{"sleep", "60"}
+		p.Metadata.Labels = map[string]string{"tier": "web"}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Spec.CPURequired != 2 || len(updated.Spec.Command) != 2 || updated.Metadata.Labels["tier"] != "web" {
+		t.Errorf("update not applied: %+v", updated)
+	}
+	if updated.Metadata.Generation != 2 || updated.Status.RestartCount != 1 {
+		t.Errorf("generation %d, restart count %d after one update, want 2 and 1", updated.Metadata.Generation, updated.Status.RestartCount)
+	}
+	if n := commitsOf("Pod", before); n != 1 {
+		t.Errorf("resizing, rewiring and relabeling a pod published %d pod changes, want 1", n)
+	}
+}
+
+func TestResizePodLocksNodesFirst(t *testing.T) {
+	resetState(t)
+	node := registerTestNode(t, "node-0001", "worker-1", 8)
+	nodesMu.Lock()
+	node.HealthStatus = "Healthy"
+	nodesMu.Unlock()
+	pod, err := createPod(&Pod{Name: "web", Namespace: "default", CPURequired: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Lock both stores in the order create, delete and reschedule do while
+	// the pod is resized, which deadlocked when resizing took them the
+	// other way round.
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			nodesMu.Lock()
+			podsMu.Lock()
+			podsMu.Unlock()
+			nodesMu.Unlock()
+		}
+	}()
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 2000; i++ {
+			if _, err := updatePod("default", "web", 0, func(p *v1.Pod) { p.Spec.CPURequired = i%4 + 1 }); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		close(stop)
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("resizing a pod deadlocked against nodesMu then podsMu")
+	}
+
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	podsMu.Lock()
+	defer podsMu.Unlock()
+	if want := 8 - pod.CPURequired; node.AvailableCPU != want {
+		t.Errorf("node has %d CPU available, want %d", node.AvailableCPU, want)
+	}
+}
+
+func TestLegacyNodeCreateReportsInvalid(t *testing.T) {
+	resetState(t)
+	plugins := mutatingPlugins
+	defer func() { mutatingPlugins = plugins }()
+	mutatingPlugins = append(mutatingPlugins, admissionPlugin{name: "zero-cpu", admit: func(req *admissionRequest) error {
+		req.Object.(*v1.Node).Spec.CPUCores = 0
+		return nil
+	}})
+
+	w := postJSON(t, handleNodes, "/nodes", map[string]interface{}{"name": "worker-1", "cpuCores": 2})
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "cpuCores must be positive") {
+		t.Errorf("creating a node a webhook left without CPU: got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestRestartPodHoldsPodsMu(t *testing.T) {
+	resetState(t)
+	registerTestNode(t, "node-0001", "worker-1", 8)
+	pod, err := createPod(&Pod{Name: "web", Namespace: "default", CPURequired: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Move the pod between nodes, under the locks reschedulePod takes,
+	// while it restarts.
+	registerTestNode(t, "node-0002", "worker-2", 8)
+	stop := make(chan struct{})
+	moved := make(chan struct{})
+	go func() {
+		defer close(moved)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			nodesMu.Lock()
+			podsMu.Lock()
+			pod.NodeID = []string{"node-0001", "node-0002"}[i%2]
+			podsMu.Unlock()
+			nodesMu.Unlock()
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if err := restartPod(pod.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	<-moved
+	podsMu.Lock()
+	defer podsMu.Unlock()
+	if pod.RestartCount != 50 || pod.Status != "Restarting" {
+		t.Errorf("after 50 restarts: count %d, status %s", pod.RestartCount, pod.Status)
+	}
+}
+
+func TestConcurrentPodCreatesDoNotOvercommit(t *testing.T) {
+	resetState(t)
+	node := registerTestNode(t, "node-0001", "worker-1", 4)
+
+	var wg sync.WaitGroup
+	var created atomic.Int32
+	start := make(chan struct{})
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			if _, err := createPod(&Pod{Name: fmt.Sprintf("web-%d", i), Namespace: "default", CPURequired: 1}); err == nil {
+				created.Add(1)
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	if created.Load() != 4 || node.AvailableCPU != 0 || len(node.Pods) != 4 {
+		t.Errorf("created %d pods of 1 CPU on a 4-CPU node, which has %d CPU left and %d pods", created.Load(), node.AvailableCPU, len(node.Pods))
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsPath serves the Prometheus text exposition of the metrics below.
+const metricsPath = "/metrics"
+
+// Latency buckets in seconds. Requests and scheduling decisions are fast;
+// heartbeat intervals sit around the node agents' 5 second period and grow
+// towards nodeHeartbeatTimeout when a node struggles.
+var (
+	latencyBuckets   = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	heartbeatBuckets = []float64{1, 2.5, 5, 7.5, 10, 15, 20, 30, 60}
+)
+
+var (
+	requestsTotal = newMetricVec("kubesim_apiserver_requests_total", "counter",
+		"Requests answered, by handler, verb and status code.", "handler", "verb", "code")
+	requestDuration = newHistogramVec("kubesim_apiserver_request_duration_seconds",
+		"Time taken to answer requests, by handler and verb.", latencyBuckets, "handler", "verb")
+	schedulingAttempts = newMetricVec("kubesim_scheduler_attempts_total", "counter",
+		"Scheduling attempts, by algorithm and result (scheduled or unschedulable).", "algorithm", "result")
+	schedulingDuration = newHistogramVec("kubesim_scheduler_scheduling_duration_seconds",
+		"Time taken to pick a node, by algorithm.", latencyBuckets, "algorithm")
+	heartbeatInterval = newHistogramVec("kubesim_node_heartbeat_interval_seconds",
+		"Time between consecutive heartbeats from the same node.", heartbeatBuckets)
+	nodeFailures = newMetricVec("kubesim_node_failures_detected_total", "counter",
+		"Nodes the health monitor marked Failed after their heartbeats stopped.", "node")
+	podEvictions = newMetricVec("kubesim_node_pod_evictions_total", "counter",
+		"Pods node agents evicted under resource pressure, by node.", "node")
+)
+
+// metricVec is a counter or gauge family: one value per combination of
+// label values.
+type metricVec struct {
+	name, kind, help string
+	labels           []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newMetricVec(name, kind, help string, labels ...string) *metricVec {
+	return &metricVec{name: name, kind: kind, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (m *metricVec) add(delta float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	m.mu.Lock()
+	m.values[key] += delta
+	m.mu.Unlock()
+}
+
+func (m *metricVec) inc(labelValues ...string) { m.add(1, labelValues...) }
+
+func (m *metricVec) set(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	m.mu.Lock()
+	m.values[key] = value
+	m.mu.Unlock()
+}
+
+func (m *metricVec) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	writeMetricHeader(w, m.name, m.kind, m.help)
+	for _, key := range sortedKeys(m.values) {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, key, "", ""), formatValue(m.values[key]))
+	}
+}
+
+// histogramVec counts observations into cumulative buckets per combination
+// of label values.
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogram)}
+}
+
+func (h *histogramVec) observe(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, upper := range h.buckets {
+		if value <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeMetricHeader(w, h.name, "histogram", h.help)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "le", formatValue(upper)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key, "", ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, key, "", ""), s.count)
+	}
+}
+
+func writeMetricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatLabels renders the label set stored under key, plus extra when it
+// is set, as {a="x",b="y"}.
+func formatLabels(names []string, key, extraName, extraValue string) string {
+	var pairs []string
+	if len(names) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, names[i]+"="+strconv.Quote(value))
+		}
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+"="+strconv.Quote(extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// clusterMetrics computes the gauges that describe the current state of the
+// cluster rather than counting events, so they are always consistent with
+// the store at scrape time.
+func clusterMetrics() []*metricVec {
+	nodeCounts := newMetricVec("kubesim_nodes", "gauge", "Nodes, by health status.", "status")
+	capacity := newMetricVec("kubesim_node_cpu_capacity_cores", "gauge", "CPU cores each node offers.", "node")
+	allocated := newMetricVec("kubesim_node_cpu_allocated_cores", "gauge", "CPU cores requested by the pods bound to each node.", "node")
+	pending := newMetricVec("kubesim_scheduler_pending_pods", "gauge", "Pods waiting for a node, such as those being moved off a failed node.")
+
+	for _, status := range []string{"Healthy", "Starting", nodeStatusUnknown, "Stopped", "Failed"} {
+		nodeCounts.set(0, status)
+	}
+	nodesMu.Lock()
+	for _, node := range nodes {
+		nodeCounts.add(1, node.HealthStatus)
+		capacity.set(float64(node.CPUCores), node.Name)
+		allocated.set(float64(node.CPUCores-node.AvailableCPU), node.Name)
+	}
+	nodesMu.Unlock()
+
+	waiting := 0
+	podsMu.Lock()
+	for _, pod := range pods {
+		if pod.Status == "Rescheduling" || pod.NodeID == "" {
+			waiting++
+		}
+	}
+	podsMu.Unlock()
+	pending.set(float64(waiting))
+
+	controllers := newMetricVec("kubesim_controllers_active", "gauge", "1 when this server holds the controller lease and runs its controllers, 0 otherwise.")
+	active := 0.0
+	if controllersActive() {
+		active = 1
+	}
+	controllers.set(active)
+
+	metrics := []*metricVec{nodeCounts, capacity, allocated, pending, controllers}
+	if replication != nil {
+		st := replication.status()
+		leader := newMetricVec("kubesim_raft_leader", "gauge", "1 when this replica is the leader, 0 otherwise.")
+		term := newMetricVec("kubesim_raft_term", "gauge", "Current Raft term.")
+		applied := newMetricVec("kubesim_raft_applied_index", "gauge", "Index of the last log entry this replica applied.")
+		leading := 0.0
+		if st.Role == raftLeader {
+			leading = 1
+		}
+		leader.set(leading)
+		term.set(float64(st.Term))
+		applied.set(float64(st.AppliedIndex))
+		metrics = append(metrics, leader, term, applied)
+	}
+	return metrics
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	requestsTotal.write(w)
+	requestDuration.write(w)
+	schedulingAttempts.write(w)
+	schedulingDuration.write(w)
+	heartbeatInterval.write(w)
+	nodeFailures.write(w)
+	podEvictions.write(w)
+	for _, m := range clusterMetrics() {
+		m.write(w)
+	}
+}
+
+// statusRecorder remembers the status code a handler answered with.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(data []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush keeps watch streams working through the wrapper.
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// withMetrics counts and times every request, including those rejected
+// before they reach a handler. The handler label is the route pattern that
+// serves the path, which keeps the number of series bounded. Watches are
+// counted but not timed, since they last as long as the client wants.
+func withMetrics(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		_, handler := mux.Handler(r)
+		if handler == "" {
+			handler = "unmatched"
+		}
+		verb := r.Method
+		if isWatchRequest(r) {
+			verb = "WATCH"
+		}
+		rw := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+		if rw.code == 0 {
+			rw.code = http.StatusOK
+		}
+		requestsTotal.inc(handler, verb, strconv.Itoa(rw.code))
+		if verb != "WATCH" {
+			requestDuration.observe(time.Since(start).Seconds(), handler, verb)
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape returns the exposition served at /metrics.
+func scrape(t *testing.T) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handleMetrics(w, httptest.NewRequest("GET", metricsPath, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("scraping metrics: got %d", w.Code)
+	}
+	return w.Body.String()
+}
+
+// expectLines fails t for each line missing from exposition.
+func expectLines(t *testing.T, exposition string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains("\n"+exposition, "\n"+line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, exposition)
+		}
+	}
+}
+
+func TestMetricVecExposition(t *testing.T) {
+	m := newMetricVec("test_total", "counter", "Things.", "kind", "result")
+	m.inc("pod", "ok")
+	m.add(2, "pod", "ok")
+	m.inc("node", `said "no"`)
+	var out strings.Builder
+	m.write(&out)
+	// Series are sorted, and label values quoted.
+	want := `# HELP test_total Things.
+# TYPE test_total counter
+test_total{kind="node",result="said \"no\""} 1
+test_total{kind="pod",result="ok"} 3
+`
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	gauge := newMetricVec("test_gauge", "gauge", "A level.")
+	gauge.set(5)
+	gauge.set(0.25)
+	out.Reset()
+	gauge.write(&out)
+	expectLines(t, out.String(), "test_gauge 0.25")
+}
+
+func TestHistogramExposition(t *testing.T) {
+	h := newHistogramVec("test_seconds", "Durations.", []float64{0.1, 1}, "op")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		h.observe(v, "get")
+	}
+	var out strings.Builder
+	h.write(&out)
+	// Buckets are cumulative and +Inf counts everything.
+	expectLines(t, out.String(),
+		"# TYPE test_seconds histogram",
+		`test_seconds_bucket{op="get",le="0.1"} 2`,
+		`test_seconds_bucket{op="get",le="1"} 3`,
+		`test_seconds_bucket{op="get",le="+Inf"} 4`,
+		`test_seconds_sum{op="get"} 3.65`,
+		`test_seconds_count{op="get"} 4`,
+	)
+}
+
+func TestWithMetrics(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/namespaces/{namespace}/pods", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /api/v1/nodes", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	requestsTotal.mu.Lock()
+	requestsTotal.values = make(map[string]float64)
+	requestsTotal.mu.Unlock()
+	requestDuration.mu.Lock()
+	requestDuration.series = make(map[string]*histogram)
+	requestDuration.mu.Unlock()
+	handler := withMetrics(mux, mux)
+	for _, target := range []string{
+		"/api/v1/namespaces/a/pods",
+		"/api/v1/namespaces/b/pods",
+		"/api/v1/namespaces/a/pods?watch=true",
+		"/api/v1/nodes",
+		"/no/such/path",
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+
+	var out strings.Builder
+	requestsTotal.write(&out)
+	// Paths are counted under the pattern serving them, and the first
+	// status written is the one recorded.
+	expectLines(t, out.String(),
+		`kubesim_apiserver_requests_total{handler="GET /api/v1/namespaces/{namespace}/pods",verb="GET",code="200"} 2`,
+		`kubesim_apiserver_requests_total{handler="GET /api/v1/namespaces/{namespace}/pods",verb="WATCH",code="200"} 1`,
+		`kubesim_apiserver_requests_total{handler="GET /api/v1/nodes",verb="GET",code="403"} 1`,
+		`kubesim_apiserver_requests_total{handler="unmatched",verb="GET",code="404"} 1`,
+	)
+	out.Reset()
+	requestDuration.write(&out)
+	if strings.Contains(out.String(), `verb="WATCH"`) {
+		t.Error("watches were timed")
+	}
+	expectLines(t, out.String(), `kubesim_apiserver_request_duration_seconds_count{handler="GET /api/v1/namespaces/{namespace}/pods",verb="GET"} 2`)
+}
+
+func TestClusterMetrics(t *testing.T) {
+	resetState(t)
+	node := registerTestNode(t, "node-0001", "worker-1", 4)
+	if _, err := createPod(&Pod{Name: "web", CPURequired: 3}); err != nil {
+		t.Fatal(err)
+	}
+	expectLines(t, scrape(t),
+		`kubesim_nodes{status="`+node.HealthStatus+`"} 1`,
+		`kubesim_nodes{status="Failed"} 0`,
+		`kubesim_node_cpu_capacity_cores{node="worker-1"} 4`,
+		`kubesim_node_cpu_allocated_cores{node="worker-1"} 3`,
+		"kubesim_scheduler_pending_pods 0",
+	)
+
+	w := httptest.NewRecorder()
+	handleMetrics(w, httptest.NewRequest("POST", metricsPath, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST %s: got %d", metricsPath, w.Code)
+	}
+}
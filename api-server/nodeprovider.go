@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	v1 "example.com/m/api/v1"
+	"example.com/m/node/agent"
+)
+
+// Node providers, chosen with -node-provider.
+const (
+	nodeProviderDocker    = "docker"
+	nodeProviderProcess   = "process"
+	nodeProviderInProcess = "inprocess"
+	nodeProviderNone      = "none"
+)
+
+// NodeProvider runs the agents of nodes. The API server registers a node
+// and launches its agent through the provider; the agent then reports to
+// the API server like any other. Agents are named by node ID.
+type NodeProvider interface {
+	// Launch starts a new agent for the node reg declares, authenticating
+	// with token. The agent registers the node as declared when it starts.
+	Launch(reg v1.NodeRegistration, token string) error
+	// Running reports whether the node's agent is running, or errNotFound
+	// if the provider has no agent for it.
+	Running(nodeID string) (bool, error)
+	Stop(nodeID string) error
+	// Start starts a stopped agent again.
+	Start(nodeID string) error
+	// Remove stops the agent, if running, and forgets it.
+	Remove(nodeID string) error
+}
+
+// agentEnv is what every agent is told at launch, besides the node it
+// registers: where the API server is, how to authenticate to it and verify
+// it, how to spread its heartbeats, and whether to run pod commands.
+type agentEnv struct {
+	apiServer       string
+	caData          func() []byte
+	heartbeatJitter float64
+	// allowPodCommands lets agents run each pod's command instead of a
+	// stand-in; set only by -allow-pod-commands.
+	allowPodCommands bool
+}
+
+// environ lists env and the node's registration as the node binary's
+// environment variables.
+func (env agentEnv) environ(reg v1.NodeRegistration, token string) []string {
+	vars := []string{
+		"NODE_ID=" + reg.NodeID,
+		"NODE_NAME=" + reg.Name,
+		"NODE_CPU_CORES=" + strconv.Itoa(reg.CPUCores),
+		"NODE_TOKEN=" + token,
+		"API_SERVER=" + env.apiServer,
+	}
+	if len(reg.Labels) > 0 {
+		pairs := make([]string, 0, len(reg.Labels))
+		for k, v := range reg.Labels {
+			pairs = append(pairs, k+"="+v)
+		}
+		sort.Strings(pairs)
+		vars = append(vars, "NODE_LABELS="+strings.Join(pairs, ","))
+	}
+	if bundle := env.caData(); bundle != nil {
+		vars = append(vars, "API_CA_DATA="+string(bundle))
+	}
+	if env.heartbeatJitter > 0 {
+		vars = append(vars, "HEARTBEAT_JITTER="+strconv.FormatFloat(env.heartbeatJitter, 'g', -1, 64))
+	}
+	if env.allowPodCommands {
+		vars = append(vars, "ALLOW_POD_COMMANDS=true")
+	}
+	return vars
+}
+
+// checkPodCommands refuses -allow-pod-commands while anyone may create
+// pods: with anonymous requests allowed and every request authorized, any
+// caller could run commands on the nodes.
+func checkPodCommands(allow bool) error {
+	if allow && allowAnonymous && authorizationMode == authorizationAlwaysAllow {
+		return fmt.Errorf("-allow-pod-commands requires -anonymous-auth=false or -authorization-mode=%s", authorizationRBAC)
+	}
+	return nil
+}
+
+// inheritedEnviron is the API server's environment as passed on to agent
+// processes, without ALLOW_POD_COMMANDS: only -allow-pod-commands turns
+// pod commands on.
+func inheritedEnviron() []string {
+	var vars []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "ALLOW_POD_COMMANDS=") {
+			vars = append(vars, kv)
+		}
+	}
+	return vars
+}
+
+// nodeProvider runs node agents; set at startup from -node-provider.
+var nodeProvider NodeProvider
+
+// inProcessIdleConns is how many idle connections in-process agents keep to
+// the API server between heartbeats.
+const inProcessIdleConns = 256
+
+func newNodeProvider(kind, image, agentPath, logDir string, env agentEnv) (NodeProvider, error) {
+	switch kind {
+	case nodeProviderDocker:
+		return &dockerProvider{image: image, env: env}, nil
+	case nodeProviderProcess:
+		if _, err := exec.LookPath(agentPath); err != nil {
+			return nil, fmt.Errorf("node agent binary: %v", err)
+		}
+		return &processProvider{path: agentPath, logDir: logDir, env: env, agents: make(map[string]*agentProcess)}, nil
+	case nodeProviderInProcess:
+		// Agents share one connection pool, so that thousands of them do not
+		// each hold connections of their own.
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConnsPerHost = inProcessIdleConns
+		if bundle := env.caData(); bundle != nil {
+			tlsTransport, err := agent.TLSTransport("", "", "", bundle)
+			if err != nil {
+				return nil, err
+			}
+			transport.TLSClientConfig = tlsTransport.(*http.Transport).TLSClientConfig
+		}
+		return &inProcessProvider{env: env, transport: transport, agents: make(map[string]*inProcessAgent)}, nil
+	case nodeProviderNone:
+		return noProvider{}, nil
+	}
+	return nil, fmt.Errorf("-node-provider must be %s, %s, %s or %s", nodeProviderDocker, nodeProviderProcess, nodeProviderInProcess, nodeProviderNone)
+}
+
+// dockerProvider runs each agent in a container named node-<id>.
+type dockerProvider struct {
+	image string
+	env   agentEnv
+}
+
+func containerName(nodeID string) string {
+	return "node-" + nodeID
+}
+
+func (p *dockerProvider) Launch(reg v1.NodeRegistration, token string) error {
+	args := []string{"run", "-d", "--name", containerName(reg.NodeID)}
+	for _, v := range p.env.environ(reg, token) {
+		args = append(args, "-e", v)
+	}
+	return exec.Command("docker", append(args, p.image)...).Run()
+}
+
+func (p *dockerProvider) Running(nodeID string) (bool, error) {
+	output, err := exec.Command("docker", "inspect", "-f", "{{.State.Running}}", containerName(nodeID)).Output()
+	if err != nil {
+		return false, fmt.Errorf("container for node %s: %w", nodeID, errNotFound)
+	}
+	return strings.TrimSpace(string(output)) == "true", nil
+}
+
+func (p *dockerProvider) Stop(nodeID string) error {
+	return exec.Command("docker", "stop", containerName(nodeID)).Run()
+}
+
+func (p *dockerProvider) Start(nodeID string) error {
+	return exec.Command("docker", "start", containerName(nodeID)).Run()
+}
+
+func (p *dockerProvider) Remove(nodeID string) error {
+	return exec.Command("docker", "rm", "-f", containerName(nodeID)).Run()
+}
+
+// processProvider runs each agent as a local process of the node binary.
+// Agents outlive a restart of the API server but are no longer tracked by
+// it, so they cannot be stopped through it.
+type processProvider struct {
+	path string
+	// logDir receives node-<id>.log per agent; output is discarded when
+	// empty.
+	logDir string
+	env    agentEnv
+
+	mu     sync.Mutex
+	agents map[string]*agentProcess
+}
+
+type agentProcess struct {
+	environ []string
+	cmd     *exec.Cmd
+	// exited is closed once cmd has been waited for.
+	exited chan struct{}
+}
+
+func (p *processProvider) Launch(reg v1.NodeRegistration, token string) error {
+	nodeID := reg.NodeID
+	a := &agentProcess{environ: append(inheritedEnviron(), p.env.environ(reg, token)...)}
+	if p.logDir != "" {
+		a.environ = append(a.environ, "POD_LOG_DIR="+p.logDir)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, exists := p.agents[nodeID]; exists {
+		return fmt.Errorf("an agent for node %s is already running", nodeID)
+	}
+	if err := p.start(nodeID, a); err != nil {
+		return err
+	}
+	p.agents[nodeID] = a
+	return nil
+}
+
+// start runs a's process. Callers must hold p.mu.
+func (p *processProvider) start(nodeID string, a *agentProcess) error {
+	cmd := exec.Command(p.path)
+	cmd.Env = a.environ
+	var logFile *os.File
+	if p.logDir != "" {
+		var err error
+		logFile, err = os.OpenFile(filepath.Join(p.logDir, containerName(nodeID)+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		cmd.Stdout, cmd.Stderr = logFile, logFile
+	}
+	if err := cmd.Start(); err != nil {
+		if logFile != nil {
+			logFile.Close()
+		}
+		return err
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		if logFile != nil {
+			logFile.Close()
+		}
+		close(exited)
+	}()
+	a.cmd, a.exited = cmd, exited
+	return nil
+}
+
+func (p *processProvider) Running(nodeID string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	a, exists := p.agents[nodeID]
+	if !exists {
+		return false, fmt.Errorf("agent process for node %s: %w", nodeID, errNotFound)
+	}
+	select {
+	case <-a.exited:
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+func (p *processProvider) Stop(nodeID string) error {
+	p.mu.Lock()
+	a, exists := p.agents[nodeID]
+	p.mu.Unlock()
+	if !exists {
+		return fmt.Errorf("agent process for node %s: %w", nodeID, errNotFound)
+	}
+	a.cmd.Process.Kill()
+	<-a.exited
+	return nil
+}
+
+func (p *processProvider) Start(nodeID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	a, exists := p.agents[nodeID]
+	if !exists {
+		return fmt.Errorf("agent process for node %s: %w", nodeID, errNotFound)
+	}
+	select {
+	case <-a.exited:
+	default:
+		return nil
+	}
+	return p.start(nodeID, a)
+}
+
+func (p *processProvider) Remove(nodeID string) error {
+	// An agent the provider does not know is already gone.
+	p.Stop(nodeID)
+	p.mu.Lock()
+	delete(p.agents, nodeID)
+	p.mu.Unlock()
+	return nil
+}
+
+// inProcessProvider runs each agent as a goroutine of the API server, so
+// nodes need neither Docker nor a node binary. Agents stop with the server,
+// and run their pods as stand-ins.
+type inProcessProvider struct {
+	env       agentEnv
+	transport http.RoundTripper
+
+	mu     sync.Mutex
+	agents map[string]*inProcessAgent
+}
+
+type inProcessAgent struct {
+	config agent.Config
+	// cancel stops the agent; nil while it is stopped.
+	cancel context.CancelFunc
+}
+
+func (p *inProcessProvider) Launch(reg v1.NodeRegistration, token string) error {
+	nodeID := reg.NodeID
+	a := &inProcessAgent{config: agent.Config{
+		NodeID:          nodeID,
+		Name:            reg.Name,
+		CPUCores:        reg.CPUCores,
+		Labels:          reg.Labels,
+		APIServer:       p.env.apiServer,
+		Token:           token,
+		Transport:       p.transport,
+		HeartbeatJitter: p.env.heartbeatJitter,
+		// The API server runs no pod commands itself, so RunPodCommands
+		// stays unset.
+		Out: io.Discard,
+	}}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, exists := p.agents[nodeID]; exists {
+		return fmt.Errorf("an agent for node %s is already running", nodeID)
+	}
+	p.start(a)
+	p.agents[nodeID] = a
+	return nil
+}
+
+// start runs a in a new goroutine. Callers must hold p.mu.
+func (p *inProcessProvider) start(a *inProcessAgent) {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+	go agent.Run(ctx, a.config)
+}
+
+func (p *inProcessProvider) Running(nodeID string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	a, exists := p.agents[nodeID]
+	if !exists {
+		return false, fmt.Errorf("agent for node %s: %w", nodeID, errNotFound)
+	}
+	return a.cancel != nil, nil
+}
+
+func (p *inProcessProvider) Stop(nodeID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	a, exists := p.agents[nodeID]
+	if !exists {
+		return fmt.Errorf("agent for node %s: %w", nodeID, errNotFound)
+	}
+	if a.cancel != nil {
+		a.cancel()
+		a.cancel = nil
+	}
+	return nil
+}
+
+func (p *inProcessProvider) Start(nodeID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	a, exists := p.agents[nodeID]
+	if !exists {
+		return fmt.Errorf("agent for node %s: %w", nodeID, errNotFound)
+	}
+	if a.cancel == nil {
+		p.start(a)
+	}
+	return nil
+}
+
+func (p *inProcessProvider) Remove(nodeID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if a, exists := p.agents[nodeID]; exists && a.cancel != nil {
+		a.cancel()
+	}
+	delete(p.agents, nodeID)
+	return nil
+}
+
+// noProvider launches nothing: the agents of nodes are run outside the API
+// server, by the hollow node binary for example, and report for nodes
+// created through the API. The server cannot stop or restart them.
+type noProvider struct{}
+
+var errAgentsNotManaged = errors.New("node agents are not run by the API server (-node-provider=none)")
+
+func (noProvider) Launch(reg v1.NodeRegistration, token string) error { return nil }
+
+func (noProvider) Running(nodeID string) (bool, error) { return false, errAgentsNotManaged }
+
+func (noProvider) Stop(nodeID string) error  { return errAgentsNotManaged }
+func (noProvider) Start(nodeID string) error { return errAgentsNotManaged }
+
+func (noProvider) Remove(nodeID string) error { return nil }
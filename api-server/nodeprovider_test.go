@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "example.com/m/api/v1"
+)
+
+func TestPodCommandsNeedOptIn(t *testing.T) {
+	reg := v1.NodeRegistration{NodeID: "n1", Name: "worker-1", CPUCores: 2}
+	env := agentEnv{apiServer: "http://localhost:8080", caData: func() []byte { return nil }}
+	if vars := env.environ(reg, "token"); slices.Contains(vars, "ALLOW_POD_COMMANDS=true") {
+		t.Errorf("agents run pod commands without -allow-pod-commands: %q", vars)
+	}
+	env.allowPodCommands = true
+	if vars := env.environ(reg, "token"); !slices.Contains(vars, "ALLOW_POD_COMMANDS=true") {
+		t.Errorf("agents run stand-ins with -allow-pod-commands: %q", vars)
+	}
+
+	// Agent processes do not pick it up from the API server's environment.
+	t.Setenv("ALLOW_POD_COMMANDS", "true")
+	if slices.Contains(inheritedEnviron(), "ALLOW_POD_COMMANDS=true") {
+		t.Error("agent processes inherit ALLOW_POD_COMMANDS")
+	}
+	if len(inheritedEnviron()) != len(os.Environ())-1 {
+		t.Error("agent processes do not inherit the rest of the environment")
+	}
+}
+
+func TestPodCommandsRefusedWhenAnyoneMayCreatePods(t *testing.T) {
+	t.Cleanup(func() {
+		allowAnonymous, authorizationMode = true, authorizationAlwaysAllow
+	})
+	for _, tc := range []struct {
+		anonymous bool
+		mode      string
+		allowed   bool
+	}{
+		{true, authorizationAlwaysAllow, false},
+		{false, authorizationAlwaysAllow, true},
+		{true, authorizationRBAC, true},
+		{false, authorizationRBAC, true},
+	} {
+		allowAnonymous, authorizationMode = tc.anonymous, tc.mode
+		if err := checkPodCommands(true); (err == nil) != tc.allowed {
+			t.Errorf("anonymous %v, %s: got %v, want allowed %v", tc.anonymous, tc.mode, err, tc.allowed)
+		}
+		if err := checkPodCommands(false); err != nil {
+			t.Errorf("anonymous %v, %s without pod commands: %v", tc.anonymous, tc.mode, err)
+		}
+	}
+}
+
+func TestAgentEnviron(t *testing.T) {
+	reg := v1.NodeRegistration{NodeID: "n1", Name: "worker-1", CPUCores: 2, Labels: map[string]string{"zone": "b", "disk": "ssd"}}
+	env := agentEnv{apiServer: "https://api:8443", caData: func() []byte { return []byte("PEM") }, heartbeatJitter: 0.25}
+	want := []string{
+		"NODE_ID=n1",
+		"NODE_NAME=worker-1",
+		"NODE_CPU_CORES=2",
+		"NODE_TOKEN=token",
+		"API_SERVER=https://api:8443",
+		"NODE_LABELS=disk=ssd,zone=b",
+		"API_CA_DATA=PEM",
+		"HEARTBEAT_JITTER=0.25",
+	}
+	if got := env.environ(reg, "token"); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNewNodeProvider(t *testing.T) {
+	env := agentEnv{caData: func() []byte { return nil }}
+	if _, err := newNodeProvider("vm", "", "", "", env); err == nil {
+		t.Error("accepted an unknown provider")
+	}
+	if _, err := newNodeProvider(nodeProviderProcess, "", filepath.Join(t.TempDir(), "node"), "", env); err == nil {
+		t.Error("accepted a missing agent binary")
+	}
+	p, err := newNodeProvider(nodeProviderNone, "", "", "", env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Launch(v1.NodeRegistration{NodeID: "n1"}, "token"); err != nil {
+		t.Errorf("launching outside the server: %v", err)
+	}
+	if _, err := p.Running("n1"); !errors.Is(err, errAgentsNotManaged) {
+		t.Errorf("Running: got %v", err)
+	}
+	if err := p.Stop("n1"); !errors.Is(err, errAgentsNotManaged) {
+		t.Errorf("Stop: got %v", err)
+	}
+}
+
+// checkRunning fails t unless the provider reports the agent of nodeID as
+// want.
+func checkRunning(t *testing.T, p NodeProvider, nodeID string, want bool) {
+	t.Helper()
+	if running, err := p.Running(nodeID); err != nil || running != want {
+		t.Errorf("running %v, %v; want %v", running, err, want)
+	}
+}
+
+func TestProcessProvider(t *testing.T) {
+	dir := t.TempDir()
+	// The agent records its environment and waits to be stopped.
+	agentPath := filepath.Join(dir, "node")
+	script := "#!/bin/sh\nenv > \"$POD_LOG_DIR/$NODE_ID.env\"\nexec sleep 60\n"
+	if err := os.WriteFile(agentPath, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	p, err := newNodeProvider(nodeProviderProcess, "", agentPath, dir, agentEnv{apiServer: "http://api:8080", caData: func() []byte { return nil }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Remove("n1") })
+	reg := v1.NodeRegistration{NodeID: "n1", Name: "worker-1", CPUCores: 2}
+	if err := p.Launch(reg, "token"); err != nil {
+		t.Fatal(err)
+	}
+	checkRunning(t, p, "n1", true)
+	if err := p.Launch(reg, "token"); err == nil {
+		t.Error("launched a second agent for the node")
+	}
+
+	var environ string
+	for deadline := time.Now().Add(5 * time.Second); environ == "" && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		data, _ := os.ReadFile(filepath.Join(dir, "n1.env"))
+		environ = string(data)
+	}
+	for _, v := range []string{"NODE_ID=n1", "NODE_TOKEN=token", "API_SERVER=http://api:8080", "POD_LOG_DIR=" + dir} {
+		if !strings.Contains(environ, v+"\n") {
+			t.Errorf("agent environment lacks %s:\n%s", v, environ)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, containerName("n1")+".log")); err != nil {
+		t.Errorf("agent log: %v", err)
+	}
+
+	if err := p.Stop("n1"); err != nil {
+		t.Fatal(err)
+	}
+	checkRunning(t, p, "n1", false)
+	if err := p.Start("n1"); err != nil {
+		t.Fatal(err)
+	}
+	checkRunning(t, p, "n1", true)
+	if err := p.Remove("n1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Running("n1"); !errors.Is(err, errNotFound) {
+		t.Errorf("Running after Remove: got %v", err)
+	}
+	if err := p.Start("n1"); !errors.Is(err, errNotFound) {
+		t.Errorf("Start after Remove: got %v", err)
+	}
+}
+
+func TestInProcessProvider(t *testing.T) {
+	// The agents keep retrying against a server that is not ready.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	p, err := newNodeProvider(nodeProviderInProcess, "", "", "", agentEnv{apiServer: srv.URL, caData: func() []byte { return nil }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Remove("n1")
+	reg := v1.NodeRegistration{NodeID: "n1", Name: "worker-1", CPUCores: 2}
+	if err := p.Launch(reg, "token"); err != nil {
+		t.Fatal(err)
+	}
+	checkRunning(t, p, "n1", true)
+	if err := p.Launch(reg, "token"); err == nil {
+		t.Error("launched a second agent for the node")
+	}
+	if err := p.Stop("n1"); err != nil {
+		t.Fatal(err)
+	}
+	checkRunning(t, p, "n1", false)
+	if err := p.Start("n1"); err != nil {
+		t.Fatal(err)
+	}
+	checkRunning(t, p, "n1", true)
+	p.Remove("n1")
+	if _, err := p.Running("n1"); !errors.Is(err, errNotFound) {
+		t.Errorf("Running after Remove: got %v", err)
+	}
+}
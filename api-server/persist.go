@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	v1 "example.com/m/api/v1"
+)
+
+// signingKeyFile holds the token signing key in -data-dir.
+const signingKeyFile = "service-account.key"
+
+// nodeStatusUnknown is the health of a node restored from storage until it
+// sends a heartbeat or the health monitor gives up on it.
+const nodeStatusUnknown = "Unknown"
+
+// schedulerConfigKind is the kind the scheduler configuration is stored
+// under.
+const schedulerConfigKind = "SchedulerConfig"
+
+// store is the system of record. Every change to the object stores is
+// written to it by watches.commit; the maps guarded by nodesMu, podsMu and
+// the rest are the decoded cache the handlers read, loaded from it at
+// startup.
+var store Storage = newKVStore(newMemoryEngine())
+
+// storeKey is the key an object is stored under within its kind.
+func storeKey(kind string, obj interface{}) string {
+	switch o := obj.(type) {
+	case Node:
+		return o.ID
+	case Pod:
+		return o.ID
+	case Deployment:
+		return deploymentKey(o.Namespace, o.Name)
+	case Event:
+		return o.Name
+	case v1.SchedulerConfig:
+		return "cluster"
+	case *v1.Lease:
+		return leaseKey(o.Metadata.Namespace, o.Metadata.Name)
+	case v1.Object:
+		if res, ok := rbacResources[kind]; ok {
+			meta := o.GetObjectMeta()
+			return res.key(meta.Namespace, meta.Name)
+		}
+	}
+	return ""
+}
+
+// storageKey is the Storage key of an object: /<kind>/<key>.
+func storageKey(kind string, obj interface{}) string {
+	return "/" + kind + "/" + storeKey(kind, obj)
+}
+
+// storageOp encodes a change for Storage.Txn.
+func storageOp(ev WatchEvent) (Op, error) {
+	op := Op{Key: storageKey(ev.Kind, ev.Object)}
+	if ev.Type == EventDeleted {
+		return op, nil
+	}
+	data, err := json.Marshal(ev.Object)
+	if err != nil {
+		return Op{}, fmt.Errorf("encoding %s: %v", op.Key, err)
+	}
+	op.Value = data
+	return op, nil
+}
+
+// loadStores fills the empty object stores from storage and starts the
+// watch cache at its revision. Watches cannot resume from before a restart,
+// since the history is gone. It returns how many objects were loaded.
+func loadStores() (int, error) {
+	kvs, rev, err := store.List("/")
+	if err != nil {
+		return 0, err
+	}
+	for _, kv := range kvs {
+		if err := decodeIntoStores(kv); err != nil {
+			return 0, err
+		}
+	}
+	watches.rv = rev
+	watches.compactedRV = rev
+	return len(kvs), nil
+}
+
+func decodeIntoStores(kv KeyValue) error {
+	kind, key, _ := strings.Cut(strings.TrimPrefix(kv.Key, "/"), "/")
+	decode := func(into interface{}) error {
+		if err := json.Unmarshal(kv.Value, into); err != nil {
+			return fmt.Errorf("decoding %s: %v", kv.Key, err)
+		}
+		return nil
+	}
+	switch kind {
+	case "Node":
+		node := &Node{}
+		if err := decode(node); err != nil {
+			return err
+		}
+		nodes[key] = node
+	case "Pod":
+		pod := &Pod{}
+		if err := decode(pod); err != nil {
+			return err
+		}
+		pods[key] = pod
+	case "Deployment":
+		d := &Deployment{}
+		if err := decode(d); err != nil {
+			return err
+		}
+		deployments[key] = d
+	case "Event":
+		e := &Event{}
+		if err := decode(e); err != nil {
+			return err
+		}
+		events[key] = e
+		eventsByKey[e.dedupKey()] = e
+	case schedulerConfigKind:
+		var cfg v1.SchedulerConfig
+		if err := decode(&cfg); err != nil {
+			return err
+		}
+		scheduler.Algorithm = cfg.Algorithm
+	case "Lease":
+		l := &v1.Lease{}
+		if err := decode(l); err != nil {
+			return err
+		}
+		leases[key] = l
+	default:
+		res, ok := rbacResources[kind]
+		if !ok {
+			log.Printf("Ignoring stored %s of unknown kind", kv.Key)
+			return nil
+		}
+		obj := res.newObject()
+		if err := decode(obj); err != nil {
+			return err
+		}
+		res.objects[key] = obj
+	}
+	// Storage decides the version: the next change to the object is
+	// compared against it.
+	if kv.ModRevision != 0 {
+		setResourceVersion(kind, key, kv.ModRevision)
+	}
+	return nil
+}
+
+// persistSchedulerConfig stores a change of scheduling algorithm.
+func persistSchedulerConfig(cfg v1.SchedulerConfig) error {
+	return watches.notify(schedulerConfigKind, EventModified, 0, func(rv uint64) interface{} { return cfg })
+}
+
+// reconcileRestoredNodes waits for the nodes that were running before the
+// restart to report in. Their containers may have kept running, or may be
+// gone: until a heartbeat arrives they are Unknown, so nothing is scheduled
+// onto them, and the health monitor fails them and reschedules their pods
+// if none arrives within nodeHeartbeatTimeout.
+//
+// The nodes change in one commit. If it does not commit, the cache is
+// reloaded as it was, and a replica tries again the next time it leads.
+func reconcileRestoredNodes() {
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	var restored []*Node
+	var changes []objectChange
+	for _, node := range nodes {
+		if node.HealthStatus == "Stopped" || node.HealthStatus == "Failed" {
+			continue
+		}
+		node.HealthStatus = nodeStatusUnknown
+		node.LastHeartbeat = time.Now()
+		restored = append(restored, node)
+		changes = append(changes, nodeChange(EventModified, node))
+	}
+	if len(changes) == 0 {
+		return
+	}
+	if _, err := watches.commit(changes...); err != nil {
+		log.Printf("Reconciling restored nodes: %v", err)
+		return
+	}
+	for _, node := range restored {
+		recordEvent(nodeRef(node), sourceHealthMonitor, v1.EventTypeNormal, "NodeRestored",
+			"Restored from storage; waiting for a heartbeat")
+	}
+}
+
+// relaunchRestoredAgents starts the agents of restored nodes again, for
+// providers whose agents stopped with the previous run of the server.
+// Stopped nodes get an agent that is stopped too, for a restart to start.
+func relaunchRestoredAgents() {
+	nodesMu.Lock()
+	var restoredNodes []Node
+	for _, node := range nodes {
+		if node.HealthStatus != "Failed" {
+			restoredNodes = append(restoredNodes, copyNode(node))
+		}
+	}
+	nodesMu.Unlock()
+	for _, node := range restoredNodes {
+		if err := launchNodeAgent(&node); err != nil {
+			log.Printf("Relaunching the agent of node %s: %v", node.Name, err)
+			continue
+		}
+		if node.HealthStatus == "Stopped" {
+			nodeProvider.Stop(node.ID)
+		}
+	}
+}
+
+// closeStorageOnSignal closes the storage when the server is asked to stop,
+// so the file backend writes a final snapshot, then exits.
+func closeStorageOnSignal() {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	// Holding the watch cache lock keeps further changes out.
+	watches.mu.Lock()
+	if err := store.Close(); err != nil {
+		log.Printf("Closing storage: %v", err)
+	}
+	fmt.Printf("%s%s[*] %sStorage closed; shutting down%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	os.Exit(0)
+}
+
+// ensureSigningKey returns the file holding the token signing key in dir,
+// generating one on first start, so node and service account tokens stay
+// valid across restarts.
+func ensureSigningKey(dir string) (string, error) {
+	path := filepath.Join(dir, signingKeyFile)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return path, writeFileSynced(path, []byte(hex.EncodeToString(key)))
+}
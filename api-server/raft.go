@@ -0,0 +1,1041 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Raft roles.
+const (
+	raftFollower  = "follower"
+	raftCandidate = "candidate"
+	raftLeader    = "leader"
+)
+
+const (
+	// raftHeartbeatInterval is how often a leader contacts idle followers.
+	raftHeartbeatInterval = 50 * time.Millisecond
+	// raftElectionTimeout is the least time a follower waits without hearing
+	// from a leader before standing for election; each wait is randomized
+	// between it and twice it so that replicas rarely stand at once.
+	raftElectionTimeout = 300 * time.Millisecond
+	raftRPCTimeout      = 250 * time.Millisecond
+	// raftProposalTimeout is how long a leader waits for a majority to
+	// store an entry before stepping down.
+	raftProposalTimeout = 5 * time.Second
+	// raftMaxAppend caps the entries sent in one AppendEntries.
+	raftMaxAppend = 256
+
+	raftTokenHeader = "X-Raft-Token"
+
+	// Files a replica keeps in <data-dir>/raft.
+	raftStateFile    = "state.json"
+	raftLogFile      = "log.jsonl"
+	raftSnapshotFile = "snapshot.json"
+)
+
+// errNotLeader is returned for writes proposed to a replica that is not, or
+// is no longer, the leader.
+var errNotLeader = errors.New("not the leader")
+
+// raftEntry is one entry of the replicated log: a Storage.Txn. An entry
+// without ops is the no-op a new leader commits to learn which entries of
+// its predecessors are committed.
+type raftEntry struct {
+	Term       uint64      `json:"term"`
+	Index      uint64      `json:"index"`
+	Conditions []Condition `json:"conditions,omitempty"`
+	Ops        []Op        `json:"ops,omitempty"`
+}
+
+// raftSnapshot is the state machine as of Index, which replaces the log up
+// to it.
+type raftSnapshot struct {
+	Index    uint64     `json:"index"`
+	Term     uint64     `json:"term"`
+	Revision uint64     `json:"revision"`
+	KVs      []KeyValue `json:"kvs,omitempty"`
+}
+
+type raftState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"votedFor,omitempty"`
+}
+
+type voteRequest struct {
+	Term         uint64 `json:"term"`
+	Candidate    string `json:"candidate"`
+	LastLogIndex uint64 `json:"lastLogIndex"`
+	LastLogTerm  uint64 `json:"lastLogTerm"`
+}
+
+type voteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+type appendRequest struct {
+	Term         uint64      `json:"term"`
+	Leader       string      `json:"leader"`
+	LeaderURL    string      `json:"leaderURL"`
+	PrevLogIndex uint64      `json:"prevLogIndex"`
+	PrevLogTerm  uint64      `json:"prevLogTerm"`
+	Entries      []raftEntry `json:"entries,omitempty"`
+	LeaderCommit uint64      `json:"leaderCommit"`
+}
+
+// appendResponse acknowledges entries up to MatchIndex or, on failure, asks
+// the leader to continue from ConflictIndex.
+type appendResponse struct {
+	Term          uint64 `json:"term"`
+	Success       bool   `json:"success"`
+	MatchIndex    uint64 `json:"matchIndex"`
+	ConflictIndex uint64 `json:"conflictIndex"`
+}
+
+type installSnapshotRequest struct {
+	Term      uint64       `json:"term"`
+	Leader    string       `json:"leader"`
+	LeaderURL string       `json:"leaderURL"`
+	Snapshot  raftSnapshot `json:"snapshot"`
+}
+
+type readIndexResponse struct {
+	Index uint64 `json:"index"`
+}
+
+type raftResult struct {
+	rev uint64
+	err error
+}
+
+// raftWaiter is a leader's proposal waiting to be applied. If the entry at
+// its index turns out to be from another term, another leader overwrote it.
+type raftWaiter struct {
+	term uint64
+	ch   chan raftResult
+}
+
+// raftNode is one replica of the replicated log. Committed entries are
+// applied, in order, to sm; entries this replica proposed as leader are
+// already in the object cache, the rest are handed to onApply.
+type raftNode struct {
+	id            string
+	url           string
+	peers         map[string]string
+	token         string
+	dir           string
+	snapshotEvery int
+	sm            *kvStore
+	scheme        string
+	client        *http.Client
+	// onApply updates the object cache with a committed entry proposed by
+	// another replica; onRestore reloads it after a snapshot is installed;
+	// onLeading runs once a new leader has applied every earlier entry.
+	onApply   func(ops []Op, rev uint64)
+	onRestore func()
+	onLeading func()
+
+	// applyMu serializes changes to sm.
+	applyMu sync.Mutex
+
+	mu               sync.Mutex
+	role             string
+	term             uint64
+	votedFor         string
+	leader           string
+	leaderURL        string
+	snapshot         raftSnapshot
+	entries          []raftEntry
+	commitIndex      uint64
+	lastApplied      uint64
+	readyIndex       uint64
+	electionDeadline time.Time
+	nextIndex        map[string]uint64
+	matchIndex       map[string]uint64
+	wake             map[string]chan struct{}
+	waiters          map[uint64]raftWaiter
+	applied          chan struct{}
+	commits          chan struct{}
+	logFile          *os.File
+	stopped          bool
+}
+
+// newRaftNode restores a replica from dir, or starts an empty one when dir
+// is empty. peers maps every other replica's ID to its Raft address.
+func newRaftNode(id, url string, peers map[string]string, key []byte, dir string, snapshotEvery int) (*raftNode, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("raft"))
+	n := &raftNode{
+		id:            id,
+		url:           url,
+		peers:         peers,
+		token:         hex.EncodeToString(mac.Sum(nil)),
+		dir:           dir,
+		snapshotEvery: snapshotEvery,
+		scheme:        "http",
+		client:        &http.Client{Transport: peerTransport()},
+		role:          raftFollower,
+		waiters:       make(map[uint64]raftWaiter),
+		applied:       make(chan struct{}),
+		commits:       make(chan struct{}, 1),
+	}
+	if servingCerts != nil {
+		n.scheme = "https"
+	}
+	if err := n.load(); err != nil {
+		return nil, err
+	}
+	n.sm = newKVStore(newMemoryEngineAt(n.snapshot.KVs, n.snapshot.Revision))
+	n.commitIndex = n.snapshot.Index
+	n.lastApplied = n.snapshot.Index
+	n.resetElectionDeadlineLocked()
+	return n, nil
+}
+
+// quorum is the number of replicas, this one included, that make a
+// majority.
+func (n *raftNode) quorum() int { return (len(n.peers)+1)/2 + 1 }
+
+func (n *raftNode) lastIndexLocked() uint64 {
+	if len(n.entries) == 0 {
+		return n.snapshot.Index
+	}
+	return n.entries[len(n.entries)-1].Index
+}
+
+func (n *raftNode) lastTermLocked() uint64 {
+	if len(n.entries) == 0 {
+		return n.snapshot.Term
+	}
+	return n.entries[len(n.entries)-1].Term
+}
+
+// termAtLocked returns the term of the entry at index, or false when it is
+// compacted or beyond the log.
+func (n *raftNode) termAtLocked(index uint64) (uint64, bool) {
+	if index == n.snapshot.Index {
+		return n.snapshot.Term, true
+	}
+	if index < n.snapshot.Index || index > n.lastIndexLocked() {
+		return 0, false
+	}
+	return n.entries[index-n.snapshot.Index-1].Term, true
+}
+
+func (n *raftNode) entryLocked(index uint64) raftEntry {
+	return n.entries[index-n.snapshot.Index-1]
+}
+
+func (n *raftNode) resetElectionDeadlineLocked() {
+	n.electionDeadline = time.Now().Add(raftElectionTimeout + time.Duration(rand.Int63n(int64(raftElectionTimeout))))
+}
+
+// run starts the election timer and the apply loop.
+func (n *raftNode) run() {
+	go n.applyLoop()
+	for range time.Tick(10 * time.Millisecond) {
+		n.mu.Lock()
+		if n.stopped {
+			n.mu.Unlock()
+			return
+		}
+		if n.role != raftLeader && time.Now().After(n.electionDeadline) {
+			n.startElectionLocked()
+		}
+		n.mu.Unlock()
+	}
+}
+
+func (n *raftNode) startElectionLocked() {
+	n.role = raftCandidate
+	n.term++
+	n.votedFor = n.id
+	n.leader, n.leaderURL = "", ""
+	n.persistStateLocked()
+	n.resetElectionDeadlineLocked()
+	term := n.term
+	req := voteRequest{Term: term, Candidate: n.id, LastLogIndex: n.lastIndexLocked(), LastLogTerm: n.lastTermLocked()}
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeaderLocked()
+		return
+	}
+	for peer := range n.peers {
+		go func(peer string) {
+			var resp voteResponse
+			if err := n.call(peer, "vote", req, &resp, raftRPCTimeout); err != nil {
+				return
+			}
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if resp.Term > n.term {
+				n.becomeFollowerLocked(resp.Term)
+				return
+			}
+			if n.role != raftCandidate || n.term != term || !resp.Granted {
+				return
+			}
+			votes++
+			if votes >= n.quorum() {
+				n.becomeLeaderLocked()
+			}
+		}(peer)
+	}
+}
+
+// becomeFollowerLocked steps down, moving to term if it is newer. Pending
+// proposals fail: whether they commit is now up to the next leader.
+func (n *raftNode) becomeFollowerLocked(term uint64) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.leader, n.leaderURL = "", ""
+		n.persistStateLocked()
+	}
+	if n.role == raftLeader {
+		log.Printf("Replica %s stepped down as leader in term %d", n.id, n.term)
+	}
+	n.role = raftFollower
+	for index, w := range n.waiters {
+		w.ch <- raftResult{err: errNotLeader}
+		delete(n.waiters, index)
+	}
+	n.resetElectionDeadlineLocked()
+}
+
+// becomeLeaderLocked takes over and appends a no-op; the replica serves
+// writes once it has applied it.
+func (n *raftNode) becomeLeaderLocked() {
+	n.role = raftLeader
+	n.leader, n.leaderURL = n.id, n.url
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.wake = make(map[string]chan struct{})
+	n.appendLocked(raftEntry{Term: n.term, Index: n.lastIndexLocked() + 1})
+	n.readyIndex = n.lastIndexLocked()
+	log.Printf("Replica %s elected leader for term %d", n.id, n.term)
+	for peer := range n.peers {
+		n.nextIndex[peer] = n.readyIndex
+		n.wake[peer] = make(chan struct{}, 1)
+		go n.replicate(peer, n.term, n.wake[peer])
+	}
+	n.advanceCommitLocked()
+}
+
+// ready reports whether this replica leads and has applied every entry of
+// earlier terms, so its object cache is complete and it may take writes.
+func (n *raftNode) ready() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.readyLocked()
+}
+
+func (n *raftNode) readyLocked() bool {
+	return n.role == raftLeader && n.lastApplied >= n.readyIndex
+}
+
+// currentLeader returns the ID and API server URL of the leader, if known.
+func (n *raftNode) currentLeader() (string, string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader, n.leaderURL
+}
+
+// appendLocked adds entries to the log and to the log file, which is
+// synced before the entries are acknowledged or counted towards a majority.
+func (n *raftNode) appendLocked(entries ...raftEntry) {
+	n.entries = append(n.entries, entries...)
+	if n.logFile == nil {
+		return
+	}
+	var buf bytes.Buffer
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			log.Fatalf("Encoding Raft entry %d: %v", e.Index, err)
+		}
+		buf.Write(append(line, '\n'))
+	}
+	if _, err := n.logFile.Write(buf.Bytes()); err != nil {
+		log.Fatalf("Writing Raft log: %v", err)
+	}
+	if err := n.logFile.Sync(); err != nil {
+		log.Fatalf("Syncing Raft log: %v", err)
+	}
+}
+
+// propose appends a transaction to the log and waits for it to be committed
+// and applied.
+func (n *raftNode) propose(conditions []Condition, ops []Op) (uint64, error) {
+	n.mu.Lock()
+	if !n.readyLocked() {
+		n.mu.Unlock()
+		return 0, errNotLeader
+	}
+	entry := raftEntry{Term: n.term, Index: n.lastIndexLocked() + 1, Conditions: conditions, Ops: ops}
+	n.appendLocked(entry)
+	ch := make(chan raftResult, 1)
+	n.waiters[entry.Index] = raftWaiter{term: entry.Term, ch: ch}
+	for _, wake := range n.wake {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	n.advanceCommitLocked()
+	n.mu.Unlock()
+
+	select {
+	case res := <-ch:
+		return res.rev, res.err
+	case <-time.After(raftProposalTimeout):
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if w, ok := n.waiters[entry.Index]; ok && w.ch == ch {
+			// No majority answered: this replica is likely cut off, and
+			// another may already lead.
+			n.becomeFollowerLocked(n.term)
+		}
+		return 0, fmt.Errorf("%w: entry %d was not stored by a majority within %v", errNotLeader, entry.Index, raftProposalTimeout)
+	}
+}
+
+// advanceCommitLocked commits the newest entry of the current term that a
+// majority has stored, and every entry before it.
+func (n *raftNode) advanceCommitLocked() {
+	for index := n.lastIndexLocked(); index > n.commitIndex; index-- {
+		if term, _ := n.termAtLocked(index); term != n.term {
+			return
+		}
+		count := 1
+		for peer := range n.peers {
+			if n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.setCommitIndexLocked(index)
+			return
+		}
+	}
+}
+
+func (n *raftNode) setCommitIndexLocked(index uint64) {
+	if index <= n.commitIndex {
+		return
+	}
+	n.commitIndex = index
+	select {
+	case n.commits <- struct{}{}:
+	default:
+	}
+}
+
+// replicate sends peer the entries it is missing, or a heartbeat when it
+// has them all, for as long as this replica leads in term.
+func (n *raftNode) replicate(peer string, term uint64, wake chan struct{}) {
+	ticker := time.NewTicker(raftHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		more, leading := n.sendTo(peer, term)
+		if !leading {
+			return
+		}
+		if more {
+			continue
+		}
+		select {
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendTo makes one AppendEntries or InstallSnapshot call. It reports
+// whether peer is still missing entries, and whether this replica still
+// leads in term.
+func (n *raftNode) sendTo(peer string, term uint64) (more, leading bool) {
+	n.mu.Lock()
+	if n.role != raftLeader || n.term != term {
+		n.mu.Unlock()
+		return false, false
+	}
+	next := n.nextIndex[peer]
+	if next <= n.snapshot.Index {
+		req := installSnapshotRequest{Term: term, Leader: n.id, LeaderURL: n.url, Snapshot: n.snapshot}
+		n.mu.Unlock()
+		var resp appendResponse
+		if err := n.call(peer, "snapshot", req, &resp, raftProposalTimeout); err != nil {
+			return false, true
+		}
+		return n.handleAppendResponse(peer, term, resp)
+	}
+	prevTerm, _ := n.termAtLocked(next - 1)
+	req := appendRequest{Term: term, Leader: n.id, LeaderURL: n.url, PrevLogIndex: next - 1, PrevLogTerm: prevTerm, LeaderCommit: n.commitIndex}
+	if last := n.lastIndexLocked(); next <= last {
+		end := min(last, next+raftMaxAppend-1)
+		req.Entries = append([]raftEntry{}, n.entries[next-n.snapshot.Index-1:end-n.snapshot.Index]...)
+	}
+	n.mu.Unlock()
+
+	var resp appendResponse
+	if err := n.call(peer, "append", req, &resp, raftRPCTimeout); err != nil {
+		return false, true
+	}
+	return n.handleAppendResponse(peer, term, resp)
+}
+
+func (n *raftNode) handleAppendResponse(peer string, term uint64, resp appendResponse) (more, leading bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if resp.Term > n.term {
+		n.becomeFollowerLocked(resp.Term)
+		return false, false
+	}
+	if n.role != raftLeader || n.term != term {
+		return false, false
+	}
+	if resp.Success {
+		if resp.MatchIndex > n.matchIndex[peer] {
+			n.matchIndex[peer] = resp.MatchIndex
+		}
+		n.nextIndex[peer] = n.matchIndex[peer] + 1
+		n.advanceCommitLocked()
+	} else {
+		n.nextIndex[peer] = max(1, min(resp.ConflictIndex, n.nextIndex[peer]-1))
+	}
+	return n.nextIndex[peer] <= n.lastIndexLocked(), true
+}
+
+// handleVote answers a candidate. A vote goes to the first candidate in a
+// term whose log is at least as up to date as this replica's.
+func (n *raftNode) handleVote(req voteRequest) voteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if req.Term > n.term {
+		n.becomeFollowerLocked(req.Term)
+	}
+	resp := voteResponse{Term: n.term}
+	if req.Term < n.term || (n.votedFor != "" && n.votedFor != req.Candidate) {
+		return resp
+	}
+	lastTerm := n.lastTermLocked()
+	if req.LastLogTerm < lastTerm || (req.LastLogTerm == lastTerm && req.LastLogIndex < n.lastIndexLocked()) {
+		return resp
+	}
+	n.votedFor = req.Candidate
+	n.persistStateLocked()
+	n.resetElectionDeadlineLocked()
+	resp.Granted = true
+	return resp
+}
+
+// acceptLeaderLocked records req's sender as the leader of its term, if it
+// is current.
+func (n *raftNode) acceptLeaderLocked(term uint64, leader, leaderURL string) bool {
+	if term < n.term {
+		return false
+	}
+	if term > n.term || n.role != raftFollower {
+		n.becomeFollowerLocked(term)
+	}
+	n.leader, n.leaderURL = leader, leaderURL
+	n.resetElectionDeadlineLocked()
+	return true
+}
+
+func (n *raftNode) handleAppend(req appendRequest) appendResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.acceptLeaderLocked(req.Term, req.Leader, req.LeaderURL) {
+		return appendResponse{Term: n.term}
+	}
+	resp := appendResponse{Term: n.term}
+
+	// Entries already in the snapshot are committed, so they match.
+	entries := req.Entries
+	if req.PrevLogIndex < n.snapshot.Index {
+		for len(entries) > 0 && entries[0].Index <= n.snapshot.Index {
+			entries = entries[1:]
+		}
+		req.PrevLogIndex, req.PrevLogTerm = n.snapshot.Index, n.snapshot.Term
+	}
+	if req.PrevLogIndex > n.lastIndexLocked() {
+		resp.ConflictIndex = n.lastIndexLocked() + 1
+		return resp
+	}
+	if term, _ := n.termAtLocked(req.PrevLogIndex); term != req.PrevLogTerm {
+		// Skip back over the whole conflicting term at once.
+		index := req.PrevLogIndex
+		for index > n.snapshot.Index+1 {
+			if t, _ := n.termAtLocked(index - 1); t != term {
+				break
+			}
+			index--
+		}
+		resp.ConflictIndex = index
+		return resp
+	}
+
+	for i, e := range entries {
+		if term, ok := n.termAtLocked(e.Index); ok {
+			if term == e.Term {
+				continue
+			}
+			n.entries = n.entries[:e.Index-n.snapshot.Index-1]
+			n.rewriteLogLocked()
+		}
+		n.appendLocked(entries[i:]...)
+		break
+	}
+	resp.Success = true
+	resp.MatchIndex = req.PrevLogIndex + uint64(len(entries))
+	n.setCommitIndexLocked(min(req.LeaderCommit, resp.MatchIndex))
+	return resp
+}
+
+// handleInstallSnapshot replaces the state machine with the leader's
+// snapshot, for a replica so far behind that the entries it lacks are
+// compacted.
+func (n *raftNode) handleInstallSnapshot(req installSnapshotRequest) appendResponse {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+	n.mu.Lock()
+	if !n.acceptLeaderLocked(req.Term, req.Leader, req.LeaderURL) {
+		defer n.mu.Unlock()
+		return appendResponse{Term: n.term}
+	}
+	snap := req.Snapshot
+	resp := appendResponse{Term: n.term, Success: true, MatchIndex: snap.Index}
+	if snap.Index <= n.lastApplied {
+		n.mu.Unlock()
+		return resp
+	}
+	if term, ok := n.termAtLocked(snap.Index); ok && term == snap.Term {
+		n.entries = append([]raftEntry{}, n.entries[snap.Index-n.snapshot.Index:]...)
+	} else {
+		n.entries = nil
+	}
+	n.snapshot = snap
+	n.lastApplied = snap.Index
+	n.setCommitIndexLocked(snap.Index)
+	n.persistSnapshotLocked()
+	n.mu.Unlock()
+
+	n.sm.restore(snap.KVs, snap.Revision)
+	n.onRestore()
+	log.Printf("Replica %s installed a snapshot at index %d, resourceVersion %d", n.id, snap.Index, snap.Revision)
+	return resp
+}
+
+// applyLoop applies committed entries in order.
+func (n *raftNode) applyLoop() {
+	for range n.commits {
+		for n.applyNext() {
+		}
+	}
+}
+
+// applyNext applies the entry after lastApplied if it is committed.
+func (n *raftNode) applyNext() bool {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+	n.mu.Lock()
+	if n.stopped || n.lastApplied >= n.commitIndex {
+		n.mu.Unlock()
+		return false
+	}
+	index := n.lastApplied + 1
+	entry := n.entryLocked(index)
+	w, local := n.waiters[index]
+	delete(n.waiters, index)
+	n.mu.Unlock()
+
+	if local && w.term != entry.Term {
+		w.ch <- raftResult{err: errNotLeader}
+		local = false
+	}
+	var res raftResult
+	if len(entry.Ops) > 0 {
+		res.rev, res.err = n.sm.Txn(entry.Conditions, entry.Ops)
+		if res.err == nil && !local {
+			n.onApply(entry.Ops, res.rev)
+		}
+	}
+	if local {
+		w.ch <- res
+	}
+
+	n.mu.Lock()
+	n.lastApplied = index
+	close(n.applied)
+	n.applied = make(chan struct{})
+	if n.role == raftLeader && index == n.readyIndex {
+		go n.onLeading()
+	}
+	snapshotDue := n.dir != "" && n.snapshotEvery > 0 && index-n.snapshot.Index >= uint64(n.snapshotEvery) ||
+		n.dir == "" && index-n.snapshot.Index >= storageHistorySize
+	n.mu.Unlock()
+	if snapshotDue {
+		n.takeSnapshot(index, entry.Term)
+	}
+	return true
+}
+
+// takeSnapshot compacts the log up to index, which the state machine has
+// just applied. Callers must hold applyMu.
+func (n *raftNode) takeSnapshot(index, term uint64) {
+	kvs, rev, _ := n.sm.List("/")
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.entries = append([]raftEntry{}, n.entries[index-n.snapshot.Index:]...)
+	n.snapshot = raftSnapshot{Index: index, Term: term, Revision: rev, KVs: kvs}
+	n.persistSnapshotLocked()
+}
+
+// waitApplied blocks until the entry at index has been applied.
+func (n *raftNode) waitApplied(ctx context.Context, index uint64) error {
+	for {
+		n.mu.Lock()
+		if n.lastApplied >= index {
+			n.mu.Unlock()
+			return nil
+		}
+		applied := n.applied
+		n.mu.Unlock()
+		select {
+		case <-applied:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// readIndex returns a commit index that covers every write acknowledged
+// before the call, after confirming with a majority that this replica still
+// leads.
+func (n *raftNode) readIndex(ctx context.Context) (uint64, error) {
+	n.mu.Lock()
+	if !n.readyLocked() {
+		n.mu.Unlock()
+		return 0, errNotLeader
+	}
+	term, index := n.term, n.commitIndex
+	req := appendRequest{Term: term, Leader: n.id, LeaderURL: n.url, PrevLogIndex: n.snapshot.Index, PrevLogTerm: n.snapshot.Term}
+	n.mu.Unlock()
+
+	acks := make(chan bool, len(n.peers))
+	for peer := range n.peers {
+		go func(peer string) {
+			var resp appendResponse
+			err := n.call(peer, "append", req, &resp, raftRPCTimeout)
+			if err == nil && resp.Term > term {
+				n.mu.Lock()
+				if resp.Term > n.term {
+					n.becomeFollowerLocked(resp.Term)
+				}
+				n.mu.Unlock()
+			}
+			acks <- err == nil && resp.Term == term
+		}(peer)
+	}
+	count := 1
+	for range n.peers {
+		if count >= n.quorum() {
+			break
+		}
+		select {
+		case ok := <-acks:
+			if ok {
+				count++
+			}
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	if count < n.quorum() {
+		return 0, fmt.Errorf("a majority of replicas did not confirm leadership for term %d", term)
+	}
+	return index, nil
+}
+
+// readBarrier waits until this replica has applied every write committed
+// before the call, asking the leader how far that is when it is a follower.
+func (n *raftNode) readBarrier(ctx context.Context) error {
+	index, err := n.readIndex(ctx)
+	if errors.Is(err, errNotLeader) {
+		leader, _ := n.currentLeader()
+		if leader == "" || leader == n.id {
+			return errors.New("no leader elected")
+		}
+		var resp readIndexResponse
+		if err := n.call(leader, "readindex", struct{}{}, &resp, time.Second); err != nil {
+			return fmt.Errorf("asking leader %s for its commit index: %v", leader, err)
+		}
+		index = resp.Index
+	} else if err != nil {
+		return err
+	}
+	return n.waitApplied(ctx, index)
+}
+
+// call POSTs a Raft RPC to peer.
+func (n *raftNode) call(peer, method string, req, resp interface{}, timeout time.Duration) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, n.scheme+"://"+n.peers[peer]+"/raft/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(raftTokenHeader, n.token)
+	httpResp, err := n.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(httpResp.Body, 512))
+		return fmt.Errorf("%s: %s", httpResp.Status, bytes.TrimSpace(msg))
+	}
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
+
+// raftListener is l, serving TLS with the serving certificate when the
+// server has one, so that the Raft token and the replicated objects never
+// cross the network in the clear and peers can tell a replica from an
+// impostor.
+func raftListener(l net.Listener) net.Listener {
+	if servingCerts == nil {
+		return l
+	}
+	return tls.NewListener(l, servingCerts.tlsConfig())
+}
+
+// handler serves the Raft RPCs to the other replicas, which prove they
+// belong to the cluster with a token derived from the shared signing key.
+func (n *raftNode) handler() http.Handler {
+	mux := http.NewServeMux()
+	serve := func(method string, fn func(r *http.Request) (interface{}, error)) {
+		mux.HandleFunc("POST /raft/"+method, func(w http.ResponseWriter, r *http.Request) {
+			if !hmac.Equal([]byte(r.Header.Get(raftTokenHeader)), []byte(n.token)) {
+				http.Error(w, "invalid Raft token", http.StatusUnauthorized)
+				return
+			}
+			resp, err := fn(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+		})
+	}
+	serve("vote", func(r *http.Request) (interface{}, error) {
+		var req voteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, err
+		}
+		return n.handleVote(req), nil
+	})
+	serve("append", func(r *http.Request) (interface{}, error) {
+		var req appendRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, err
+		}
+		return n.handleAppend(req), nil
+	})
+	serve("snapshot", func(r *http.Request) (interface{}, error) {
+		var req installSnapshotRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, err
+		}
+		return n.handleInstallSnapshot(req), nil
+	})
+	serve("readindex", func(r *http.Request) (interface{}, error) {
+		index, err := n.readIndex(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		return readIndexResponse{Index: index}, nil
+	})
+	return mux
+}
+
+// stop halts the replica and closes its log file.
+func (n *raftNode) stop() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.stopped = true
+	n.becomeFollowerLocked(n.term)
+	if n.logFile != nil {
+		return n.logFile.Close()
+	}
+	return nil
+}
+
+// load restores the term, vote, snapshot and log from dir.
+func (n *raftNode) load() error {
+	if n.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(n.dir, 0o700); err != nil {
+		return err
+	}
+	var state raftState
+	if err := readJSONFile(filepath.Join(n.dir, raftStateFile), &state); err != nil {
+		return err
+	}
+	n.term, n.votedFor = state.Term, state.VotedFor
+	if err := readJSONFile(filepath.Join(n.dir, raftSnapshotFile), &n.snapshot); err != nil {
+		return err
+	}
+
+	path := filepath.Join(n.dir, raftLogFile)
+	if f, err := os.Open(path); err == nil {
+		reader := bufio.NewReader(f)
+		var good int64
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err == io.EOF && len(line) == 0 {
+				break
+			}
+			var e raftEntry
+			if err != nil || json.Unmarshal(line, &e) != nil {
+				log.Printf("Raft log %s has a torn entry at offset %d; discarding it", path, good)
+				f.Close()
+				if err := os.Truncate(path, good); err != nil {
+					return err
+				}
+				break
+			}
+			good += int64(len(line))
+			// Entries at or below the snapshot remain when the replica
+			// stopped between writing a snapshot and rewriting the log.
+			if e.Index > n.snapshot.Index {
+				n.entries = append(n.entries, e)
+			}
+		}
+		f.Close()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var err error
+	n.logFile, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	return err
+}
+
+func readJSONFile(path string, into interface{}) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, into); err != nil {
+		return fmt.Errorf("reading %s: %v", path, err)
+	}
+	return nil
+}
+
+// persistStateLocked records the term and vote before they are acted on,
+// so a restarted replica never votes twice in a term.
+func (n *raftNode) persistStateLocked() {
+	if n.dir == "" {
+		return
+	}
+	data, _ := json.Marshal(raftState{Term: n.term, VotedFor: n.votedFor})
+	if err := replaceFileSynced(filepath.Join(n.dir, raftStateFile), data); err != nil {
+		log.Fatalf("Writing Raft state: %v", err)
+	}
+}
+
+// persistSnapshotLocked writes the snapshot, then the log that follows it.
+func (n *raftNode) persistSnapshotLocked() {
+	if n.dir == "" {
+		return
+	}
+	data, err := json.Marshal(n.snapshot)
+	if err != nil {
+		log.Fatalf("Encoding Raft snapshot: %v", err)
+	}
+	if err := replaceFileSynced(filepath.Join(n.dir, raftSnapshotFile), data); err != nil {
+		log.Fatalf("Writing Raft snapshot: %v", err)
+	}
+	n.rewriteLogLocked()
+}
+
+// rewriteLogLocked replaces the log file with the entries in memory, after
+// they were truncated or compacted.
+func (n *raftNode) rewriteLogLocked() {
+	if n.dir == "" {
+		return
+	}
+	var buf bytes.Buffer
+	for _, e := range n.entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			log.Fatalf("Encoding Raft entry %d: %v", e.Index, err)
+		}
+		buf.Write(append(line, '\n'))
+	}
+	path := filepath.Join(n.dir, raftLogFile)
+	if err := replaceFileSynced(path, buf.Bytes()); err != nil {
+		log.Fatalf("Writing Raft log: %v", err)
+	}
+	n.logFile.Close()
+	var err error
+	if n.logFile, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600); err != nil {
+		log.Fatalf("Opening Raft log: %v", err)
+	}
+}
+
+func (n *raftNode) status() replicationStatus {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	st := replicationStatus{
+		ID:              n.id,
+		Role:            n.role,
+		Term:            n.term,
+		Leader:          n.leader,
+		LeaderURL:       n.leaderURL,
+		CommitIndex:     n.commitIndex,
+		AppliedIndex:    n.lastApplied,
+		LastLogIndex:    n.lastIndexLocked(),
+		SnapshotIndex:   n.snapshot.Index,
+		ResourceVersion: n.sm.Revision(),
+		Peers:           make(map[string]replicaPeerStatus),
+	}
+	for peer, addr := range n.peers {
+		ps := replicaPeerStatus{Address: addr}
+		if n.role == raftLeader {
+			match := n.matchIndex[peer]
+			ps.MatchIndex = &match
+		}
+		st.Peers[peer] = ps
+	}
+	return st
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testReplica is a raftNode serving Raft on a local port.
+type testReplica struct {
+	*raftNode
+	server *http.Server
+}
+
+// startTestReplicas starts count in-memory replicas that know each other.
+func startTestReplicas(t *testing.T, count int) []*testReplica {
+	t.Helper()
+	listeners := make(map[string]net.Listener)
+	addrs := make(map[string]string)
+	for i := 1; i <= count; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := fmt.Sprintf("r%d", i)
+		listeners[id], addrs[id] = l, l.Addr().String()
+	}
+	var replicas []*testReplica
+	for i := 1; i <= count; i++ {
+		id := fmt.Sprintf("r%d", i)
+		peers := make(map[string]string)
+		for peer, addr := range addrs {
+			if peer != id {
+				peers[peer] = addr
+			}
+		}
+		node, err := newRaftNode(id, "http://api-"+id, peers, []byte("test key"), "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node.onApply = func([]Op, uint64) {}
+		node.onRestore = func() {}
+		node.onLeading = func() {}
+		r := &testReplica{raftNode: node, server: &http.Server{Handler: node.handler()}}
+		go r.server.Serve(raftListener(listeners[id]))
+		go node.run()
+		replicas = append(replicas, r)
+	}
+	t.Cleanup(func() {
+		for _, r := range replicas {
+			r.halt()
+		}
+	})
+	return replicas
+}
+
+// halt stops the replica and cuts it off from its peers.
+func (r *testReplica) halt() {
+	r.stop()
+	r.server.Close()
+}
+
+// waitForLeader waits until exactly one of replicas takes writes and the
+// others follow it.
+func waitForLeader(t *testing.T, replicas []*testReplica) *testReplica {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		var leader *testReplica
+		ready := 0
+		for _, r := range replicas {
+			if r.ready() {
+				leader = r
+				ready++
+			}
+		}
+		if ready == 1 {
+			followed := true
+			for _, r := range replicas {
+				if id, _ := r.currentLeader(); id != leader.id {
+					followed = false
+				}
+			}
+			if followed {
+				return leader
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no leader elected")
+	return nil
+}
+
+// waitForKey waits until every replica has applied a write to key.
+func waitForKey(t *testing.T, replicas []*testReplica, key string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, r := range replicas {
+		for {
+			if _, err := r.sm.Get(key); err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("replica %s has not applied %s", r.id, key)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestRaftElectsLeaderAndReplicates(t *testing.T) {
+	replicas := startTestReplicas(t, 3)
+	leader := waitForLeader(t, replicas)
+	if _, err := leader.propose(nil, []Op{{Key: "/Node/a", Value: []byte(`{}`)}}); err != nil {
+		t.Fatalf("proposing to leader %s: %v", leader.id, err)
+	}
+	waitForKey(t, replicas, "/Node/a")
+	for _, r := range replicas {
+		if r != leader {
+			if _, err := r.propose(nil, []Op{{Key: "/Node/b", Value: []byte(`{}`)}}); !errors.Is(err, errNotLeader) {
+				t.Errorf("proposing to follower %s: got %v, want errNotLeader", r.id, err)
+			}
+		}
+	}
+
+	// The two left are a majority and elect one of themselves in a later
+	// term, which keeps the committed write.
+	leader.halt()
+	var rest []*testReplica
+	for _, r := range replicas {
+		if r != leader {
+			rest = append(rest, r)
+		}
+	}
+	next := waitForLeader(t, rest)
+	if next.status().Term <= leader.status().Term {
+		t.Errorf("new leader %s is in term %d, not after %d", next.id, next.status().Term, leader.status().Term)
+	}
+	if _, err := next.propose(nil, []Op{{Key: "/Node/c", Value: []byte(`{}`)}}); err != nil {
+		t.Fatalf("proposing to new leader %s: %v", next.id, err)
+	}
+	waitForKey(t, rest, "/Node/a")
+	waitForKey(t, rest, "/Node/c")
+}
+
+func TestRaftOverTLS(t *testing.T) {
+	files, err := ensureSelfSignedCerts(t.TempDir(), []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := newCertReloader(files.cert, files.key, "", files.caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	servingCerts = certs
+	t.Cleanup(func() { servingCerts = nil })
+
+	replicas := startTestReplicas(t, 3)
+	leader := waitForLeader(t, replicas)
+	if _, err := leader.propose(nil, []Op{{Key: "/Node/a", Value: []byte(`{}`)}}); err != nil {
+		t.Fatalf("proposing to leader %s: %v", leader.id, err)
+	}
+	waitForKey(t, replicas, "/Node/a")
+
+	// Nothing is served in the clear.
+	for peer, addr := range leader.peers {
+		resp, err := http.Post("http://"+addr+"/raft/vote", "application/json", strings.NewReader("{}"))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				t.Errorf("replica %s answered Raft over plain HTTP", peer)
+			}
+		}
+	}
+}
+
+// logTerms lists the terms of a replica's entries.
+func logTerms(n *raftNode) []uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var terms []uint64
+	for _, e := range n.entries {
+		terms = append(terms, e.Term)
+	}
+	return terms
+}
+
+func TestRaftAppendTruncatesConflictingEntries(t *testing.T) {
+	n, err := newRaftNode("r2", "", map[string]string{"r1": "", "r3": ""}, []byte("test key"), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := n.handleAppend(appendRequest{Term: 1, Leader: "r1", Entries: []raftEntry{
+		{Term: 1, Index: 1}, {Term: 1, Index: 2}, {Term: 1, Index: 3},
+	}})
+	if !resp.Success || resp.MatchIndex != 3 {
+		t.Fatalf("appending to an empty log: %+v", resp)
+	}
+
+	// A leader of term 2 whose log differs from index 2 on: the follower
+	// points it back past the whole of term 1, then takes its entries in
+	// place of its own.
+	resp = n.handleAppend(appendRequest{Term: 2, Leader: "r3", PrevLogIndex: 3, PrevLogTerm: 2})
+	if resp.Success || resp.ConflictIndex != 1 {
+		t.Fatalf("appending after a conflicting entry: %+v, want failure from index 1", resp)
+	}
+	resp = n.handleAppend(appendRequest{Term: 2, Leader: "r3", PrevLogIndex: 1, PrevLogTerm: 1, Entries: []raftEntry{
+		{Term: 2, Index: 2},
+	}})
+	if !resp.Success || resp.MatchIndex != 2 {
+		t.Fatalf("repairing the log: %+v", resp)
+	}
+	if got := fmt.Sprint(logTerms(n)); got != "[1 2]" {
+		t.Errorf("log terms are %s, want [1 2]", got)
+	}
+
+	// Entries it already has are kept, and a deposed leader is refused.
+	resp = n.handleAppend(appendRequest{Term: 2, Leader: "r3", Entries: []raftEntry{{Term: 1, Index: 1}}})
+	if !resp.Success || fmt.Sprint(logTerms(n)) != "[1 2]" {
+		t.Errorf("resending a stored entry: %+v, log terms %v", resp, logTerms(n))
+	}
+	resp = n.handleAppend(appendRequest{Term: 1, Leader: "r1", PrevLogIndex: 2, PrevLogTerm: 2})
+	if resp.Success || resp.Term != 2 {
+		t.Errorf("appending from term 1: %+v, want refusal in term 2", resp)
+	}
+	if leader, _ := n.currentLeader(); leader != "r3" {
+		t.Errorf("leader is %q, want r3", leader)
+	}
+}
+
+func TestRaftLeaderWaitsForNoOpBeforeWrites(t *testing.T) {
+	n, err := newRaftNode("r1", "", map[string]string{}, []byte("test key"), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leading := make(chan struct{})
+	n.onLeading = func() { close(leading) }
+	n.mu.Lock()
+	n.startElectionLocked()
+	n.mu.Unlock()
+
+	// The replica leads, but entries of earlier terms may still be
+	// unapplied until its no-op is.
+	if st := n.status(); st.Role != raftLeader || st.CommitIndex != 1 || st.AppliedIndex != 0 {
+		t.Fatalf("after the election: %+v", st)
+	}
+	if _, err := n.propose(nil, []Op{{Key: "/Node/a", Value: []byte(`{}`)}}); !errors.Is(err, errNotLeader) {
+		t.Fatalf("proposing before the no-op is applied: got %v, want errNotLeader", err)
+	}
+	if _, err := n.readIndex(context.Background()); !errors.Is(err, errNotLeader) {
+		t.Fatalf("reading before the no-op is applied: got %v, want errNotLeader", err)
+	}
+
+	go n.applyLoop()
+	select {
+	case <-leading:
+	case <-time.After(5 * time.Second):
+		t.Fatal("onLeading was not called")
+	}
+	if !n.ready() {
+		t.Fatal("not ready after applying the no-op")
+	}
+	rev, err := n.propose(nil, []Op{{Key: "/Node/a", Value: []byte(`{}`)}})
+	if err != nil || rev != 1 {
+		t.Fatalf("proposing once ready: revision %d, %v", rev, err)
+	}
+}
+
+func TestRaftKeepsLogAndVoteAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	peers := map[string]string{"r1": "", "r3": ""}
+	n, err := newRaftNode("r2", "", peers, []byte("test key"), dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := n.handleAppend(appendRequest{Term: 1, Leader: "r1", Entries: []raftEntry{
+		{Term: 1, Index: 1}, {Term: 1, Index: 2, Ops: []Op{{Key: "/Node/a", Value: []byte(`{}`)}}},
+	}})
+	if !resp.Success {
+		t.Fatalf("appending: %+v", resp)
+	}
+	if vote := n.handleVote(voteRequest{Term: 2, Candidate: "r3", LastLogIndex: 2, LastLogTerm: 1}); !vote.Granted {
+		t.Fatalf("voting: %+v", vote)
+	}
+	if err := n.stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// What was acknowledged is there after a restart, and the vote for term
+	// 2 is not given again.
+	n, err = newRaftNode("r2", "", peers, []byte("test key"), dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+	if got := fmt.Sprint(logTerms(n)); got != "[1 1]" {
+		t.Errorf("log terms after a restart are %s, want [1 1]", got)
+	}
+	if vote := n.handleVote(voteRequest{Term: 2, Candidate: "r1", LastLogIndex: 2, LastLogTerm: 1}); vote.Granted {
+		t.Error("voted twice in term 2")
+	}
+}
@@ -0,0 +1,742 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "example.com/m/api/v1"
+	"github.com/google/uuid"
+)
+
+// Authorization modes, chosen with -authorization-mode.
+const (
+	authorizationAlwaysAllow = "AlwaysAllow"
+	authorizationRBAC        = "RBAC"
+)
+
+// bootstrapAnnotation marks the roles and bindings the server creates at
+// startup.
+const bootstrapAnnotation = "kube-sim.io/bootstrap-policy"
+
+var authorizationMode = authorizationAlwaysAllow
+
+// rbacResource describes one of the four RBAC kinds. They are stored as
+// their v1 types; rbacMu guards every objects map.
+type rbacResource struct {
+	kind       string
+	resource   string
+	namespaced bool
+	objects    map[string]v1.Object
+	newObject  func() v1.Object
+	newList    func(items []v1.Object, meta v1.ListMeta) interface{}
+}
+
+var (
+	rbacMu sync.Mutex
+
+	roleResource = &rbacResource{
+		kind: "Role", resource: "roles", namespaced: true,
+		objects:   make(map[string]v1.Object),
+		newObject: func() v1.Object { return &v1.Role{} },
+		newList: func(items []v1.Object, meta v1.ListMeta) interface{} {
+			list := v1.RoleList{TypeMeta: typeMeta("RoleList"), Metadata: meta, Items: []v1.Role{}}
+			for _, obj := range items {
+				list.Items = append(list.Items, *obj.(*v1.Role))
+			}
+			return list
+		},
+	}
+	clusterRoleResource = &rbacResource{
+		kind: "ClusterRole", resource: "clusterroles",
+		objects:   make(map[string]v1.Object),
+		newObject: func() v1.Object { return &v1.ClusterRole{} },
+		newList: func(items []v1.Object, meta v1.ListMeta) interface{} {
+			list := v1.ClusterRoleList{TypeMeta: typeMeta("ClusterRoleList"), Metadata: meta, Items: []v1.ClusterRole{}}
+			for _, obj := range items {
+				list.Items = append(list.Items, *obj.(*v1.ClusterRole))
+			}
+			return list
+		},
+	}
+	roleBindingResource = &rbacResource{
+		kind: "RoleBinding", resource: "rolebindings", namespaced: true,
+		objects:   make(map[string]v1.Object),
+		newObject: func() v1.Object { return &v1.RoleBinding{} },
+		newList: func(items []v1.Object, meta v1.ListMeta) interface{} {
+			list := v1.RoleBindingList{TypeMeta: typeMeta("RoleBindingList"), Metadata: meta, Items: []v1.RoleBinding{}}
+			for _, obj := range items {
+				list.Items = append(list.Items, *obj.(*v1.RoleBinding))
+			}
+			return list
+		},
+	}
+	clusterRoleBindingResource = &rbacResource{
+		kind: "ClusterRoleBinding", resource: "clusterrolebindings",
+		objects:   make(map[string]v1.Object),
+		newObject: func() v1.Object { return &v1.ClusterRoleBinding{} },
+		newList: func(items []v1.Object, meta v1.ListMeta) interface{} {
+			list := v1.ClusterRoleBindingList{TypeMeta: typeMeta("ClusterRoleBindingList"), Metadata: meta, Items: []v1.ClusterRoleBinding{}}
+			for _, obj := range items {
+				list.Items = append(list.Items, *obj.(*v1.ClusterRoleBinding))
+			}
+			return list
+		},
+	}
+
+	rbacResources = map[string]*rbacResource{
+		"Role":               roleResource,
+		"ClusterRole":        clusterRoleResource,
+		"RoleBinding":        roleBindingResource,
+		"ClusterRoleBinding": clusterRoleBindingResource,
+	}
+)
+
+func (res *rbacResource) key(namespace, name string) string {
+	if res.namespaced {
+		return namespace + "/" + name
+	}
+	return name
+}
+
+// copyRBACObject returns a private copy of obj, which callers may modify or
+// hand out after releasing rbacMu.
+func copyRBACObject(res *rbacResource, obj v1.Object) v1.Object {
+	data, _ := json.Marshal(obj)
+	c := res.newObject()
+	json.Unmarshal(data, c)
+	return c
+}
+
+// rbacChanged bumps obj's resourceVersion and records the change. Callers
+// must hold rbacMu.
+func rbacChanged(res *rbacResource, eventType string, obj v1.Object) error {
+	return watches.notify(res.kind, eventType, obj.GetObjectMeta().ResourceVersion, func(rv uint64) interface{} {
+		obj.GetObjectMeta().ResourceVersion = rv
+		return copyRBACObject(res, obj)
+	})
+}
+
+func policyRules(obj v1.Object) []v1.PolicyRule {
+	switch o := obj.(type) {
+	case *v1.Role:
+		return o.Rules
+	case *v1.ClusterRole:
+		return o.Rules
+	}
+	return nil
+}
+
+func bindingOf(obj v1.Object) ([]v1.Subject, v1.RoleRef) {
+	switch o := obj.(type) {
+	case *v1.RoleBinding:
+		return o.Subjects, o.RoleRef
+	case *v1.ClusterRoleBinding:
+		return o.Subjects, o.RoleRef
+	}
+	return nil, v1.RoleRef{}
+}
+
+// bootstrapRBAC creates the default roles and bindings that are missing:
+// cluster-admin for the system:masters group, admin, edit and view to bind
+// as needed, and system:node, which lets the system:nodes group register
+// and post heartbeats. Roles the server created, still marked with
+// bootstrapAnnotation, get the rules of this version of the server.
+func bootstrapRBAC() {
+	readWrite := []string{"get", "list", "watch", "create", "update", "delete"}
+	readOnly := []string{"get", "list", "watch"}
+	clusterRoles := map[string][]v1.PolicyRule{
+		"cluster-admin": {{Verbs: []string{"*"}, Resources: []string{"*"}}},
+		"admin": {
+			{Verbs: readWrite, Resources: []string{"pods", "pods/restart", "deployments", "roles", "rolebindings", "leases", "serviceaccounts/token"}},
+			{Verbs: readOnly, Resources: []string{"events"}},
+		},
+		"edit": {
+			{Verbs: readWrite, Resources: []string{"pods", "pods/restart", "deployments"}},
+			{Verbs: readOnly, Resources: []string{"events"}},
+		},
+		"view": {
+			{Verbs: readOnly, Resources: []string{"pods", "deployments", "events", "nodes", "leases"}},
+			{Verbs: []string{"get"}, Resources: []string{"scheduler"}},
+		},
+		"system:node":       {{Verbs: []string{"create"}, Resources: []string{"heartbeats", "registrations"}}},
+		"system:monitoring": {{Verbs: []string{"get"}, Resources: []string{"metrics", "replication"}}},
+	}
+	bindings := map[string]v1.Subject{
+		"cluster-admin":     {Kind: v1.SubjectKindGroup, Name: "system:masters"},
+		"system:node":       {Kind: v1.SubjectKindGroup, Name: groupNodes},
+		"system:monitoring": {Kind: v1.SubjectKindGroup, Name: groupMonitoring},
+	}
+
+	rbacMu.Lock()
+	defer rbacMu.Unlock()
+	meta := func(name string) v1.ObjectMeta {
+		return v1.ObjectMeta{Name: name, Annotations: map[string]string{bootstrapAnnotation: "true"}}
+	}
+	for name, rules := range clusterRoles {
+		cur, exists := clusterRoleResource.objects[name]
+		switch {
+		case !exists:
+			storeRBACObject(clusterRoleResource, &v1.ClusterRole{Metadata: meta(name), Rules: rules})
+		case cur.GetObjectMeta().Annotations[bootstrapAnnotation] == "true" && !sameRBACContent(cur, &v1.ClusterRole{Rules: rules}):
+			// Restored from an older server, which granted less.
+			role := cur.(*v1.ClusterRole)
+			role.Rules = rules
+			role.Metadata.Generation++
+			rbacChanged(clusterRoleResource, EventModified, role)
+		}
+	}
+	for name, subject := range bindings {
+		if _, exists := clusterRoleBindingResource.objects[name]; !exists {
+			storeRBACObject(clusterRoleBindingResource, &v1.ClusterRoleBinding{
+				Metadata: meta(name),
+				Subjects: []v1.Subject{subject},
+				RoleRef:  v1.RoleRef{Kind: "ClusterRole", Name: name},
+			})
+		}
+	}
+}
+
+// storeRBACObject adds a new object. Callers must hold rbacMu.
+func storeRBACObject(res *rbacResource, obj v1.Object) error {
+	meta := obj.GetObjectMeta()
+	meta.UID = uuid.New().String()
+	meta.CreationTimestamp = time.Now()
+	meta.Generation = 1
+	setRBACTypeMeta(res, obj)
+	res.objects[res.key(meta.Namespace, meta.Name)] = obj
+	return rbacChanged(res, EventAdded, obj)
+}
+
+// authorize decides whether user may perform attrs. ClusterRoleBindings
+// grant everywhere; RoleBindings only within their namespace, and so never
+// for cluster-scoped resources or requests across all namespaces.
+func authorize(user *userInfo, attrs v1.ResourceAttributes) (bool, string) {
+	if authorizationMode != authorizationRBAC {
+		return true, "authorization mode is " + authorizationMode
+	}
+	rbacMu.Lock()
+	defer rbacMu.Unlock()
+
+	for name, obj := range clusterRoleBindingResource.objects {
+		b := obj.(*v1.ClusterRoleBinding)
+		if bindsUser(b.Subjects, "", user) && rulesAllow(referencedRules(b.RoleRef, ""), attrs) {
+			return true, fmt.Sprintf("allowed by ClusterRoleBinding %q", name)
+		}
+	}
+	if attrs.Namespace != "" {
+		for _, obj := range roleBindingResource.objects {
+			b := obj.(*v1.RoleBinding)
+			if b.Metadata.Namespace != attrs.Namespace || !bindsUser(b.Subjects, attrs.Namespace, user) {
+				continue
+			}
+			if rulesAllow(referencedRules(b.RoleRef, attrs.Namespace), attrs) {
+				return true, fmt.Sprintf("allowed by RoleBinding %s/%s", b.Metadata.Namespace, b.Metadata.Name)
+			}
+		}
+	}
+	return false, forbiddenMessage(user, attrs)
+}
+
+// referencedRules returns the rules of the role ref names; Roles are looked
+// up in namespace. Callers must hold rbacMu.
+func referencedRules(ref v1.RoleRef, namespace string) []v1.PolicyRule {
+	var obj v1.Object
+	switch ref.Kind {
+	case "ClusterRole":
+		obj = clusterRoleResource.objects[ref.Name]
+	case "Role":
+		obj = roleResource.objects[roleResource.key(namespace, ref.Name)]
+	}
+	if obj == nil {
+		return nil
+	}
+	return policyRules(obj)
+}
+
+// bindsUser reports whether subjects include user. Service accounts without
+// a namespace are in the binding's.
+func bindsUser(subjects []v1.Subject, bindingNamespace string, user *userInfo) bool {
+	for _, s := range subjects {
+		switch s.Kind {
+		case v1.SubjectKindUser:
+			if s.Name == user.Name {
+				return true
+			}
+		case v1.SubjectKindGroup:
+			if user.inGroup(s.Name) {
+				return true
+			}
+		case v1.SubjectKindServiceAccount:
+			namespace := s.Namespace
+			if namespace == "" {
+				namespace = bindingNamespace
+			}
+			if user.Name == serviceAccountPrefix+namespace+":"+s.Name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func rulesAllow(rules []v1.PolicyRule, attrs v1.ResourceAttributes) bool {
+	for _, rule := range rules {
+		if matchesWildcard(rule.Verbs, attrs.Verb) && matchesWildcard(rule.Resources, attrs.Resource) &&
+			(len(rule.ResourceNames) == 0 || (attrs.Name != "" && containsString(rule.ResourceNames, attrs.Name))) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesWildcard(allowed []string, value string) bool {
+	return containsString(allowed, "*") || containsString(allowed, value)
+}
+
+func forbiddenMessage(user *userInfo, attrs v1.ResourceAttributes) string {
+	msg := fmt.Sprintf("user %q cannot %s resource %q", user.Name, attrs.Verb, attrs.Resource)
+	if attrs.Name != "" {
+		msg += fmt.Sprintf(" named %q", attrs.Name)
+	}
+	if attrs.Namespace != "" {
+		msg += fmt.Sprintf(" in namespace %q", attrs.Namespace)
+	} else {
+		msg += " at the cluster scope"
+	}
+	return msg
+}
+
+// requestAttributes works out what a request does from its method and path,
+// on the versioned and the legacy paths alike. It reports false for requests
+// that need no authorization here: finding out who you are and what you may
+// do is always allowed, and apply authorizes each object it touches. The
+// attributes are filled in either way, for the audit log.
+func requestAttributes(r *http.Request) (v1.ResourceAttributes, bool) {
+	var attrs v1.ResourceAttributes
+	path := strings.Trim(r.URL.Path, "/")
+	rest, versioned := strings.CutPrefix(path, "api/v1")
+	if versioned {
+		path = strings.TrimPrefix(rest, "/")
+	}
+	parts := strings.Split(path, "/")
+	switch {
+	case versioned && len(parts) >= 3 && parts[0] == "namespaces":
+		attrs.Namespace, parts = parts[1], parts[2:]
+	case !versioned && len(parts) == 3 && parts[0] == "deployments":
+		// Legacy /deployments/{namespace}/{name}.
+		attrs.Namespace, parts = parts[1], []string{parts[0], parts[2]}
+	}
+
+	check := true
+	switch parts[0] {
+	case "whoami", "selfsubjectaccessreviews", "apply", "cabundle":
+		check = false
+	case "heartbeat":
+		parts[0] = "heartbeats"
+	case "registration":
+		parts[0] = "registrations"
+	case "admin":
+		// /admin/snapshot and /admin/restore are resources of their own.
+		if len(parts) > 1 {
+			parts = parts[1:]
+		}
+	}
+	attrs.Resource = parts[0]
+	if len(parts) > 1 {
+		attrs.Name = parts[1]
+	}
+	if len(parts) > 2 {
+		attrs.Resource += "/" + strings.Join(parts[2:], "/")
+	}
+
+	switch r.Method {
+	case "GET":
+		switch {
+		case attrs.Name != "" || attrs.Resource == "scheduler" || attrs.Resource == "metrics" || attrs.Resource == "replication" || attrs.Resource == "snapshot":
+			attrs.Verb = "get"
+		case isWatchRequest(r):
+			attrs.Verb = "watch"
+		default:
+			attrs.Verb = "list"
+		}
+	case "POST":
+		attrs.Verb = "create"
+		if attrs.Resource == "scheduler" {
+			attrs.Verb = "update"
+		}
+	case "PUT":
+		attrs.Verb = "update"
+	case "DELETE":
+		attrs.Verb = "delete"
+	default:
+		attrs.Verb = strings.ToLower(r.Method)
+	}
+	return attrs, check
+}
+
+// withAuthorization refuses requests the authenticated user is not allowed to
+// make with 403 Forbidden.
+func withAuthorization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" || r.URL.Path == "/health" || r.URL.Path == caBundlePath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if attrs, check := requestAttributes(r); check {
+			if allowed, reason := authorize(requestUser(r), attrs); !allowed {
+				writeError(w, reason, http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkEscalation refuses to let user write obj if that would grant anyone
+// permissions user does not hold. Writing a role needs every one of its
+// rules, or the escalate verb on the role; writing a binding needs the rules
+// of the role it refers to, or the bind verb on that role. It must be called
+// without rbacMu held.
+func checkEscalation(user *userInfo, res *rbacResource, obj v1.Object) error {
+	if authorizationMode != authorizationRBAC {
+		return nil
+	}
+	meta := obj.GetObjectMeta()
+	var rules []v1.PolicyRule
+	var scope string
+	switch obj.(type) {
+	case *v1.Role, *v1.ClusterRole:
+		scope = meta.Namespace
+		if allowed, _ := authorize(user, v1.ResourceAttributes{Verb: "escalate", Resource: res.resource, Namespace: scope, Name: meta.Name}); allowed {
+			return nil
+		}
+		rules = policyRules(obj)
+	default:
+		_, ref := bindingOf(obj)
+		scope = meta.Namespace
+		refResource := "clusterroles"
+		if ref.Kind == "Role" {
+			refResource = "roles"
+		}
+		if allowed, _ := authorize(user, v1.ResourceAttributes{Verb: "bind", Resource: refResource, Namespace: scope, Name: ref.Name}); allowed {
+			return nil
+		}
+		rbacMu.Lock()
+		rules = referencedRules(ref, scope)
+		rbacMu.Unlock()
+		if rules == nil {
+			return forbiddenError("%s %q does not exist and user %q may not bind it", ref.Kind, ref.Name, user.Name)
+		}
+	}
+
+	for _, rule := range rules {
+		names := rule.ResourceNames
+		if len(names) == 0 {
+			names = []string{""}
+		}
+		for _, verb := range rule.Verbs {
+			for _, resource := range rule.Resources {
+				for _, name := range names {
+					attrs := v1.ResourceAttributes{Verb: verb, Resource: resource, Namespace: scope, Name: name}
+					if allowed, _ := authorize(user, attrs); !allowed {
+						return forbiddenError("%s %s would grant permissions user %q does not have: %s", res.kind, meta.Name, user.Name, forbiddenMessage(user, attrs))
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func validateRBACObject(res *rbacResource, obj v1.Object) error {
+	meta := obj.GetObjectMeta()
+	if meta.Name == "" || strings.Contains(meta.Name, "/") {
+		return invalidError("metadata.name %q is not a valid %s name", meta.Name, res.kind)
+	}
+	if err := validateLabels(meta.Labels); err != nil {
+		return invalidError("%v", err)
+	}
+	switch obj.(type) {
+	case *v1.Role, *v1.ClusterRole:
+		for i, rule := range policyRules(obj) {
+			if len(rule.Verbs) == 0 || len(rule.Resources) == 0 {
+				return invalidError("rules[%d] needs at least one verb and one resource", i)
+			}
+		}
+	default:
+		subjects, ref := bindingOf(obj)
+		switch {
+		case ref.Name == "":
+			return invalidError("roleRef.name is required")
+		case ref.Kind != "ClusterRole" && (ref.Kind != "Role" || !res.namespaced):
+			if res.namespaced {
+				return invalidError("roleRef.kind must be Role or ClusterRole")
+			}
+			return invalidError("roleRef.kind must be ClusterRole")
+		}
+		for i, s := range subjects {
+			if s.Name == "" {
+				return invalidError("subjects[%d].name is required", i)
+			}
+			switch s.Kind {
+			case v1.SubjectKindUser, v1.SubjectKindGroup:
+			case v1.SubjectKindServiceAccount:
+				if s.Namespace == "" && !res.namespaced {
+					return invalidError("subjects[%d].namespace is required for a ServiceAccount", i)
+				}
+			default:
+				return invalidError("subjects[%d].kind must be User, Group or ServiceAccount", i)
+			}
+		}
+	}
+	return nil
+}
+
+// createRBACObject validates obj and stores it on behalf of user, returning a
+// copy of what was stored.
+func createRBACObject(user *userInfo, res *rbacResource, obj v1.Object) (v1.Object, error) {
+	if err := validateRBACObject(res, obj); err != nil {
+		return nil, err
+	}
+	if err := checkEscalation(user, res, obj); err != nil {
+		return nil, err
+	}
+	meta := obj.GetObjectMeta()
+	rbacMu.Lock()
+	defer rbacMu.Unlock()
+	if _, exists := res.objects[res.key(meta.Namespace, meta.Name)]; exists {
+		return nil, fmt.Errorf("%w: %s %s", errNameTaken, res.kind, res.key(meta.Namespace, meta.Name))
+	}
+	if err := storeRBACObject(res, obj); err != nil {
+		return nil, err
+	}
+	return copyRBACObject(res, obj), nil
+}
+
+// updateRBACObject replaces the stored object named like obj on behalf of
+// user. A non-zero expectedRV must match the stored resourceVersion. A
+// binding's roleRef cannot change.
+func updateRBACObject(user *userInfo, res *rbacResource, obj v1.Object, expectedRV uint64) (v1.Object, error) {
+	if err := validateRBACObject(res, obj); err != nil {
+		return nil, err
+	}
+	if err := checkEscalation(user, res, obj); err != nil {
+		return nil, err
+	}
+	meta := obj.GetObjectMeta()
+	key := res.key(meta.Namespace, meta.Name)
+	rbacMu.Lock()
+	defer rbacMu.Unlock()
+	cur, exists := res.objects[key]
+	if !exists {
+		return nil, fmt.Errorf("%s %s: %w", res.kind, key, errNotFound)
+	}
+	curMeta := cur.GetObjectMeta()
+	if expectedRV != 0 && curMeta.ResourceVersion != expectedRV {
+		return nil, conflictError(res.kind, key, curMeta.ResourceVersion, expectedRV)
+	}
+	if _, ref := bindingOf(obj); ref != (v1.RoleRef{}) {
+		if _, curRef := bindingOf(cur); ref != curRef {
+			return nil, invalidError("roleRef cannot be changed; delete and recreate the %s", res.kind)
+		}
+	}
+
+	meta.UID = curMeta.UID
+	meta.ResourceVersion = curMeta.ResourceVersion
+	meta.CreationTimestamp = curMeta.CreationTimestamp
+	meta.Generation = curMeta.Generation
+	if !sameRBACContent(cur, obj) {
+		meta.Generation++
+	}
+	setRBACTypeMeta(res, obj)
+	res.objects[key] = obj
+	if err := rbacChanged(res, EventModified, obj); err != nil {
+		return nil, err
+	}
+	return copyRBACObject(res, obj), nil
+}
+
+func deleteRBACObject(res *rbacResource, namespace, name string) error {
+	key := res.key(namespace, name)
+	rbacMu.Lock()
+	defer rbacMu.Unlock()
+	obj, exists := res.objects[key]
+	if !exists {
+		return fmt.Errorf("%s %s: %w", res.kind, key, errNotFound)
+	}
+	delete(res.objects, key)
+	return rbacChanged(res, EventDeleted, obj)
+}
+
+// sameRBACContent reports whether a and b grant the same rules or bind the
+// same subjects, ignoring metadata.
+func sameRBACContent(a, b v1.Object) bool {
+	subjectsA, refA := bindingOf(a)
+	subjectsB, refB := bindingOf(b)
+	x, _ := json.Marshal([]interface{}{policyRules(a), subjectsA, refA})
+	y, _ := json.Marshal([]interface{}{policyRules(b), subjectsB, refB})
+	return string(x) == string(y)
+}
+
+func setRBACTypeMeta(res *rbacResource, obj v1.Object) {
+	switch o := obj.(type) {
+	case *v1.Role:
+		o.TypeMeta = typeMeta(res.kind)
+	case *v1.ClusterRole:
+		o.TypeMeta = typeMeta(res.kind)
+	case *v1.RoleBinding:
+		o.TypeMeta = typeMeta(res.kind)
+	case *v1.ClusterRoleBinding:
+		o.TypeMeta = typeMeta(res.kind)
+	}
+}
+
+var rbacFieldNames = []string{"metadata.name", "metadata.namespace"}
+
+// rbacSnapshots returns a function copying every object of res for
+// serveWatch. It is called with rbacMu held.
+func rbacSnapshots(res *rbacResource) func() []interface{} {
+	return func() []interface{} {
+		objs := make([]interface{}, 0, len(res.objects))
+		for _, obj := range res.objects {
+			objs = append(objs, copyRBACObject(res, obj))
+		}
+		return objs
+	}
+}
+
+// handleRBACCollection serves list, watch and create for res, across all
+// namespaces when the path has none.
+func handleRBACCollection(res *rbacResource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := r.PathValue("namespace")
+
+		switch r.Method {
+		case "GET":
+			opts, err := parseListOptions(r, rbacFieldNames)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			match := func(obj v1.Object) bool {
+				meta := obj.GetObjectMeta()
+				fields := map[string]string{"metadata.name": meta.Name, "metadata.namespace": meta.Namespace}
+				return (namespace == "" || meta.Namespace == namespace) && opts.matches(meta.Labels, fields)
+			}
+
+			if isWatchRequest(r) {
+				serveWatch(w, r, res.kind, &rbacMu, rbacSnapshots(res), func(obj interface{}) bool {
+					return match(obj.(v1.Object))
+				}, func(obj interface{}) interface{} { return obj })
+				return
+			}
+
+			rbacMu.Lock()
+			keys := make([]string, 0, len(res.objects))
+			for key := range res.objects {
+				keys = append(keys, key)
+			}
+			selected, next := opts.page(keys, func(key string) bool { return match(res.objects[key]) })
+			items := make([]v1.Object, 0, len(selected))
+			for _, key := range selected {
+				items = append(items, copyRBACObject(res, res.objects[key]))
+			}
+			meta := v1.ListMeta{ResourceVersion: watches.currentResourceVersion(), Continue: next}
+			rbacMu.Unlock()
+			writeList(w, meta, res.newList(items, meta))
+
+		case "POST":
+			if res.namespaced && namespace == "" {
+				writeError(w, fmt.Sprintf("%s are created in a namespace: POST /api/v1/namespaces/{namespace}/%s", res.resource, res.resource), http.StatusMethodNotAllowed)
+				return
+			}
+			obj := res.newObject()
+			if !decodeBody(w, r, obj) || !checkObjectMeta(w, obj.GetObjectMeta(), namespace, "") {
+				return
+			}
+			obj.GetObjectMeta().Namespace = namespace
+			created, err := createRBACObject(requestUser(r), res, obj)
+			if err != nil {
+				writeErrorFor(w, err, http.StatusUnprocessableEntity)
+				return
+			}
+			writeJSON(w, http.StatusCreated, created)
+
+		default:
+			writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// handleRBACObject serves get, update and delete of one object of res.
+func handleRBACObject(res *rbacResource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace, name := r.PathValue("namespace"), r.PathValue("name")
+		key := res.key(namespace, name)
+
+		switch r.Method {
+		case "GET":
+			rbacMu.Lock()
+			defer rbacMu.Unlock()
+			obj, exists := res.objects[key]
+			if !exists {
+				writeErrorFor(w, fmt.Errorf("%s %s: %w", res.kind, key, errNotFound), http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, obj)
+
+		case "PUT":
+			obj := res.newObject()
+			if !decodeBody(w, r, obj) || !checkObjectMeta(w, obj.GetObjectMeta(), namespace, name) {
+				return
+			}
+			meta := obj.GetObjectMeta()
+			if meta.ResourceVersion == 0 {
+				writeError(w, "metadata.resourceVersion is required", http.StatusUnprocessableEntity)
+				return
+			}
+			meta.Namespace, meta.Name = namespace, name
+			updated, err := updateRBACObject(requestUser(r), res, obj, meta.ResourceVersion)
+			if err != nil {
+				writeErrorFor(w, err, http.StatusUnprocessableEntity)
+				return
+			}
+			writeJSON(w, http.StatusOK, updated)
+
+		case "DELETE":
+			if err := deleteRBACObject(res, namespace, name); err != nil {
+				writeErrorFor(w, err, http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, deletedStatus(res.kind, namespace, name))
+
+		default:
+			writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// handleSelfSubjectAccessReview answers whether the caller may perform the
+// action in the review's spec. Anyone may ask about themselves.
+func handleSelfSubjectAccessReview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var review v1.SelfSubjectAccessReview
+	if !decodeBody(w, r, &review) {
+		return
+	}
+	if review.Spec.Verb == "" || review.Spec.Resource == "" {
+		writeError(w, "spec.verb and spec.resource are required", http.StatusUnprocessableEntity)
+		return
+	}
+	allowed, reason := authorize(requestUser(r), review.Spec)
+	review.TypeMeta = typeMeta("SelfSubjectAccessReview")
+	review.Status = v1.SubjectAccessReviewStatus{Allowed: allowed, Reason: reason}
+	writeJSON(w, http.StatusCreated, review)
+}
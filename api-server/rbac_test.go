@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v1 "example.com/m/api/v1"
+)
+
+var clusterAdmin = &userInfo{Name: "root", Groups: []string{"system:masters", groupAuthenticated}}
+
+// useRBAC starts the test from the bootstrap policy with RBAC enforced.
+func useRBAC(t *testing.T) {
+	t.Helper()
+	resetState(t)
+	authorizationMode = authorizationRBAC
+	t.Cleanup(func() { authorizationMode = authorizationAlwaysAllow })
+	bootstrapRBAC()
+}
+
+// grant stores obj as a cluster admin.
+func grant(t *testing.T, res *rbacResource, obj v1.Object) {
+	t.Helper()
+	if _, err := createRBACObject(clusterAdmin, res, obj); err != nil {
+		t.Fatalf("creating %s %s: %v", res.kind, obj.GetObjectMeta().Name, err)
+	}
+}
+
+func bindRole(namespace, name, roleKind, role string, subjects ...v1.Subject) *v1.RoleBinding {
+	return &v1.RoleBinding{
+		Metadata: v1.ObjectMeta{Namespace: namespace, Name: name},
+		Subjects: subjects,
+		RoleRef:  v1.RoleRef{Kind: roleKind, Name: role},
+	}
+}
+
+func user(name string) v1.Subject {
+	return v1.Subject{Kind: v1.SubjectKindUser, Name: name}
+}
+
+func TestRBACAllowsAndDenies(t *testing.T) {
+	useRBAC(t)
+	grant(t, roleBindingResource, bindRole("dev", "alice-edit", "ClusterRole", "edit", user("alice")))
+	grant(t, roleResource, &v1.Role{
+		Metadata: v1.ObjectMeta{Namespace: "dev", Name: "restart-web"},
+		Rules:    []v1.PolicyRule{{Verbs: []string{"create"}, Resources: []string{"pods/restart"}, ResourceNames: []string{"web"}}},
+	})
+	grant(t, roleBindingResource, bindRole("dev", "ci-restart", "Role", "restart-web", v1.Subject{Kind: v1.SubjectKindServiceAccount, Name: "ci"}))
+	grant(t, clusterRoleBindingResource, &v1.ClusterRoleBinding{
+		Metadata: v1.ObjectMeta{Name: "viewers"},
+		Subjects: []v1.Subject{{Kind: v1.SubjectKindGroup, Name: "auditors"}},
+		RoleRef:  v1.RoleRef{Kind: "ClusterRole", Name: "view"},
+	})
+
+	alice := &userInfo{Name: "alice", Groups: []string{groupAuthenticated}}
+	ci := &userInfo{Name: "system:serviceaccount:dev:ci", Groups: []string{groupServiceAccounts}}
+	otherCI := &userInfo{Name: "system:serviceaccount:prod:ci", Groups: []string{groupServiceAccounts}}
+	auditor := &userInfo{Name: "bob", Groups: []string{"auditors"}}
+	node := &userInfo{Name: "system:node:worker-1", Groups: []string{groupNodes}}
+	anonymous := &userInfo{Name: anonymousUser, Groups: []string{groupUnauthenticated}}
+	for _, tc := range []struct {
+		user    *userInfo
+		attrs   v1.ResourceAttributes
+		allowed bool
+	}{
+		{clusterAdmin, v1.ResourceAttributes{Verb: "delete", Resource: "nodes", Name: "worker-1"}, true},
+		{alice, v1.ResourceAttributes{Verb: "create", Resource: "pods", Namespace: "dev"}, true},
+		{alice, v1.ResourceAttributes{Verb: "create", Resource: "pods", Namespace: "prod"}, false},
+		// A RoleBinding never grants across namespaces or at the cluster
+		// scope.
+		{alice, v1.ResourceAttributes{Verb: "list", Resource: "pods"}, false},
+		{alice, v1.ResourceAttributes{Verb: "create", Resource: "roles", Namespace: "dev"}, false},
+		{ci, v1.ResourceAttributes{Verb: "create", Resource: "pods/restart", Namespace: "dev", Name: "web"}, true},
+		{ci, v1.ResourceAttributes{Verb: "create", Resource: "pods/restart", Namespace: "dev", Name: "db"}, false},
+		{ci, v1.ResourceAttributes{Verb: "create", Resource: "pods/restart", Namespace: "dev"}, false},
+		{otherCI, v1.ResourceAttributes{Verb: "create", Resource: "pods/restart", Namespace: "dev", Name: "web"}, false},
+		{auditor, v1.ResourceAttributes{Verb: "list", Resource: "nodes"}, true},
+		{auditor, v1.ResourceAttributes{Verb: "watch", Resource: "pods", Namespace: "prod"}, true},
+		{auditor, v1.ResourceAttributes{Verb: "delete", Resource: "pods", Namespace: "prod", Name: "web"}, false},
+		{node, v1.ResourceAttributes{Verb: "create", Resource: "heartbeats"}, true},
+		{node, v1.ResourceAttributes{Verb: "create", Resource: "pods", Namespace: "default"}, false},
+		{anonymous, v1.ResourceAttributes{Verb: "list", Resource: "pods", Namespace: "default"}, false},
+	} {
+		allowed, reason := authorize(tc.user, tc.attrs)
+		if allowed != tc.allowed {
+			t.Errorf("%s %+v: allowed %v (%s), want %v", tc.user.Name, tc.attrs, allowed, reason, tc.allowed)
+		}
+	}
+
+	// Deleting the binding takes the permission away.
+	if err := deleteRBACObject(roleBindingResource, "dev", "alice-edit"); err != nil {
+		t.Fatal(err)
+	}
+	if allowed, _ := authorize(alice, v1.ResourceAttributes{Verb: "create", Resource: "pods", Namespace: "dev"}); allowed {
+		t.Error("alice may still create pods once unbound")
+	}
+
+	authorizationMode = authorizationAlwaysAllow
+	if allowed, _ := authorize(anonymous, v1.ResourceAttributes{Verb: "delete", Resource: "nodes"}); !allowed {
+		t.Error("AlwaysAllow denied a request")
+	}
+}
+
+func TestRBACPreventsEscalation(t *testing.T) {
+	useRBAC(t)
+	grant(t, roleBindingResource, bindRole("dev", "carol-admin", "ClusterRole", "admin", user("carol")))
+	carol := &userInfo{Name: "carol", Groups: []string{groupAuthenticated}}
+
+	// Carol may hand out what she holds in her namespace.
+	podReader := &v1.Role{
+		Metadata: v1.ObjectMeta{Namespace: "dev", Name: "pod-reader"},
+		Rules:    []v1.PolicyRule{{Verbs: []string{"get", "list"}, Resources: []string{"pods"}}},
+	}
+	if _, err := createRBACObject(carol, roleResource, podReader); err != nil {
+		t.Fatalf("creating a role within her permissions: %v", err)
+	}
+	if _, err := createRBACObject(carol, roleBindingResource, bindRole("dev", "dave-read", "Role", "pod-reader", user("dave"))); err != nil {
+		t.Fatalf("binding a role within her permissions: %v", err)
+	}
+	if _, err := createRBACObject(carol, roleBindingResource, bindRole("dev", "dave-edit", "ClusterRole", "edit", user("dave"))); err != nil {
+		t.Fatalf("binding edit, which admin covers: %v", err)
+	}
+
+	// But not more, nor anything elsewhere.
+	nodeDeleter := &v1.Role{
+		Metadata: v1.ObjectMeta{Namespace: "dev", Name: "node-deleter"},
+		Rules:    []v1.PolicyRule{{Verbs: []string{"delete"}, Resources: []string{"nodes"}}},
+	}
+	for name, attempt := range map[string]func() error{
+		"a role with rules she lacks": func() error {
+			_, err := createRBACObject(carol, roleResource, nodeDeleter)
+			return err
+		},
+		"a binding to cluster-admin": func() error {
+			_, err := createRBACObject(carol, roleBindingResource, bindRole("dev", "dave-root", "ClusterRole", "cluster-admin", user("dave")))
+			return err
+		},
+		"a binding in another namespace": func() error {
+			_, err := createRBACObject(carol, roleBindingResource, bindRole("prod", "dave-read", "ClusterRole", "view", user("dave")))
+			return err
+		},
+		"a binding to a missing role": func() error {
+			_, err := createRBACObject(carol, roleBindingResource, bindRole("dev", "dave-ghost", "Role", "ghost", user("dave")))
+			return err
+		},
+		"widening an existing role": func() error {
+			wider := *podReader
+			wider.Rules = []v1.PolicyRule{{Verbs: []string{"*"}, Resources: []string{"*"}}}
+			_, err := updateRBACObject(carol, roleResource, &wider, 0)
+			return err
+		},
+	} {
+		if err := attempt(); !errors.Is(err, errForbidden) {
+			t.Errorf("%s: got %v, want forbidden", name, err)
+		}
+	}
+
+	// The bind and escalate verbs allow exactly that.
+	grant(t, roleResource, &v1.Role{
+		Metadata: v1.ObjectMeta{Namespace: "dev", Name: "delegate"},
+		Rules: []v1.PolicyRule{
+			{Verbs: []string{"bind"}, Resources: []string{"clusterroles"}, ResourceNames: []string{"cluster-admin"}},
+			{Verbs: []string{"escalate"}, Resources: []string{"roles"}, ResourceNames: []string{"node-deleter"}},
+		},
+	})
+	grant(t, roleBindingResource, bindRole("dev", "carol-delegate", "Role", "delegate", user("carol")))
+	if _, err := createRBACObject(carol, roleBindingResource, bindRole("dev", "dave-root", "ClusterRole", "cluster-admin", user("dave"))); err != nil {
+		t.Errorf("binding with the bind verb: %v", err)
+	}
+	if _, err := createRBACObject(carol, roleResource, nodeDeleter); err != nil {
+		t.Errorf("creating a role with the escalate verb: %v", err)
+	}
+}
+
+func TestRBACValidation(t *testing.T) {
+	useRBAC(t)
+	grant(t, roleBindingResource, bindRole("dev", "alice-view", "ClusterRole", "view", user("alice")))
+	for name, tc := range map[string]struct {
+		res *rbacResource
+		obj v1.Object
+	}{
+		"a rule without verbs":          {roleResource, &v1.Role{Metadata: v1.ObjectMeta{Namespace: "dev", Name: "r"}, Rules: []v1.PolicyRule{{Resources: []string{"pods"}}}}},
+		"a cluster binding to a Role":   {clusterRoleBindingResource, &v1.ClusterRoleBinding{Metadata: v1.ObjectMeta{Name: "b"}, RoleRef: v1.RoleRef{Kind: "Role", Name: "r"}}},
+		"an unknown subject kind":       {roleBindingResource, bindRole("dev", "b", "ClusterRole", "view", v1.Subject{Kind: "Robot", Name: "r2"})},
+		"a service account without one": {clusterRoleBindingResource, &v1.ClusterRoleBinding{Metadata: v1.ObjectMeta{Name: "b"}, Subjects: []v1.Subject{{Kind: v1.SubjectKindServiceAccount, Name: "ci"}}, RoleRef: v1.RoleRef{Kind: "ClusterRole", Name: "view"}}},
+		"a changed roleRef":             {roleBindingResource, bindRole("dev", "alice-view", "ClusterRole", "edit", user("alice"))},
+	} {
+		var err error
+		if name == "a changed roleRef" {
+			_, err = updateRBACObject(clusterAdmin, tc.res, tc.obj, 0)
+		} else {
+			_, err = createRBACObject(clusterAdmin, tc.res, tc.obj)
+		}
+		if !errors.Is(err, errInvalid) {
+			t.Errorf("%s: got %v, want invalid", name, err)
+		}
+	}
+	if _, err := createRBACObject(clusterAdmin, roleBindingResource, bindRole("dev", "alice-view", "ClusterRole", "view", user("alice"))); !errors.Is(err, errNameTaken) {
+		t.Errorf("creating a binding twice: got %v", err)
+	}
+}
+
+func TestRequestAttributes(t *testing.T) {
+	for _, tc := range []struct {
+		method, target string
+		want           v1.ResourceAttributes
+		check          bool
+	}{
+		{"GET", "/api/v1/namespaces/dev/pods", v1.ResourceAttributes{Verb: "list", Resource: "pods", Namespace: "dev"}, true},
+		{"GET", "/api/v1/pods?watch=true", v1.ResourceAttributes{Verb: "watch", Resource: "pods"}, true},
+		{"GET", "/api/v1/nodes/worker-1", v1.ResourceAttributes{Verb: "get", Resource: "nodes", Name: "worker-1"}, true},
+		{"POST", "/api/v1/namespaces/dev/pods/web/restart", v1.ResourceAttributes{Verb: "create", Resource: "pods/restart", Namespace: "dev", Name: "web"}, true},
+		{"PUT", "/api/v1/scheduler", v1.ResourceAttributes{Verb: "update", Resource: "scheduler"}, true},
+		{"POST", "/scheduler", v1.ResourceAttributes{Verb: "update", Resource: "scheduler"}, true},
+		{"DELETE", "/deployments/dev/web", v1.ResourceAttributes{Verb: "delete", Resource: "deployments", Namespace: "dev", Name: "web"}, true},
+		{"POST", "/heartbeat", v1.ResourceAttributes{Verb: "create", Resource: "heartbeats"}, true},
+		{"GET", "/api/v1/whoami", v1.ResourceAttributes{Verb: "list", Resource: "whoami"}, false},
+	} {
+		got, check := requestAttributes(httptest.NewRequest(tc.method, tc.target, nil))
+		if got != tc.want || check != tc.check {
+			t.Errorf("%s %s: %+v (check %v), want %+v (check %v)", tc.method, tc.target, got, check, tc.want, tc.check)
+		}
+	}
+}
+
+func TestWithAuthorization(t *testing.T) {
+	useRBAC(t)
+	grant(t, roleBindingResource, bindRole("dev", "alice-view", "ClusterRole", "view", user("alice")))
+	handler := withAuthorization(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(method, target string) int {
+		r := httptest.NewRequest(method, target, nil)
+		alice := &userInfo{Name: "alice", Groups: []string{groupAuthenticated}}
+		r = r.WithContext(context.WithValue(r.Context(), userContextKey{}, alice))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	for _, tc := range []struct {
+		method, target string
+		code           int
+	}{
+		{"GET", "/api/v1/namespaces/dev/pods", http.StatusOK},
+		{"DELETE", "/api/v1/namespaces/dev/pods/web", http.StatusForbidden},
+		{"GET", "/api/v1/pods", http.StatusForbidden},
+		{"GET", "/api/v1/whoami", http.StatusOK},
+		{"GET", "/health", http.StatusOK},
+	} {
+		if code := serve(tc.method, tc.target); code != tc.code {
+			t.Errorf("%s %s: got %d, want %d", tc.method, tc.target, code, tc.code)
+		}
+	}
+}
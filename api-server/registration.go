@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	v1 "example.com/m/api/v1"
+)
+
+// maxNodeIDLength bounds the IDs agents may register nodes under.
+const maxNodeIDLength = 128
+
+func handleRegistration(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var reg v1.NodeRegistration
+	if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+		writeError(w, "Invalid registration", http.StatusBadRequest)
+		return
+	}
+	node, created, err := registerNode(requestUser(r), reg)
+	if err != nil {
+		writeErrorFor(w, err, http.StatusInternalServerError)
+		return
+	}
+	code := http.StatusOK
+	if created {
+		code = http.StatusCreated
+	}
+	writeJSON(w, code, node)
+}
+
+// registerNode creates the node reg declares, or brings the node already
+// registered under its ID, or its name when it has no ID, up to date with
+// the declared capacity, labels and agent version. Labels the node has and
+// the agent does not declare are kept. Nodes may only register themselves.
+func registerNode(user *userInfo, reg v1.NodeRegistration) (*v1.Node, bool, error) {
+	if err := validateRegistration(reg); err != nil {
+		return nil, false, err
+	}
+	if user.inGroup(groupNodes) && (user.Name != nodeUserPrefix+reg.Name || user.UID != "" && user.UID != reg.NodeID) {
+		return nil, false, forbiddenError("%s may only register itself", user.Name)
+	}
+
+	nodesMu.Lock()
+	var existing *Node
+	if reg.NodeID != "" {
+		existing = nodes[reg.NodeID]
+		if other := findNodeByName(reg.Name); existing == nil && other != nil {
+			nodesMu.Unlock()
+			return nil, false, fmt.Errorf("%w: node %q is registered with another ID", errNameTaken, reg.Name)
+		}
+	} else {
+		existing = findNodeByName(reg.Name)
+	}
+	var name string
+	if existing != nil {
+		name = existing.Name
+	}
+	nodesMu.Unlock()
+
+	if existing == nil {
+		node, err := addNode(v1.Node{
+			Metadata: v1.ObjectMeta{Name: reg.Name, UID: reg.NodeID, Labels: reg.Labels},
+			Spec:     v1.NodeSpec{CPUCores: reg.CPUCores},
+		}, reg.AgentVersion)
+		if err != nil {
+			return nil, false, err
+		}
+		nodesMu.Lock()
+		defer nodesMu.Unlock()
+		registered := toV1Node(node)
+		return &registered, true, nil
+	}
+	if name != reg.Name {
+		return nil, false, invalidError("node %s is named %q; node names cannot change", reg.NodeID, name)
+	}
+
+	if _, err := updateNode(name, 0, func(node *v1.Node) {
+		node.Spec.CPUCores = reg.CPUCores
+		if node.Metadata.Labels == nil && len(reg.Labels) > 0 {
+			node.Metadata.Labels = make(map[string]string, len(reg.Labels))
+		}
+		for k, v := range reg.Labels {
+			node.Metadata.Labels[k] = v
+		}
+	}); err != nil {
+		return nil, false, err
+	}
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	node := findNodeByName(name)
+	if node == nil {
+		return nil, false, fmt.Errorf("node %q: %w", name, errNotFound)
+	}
+	node.AgentVersion = reg.AgentVersion
+	if err := nodeChanged(EventModified, node); err != nil {
+		return nil, false, err
+	}
+	recordEvent(nodeRef(node), sourceNodeAgent, v1.EventTypeNormal, "AgentRegistered",
+		"Agent %s registered the node with %d CPU cores", reg.AgentVersion, reg.CPUCores)
+	registered := toV1Node(node)
+	return &registered, false, nil
+}
+
+func validateRegistration(reg v1.NodeRegistration) error {
+	switch {
+	case reg.Name == "":
+		return invalidError("name is required")
+	case reg.CPUCores <= 0:
+		return invalidError("cpuCores must be positive")
+	case reg.AgentVersion == "":
+		return invalidError("agentVersion is required")
+	case len(reg.NodeID) > maxNodeIDLength || strings.ContainsAny(reg.NodeID, "/?#% "):
+		return invalidError("nodeID %q is not a valid ID", reg.NodeID)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v1 "example.com/m/api/v1"
+)
+
+func postJSON(t *testing.T, handler http.HandlerFunc, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", path, bytes.NewReader(data)))
+	return w
+}
+
+func TestShortNodeID(t *testing.T) {
+	resetState(t)
+	w := postJSON(t, handleRegistration, "/api/v1/registration", v1.NodeRegistration{NodeID: "n1", Name: "worker-1", CPUCores: 2, AgentVersion: "test"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("registering n1: got %d: %s", w.Code, w.Body)
+	}
+	w = postJSON(t, handleHeartbeat, "/api/v1/heartbeat", v1.HeartbeatRequest{NodeID: "n1", Status: "Healthy"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("heartbeat from n1: got %d: %s", w.Code, w.Body)
+	}
+
+	nodesMu.Lock()
+	nodes["n1"].LastHeartbeat = time.Now().Add(-2 * nodeHeartbeatTimeout)
+	nodesMu.Unlock()
+	failNode("n1")
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	if status := nodes["n1"].HealthStatus; status != "Failed" {
+		t.Errorf("n1 is %s after its heartbeats stopped, want Failed", status)
+	}
+}
+
+func TestShortID(t *testing.T) {
+	for id, want := range map[string]string{
+		"":                                     "",
+		"n1":                                   "n1",
+		"12345678":                             "12345678",
+		"0f8fad5b-d9cb-469f-a165-70867728950e": "0f8fad5b",
+	} {
+		if got := shortID(id); got != want {
+			t.Errorf("shortID(%q) = %q, want %q", id, got, want)
+		}
+	}
+}
+
+// requestNodeToken asks for the token of the node name as an admin.
+func requestNodeToken(t *testing.T, name string) v1.TokenRequest {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/api/v1/nodes/"+name+"/token", nil)
+	r.SetPathValue("name", name)
+	r.SetPathValue("action", "token")
+	admin := &userInfo{Name: "admin", Groups: []string{"system:masters"}}
+	r = r.WithContext(context.WithValue(r.Context(), userContextKey{}, admin))
+	w := httptest.NewRecorder()
+	handleV1NodeAction(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("node token for %s: got %d: %s", name, w.Code, w.Body)
+	}
+	var token v1.TokenRequest
+	if err := json.NewDecoder(w.Body).Decode(&token); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// tokenUser authenticates a request bearing token.
+func tokenUser(t *testing.T, token string) *userInfo {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/api/v1/heartbeat", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	user, err := authenticate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestNodeTokens(t *testing.T) {
+	resetState(t)
+	if err := configureAuthentication("", "", true); err != nil {
+		t.Fatal(err)
+	}
+
+	first, second := requestNodeToken(t, "hollow-node-00001"), requestNodeToken(t, "hollow-node-00002")
+	if first.Metadata.UID == "" || first.Metadata.UID == second.Metadata.UID {
+		t.Fatalf("new nodes got IDs %q and %q", first.Metadata.UID, second.Metadata.UID)
+	}
+	user := tokenUser(t, first.Status.Token)
+	other := tokenUser(t, second.Status.Token)
+	if classify(httptest.NewRequest("POST", "/api/v1/heartbeat", nil), user) != priorityNodeHigh {
+		t.Errorf("%s is not classified %s", user.Name, priorityNodeHigh)
+	}
+	if flowKey(nil, user) == flowKey(nil, other) {
+		t.Errorf("two nodes share the flow %q", flowKey(nil, user))
+	}
+
+	// The agent registers the node under the ID the token was issued for.
+	reg := v1.NodeRegistration{NodeID: first.Metadata.UID, Name: "hollow-node-00001", CPUCores: 4, AgentVersion: "test"}
+	if _, created, err := registerNode(user, reg); err != nil || !created {
+		t.Fatalf("registering with the node token: created %v, %v", created, err)
+	}
+	reg.NodeID = "another-id"
+	if _, _, err := registerNode(user, reg); !errors.Is(err, errForbidden) {
+		t.Errorf("registering under another ID: got %v, want forbidden", err)
+	}
+	if again := requestNodeToken(t, "hollow-node-00001"); again.Metadata.UID != first.Metadata.UID {
+		t.Errorf("registered node got a token for ID %q, want %q", again.Metadata.UID, first.Metadata.UID)
+	}
+}
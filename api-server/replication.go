@@ -0,0 +1,430 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	v1 "example.com/m/api/v1"
+)
+
+// replicationPath reports this replica's view of the Raft cluster.
+const replicationPath = "/replication"
+
+// How a follower hands writes to the leader, chosen with
+// -replica-write-mode. Requests authenticated with a client certificate are
+// always redirected, since a forwarded request cannot carry it.
+const (
+	writeModeForward  = "forward"
+	writeModeRedirect = "redirect"
+)
+
+var (
+	// replication is set when the server runs as one replica of a cluster.
+	replication      *raftNode
+	replicaWriteMode = writeModeForward
+
+	leadingHooks []func()
+)
+
+// raftStorage is the Storage of a replica: reads are served from its copy
+// of the replicated state, writes are proposed to the replicated log and
+// return once a majority has stored them and they have been applied here.
+type raftStorage struct {
+	*kvStore
+	node *raftNode
+}
+
+func (s *raftStorage) Txn(conditions []Condition, ops []Op) (uint64, error) {
+	return s.node.propose(conditions, ops)
+}
+
+func (s *raftStorage) Create(key string, value []byte) (uint64, error) {
+	rev, err := s.Txn([]Condition{{Key: key}}, []Op{{Key: key, Value: value}})
+	if errors.Is(err, errConflict) {
+		return 0, fmt.Errorf("%w: key %s", errNameTaken, key)
+	}
+	return rev, err
+}
+
+func (s *raftStorage) Update(key string, value []byte, expectedRevision uint64) (uint64, error) {
+	return s.write(Op{Key: key, Value: value}, expectedRevision)
+}
+
+func (s *raftStorage) Delete(key string, expectedRevision uint64) (uint64, error) {
+	return s.write(Op{Key: key}, expectedRevision)
+}
+
+// write applies op to an existing key. Without an expected revision the
+// one read here is expected, so a concurrent change fails with errConflict.
+func (s *raftStorage) write(op Op, expectedRevision uint64) (uint64, error) {
+	if expectedRevision == 0 {
+		kv, err := s.Get(op.Key)
+		if err != nil {
+			return 0, err
+		}
+		expectedRevision = kv.ModRevision
+	}
+	return s.Txn([]Condition{{Key: op.Key, ModRevision: expectedRevision}}, []Op{op})
+}
+
+func (s *raftStorage) Close() error {
+	err := s.node.stop()
+	if cerr := s.kvStore.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// parseRaftPeers parses -raft-peers: comma-separated id=host:port pairs
+// naming every replica's Raft address, this one's included.
+func parseRaftPeers(spec string) (map[string]string, error) {
+	peers := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		id, addr, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || id == "" || addr == "" {
+			return nil, fmt.Errorf("-raft-peers entry %q is not id=host:port", pair)
+		}
+		if _, dup := peers[id]; dup {
+			return nil, fmt.Errorf("-raft-peers names replica %s twice", id)
+		}
+		peers[id] = addr
+	}
+	return peers, nil
+}
+
+// startReplication joins the cluster as replica id, serving Raft on its
+// address from -raft-peers. advertiseURL is where followers send writes
+// while this replica leads. The log and snapshots are kept in
+// <dataDir>/raft, or in memory when dataDir is empty.
+func startReplication(id, peerSpec, advertiseURL, dataDir string, snapshotEvery int) (Storage, error) {
+	peers, err := parseRaftPeers(peerSpec)
+	if err != nil {
+		return nil, err
+	}
+	addr, ok := peers[id]
+	if !ok {
+		return nil, fmt.Errorf("-raft-peers has no address for replica %q", id)
+	}
+	delete(peers, id)
+	dir := ""
+	if dataDir != "" {
+		dir = filepath.Join(dataDir, "raft")
+	}
+	node, err := newRaftNode(id, advertiseURL, peers, tokenSigningKey, dir, snapshotEvery)
+	if err != nil {
+		return nil, err
+	}
+	node.onApply = applyReplicated
+	node.onRestore = resyncStores
+	node.onLeading = runLeadingHooks
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		log.Fatal("Raft server failed: ", http.Serve(raftListener(listener), node.handler()))
+	}()
+	go node.run()
+	replication = node
+	fmt.Printf("%s%s[*] %sReplica %s serving Raft on %s with %d peers%s\n", NEON_BLUE, BOLD, NEON_CYAN, id, addr, len(peers), NC)
+	return &raftStorage{kvStore: node.sm, node: node}, nil
+}
+
+// onLeading registers fn to run each time this replica becomes the leader,
+// or once at startup when the server is not replicated.
+func onLeading(fn func()) {
+	if replication == nil {
+		fn()
+		return
+	}
+	leadingHooks = append(leadingHooks, fn)
+}
+
+func runLeadingHooks() {
+	fmt.Printf("%s%s[*] %sReplica %s is now the leader%s\n", NEON_BLUE, BOLD, NEON_CYAN, replication.id, NC)
+	for _, fn := range leadingHooks {
+		fn()
+	}
+}
+
+// isLeader reports whether this server may make changes of its own: it is
+// not replicated, or it is the leader.
+func isLeader() bool {
+	return replication == nil || replication.ready()
+}
+
+// lockStores takes every object store lock, for replacing their contents
+// wholesale.
+func lockStores() {
+	nodesMu.Lock()
+	podsMu.Lock()
+	deploymentsMu.Lock()
+	eventsMu.Lock()
+	rbacMu.Lock()
+	leasesMu.Lock()
+}
+
+func unlockStores() {
+	leasesMu.Unlock()
+	rbacMu.Unlock()
+	eventsMu.Unlock()
+	deploymentsMu.Unlock()
+	podsMu.Unlock()
+	nodesMu.Unlock()
+}
+
+// applyReplicated updates the object cache of a follower with a transaction
+// the leader committed at rev, and publishes it to watchers.
+func applyReplicated(ops []Op, rev uint64) {
+	lockStores()
+	defer unlockStores()
+	var evs []WatchEvent
+	for _, op := range ops {
+		kind, key, _ := strings.Cut(strings.TrimPrefix(op.Key, "/"), "/")
+		prev, existed := cachedObject(kind, key)
+		if op.isDelete() {
+			if existed {
+				removeFromStores(kind, key)
+				evs = append(evs, WatchEvent{Type: EventDeleted, Kind: kind, Object: prev})
+			}
+			continue
+		}
+		if err := decodeIntoStores(KeyValue{Key: op.Key, Value: op.Value}); err != nil {
+			log.Printf("Applying replicated change: %v", err)
+			continue
+		}
+		eventType := EventModified
+		if !existed {
+			eventType = EventAdded
+		}
+		obj, _ := cachedObject(kind, key)
+		evs = append(evs, WatchEvent{Type: eventType, Kind: kind, Object: obj})
+	}
+	watches.replicated(rev, evs)
+}
+
+// cachedObject returns a copy of the cached object, as watchers receive it.
+// Callers must hold the store locks.
+func cachedObject(kind, key string) (interface{}, bool) {
+	switch kind {
+	case "Node":
+		if node, ok := nodes[key]; ok {
+			return copyNode(node), true
+		}
+	case "Pod":
+		if pod, ok := pods[key]; ok {
+			return copyPod(pod), true
+		}
+	case "Deployment":
+		if d, ok := deployments[key]; ok {
+			return copyDeployment(d), true
+		}
+	case "Event":
+		if e, ok := events[key]; ok {
+			return *e, true
+		}
+	case schedulerConfigKind:
+		return v1.SchedulerConfig{Algorithm: scheduler.Algorithm}, true
+	case "Lease":
+		if l, ok := leases[key]; ok {
+			return copyLease(l), true
+		}
+	default:
+		if res, ok := rbacResources[kind]; ok {
+			if obj, ok := res.objects[key]; ok {
+				return copyRBACObject(res, obj), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// removeFromStores deletes a cached object. Callers must hold the store
+// locks.
+func removeFromStores(kind, key string) {
+	switch kind {
+	case "Node":
+		delete(nodes, key)
+	case "Pod":
+		delete(pods, key)
+	case "Deployment":
+		delete(deployments, key)
+	case "Event":
+		if e, ok := events[key]; ok && eventsByKey[e.dedupKey()] == e {
+			delete(eventsByKey, e.dedupKey())
+		}
+		delete(events, key)
+	case "Lease":
+		delete(leases, key)
+	default:
+		if res, ok := rbacResources[kind]; ok {
+			delete(res.objects, key)
+		}
+	}
+}
+
+// clearStores empties the object cache, leases aside. Callers must hold the
+// store locks.
+func clearStores() {
+	nodes = make(map[string]*Node)
+	pods = make(map[string]*Pod)
+	deployments = make(map[string]*Deployment)
+	events = make(map[string]*Event)
+	eventsByKey = make(map[string]*Event)
+	for _, res := range rbacResources {
+		res.objects = make(map[string]v1.Object)
+	}
+}
+
+// resyncStores reloads the object cache from storage, after a snapshot
+// replaced it or a change this replica made as leader failed to commit.
+// Watches end, and clients relist.
+func resyncStores() {
+	lockStores()
+	defer unlockStores()
+	watches.mu.Lock()
+	defer watches.mu.Unlock()
+
+	clearStores()
+	leases = make(map[string]*v1.Lease)
+	if _, err := loadStores(); err != nil {
+		log.Fatalf("Reloading state from storage: %v", err)
+	}
+	watches.history = nil
+	for wt := range watches.watchers {
+		delete(watches.watchers, wt)
+		close(wt.ch)
+	}
+	log.Printf("Reloaded the object cache from storage at resourceVersion %d", watches.rv)
+}
+
+// withReplication makes a replica behave like a single server. Writes
+// reaching a follower are forwarded or redirected to the leader. Reads wait
+// until this replica has applied every write committed before they arrived,
+// so they never see older state than a previous request did, wherever it
+// was served. Watches follow the replica's log as it is applied.
+func withReplication(next http.Handler) http.Handler {
+	if replication == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health", metricsPath, replicationPath, caBundlePath:
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch r.Method {
+		case "OPTIONS":
+		case "GET", "HEAD":
+			if !isWatchRequest(r) {
+				if err := replication.readBarrier(r.Context()); err != nil {
+					w.Header().Set("Retry-After", "1")
+					writeError(w, fmt.Sprintf("Replica cannot serve a consistent read: %v", err), http.StatusServiceUnavailable)
+					return
+				}
+			}
+		default:
+			if replication.ready() {
+				break
+			}
+			leader, leaderURL := replication.currentLeader()
+			if leader == "" || leader == replication.id {
+				w.Header().Set("Retry-After", "1")
+				writeError(w, "No leader elected yet; retry shortly", http.StatusServiceUnavailable)
+				return
+			}
+			if replicaWriteMode == writeModeRedirect || r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+				http.Redirect(w, r, leaderURL+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+				return
+			}
+			forwardToLeader(w, r, leader, leaderURL)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// peerTransport is how a replica reaches the others. With TLS it verifies
+// them against the CA bundle this server hands out: replicas share the
+// serving CA.
+func peerTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if servingCerts != nil {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pool.AppendCertsFromPEM(servingCerts.bundle())
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return transport
+}
+
+var (
+	leaderTransportOnce sync.Once
+	leaderTransport     http.RoundTripper
+)
+
+// forwardToLeader proxies a write to the leader, which authenticates it
+// again from its headers.
+func forwardToLeader(w http.ResponseWriter, r *http.Request, leader, leaderURL string) {
+	target, err := url.Parse(leaderURL)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Leader %s advertises an invalid URL: %v", leader, err), http.StatusServiceUnavailable)
+		return
+	}
+	leaderTransportOnce.Do(func() { leaderTransport = peerTransport() })
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+		},
+		Transport: leaderTransport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			w.Header().Set("Retry-After", "1")
+			writeError(w, fmt.Sprintf("Forwarding to leader %s: %v", leader, err), http.StatusServiceUnavailable)
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// replicationStatus is a replica's view of the cluster.
+type replicationStatus struct {
+	ID              string                       `json:"id"`
+	Role            string                       `json:"role"`
+	Term            uint64                       `json:"term"`
+	Leader          string                       `json:"leader,omitempty"`
+	LeaderURL       string                       `json:"leaderURL,omitempty"`
+	CommitIndex     uint64                       `json:"commitIndex"`
+	AppliedIndex    uint64                       `json:"appliedIndex"`
+	LastLogIndex    uint64                       `json:"lastLogIndex"`
+	SnapshotIndex   uint64                       `json:"snapshotIndex"`
+	ResourceVersion uint64                       `json:"resourceVersion"`
+	Peers           map[string]replicaPeerStatus `json:"peers"`
+}
+
+type replicaPeerStatus struct {
+	Address string `json:"address"`
+	// MatchIndex is how much of the log the peer has stored, as far as the
+	// leader knows; followers leave it out.
+	MatchIndex *uint64 `json:"matchIndex,omitempty"`
+}
+
+func handleReplication(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, replication.status())
+}
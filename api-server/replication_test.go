@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// followerOf makes this server a follower of a leader serving its API at
+// leaderURL, for the rest of the test.
+func followerOf(t *testing.T, leaderURL string) {
+	t.Helper()
+	n, err := newRaftNode("r2", "", map[string]string{"r1": ""}, []byte("test key"), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaderURL != "" {
+		n.leader, n.leaderURL = "r1", leaderURL
+	}
+	replication = n
+	t.Cleanup(func() {
+		replication, replicaWriteMode = nil, writeModeForward
+	})
+}
+
+func TestFollowerForwardsWrites(t *testing.T) {
+	var got string
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = r.Method + " " + r.URL.RequestURI() + " " + string(body) + " " + r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "created by the leader")
+	}))
+	defer leader.Close()
+	followerOf(t, leader.URL)
+	handler := withReplication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("follower served %s %s itself", r.Method, r.URL)
+	}))
+
+	req := httptest.NewRequest("POST", "/api/v1/namespaces/default/pods?dryRun=All", strings.NewReader(`{"kind":"Pod"}`))
+	req.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated || w.Body.String() != "created by the leader" {
+		t.Fatalf("forwarded write: got %d: %s", w.Code, w.Body)
+	}
+	if want := `POST /api/v1/namespaces/default/pods?dryRun=All {"kind":"Pod"} Bearer token`; got != want {
+		t.Errorf("leader received %q, want %q", got, want)
+	}
+}
+
+func TestFollowerRedirectsWrites(t *testing.T) {
+	followerOf(t, "https://leader:8080")
+	replicaWriteMode = writeModeRedirect
+	handler := withReplication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("follower served %s %s itself", r.Method, r.URL)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/nodes/worker-1?gracePeriodSeconds=0", nil))
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("redirected write: got %d: %s", w.Code, w.Body)
+	}
+	if loc, want := w.Header().Get("Location"), "https://leader:8080/api/v1/nodes/worker-1?gracePeriodSeconds=0"; loc != want {
+		t.Errorf("redirected to %q, want %q", loc, want)
+	}
+}
+
+func TestFollowerWithoutLeaderRefusesWrites(t *testing.T) {
+	followerOf(t, "")
+	served := false
+	handler := withReplication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("PUT", "/api/v1/nodes/worker-1", strings.NewReader(`{}`)))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("write without a leader: got %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if served {
+		t.Error("follower served a write itself")
+	}
+
+	// Health checks are always answered by the replica itself.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	if !served {
+		t.Error("follower did not serve /health")
+	}
+}
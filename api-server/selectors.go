@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// labelRequirement is one comma-separated term of a label selector, e.g.
+// "tier=web", "env!=prod", "zone in (a,b)", "gpu" or "!spot".
+type labelRequirement struct {
+	key    string
+	op     string
+	values []string
+}
+
+type labelSelector []labelRequirement
+
+// fieldRequirement is one term of a field selector such as
+// "status.phase=Running" or "spec.nodeName!=abc".
+type fieldRequirement struct {
+	field  string
+	value  string
+	negate bool
+}
+
+type fieldSelector []fieldRequirement
+
+// listOptions carries the selector and paging parameters of a list or watch
+// request.
+type listOptions struct {
+	labels   labelSelector
+	fields   fieldSelector
+	limit    int
+	startKey string
+}
+
+var (
+	nodeFieldNames       = []string{"metadata.name", "status.healthStatus"}
+	podFieldNames        = []string{"metadata.name", "metadata.namespace", "spec.nodeName", "status.phase"}
+	deploymentFieldNames = []string{"metadata.name", "metadata.namespace"}
+)
+
+func nodeFields(node *Node) map[string]string {
+	return map[string]string{
+		"metadata.name":       node.Name,
+		"status.healthStatus": node.HealthStatus,
+	}
+}
+
+func podFields(pod *Pod) map[string]string {
+	return map[string]string{
+		"metadata.name":      pod.Name,
+		"metadata.namespace": pod.Namespace,
+		"spec.nodeName":      pod.NodeID,
+		"status.phase":       pod.Status,
+	}
+}
+
+func deploymentFields(d *Deployment) map[string]string {
+	return map[string]string{
+		"metadata.name":      d.Name,
+		"metadata.namespace": d.Namespace,
+	}
+}
+
+// splitSelector splits on commas that are not inside a parenthesised set.
+func splitSelector(s string) []string {
+	var terms []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(terms, s[start:])
+}
+
+func parseLabelSelector(s string) (labelSelector, error) {
+	var sel labelSelector
+	if strings.TrimSpace(s) == "" {
+		return sel, nil
+	}
+	for _, term := range splitSelector(s) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			return nil, fmt.Errorf("empty term in label selector %q", s)
+		}
+
+		if open := strings.Index(term, "("); open >= 0 {
+			head := strings.Fields(term[:open])
+			if len(head) != 2 || (head[1] != "in" && head[1] != "notin") || !strings.HasSuffix(term, ")") {
+				return nil, fmt.Errorf("invalid set-based requirement %q", term)
+			}
+			var values []string
+			for _, v := range strings.Split(term[open+1:len(term)-1], ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					values = append(values, v)
+				}
+			}
+			sel = append(sel, labelRequirement{key: head[0], op: head[1], values: values})
+			continue
+		}
+
+		var req labelRequirement
+		switch {
+		case strings.HasPrefix(term, "!"):
+			req = labelRequirement{key: strings.TrimSpace(term[1:]), op: "!"}
+		case strings.Contains(term, "!="):
+			kv := strings.SplitN(term, "!=", 2)
+			req = labelRequirement{key: strings.TrimSpace(kv[0]), op: "!=", values: []string{strings.TrimSpace(kv[1])}}
+		case strings.Contains(term, "=="):
+			kv := strings.SplitN(term, "==", 2)
+			req = labelRequirement{key: strings.TrimSpace(kv[0]), op: "=", values: []string{strings.TrimSpace(kv[1])}}
+		case strings.Contains(term, "="):
+			kv := strings.SplitN(term, "=", 2)
+			req = labelRequirement{key: strings.TrimSpace(kv[0]), op: "=", values: []string{strings.TrimSpace(kv[1])}}
+		default:
+			req = labelRequirement{key: term, op: "exists"}
+		}
+		if req.key == "" || strings.ContainsAny(req.key, " ()") {
+			return nil, fmt.Errorf("invalid label key in %q", term)
+		}
+		sel = append(sel, req)
+	}
+	return sel, nil
+}
+
+func (sel labelSelector) matches(labels map[string]string) bool {
+	for _, req := range sel {
+		value, has := labels[req.key]
+		switch req.op {
+		case "=":
+			if !has || value != req.values[0] {
+				return false
+			}
+		case "!=":
+			if has && value == req.values[0] {
+				return false
+			}
+		case "in":
+			if !has || !containsString(req.values, value) {
+				return false
+			}
+		case "notin":
+			if has && containsString(req.values, value) {
+				return false
+			}
+		case "exists":
+			if !has {
+				return false
+			}
+		case "!":
+			if has {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func parseFieldSelector(s string, supported []string) (fieldSelector, error) {
+	var sel fieldSelector
+	if strings.TrimSpace(s) == "" {
+		return sel, nil
+	}
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		var req fieldRequirement
+		switch {
+		case strings.Contains(term, "!="):
+			kv := strings.SplitN(term, "!=", 2)
+			req = fieldRequirement{field: kv[0], value: kv[1], negate: true}
+		case strings.Contains(term, "=="):
+			kv := strings.SplitN(term, "==", 2)
+			req = fieldRequirement{field: kv[0], value: kv[1]}
+		case strings.Contains(term, "="):
+			kv := strings.SplitN(term, "=", 2)
+			req = fieldRequirement{field: kv[0], value: kv[1]}
+		default:
+			return nil, fmt.Errorf("invalid field selector term %q", term)
+		}
+		req.field = strings.TrimSpace(req.field)
+		req.value = strings.TrimSpace(req.value)
+		if !containsString(supported, req.field) {
+			return nil, fmt.Errorf("field label %q not supported, use one of %s", req.field, strings.Join(supported, ", "))
+		}
+		sel = append(sel, req)
+	}
+	return sel, nil
+}
+
+func (sel fieldSelector) matches(fields map[string]string) bool {
+	for _, req := range sel {
+		if (fields[req.field] == req.value) == req.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// parseListOptions reads labelSelector, fieldSelector, limit and continue
+// from the query string. fieldNames lists the fields the resource supports.
+func parseListOptions(r *http.Request, fieldNames []string) (listOptions, error) {
+	q := r.URL.Query()
+	var opts listOptions
+	var err error
+
+	if opts.labels, err = parseLabelSelector(q.Get("labelSelector")); err != nil {
+		return opts, err
+	}
+	if opts.fields, err = parseFieldSelector(q.Get("fieldSelector"), fieldNames); err != nil {
+		return opts, err
+	}
+	if s := q.Get("limit"); s != "" {
+		if opts.limit, err = strconv.Atoi(s); err != nil || opts.limit < 0 {
+			return opts, fmt.Errorf("invalid limit %q", s)
+		}
+	}
+	if token := q.Get("continue"); token != "" {
+		key, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil || len(key) == 0 {
+			return opts, fmt.Errorf("invalid continue token")
+		}
+		opts.startKey = string(key)
+	}
+	return opts, nil
+}
+
+func (o listOptions) matches(labels, fields map[string]string) bool {
+	return o.labels.matches(labels) && o.fields.matches(fields)
+}
+
+// page sorts ids, drops everything up to and including the continue key and
+// the ids that do not match, and cuts the result at limit. The returned token
+// is empty once the last page has been served.
+func (o listOptions) page(ids []string, match func(id string) bool) ([]string, string) {
+	sort.Strings(ids)
+	var selected []string
+	for _, id := range ids {
+		if o.startKey != "" && id <= o.startKey {
+			continue
+		}
+		if !match(id) {
+			continue
+		}
+		if o.limit > 0 && len(selected) == o.limit {
+			return selected, base64.RawURLEncoding.EncodeToString([]byte(selected[len(selected)-1]))
+		}
+		selected = append(selected, id)
+	}
+	return selected, ""
+}
+
+// validateLabels rejects keys and values that could not be expressed in a
+// selector.
+func validateLabels(labels map[string]string) error {
+	for k, v := range labels {
+		if k == "" || strings.ContainsAny(k, " ,=!()") {
+			return fmt.Errorf("invalid label key %q", k)
+		}
+		if strings.ContainsAny(v, " ,=!()") {
+			return fmt.Errorf("invalid value %q for label %q", v, k)
+		}
+	}
+	return nil
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	c := make(map[string]string, len(labels))
+	for k, v := range labels {
+		c[k] = v
+	}
+	return c
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestLabelSelectorMatches(t *testing.T) {
+	labels := map[string]string{"tier": "web", "env": "prod", "zone": "a"}
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"", true},
+		{"tier=web", true},
+		{"tier==web", true},
+		{"tier=db", false},
+		{"env!=dev", true},
+		{"env!=prod", false},
+		{"missing!=x", true},
+		{"zone in (a,b)", true},
+		{"zone in (b, c)", false},
+		{"missing in (a)", false},
+		{"zone notin (b,c)", true},
+		{"zone notin (a)", false},
+		{"missing notin (a)", true},
+		{"tier", true},
+		{"missing", false},
+		{"!missing", true},
+		{"!tier", false},
+		{"tier=web,zone in (a,b),!spot", true},
+		{"tier=web,zone notin (a)", false},
+	}
+	for _, tt := range tests {
+		sel, err := parseLabelSelector(tt.selector)
+		if err != nil {
+			t.Errorf("parseLabelSelector(%q): %v", tt.selector, err)
+			continue
+		}
+		if got := sel.matches(labels); got != tt.want {
+			t.Errorf("%q matches %v = %v, want %v", tt.selector, labels, got, tt.want)
+		}
+	}
+}
+
+func TestParseLabelSelectorRequirements(t *testing.T) {
+	sel, err := parseLabelSelector("zone in (a, b),env notin (dev),!spot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := labelSelector{
+		{key: "zone", op: "in", values: []string{"a", "b"}},
+		{key: "env", op: "notin", values: []string{"dev"}},
+		{key: "spot", op: "!"},
+	}
+	if !reflect.DeepEqual(sel, want) {
+		t.Errorf("got %+v, want %+v", sel, want)
+	}
+}
+
+func TestMalformedSelectors(t *testing.T) {
+	tests := []struct {
+		name  string
+		query url.Values
+	}{
+		{"empty label term", url.Values{"labelSelector": {"tier=web,,env=prod"}}},
+		{"set without operator", url.Values{"labelSelector": {"zone (a,b)"}}},
+		{"unknown set operator", url.Values{"labelSelector": {"zone within (a,b)"}}},
+		{"unclosed set", url.Values{"labelSelector": {"zone in (a,b"}}},
+		{"missing key", url.Values{"labelSelector": {"=web"}}},
+		{"bare negation", url.Values{"labelSelector": {"!"}}},
+		{"field term without operator", url.Values{"fieldSelector": {"metadata.name"}}},
+		{"unsupported field", url.Values{"fieldSelector": {"spec.cpuCores=4"}}},
+		{"negative limit", url.Values{"limit": {"-1"}}},
+		{"non-numeric limit", url.Values{"limit": {"ten"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleV1Nodes(w, httptest.NewRequest("GET", "/api/v1/nodes?"+tt.query.Encode(), nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("got %d, want 400: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestContinueTokens(t *testing.T) {
+	ids := []string{"c", "a", "e", "b", "d"}
+	opts := listOptions{limit: 2}
+	var pages [][]string
+	for {
+		page, next := opts.page(append([]string{}, ids...), func(string) bool { return true })
+		pages = append(pages, page)
+		if next == "" {
+			break
+		}
+		r := httptest.NewRequest("GET", "/api/v1/nodes?limit=2&continue="+url.QueryEscape(next), nil)
+		var err error
+		if opts, err = parseListOptions(r, nodeFieldNames); err != nil {
+			t.Fatalf("continue token %q from the previous page: %v", next, err)
+		}
+	}
+	want := [][]string{{"a", "b"}, {"c", "d"}, {"e"}}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("pages = %v, want %v", pages, want)
+	}
+
+	for _, token := range []string{
+		"not*base64!",
+		base64.StdEncoding.EncodeToString([]byte("b")) + "==",
+		base64.RawURLEncoding.EncodeToString(nil) + "%",
+	} {
+		w := httptest.NewRecorder()
+		handleV1Nodes(w, httptest.NewRequest("GET", "/api/v1/nodes?continue="+url.QueryEscape(token), nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("tampered continue token %q: got %d, want 400", token, w.Code)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "example.com/m/api/v1"
+)
+
+const (
+	snapshotPath = "/admin/snapshot"
+	restorePath  = "/admin/restore"
+)
+
+// takeSnapshot reads every stored object at one revision. Storage lists
+// atomically, so the snapshot is consistent without stopping writes.
+func takeSnapshot() (*v1.ClusterSnapshot, error) {
+	kvs, rev, err := store.List("/")
+	if err != nil {
+		return nil, err
+	}
+	snap := &v1.ClusterSnapshot{
+		TypeMeta:          typeMeta("ClusterSnapshot"),
+		ResourceVersion:   rev,
+		CreationTimestamp: time.Now(),
+		// The algorithm is only stored once it has been changed.
+		SchedulerConfig: v1.SchedulerConfig{Algorithm: "first-fit"},
+		Objects:         []v1.SnapshotObject{},
+	}
+	for _, kv := range kvs {
+		kind, key, _ := strings.Cut(strings.TrimPrefix(kv.Key, "/"), "/")
+		if kind == schedulerConfigKind {
+			if err := json.Unmarshal(kv.Value, &snap.SchedulerConfig); err != nil {
+				return nil, fmt.Errorf("decoding %s: %v", kv.Key, err)
+			}
+			continue
+		}
+		snap.Objects = append(snap.Objects, v1.SnapshotObject{Kind: kind, Key: key, Object: kv.Value})
+	}
+	return snap, nil
+}
+
+// restorableKey decodes a snapshot object as its kind and returns the key it
+// is stored under, so that a snapshot that would not load is refused before
+// anything changes. Leases are not restored: their holders keep renewing the
+// current ones.
+func restorableKey(obj v1.SnapshotObject) (string, bool, error) {
+	var decoded interface{}
+	var err error
+	switch obj.Kind {
+	case "Lease":
+		return "", false, nil
+	case "Node":
+		var n Node
+		err, decoded = json.Unmarshal(obj.Object, &n), n
+	case "Pod":
+		var p Pod
+		err, decoded = json.Unmarshal(obj.Object, &p), p
+	case "Deployment":
+		var d Deployment
+		err, decoded = json.Unmarshal(obj.Object, &d), d
+	case "Event":
+		var e Event
+		err, decoded = json.Unmarshal(obj.Object, &e), e
+	default:
+		res, ok := rbacResources[obj.Kind]
+		if !ok {
+			return "", false, invalidError("snapshot object %s/%s has unknown kind", obj.Kind, obj.Key)
+		}
+		o := res.newObject()
+		err, decoded = json.Unmarshal(obj.Object, o), o
+	}
+	if err != nil {
+		return "", false, invalidError("decoding snapshot object %s/%s: %v", obj.Kind, obj.Key, err)
+	}
+	if key := storeKey(obj.Kind, decoded); key != obj.Key {
+		return "", false, invalidError("snapshot object %s/%s is stored under key %q", obj.Kind, obj.Key, key)
+	}
+	return obj.Key, true, nil
+}
+
+// setResourceVersion stamps a cached object. Callers must hold the store
+// locks.
+func setResourceVersion(kind, key string, rv uint64) {
+	switch kind {
+	case "Node":
+		nodes[key].ResourceVersion = rv
+	case "Pod":
+		pods[key].ResourceVersion = rv
+	case "Deployment":
+		deployments[key].ResourceVersion = rv
+	case "Event":
+		events[key].ResourceVersion = rv
+	case "Lease":
+		leases[key].Metadata.ResourceVersion = rv
+	default:
+		if res, ok := rbacResources[kind]; ok {
+			res.objects[key].GetObjectMeta().ResourceVersion = rv
+		}
+	}
+}
+
+// restoreSnapshot replaces every object but the leases with those in snap,
+// in one transaction: watchers see the differences as ordinary events, and
+// replicas apply it like any other write. Restored objects get the
+// transaction's resourceVersion. Restored nodes are Unknown until they send
+// a heartbeat; with RestoreNodesRelaunch their agents are started afresh.
+func restoreSnapshot(snap *v1.ClusterSnapshot, nodeMode string) (*v1.RestoreResult, error) {
+	if !validAlgorithms[snap.SchedulerConfig.Algorithm] {
+		return nil, invalidError("snapshot has unknown scheduling algorithm %q", snap.SchedulerConfig.Algorithm)
+	}
+	restore := make(map[string]v1.SnapshotObject, len(snap.Objects))
+	for _, obj := range snap.Objects {
+		key, ok, err := restorableKey(obj)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			restore[obj.Kind+"/"+key] = obj
+		}
+	}
+
+	result := &v1.RestoreResult{TypeMeta: typeMeta("RestoreResult"), SnapshotResourceVersion: snap.ResourceVersion}
+	var restoredNodes []Node
+	var removedNodes []string
+	err := func() error {
+		lockStores()
+		defer unlockStores()
+
+		kvs, _, err := store.List("/")
+		if err != nil {
+			return err
+		}
+		previous := make(map[string]interface{})
+		for _, kv := range kvs {
+			kind, key, _ := strings.Cut(strings.TrimPrefix(kv.Key, "/"), "/")
+			if kind == "Lease" || kind == schedulerConfigKind {
+				continue
+			}
+			if obj, ok := cachedObject(kind, key); ok {
+				previous[kind+"/"+key] = obj
+			}
+		}
+
+		clearStores()
+		for _, obj := range restore {
+			if err := decodeIntoStores(KeyValue{Key: "/" + obj.Kind + "/" + obj.Key, Value: obj.Object}); err != nil {
+				// Checked by restorableKey; the cache is rebuilt from storage.
+				go resyncStores()
+				return err
+			}
+		}
+		scheduler.Algorithm = snap.SchedulerConfig.Algorithm
+		now := time.Now()
+		for _, node := range nodes {
+			if node.HealthStatus == "Stopped" || node.HealthStatus == "Failed" {
+				continue
+			}
+			node.HealthStatus = nodeStatusUnknown
+			node.LastHeartbeat = now
+			restoredNodes = append(restoredNodes, copyNode(node))
+		}
+
+		changes := []objectChange{{kind: schedulerConfigKind, eventType: EventModified, stamp: func(rv uint64) interface{} {
+			return v1.SchedulerConfig{Algorithm: scheduler.Algorithm}
+		}}}
+		for id, obj := range previous {
+			if _, kept := restore[id]; kept {
+				continue
+			}
+			kind, key, _ := strings.Cut(id, "/")
+			if kind == "Node" {
+				removedNodes = append(removedNodes, key)
+			}
+			changes = append(changes, objectChange{kind: kind, eventType: EventDeleted, stamp: func(uint64) interface{} { return obj }})
+			result.Deleted++
+		}
+		for id, obj := range restore {
+			eventType := EventAdded
+			if _, existed := previous[id]; existed {
+				eventType = EventModified
+			}
+			kind, key := obj.Kind, obj.Key
+			changes = append(changes, objectChange{kind: kind, eventType: eventType, stamp: func(rv uint64) interface{} {
+				setResourceVersion(kind, key, rv)
+				cached, _ := cachedObject(kind, key)
+				return cached
+			}})
+			result.Restored++
+		}
+		rv, err := watches.commit(changes...)
+		if err != nil {
+			return fmt.Errorf("the restore did not commit; the previous state was kept: %w", err)
+		}
+		result.ResourceVersion = rv
+		return nil
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, node := range restoredNodes {
+		recordEvent(nodeRef(&node), sourceAPIServer, v1.EventTypeNormal, "NodeRestored",
+			"Restored from snapshot; waiting for a heartbeat")
+	}
+	if nodeMode == v1.RestoreNodesRelaunch {
+		for _, id := range removedNodes {
+			nodeProvider.Remove(id)
+		}
+		for _, node := range restoredNodes {
+			// An agent left from before would keep the node's name taken.
+			nodeProvider.Remove(node.ID)
+			if err := launchNodeAgent(&node); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("node %s: %v", node.Name, err))
+				continue
+			}
+			result.RelaunchedNodes = append(result.RelaunchedNodes, node.Name)
+		}
+		sort.Strings(result.RelaunchedNodes)
+	}
+	log.Printf("Restored %d objects from the snapshot at resourceVersion %d, deleted %d, at resourceVersion %d",
+		result.Restored, snap.ResourceVersion, result.Deleted, result.ResourceVersion)
+	return result, nil
+}
+
+// handleSnapshot serves a snapshot of the cluster, to save for restore.
+func handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	snap, err := takeSnapshot()
+	if err != nil {
+		writeError(w, fmt.Sprintf("Reading storage: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=kube-sim-snapshot-%d.json", snap.ResourceVersion))
+	writeJSON(w, http.StatusOK, snap)
+}
+
+// handleRestore replaces the cluster's objects with those of a snapshot.
+// ?nodes=relaunch also relaunches node agents; the default, keep, leaves
+// them alone.
+func handleRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	nodeMode := r.URL.Query().Get("nodes")
+	switch nodeMode {
+	case "":
+		nodeMode = v1.RestoreNodesKeep
+	case v1.RestoreNodesKeep, v1.RestoreNodesRelaunch:
+	default:
+		writeError(w, fmt.Sprintf("nodes must be %s or %s", v1.RestoreNodesKeep, v1.RestoreNodesRelaunch), http.StatusBadRequest)
+		return
+	}
+	var snap v1.ClusterSnapshot
+	if !decodeBody(w, r, &snap) {
+		return
+	}
+	if snap.Kind != "ClusterSnapshot" {
+		writeError(w, fmt.Sprintf("expected a ClusterSnapshot, got kind %q", snap.Kind), http.StatusBadRequest)
+		return
+	}
+	result, err := restoreSnapshot(&snap, nodeMode)
+	if err != nil {
+		writeErrorFor(w, err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	v1 "example.com/m/api/v1"
+)
+
+// snapshotRoundTrip takes a snapshot and passes it through JSON, as a saved
+// backup would be.
+func snapshotRoundTrip(t *testing.T) *v1.ClusterSnapshot {
+	t.Helper()
+	snap, err := takeSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved v1.ClusterSnapshot
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	return &saved
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	resetState(t)
+	algorithm := scheduler.Algorithm
+	t.Cleanup(func() { scheduler.Algorithm = algorithm })
+	scheduler.Algorithm = "best-fit"
+	if err := persistSchedulerConfig(v1.SchedulerConfig{Algorithm: "best-fit"}); err != nil {
+		t.Fatal(err)
+	}
+	registerTestNode(t, "node-0001", "worker-1", 4)
+	web, err := createPod(&Pod{Name: "web", CPURequired: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createLease(testLease("a")); err != nil {
+		t.Fatal(err)
+	}
+	snap := snapshotRoundTrip(t)
+	if snap.SchedulerConfig.Algorithm != "best-fit" {
+		t.Errorf("snapshot has algorithm %q", snap.SchedulerConfig.Algorithm)
+	}
+
+	// Change everything the snapshot covers, and the lease it does not.
+	scheduler.Algorithm = "worst-fit"
+	if err := deletePod(web.ID); err != nil {
+		t.Fatal(err)
+	}
+	registerTestNode(t, "node-0002", "worker-2", 8)
+	extra, err := createPod(&Pod{Name: "extra", CPURequired: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease := copyLease(leases[leaseKey("kube-system", "scheduler")])
+	lease.Spec.HolderIdentity = "b"
+	if _, err := updateLease(lease, lease.Metadata.ResourceVersion); err != nil {
+		t.Fatal(err)
+	}
+	before := watches.currentResourceVersion()
+
+	result, err := restoreSnapshot(snap, v1.RestoreNodesKeep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SnapshotResourceVersion != snap.ResourceVersion || result.ResourceVersion <= before || result.Deleted == 0 {
+		t.Errorf("restore result: %+v", result)
+	}
+	if scheduler.Algorithm != "best-fit" {
+		t.Errorf("algorithm after the restore: %s", scheduler.Algorithm)
+	}
+	if _, ok := pods[extra.ID]; ok {
+		t.Error("a pod created after the snapshot survived")
+	}
+	restored, ok := pods[web.ID]
+	if !ok || restored.NodeID != "node-0001" || restored.ResourceVersion != result.ResourceVersion {
+		t.Fatalf("restored pod: %+v", restored)
+	}
+	if _, ok := nodes["node-0002"]; ok {
+		t.Error("a node added after the snapshot survived")
+	}
+	node := nodes["node-0001"]
+	if node.HealthStatus != nodeStatusUnknown || node.AvailableCPU != 2 || len(node.Pods) != 1 {
+		t.Errorf("restored node: %s, %d CPU free, pods %v", node.HealthStatus, node.AvailableCPU, node.Pods)
+	}
+	if l := leases[leaseKey("kube-system", "scheduler")]; l.Spec.HolderIdentity != "b" {
+		t.Errorf("the lease was restored to holder %q", l.Spec.HolderIdentity)
+	}
+	// Watchers see the restore as one change per object.
+	if commitsOf("Pod", before) != 2 || commitsOf("Node", before) != 2 {
+		t.Errorf("restore published %d pod and %d node changes", commitsOf("Pod", before), commitsOf("Node", before))
+	}
+
+	// The restored state is what storage holds too, beside the events the
+	// restore recorded.
+	objects := func(s *v1.ClusterSnapshot) map[string]bool {
+		keys := make(map[string]bool)
+		for _, obj := range s.Objects {
+			if obj.Kind != "Event" && obj.Kind != "Lease" {
+				keys[obj.Kind+"/"+obj.Key] = true
+			}
+		}
+		return keys
+	}
+	if got, want := objects(snapshotRoundTrip(t)), objects(snap); len(got) != len(want) || !got["Pod/"+web.ID] || !got["Node/node-0001"] {
+		t.Errorf("storage after the restore holds %v, want %v", got, want)
+	}
+}
+
+func TestRestoreRefusesBadSnapshots(t *testing.T) {
+	resetState(t)
+	registerTestNode(t, "node-0001", "worker-1", 4)
+	snap := snapshotRoundTrip(t)
+	node, _ := json.Marshal(nodes["node-0001"])
+
+	for name, bad := range map[string]func(s *v1.ClusterSnapshot){
+		"an unknown algorithm": func(s *v1.ClusterSnapshot) { s.SchedulerConfig.Algorithm = "random" },
+		"an unknown kind": func(s *v1.ClusterSnapshot) {
+			s.Objects = append(s.Objects, v1.SnapshotObject{Kind: "Widget", Key: "w", Object: json.RawMessage(`{}`)})
+		},
+		"an object under another key": func(s *v1.ClusterSnapshot) {
+			s.Objects = append(s.Objects, v1.SnapshotObject{Kind: "Node", Key: "node-0002", Object: node})
+		},
+		"an undecodable object": func(s *v1.ClusterSnapshot) {
+			s.Objects = append(s.Objects, v1.SnapshotObject{Kind: "Pod", Key: "p", Object: json.RawMessage(`[]`)})
+		},
+	} {
+		s := *snap
+		s.Objects = append([]v1.SnapshotObject(nil), snap.Objects...)
+		bad(&s)
+		if _, err := restoreSnapshot(&s, v1.RestoreNodesKeep); !errors.Is(err, errInvalid) {
+			t.Errorf("snapshot with %s: got %v", name, err)
+		}
+	}
+	if _, ok := nodes["node-0001"]; !ok || len(nodes) != 1 {
+		t.Error("a refused restore changed the cluster")
+	}
+}
+
+func TestHandleRestore(t *testing.T) {
+	resetState(t)
+	for _, tc := range []struct {
+		query, body string
+		want        int
+	}{
+		{"?nodes=sometimes", `{"kind":"ClusterSnapshot"}`, http.StatusBadRequest},
+		{"", `{"kind":"Pod"}`, http.StatusBadRequest},
+		{"", `{"kind":"ClusterSnapshot","schedulerConfig":{"algorithm":"random"}}`, http.StatusUnprocessableEntity},
+		{"?nodes=keep", `{"kind":"ClusterSnapshot","schedulerConfig":{"algorithm":"first-fit"},"objects":[]}`, http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		handleRestore(w, httptest.NewRequest("POST", restorePath+tc.query, strings.NewReader(tc.body)))
+		if w.Code != tc.want {
+			t.Errorf("restore%s of %s: got %d, want %d: %s", tc.query, tc.body, w.Code, tc.want, w.Body)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	v1 "example.com/m/api/v1"
+)
+
+// writeError answers with a failed v1.Status whose reason follows from code.
+// Every handler, versioned or legacy, reports errors this way.
+func writeError(w http.ResponseWriter, message string, code int) {
+	writeStatus(w, code, v1.ReasonForCode(code), message)
+}
+
+func writeStatus(w http.ResponseWriter, code int, reason v1.StatusReason, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v1.Status{
+		TypeMeta: v1.TypeMeta{APIVersion: v1.APIVersion, Kind: "Status"},
+		Status:   v1.StatusFailure,
+		Message:  message,
+		Reason:   reason,
+		Code:     code,
+	})
+}
+
+// writeErrorFor reports err, using the status its sentinel or admission
+// rejection implies and code for anything else.
+func writeErrorFor(w http.ResponseWriter, err error, code int) {
+	var denied *admissionError
+	switch {
+	case errors.As(err, &denied):
+		writeStatus(w, denied.code, denied.reason, err.Error())
+	case errors.Is(err, errInvalid):
+		writeStatus(w, http.StatusUnprocessableEntity, v1.StatusReasonInvalid, err.Error())
+	case errors.Is(err, errNotFound):
+		writeStatus(w, http.StatusNotFound, v1.StatusReasonNotFound, err.Error())
+	case errors.Is(err, errNameTaken):
+		writeStatus(w, http.StatusConflict, v1.StatusReasonAlreadyExists, err.Error())
+	case errors.Is(err, errConflict), errors.Is(err, errNodeHasPods):
+		writeStatus(w, http.StatusConflict, v1.StatusReasonConflict, err.Error())
+	case errors.Is(err, errForbidden):
+		writeStatus(w, http.StatusForbidden, v1.StatusReasonForbidden, err.Error())
+	case errors.Is(err, errNodeStopped):
+		writeError(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errNotCommitted):
+		w.Header().Set("Retry-After", "1")
+		writeStatus(w, http.StatusServiceUnavailable, v1.StatusReasonServiceUnavailable, err.Error())
+	default:
+		writeError(w, err.Error(), code)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// statusError carries its own message while still matching a sentinel with
+// errors.Is.
+type statusError struct {
+	sentinel error
+	message  string
+}
+
+func (e *statusError) Error() string { return e.message }
+func (e *statusError) Unwrap() error { return e.sentinel }
+
+// invalidError reports a request that fails validation; it answers 422.
+func invalidError(format string, args ...interface{}) error {
+	return &statusError{sentinel: errInvalid, message: fmt.Sprintf(format, args...)}
+}
+
+// forbiddenError reports a request the caller is not allowed to make; it
+// answers 403.
+func forbiddenError(format string, args ...interface{}) error {
+	return &statusError{sentinel: errForbidden, message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Storage backends, chosen with -storage-backend.
+const (
+	storageMemory = "memory"
+	storageFile   = "file"
+	storageBTree  = "btree"
+)
+
+// storageHistorySize is how many committed changes a Storage keeps for
+// watches resuming from an earlier revision.
+const storageHistorySize = 1000
+
+// Storage is the system of record: a key-value store whose every change,
+// single or in a transaction, gets the next cluster-wide revision. Values are
+// opaque bytes; the API server stores JSON-encoded objects under
+// /<kind>/<key>.
+type Storage interface {
+	// Get returns the value under key, or errNotFound.
+	Get(key string) (KeyValue, error)
+	// List returns every value whose key starts with prefix, in key order,
+	// and the revision they were read at.
+	List(prefix string) ([]KeyValue, uint64, error)
+	// Create stores a new key, failing with errNameTaken if it exists.
+	Create(key string, value []byte) (uint64, error)
+	// Update replaces the value under key. A non-zero expectedRevision must
+	// match the key's ModRevision, or errConflict is returned.
+	Update(key string, value []byte, expectedRevision uint64) (uint64, error)
+	// Delete removes key, checking expectedRevision like Update.
+	Delete(key string, expectedRevision uint64) (uint64, error)
+	// Txn applies ops atomically, at a single revision, if every condition
+	// holds, and fails with errConflict otherwise.
+	Txn(conditions []Condition, ops []Op) (uint64, error)
+	// Watch streams changes to keys under prefix committed after
+	// fromRevision, or from now on when it is zero.
+	Watch(prefix string, fromRevision uint64) (*StorageWatch, error)
+	// Revision is that of the latest committed change.
+	Revision() uint64
+	Close() error
+}
+
+// KeyValue is a stored value with the revisions it was created and last
+// modified at.
+type KeyValue struct {
+	Key            string `json:"key"`
+	Value          []byte `json:"value"`
+	CreateRevision uint64 `json:"createRevision"`
+	ModRevision    uint64 `json:"modRevision"`
+}
+
+// Op is one write in a transaction: a put when Value is set, otherwise a
+// delete.
+type Op struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+func (op Op) isDelete() bool { return op.Value == nil }
+
+// Condition requires Key to be at ModRevision, or to be absent when
+// ModRevision is zero.
+type Condition struct {
+	Key         string
+	ModRevision uint64
+}
+
+// StorageEvent is a committed change to one key. Deletions carry the last
+// value in PrevValue.
+type StorageEvent struct {
+	Type      string
+	KeyValue  KeyValue
+	PrevValue []byte
+}
+
+type StorageWatch struct {
+	Events <-chan StorageEvent
+	stop   func()
+}
+
+// Stop ends the watch and closes Events.
+func (w *StorageWatch) Stop() { w.stop() }
+
+// kvEngine is what a backend implements: ordered lookups, and applying a
+// transaction's resolved writes durably before they become visible.
+type kvEngine interface {
+	get(key string) (KeyValue, bool)
+	// scan calls fn for every key under prefix in key order.
+	scan(prefix string, fn func(KeyValue))
+	// revision is the revision the engine was opened at.
+	revision() uint64
+	// apply makes writes, all at rev, durable. Deletes carry no value.
+	apply(rev uint64, writes []KeyValue) error
+	close() error
+}
+
+// kvStore implements Storage on top of an engine: revisions, conditions and
+// watches are the same for every backend.
+type kvStore struct {
+	engine kvEngine
+
+	mu       sync.RWMutex
+	rev      uint64
+	history  []StorageEvent
+	watchers map[*storageWatcher]struct{}
+	// compacted is the newest revision no longer in history.
+	compacted uint64
+}
+
+type storageWatcher struct {
+	prefix string
+	ch     chan StorageEvent
+}
+
+func newKVStore(engine kvEngine) *kvStore {
+	rev := engine.revision()
+	return &kvStore{engine: engine, rev: rev, compacted: rev, watchers: make(map[*storageWatcher]struct{})}
+}
+
+// openStorage opens the chosen backend. The file and btree backends keep
+// their data in dir.
+func openStorage(backend, dir string, snapshotEvery int) (Storage, error) {
+	var engine kvEngine
+	var err error
+	switch backend {
+	case storageMemory:
+		engine = newMemoryEngine()
+	case storageFile:
+		engine, err = openFileEngine(dir, snapshotEvery)
+	case storageBTree:
+		engine, err = openBTreeEngine(dir)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newKVStore(engine), nil
+}
+
+func (s *kvStore) Get(key string) (KeyValue, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	kv, ok := s.engine.get(key)
+	if !ok {
+		return KeyValue{}, fmt.Errorf("%w: key %s", errNotFound, key)
+	}
+	return kv, nil
+}
+
+func (s *kvStore) List(prefix string) ([]KeyValue, uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var kvs []KeyValue
+	s.engine.scan(prefix, func(kv KeyValue) { kvs = append(kvs, kv) })
+	return kvs, s.rev, nil
+}
+
+func (s *kvStore) Create(key string, value []byte) (uint64, error) {
+	rev, err := s.Txn([]Condition{{Key: key}}, []Op{{Key: key, Value: value}})
+	if errors.Is(err, errConflict) {
+		return 0, fmt.Errorf("%w: key %s", errNameTaken, key)
+	}
+	return rev, err
+}
+
+func (s *kvStore) Update(key string, value []byte, expectedRevision uint64) (uint64, error) {
+	return s.write(key, Op{Key: key, Value: value}, expectedRevision)
+}
+
+func (s *kvStore) Delete(key string, expectedRevision uint64) (uint64, error) {
+	return s.write(key, Op{Key: key}, expectedRevision)
+}
+
+// write applies op to an existing key.
+func (s *kvStore) write(key string, op Op, expectedRevision uint64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.engine.get(key)
+	if !ok {
+		return 0, fmt.Errorf("%w: key %s", errNotFound, key)
+	}
+	if expectedRevision != 0 && current.ModRevision != expectedRevision {
+		return 0, fmt.Errorf("%w: key %s is at revision %d, not %d", errConflict, key, current.ModRevision, expectedRevision)
+	}
+	return s.commitLocked([]Op{op})
+}
+
+func (s *kvStore) Txn(conditions []Condition, ops []Op) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range conditions {
+		current, ok := s.engine.get(c.Key)
+		switch {
+		case c.ModRevision == 0 && ok:
+			return 0, fmt.Errorf("%w: key %s exists", errConflict, c.Key)
+		case c.ModRevision != 0 && (!ok || current.ModRevision != c.ModRevision):
+			return 0, fmt.Errorf("%w: key %s is not at revision %d", errConflict, c.Key, c.ModRevision)
+		}
+	}
+	return s.commitLocked(ops)
+}
+
+// commitLocked applies ops at the next revision and publishes them. When a
+// key is written more than once, the last write wins. Callers must hold
+// s.mu.
+func (s *kvStore) commitLocked(ops []Op) (uint64, error) {
+	last := make(map[string]Op, len(ops))
+	var order []string
+	for _, op := range ops {
+		if _, ok := last[op.Key]; !ok {
+			order = append(order, op.Key)
+		}
+		last[op.Key] = op
+	}
+
+	rev := s.rev + 1
+	var writes []KeyValue
+	var events []StorageEvent
+	for _, key := range order {
+		op := last[key]
+		prev, existed := s.engine.get(key)
+		kv := KeyValue{Key: key, Value: op.Value, CreateRevision: rev, ModRevision: rev}
+		ev := StorageEvent{Type: EventAdded}
+		switch {
+		case op.isDelete() && !existed:
+			continue
+		case op.isDelete():
+			ev = StorageEvent{Type: EventDeleted, PrevValue: prev.Value}
+			kv.CreateRevision = prev.CreateRevision
+		case existed:
+			ev = StorageEvent{Type: EventModified, PrevValue: prev.Value}
+			kv.CreateRevision = prev.CreateRevision
+		}
+		ev.KeyValue = kv
+		writes = append(writes, kv)
+		events = append(events, ev)
+	}
+	if len(writes) == 0 {
+		return s.rev, nil
+	}
+	if err := s.engine.apply(rev, writes); err != nil {
+		return 0, err
+	}
+	s.rev = rev
+	for _, ev := range events {
+		s.publishLocked(ev)
+	}
+	return rev, nil
+}
+
+func (s *kvStore) publishLocked(ev StorageEvent) {
+	s.history = append(s.history, ev)
+	if len(s.history) > storageHistorySize {
+		s.compacted = s.history[0].KeyValue.ModRevision
+		s.history = s.history[1:]
+	}
+	for w := range s.watchers {
+		if !strings.HasPrefix(ev.KeyValue.Key, w.prefix) {
+			continue
+		}
+		select {
+		case w.ch <- ev:
+		default:
+			// Slow consumer: drop it, it resumes from its last revision.
+			delete(s.watchers, w)
+			close(w.ch)
+		}
+	}
+}
+
+func (s *kvStore) Watch(prefix string, fromRevision uint64) (*StorageWatch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var backlog []StorageEvent
+	if fromRevision != 0 {
+		if fromRevision < s.compacted {
+			return nil, errResourceVersionTooOld
+		}
+		for _, ev := range s.history {
+			if ev.KeyValue.ModRevision > fromRevision && strings.HasPrefix(ev.KeyValue.Key, prefix) {
+				backlog = append(backlog, ev)
+			}
+		}
+	}
+	w := &storageWatcher{prefix: prefix, ch: make(chan StorageEvent, len(backlog)+watchChannelSize)}
+	for _, ev := range backlog {
+		w.ch <- ev
+	}
+	s.watchers[w] = struct{}{}
+	return &StorageWatch{Events: w.ch, stop: func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.watchers[w]; ok {
+			delete(s.watchers, w)
+			close(w.ch)
+		}
+	}}, nil
+}
+
+func (s *kvStore) Revision() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rev
+}
+
+func (s *kvStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for w := range s.watchers {
+		delete(s.watchers, w)
+		close(w.ch)
+	}
+	return s.engine.close()
+}
+
+// restore replaces the whole state with kvs at rev, as when a replica
+// installs a snapshot. Watches are ended and cannot resume from before it.
+func (s *kvStore) restore(kvs []KeyValue, rev uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.engine = newMemoryEngineAt(kvs, rev)
+	s.rev = rev
+	s.history = nil
+	s.compacted = rev
+	for w := range s.watchers {
+		delete(s.watchers, w)
+		close(w.ch)
+	}
+}
+
+// memoryEngine keeps everything in a map; nothing survives a restart.
+type memoryEngine struct {
+	kvs      map[string]KeyValue
+	openedAt uint64
+}
+
+func newMemoryEngine() *memoryEngine {
+	return &memoryEngine{kvs: make(map[string]KeyValue)}
+}
+
+// newMemoryEngineAt starts from kvs, a snapshot taken at rev.
+func newMemoryEngineAt(kvs []KeyValue, rev uint64) *memoryEngine {
+	e := &memoryEngine{kvs: make(map[string]KeyValue, len(kvs)), openedAt: rev}
+	for _, kv := range kvs {
+		e.kvs[kv.Key] = kv
+	}
+	return e
+}
+
+func (e *memoryEngine) get(key string) (KeyValue, bool) {
+	kv, ok := e.kvs[key]
+	return kv, ok
+}
+
+func (e *memoryEngine) scan(prefix string, fn func(KeyValue)) {
+	keys := make([]string, 0, len(e.kvs))
+	for key := range e.kvs {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fn(e.kvs[key])
+	}
+}
+
+func (e *memoryEngine) revision() uint64 { return e.openedAt }
+
+func (e *memoryEngine) apply(rev uint64, writes []KeyValue) error {
+	for _, kv := range writes {
+		if kv.Value == nil {
+			delete(e.kvs, kv.Key)
+		} else {
+			e.kvs[kv.Key] = kv
+		}
+	}
+	return nil
+}
+
+func (e *memoryEngine) close() error { return nil }
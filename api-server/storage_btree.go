@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	btreeFileName = "state.db"
+	btreeMagic    = "KSIMBT1\n"
+	// btreeMaxEntries is how many entries or children a page holds before
+	// it splits.
+	btreeMaxEntries = 64
+	// A file at least btreeCompactMinSize bytes, of which less than one in
+	// btreeCompactRatio is live, is rewritten with only its live pages.
+	btreeCompactMinSize = 1 << 20
+	btreeCompactRatio   = 4
+)
+
+// Record types in the file.
+const (
+	btreeLeaf   = 'L'
+	btreeBranch = 'B'
+	btreeMeta   = 'M'
+)
+
+// btreeEngine is an embedded copy-on-write B+tree in a single append-only
+// file. A commit appends the pages it changed, children before parents, and
+// then a meta record naming the new root and revision; pages are never
+// overwritten, so the last complete meta record always describes a
+// consistent tree and anything after it is an unfinished commit. When most
+// of the file is superseded pages it is compacted into a new one.
+//
+// Every record is a 4-byte length, a 4-byte CRC-32 of the payload and the
+// payload, whose first byte is its type.
+type btreeEngine struct {
+	path string
+	file *os.File
+	size int64
+	// live is how many bytes of the file the current tree's pages take.
+	live int64
+	root *btreeNode
+	rev  uint64
+}
+
+// btreeNode is a page. Leaves hold entries in key order; branches hold
+// children and, in keys, the smallest key under each child but the first.
+// offset is where the page was last written, zero while it has changes that
+// are not yet on disk.
+type btreeNode struct {
+	leaf     bool
+	entries  []KeyValue
+	keys     []string
+	children []*btreeNode
+	offset   int64
+	size     int64
+}
+
+func openBTreeEngine(dir string) (*btreeEngine, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	e := &btreeEngine{path: filepath.Join(dir, btreeFileName), root: &btreeNode{leaf: true}}
+	data, err := os.ReadFile(e.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(data) == 0 {
+		if err := writeFileSynced(e.path, []byte(btreeMagic)); err != nil {
+			return nil, err
+		}
+		data = []byte(btreeMagic)
+	}
+	if !bytes.HasPrefix(data, []byte(btreeMagic)) {
+		return nil, fmt.Errorf("%s is not a B-tree state file", e.path)
+	}
+	if err := e.load(data); err != nil {
+		return nil, err
+	}
+	e.file, err = os.OpenFile(e.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// load finds the last complete commit in data and reads its tree. Records
+// after it are truncated away.
+func (e *btreeEngine) load(data []byte) error {
+	pos := int64(len(btreeMagic))
+	end := pos
+	var rootOffset int64
+	for {
+		payload, next, ok := readBTreeRecord(data, pos)
+		if !ok {
+			break
+		}
+		if payload[0] == btreeMeta {
+			r := bytes.NewReader(payload[1:])
+			rev, err1 := binary.ReadUvarint(r)
+			root, err2 := binary.ReadUvarint(r)
+			if err1 != nil || err2 != nil {
+				break
+			}
+			e.rev, rootOffset, end = rev, int64(root), next
+		}
+		pos = next
+	}
+	if end < int64(len(data)) {
+		log.Printf("B-tree state file %s has an unfinished commit at offset %d; discarding it", e.path, end)
+		if err := os.Truncate(e.path, end); err != nil {
+			return err
+		}
+	}
+	e.size = end
+	if rootOffset == 0 {
+		return nil
+	}
+	root, err := e.readNode(data, rootOffset)
+	if err != nil {
+		return err
+	}
+	e.root = root
+	return nil
+}
+
+func readBTreeRecord(data []byte, pos int64) ([]byte, int64, bool) {
+	if pos+8 > int64(len(data)) {
+		return nil, pos, false
+	}
+	length := int64(binary.LittleEndian.Uint32(data[pos:]))
+	sum := binary.LittleEndian.Uint32(data[pos+4:])
+	next := pos + 8 + length
+	if length == 0 || next > int64(len(data)) {
+		return nil, pos, false
+	}
+	payload := data[pos+8 : next]
+	if crc32.ChecksumIEEE(payload) != sum {
+		return nil, pos, false
+	}
+	return payload, next, true
+}
+
+func (e *btreeEngine) readNode(data []byte, offset int64) (*btreeNode, error) {
+	payload, next, ok := readBTreeRecord(data, offset)
+	if !ok {
+		return nil, fmt.Errorf("%s: no page at offset %d", e.path, offset)
+	}
+	n := &btreeNode{leaf: payload[0] == btreeLeaf, offset: offset, size: next - offset}
+	e.live += n.size
+	r := bytes.NewReader(payload[1:])
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < count; i++ {
+		key, err := readBTreeBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		if n.leaf {
+			value, err := readBTreeBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			created, err1 := binary.ReadUvarint(r)
+			modified, err2 := binary.ReadUvarint(r)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("%s: corrupt page at offset %d", e.path, offset)
+			}
+			n.entries = append(n.entries, KeyValue{Key: string(key), Value: value, CreateRevision: created, ModRevision: modified})
+			continue
+		}
+		childOffset, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		child, err := e.readNode(data, int64(childOffset))
+		if err != nil {
+			return nil, err
+		}
+		n.keys = append(n.keys, string(key))
+		n.children = append(n.children, child)
+	}
+	return n, nil
+}
+
+func readBTreeBytes(r *bytes.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, length)
+	r.Read(b)
+	return b, nil
+}
+
+func appendBTreeBytes(buf []byte, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func appendBTreeRecord(buf, payload []byte) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(payload))
+	return append(buf, payload...)
+}
+
+// childIndex picks the child of a branch whose range holds key.
+func (n *btreeNode) childIndex(key string) int {
+	i := sort.Search(len(n.keys), func(i int) bool { return n.keys[i] > key }) - 1
+	if i < 0 {
+		i = 0
+	}
+	return i
+}
+
+func (n *btreeNode) empty() bool {
+	return len(n.entries) == 0 && len(n.children) == 0
+}
+
+func (n *btreeNode) minKey() string {
+	if n.leaf {
+		return n.entries[0].Key
+	}
+	return n.children[0].minKey()
+}
+
+func (e *btreeEngine) dirty(n *btreeNode) {
+	if n.offset != 0 {
+		e.live -= n.size
+		n.offset, n.size = 0, 0
+	}
+}
+
+func (e *btreeEngine) get(key string) (KeyValue, bool) {
+	n := e.root
+	for !n.leaf {
+		n = n.children[n.childIndex(key)]
+	}
+	i := sort.Search(len(n.entries), func(i int) bool { return n.entries[i].Key >= key })
+	if i < len(n.entries) && n.entries[i].Key == key {
+		return n.entries[i], true
+	}
+	return KeyValue{}, false
+}
+
+func (e *btreeEngine) scan(prefix string, fn func(KeyValue)) {
+	e.scanNode(e.root, prefix, fn)
+}
+
+// scanNode visits the keys under prefix in n, and reports false once it has
+// passed them.
+func (e *btreeEngine) scanNode(n *btreeNode, prefix string, fn func(KeyValue)) bool {
+	past := func(key string) bool { return key > prefix && !strings.HasPrefix(key, prefix) }
+	if n.leaf {
+		i := sort.Search(len(n.entries), func(i int) bool { return n.entries[i].Key >= prefix })
+		for ; i < len(n.entries); i++ {
+			if past(n.entries[i].Key) {
+				return false
+			}
+			fn(n.entries[i])
+		}
+		return true
+	}
+	for i, child := range n.children {
+		if i+1 < len(n.keys) && n.keys[i+1] <= prefix {
+			continue
+		}
+		if i > 0 && past(n.keys[i]) {
+			return false
+		}
+		if !e.scanNode(child, prefix, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *btreeEngine) revision() uint64 { return e.rev }
+
+// put stores kv under n, returning the new right half if n had to split.
+func (e *btreeEngine) put(n *btreeNode, kv KeyValue) *btreeNode {
+	e.dirty(n)
+	if n.leaf {
+		i := sort.Search(len(n.entries), func(i int) bool { return n.entries[i].Key >= kv.Key })
+		if i < len(n.entries) && n.entries[i].Key == kv.Key {
+			n.entries[i] = kv
+			return nil
+		}
+		n.entries = append(n.entries, KeyValue{})
+		copy(n.entries[i+1:], n.entries[i:])
+		n.entries[i] = kv
+		if len(n.entries) <= btreeMaxEntries {
+			return nil
+		}
+		half := len(n.entries) / 2
+		right := &btreeNode{leaf: true, entries: append([]KeyValue{}, n.entries[half:]...)}
+		n.entries = n.entries[:half:half]
+		return right
+	}
+
+	i := n.childIndex(kv.Key)
+	split := e.put(n.children[i], kv)
+	if split == nil {
+		return nil
+	}
+	n.keys = append(n.keys, "")
+	copy(n.keys[i+2:], n.keys[i+1:])
+	n.keys[i+1] = split.minKey()
+	n.children = append(n.children, nil)
+	copy(n.children[i+2:], n.children[i+1:])
+	n.children[i+1] = split
+	if len(n.children) <= btreeMaxEntries {
+		return nil
+	}
+	half := len(n.children) / 2
+	right := &btreeNode{keys: append([]string{}, n.keys[half:]...), children: append([]*btreeNode{}, n.children[half:]...)}
+	n.keys, n.children = n.keys[:half:half], n.children[:half:half]
+	return right
+}
+
+// remove deletes key under n and reports whether it was there. Pages left
+// empty are dropped from their parent; others may stay underfull, which
+// keeps every leaf at the same depth without merging.
+func (e *btreeEngine) remove(n *btreeNode, key string) bool {
+	if n.leaf {
+		i := sort.Search(len(n.entries), func(i int) bool { return n.entries[i].Key >= key })
+		if i == len(n.entries) || n.entries[i].Key != key {
+			return false
+		}
+		e.dirty(n)
+		n.entries = append(n.entries[:i], n.entries[i+1:]...)
+		return true
+	}
+	i := n.childIndex(key)
+	if !e.remove(n.children[i], key) {
+		return false
+	}
+	e.dirty(n)
+	if n.children[i].empty() {
+		n.keys = append(n.keys[:i], n.keys[i+1:]...)
+		n.children = append(n.children[:i], n.children[i+1:]...)
+	}
+	return true
+}
+
+// apply changes the tree in memory, then appends the changed pages and a
+// meta record in one write, synced before it returns so a committed
+// transaction survives a crashed machine too.
+func (e *btreeEngine) apply(rev uint64, writes []KeyValue) error {
+	for _, kv := range writes {
+		if kv.Value == nil {
+			e.remove(e.root, kv.Key)
+			// Shrink the tree when the root is left with one child or
+			// none.
+			for !e.root.leaf && len(e.root.children) == 1 {
+				e.root = e.root.children[0]
+			}
+			if !e.root.leaf && len(e.root.children) == 0 {
+				e.root = &btreeNode{leaf: true}
+			}
+			continue
+		}
+		if split := e.put(e.root, kv); split != nil {
+			e.root = &btreeNode{keys: []string{"", split.minKey()}, children: []*btreeNode{e.root, split}}
+		}
+	}
+
+	buf, written := e.appendDirty(nil, e.root, e.size)
+	meta := []byte{btreeMeta}
+	meta = binary.AppendUvarint(meta, rev)
+	rootOffset := e.root.offset
+	if rootOffset == 0 {
+		rootOffset = written[e.root]
+	}
+	meta = binary.AppendUvarint(meta, uint64(rootOffset))
+	buf = appendBTreeRecord(buf, meta)
+	if _, err := e.file.Write(buf); err != nil {
+		return err
+	}
+	if err := e.file.Sync(); err != nil {
+		return err
+	}
+	for n, offset := range written {
+		n.offset = offset
+	}
+	e.size += int64(len(buf))
+	e.rev = rev
+	if e.size >= btreeCompactMinSize && e.size > btreeCompactRatio*e.live {
+		return e.compact()
+	}
+	return nil
+}
+
+// appendDirty encodes every page under n that is not on disk, children
+// first, as if buf were written at base. It returns where each page went;
+// the pages' sizes are recorded at once, their offsets only once the write
+// succeeds.
+func (e *btreeEngine) appendDirty(buf []byte, n *btreeNode, base int64) ([]byte, map[*btreeNode]int64) {
+	written := make(map[*btreeNode]int64)
+	var visit func(n *btreeNode)
+	visit = func(n *btreeNode) {
+		if n.offset != 0 {
+			return
+		}
+		payload := []byte{btreeBranch}
+		if n.leaf {
+			payload[0] = btreeLeaf
+			payload = binary.AppendUvarint(payload, uint64(len(n.entries)))
+			for _, kv := range n.entries {
+				payload = appendBTreeBytes(payload, []byte(kv.Key))
+				payload = appendBTreeBytes(payload, kv.Value)
+				payload = binary.AppendUvarint(payload, kv.CreateRevision)
+				payload = binary.AppendUvarint(payload, kv.ModRevision)
+			}
+		} else {
+			for _, child := range n.children {
+				visit(child)
+			}
+			payload = binary.AppendUvarint(payload, uint64(len(n.children)))
+			for i, child := range n.children {
+				offset := child.offset
+				if offset == 0 {
+					offset = written[child]
+				}
+				payload = appendBTreeBytes(payload, []byte(n.keys[i]))
+				payload = binary.AppendUvarint(payload, uint64(offset))
+			}
+		}
+		written[n] = base + int64(len(buf))
+		before := len(buf)
+		buf = appendBTreeRecord(buf, payload)
+		n.size = int64(len(buf) - before)
+		e.live += n.size
+	}
+	visit(n)
+	return buf, written
+}
+
+// compact writes the live tree to a new file and swaps it in.
+func (e *btreeEngine) compact() error {
+	var clear func(n *btreeNode)
+	saved := make(map[*btreeNode]int64)
+	clear = func(n *btreeNode) {
+		saved[n] = n.offset
+		n.offset = 0
+		for _, child := range n.children {
+			clear(child)
+		}
+	}
+	clear(e.root)
+	restore := func() {
+		for n, offset := range saved {
+			n.offset = offset
+		}
+	}
+
+	e.live = 0
+	buf, written := e.appendDirty([]byte(btreeMagic), e.root, 0)
+	meta := binary.AppendUvarint([]byte{btreeMeta}, e.rev)
+	meta = binary.AppendUvarint(meta, uint64(written[e.root]))
+	buf = appendBTreeRecord(buf, meta)
+	if err := writeFileSynced(e.path+".tmp", buf); err != nil {
+		restore()
+		return err
+	}
+	if err := os.Rename(e.path+".tmp", e.path); err != nil {
+		restore()
+		return err
+	}
+	if err := syncDir(filepath.Dir(e.path)); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(e.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	e.file.Close()
+	e.file = file
+	for n, offset := range written {
+		n.offset = offset
+	}
+	e.size = int64(len(buf))
+	return nil
+}
+
+func (e *btreeEngine) close() error {
+	err := e.file.Sync()
+	if cerr := e.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Files the file backend keeps in -data-dir.
+const (
+	walFileName      = "wal.log"
+	snapshotFileName = "snapshot.json"
+)
+
+// walRecord is one line of the write-ahead log: the writes of one
+// transaction.
+type walRecord struct {
+	Revision uint64     `json:"rev"`
+	Writes   []KeyValue `json:"writes"`
+}
+
+type snapshotFile struct {
+	Revision uint64     `json:"revision"`
+	Taken    time.Time  `json:"taken"`
+	KVs      []KeyValue `json:"kvs"`
+}
+
+// fileEngine keeps every value in memory and makes changes durable by
+// appending each transaction to a write-ahead log. Every snapshotEvery
+// transactions the whole state is written out as a snapshot and the log
+// starts afresh; on open the snapshot is loaded and the log replayed on top.
+type fileEngine struct {
+	*memoryEngine
+	dir           string
+	snapshotEvery int
+
+	file    *os.File
+	rev     uint64
+	records int
+}
+
+func openFileEngine(dir string, snapshotEvery int) (*fileEngine, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	e := &fileEngine{memoryEngine: newMemoryEngine(), dir: dir, snapshotEvery: snapshotEvery}
+	if err := e.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	replayed, err := e.replay()
+	if err != nil {
+		return nil, err
+	}
+	e.file, err = os.OpenFile(filepath.Join(dir, walFileName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	e.records = replayed
+	return e, nil
+}
+
+func (e *fileEngine) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(e.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap snapshotFile
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("reading snapshot: %v", err)
+	}
+	for _, kv := range snap.KVs {
+		e.kvs[kv.Key] = kv
+	}
+	e.rev = snap.Revision
+	return nil
+}
+
+// replay applies the log on top of the snapshot. Records at or below the
+// snapshot's revision were already in it; they remain when the server
+// stopped between writing a snapshot and truncating the log. A torn final
+// record, left by a crash mid-write, is cut off.
+func (e *fileEngine) replay() (int, error) {
+	path := filepath.Join(e.dir, walFileName)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var good int64
+	replayed := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		var rec walRecord
+		if err != nil || json.Unmarshal(line, &rec) != nil {
+			log.Printf("Write-ahead log %s has a torn record at offset %d; discarding it", path, good)
+			if err := os.Truncate(path, good); err != nil {
+				return 0, err
+			}
+			break
+		}
+		good += int64(len(line))
+		if rec.Revision <= e.rev {
+			continue
+		}
+		e.memoryEngine.apply(rec.Revision, rec.Writes)
+		e.rev = rec.Revision
+		replayed++
+	}
+	return replayed, nil
+}
+
+func (e *fileEngine) revision() uint64 { return e.rev }
+
+// apply appends the transaction to the log and syncs it before applying
+// it, so a committed transaction survives a crashed machine too.
+func (e *fileEngine) apply(rev uint64, writes []KeyValue) error {
+	line, err := json.Marshal(walRecord{Revision: rev, Writes: writes})
+	if err != nil {
+		return err
+	}
+	if _, err := e.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := e.file.Sync(); err != nil {
+		return err
+	}
+	e.memoryEngine.apply(rev, writes)
+	e.rev = rev
+	e.records++
+	if e.snapshotEvery > 0 && e.records >= e.snapshotEvery {
+		return e.snapshot()
+	}
+	return nil
+}
+
+// snapshot writes the state out and empties the log. The snapshot is synced
+// and renamed into place first, so a crash at any point leaves a snapshot
+// and log that replay to the same state.
+func (e *fileEngine) snapshot() error {
+	snap := snapshotFile{Revision: e.rev, Taken: time.Now(), KVs: make([]KeyValue, 0, len(e.kvs))}
+	for _, kv := range e.kvs {
+		snap.KVs = append(snap.KVs, kv)
+	}
+	sort.Slice(snap.KVs, func(i, j int) bool { return snap.KVs[i].Key < snap.KVs[j].Key })
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(e.dir, snapshotFileName)
+	if err := writeFileSynced(path+".tmp", data); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	// The rename must be durable before the log it replaces is emptied.
+	if err := syncDir(e.dir); err != nil {
+		return err
+	}
+	if err := e.file.Truncate(0); err != nil {
+		return err
+	}
+	e.records = 0
+	return nil
+}
+
+// close writes a final snapshot so the next start replays nothing.
+func (e *fileEngine) close() error {
+	err := e.snapshot()
+	if cerr := e.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func writeFileSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// replaceFileSynced replaces path with data so that a crash leaves either
+// the old contents or the new: data is synced to a temporary file, which is
+// renamed over path, and the rename is synced too.
+func replaceFileSynced(path string, data []byte) error {
+	if err := writeFileSynced(path+".tmp", data); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir makes the renames in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// certReloadInterval is how often the serving certificate, key and CA
+	// files are checked for changes. SIGHUP reloads them immediately.
+	certReloadInterval = 10 * time.Second
+	// servingCertRenewBefore is how close to expiry a generated serving
+	// certificate is reissued at startup.
+	servingCertRenewBefore = 30 * 24 * time.Hour
+
+	caValidity          = 10 * 365 * 24 * time.Hour
+	servingCertValidity = 365 * 24 * time.Hour
+)
+
+// defaultServingHosts are always in a generated serving certificate, so the
+// CLI on this machine and node containers can both verify it.
+var defaultServingHosts = []string{"localhost", "127.0.0.1", "::1", "host.docker.internal"}
+
+// selfSignedFiles are the files ensureSelfSignedCerts keeps in its directory.
+type selfSignedFiles struct {
+	caCert, caKey, cert, key string
+}
+
+// ensureSelfSignedCerts makes sure dir holds a CA and a serving certificate
+// signed by it for hosts. An existing CA is kept, so clients that already
+// trust it keep working; the serving certificate is reissued when it is
+// missing, close to expiry, or does not cover every host.
+func ensureSelfSignedCerts(dir string, hosts []string) (selfSignedFiles, error) {
+	files := selfSignedFiles{
+		caCert: filepath.Join(dir, "ca.crt"),
+		caKey:  filepath.Join(dir, "ca.key"),
+		cert:   filepath.Join(dir, "apiserver.crt"),
+		key:    filepath.Join(dir, "apiserver.key"),
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return files, err
+	}
+
+	ca, caKey, err := loadCA(files.caCert, files.caKey)
+	if errors.Is(err, fs.ErrNotExist) {
+		ca, caKey, err = generateCA(files.caCert, files.caKey)
+		if err == nil {
+			log.Printf("Generated CA %s (SHA-256 %s)", files.caCert, certFingerprint(ca))
+		}
+	}
+	if err != nil {
+		return files, fmt.Errorf("CA: %v", err)
+	}
+
+	if servingCertValid(files.cert, ca, hosts) {
+		return files, nil
+	}
+	if err := issueServingCert(files.cert, files.key, ca, caKey, hosts); err != nil {
+		return files, fmt.Errorf("serving certificate: %v", err)
+	}
+	log.Printf("Issued serving certificate %s for %s", files.cert, strings.Join(hosts, ", "))
+	return files, nil
+}
+
+func loadCA(certFile, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no certificate in %s", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no key in %s", keyFile)
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func generateCA(certFile, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "kube-sim-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeKeyPair(certFile, keyFile, der, key); err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+func issueServingCert(certFile, keyFile string, ca *x509.Certificate, caKey *ecdsa.PrivateKey, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: "kube-sim-apiserver"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(servingCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	return writeKeyPair(certFile, keyFile, der, key)
+}
+
+// servingCertValid reports whether the certificate in certFile was signed by
+// ca, is not about to expire and covers every host.
+func servingCertValid(certFile string, ca *x509.Certificate, hosts []string) bool {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return false
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil || cert.CheckSignatureFrom(ca) != nil || time.Until(cert.NotAfter) < servingCertRenewBefore {
+		return false
+	}
+	for _, host := range hosts {
+		if cert.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// writeKeyPair writes the key first and both files atomically, so a
+// reloading server never sees a certificate without its key.
+func writeKeyPair(certFile, keyFile string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	return writeFileAtomic(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func randomSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	return serial
+}
+
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// bundleFingerprint is the fingerprint of the first certificate in a PEM
+// bundle, which clients compare before trusting the bundle.
+func bundleFingerprint(bundle []byte) string {
+	block, _ := pem.Decode(bundle)
+	if block == nil {
+		return ""
+	}
+	sum := sha256.Sum256(block.Bytes)
+	return hex.EncodeToString(sum[:])
+}
+
+// certReloader holds the serving certificate, the client CAs and the CA
+// bundle handed out to clients, and reloads them when their files change.
+// A file that fails to load leaves the previous contents in use.
+type certReloader struct {
+	certFile, keyFile, clientCAFile, caBundleFile string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	caBundle  []byte
+	modTimes  map[string]time.Time
+}
+
+func newCertReloader(certFile, keyFile, clientCAFile, caBundleFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile, caBundleFile: caBundleFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	var files []string
+	for _, f := range []string{r.certFile, r.keyFile, r.clientCAFile, r.caBundleFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+func (r *certReloader) reload() error {
+	modTimes := make(map[string]time.Time)
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes[f] = info.ModTime()
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading serving certificate: %v", err)
+	}
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		if clientCAs, err = loadClientCAs(r.clientCAFile); err != nil {
+			return err
+		}
+	}
+	var bundle []byte
+	if r.caBundleFile != "" {
+		if bundle, err = os.ReadFile(r.caBundleFile); err != nil {
+			return fmt.Errorf("reading CA bundle: %v", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(bundle) {
+			return fmt.Errorf("no certificates found in CA bundle %s", r.caBundleFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert, r.clientCAs, r.caBundle, r.modTimes = &cert, clientCAs, bundle, modTimes
+	r.mu.Unlock()
+	return nil
+}
+
+// changed reports whether any file was modified since the last successful
+// reload.
+func (r *certReloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil || !info.ModTime().Equal(r.modTimes[f]) {
+			return true
+		}
+	}
+	return false
+}
+
+// run reloads the files whenever they change or the process gets SIGHUP,
+// until ctx is done.
+func (r *certReloader) run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(certReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+		case <-hup:
+		}
+		if err := r.reload(); err != nil {
+			log.Printf("Reloading TLS credentials failed, keeping the previous ones: %v", err)
+			continue
+		}
+		log.Printf("Reloaded TLS credentials from %s", strings.Join(r.files(), ", "))
+	}
+}
+
+// tlsConfig returns a server config that picks up the current certificate
+// and client CAs for every new connection.
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			config := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{*r.cert}}
+			if r.clientCAs != nil {
+				config.ClientCAs = r.clientCAs
+				config.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return config, nil
+		},
+	}
+}
+
+func (r *certReloader) bundle() []byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.caBundle
+}
+
+// caBundlePath serves the CA bundle without authentication.
+const caBundlePath = "/api/v1/cabundle"
+
+// servingCerts is set when the server serves HTTPS.
+var servingCerts *certReloader
+
+// handleCABundle serves the PEM bundle clients should trust to reach this
+// server. It is public: clients fetch it before they can verify anything and
+// should check its fingerprint out of band.
+func handleCABundle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if servingCerts == nil || servingCerts.bundle() == nil {
+		writeError(w, "the server has no CA bundle to distribute", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Write(servingCerts.bundle())
+}
+
+// nodeAPIServerURL is the URL of this server at host and port: https when
+// it serves TLS, http otherwise.
+func nodeAPIServerURL(host, port string) string {
+	if servingCerts != nil {
+		return "https://" + net.JoinHostPort(host, port)
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// nodeCABundle is the PEM bundle node agents verify this server with, or
+// nil when it does not serve TLS.
+func nodeCABundle() []byte {
+	if servingCerts == nil {
+		return nil
+	}
+	return servingCerts.bundle()
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "example.com/m/api/v1"
+)
+
+func readCert(t *testing.T, path string) *x509.Certificate {
+	t.Helper()
+	cert, err := tls.LoadX509KeyPair(path, path[:len(path)-len(".crt")]+".key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestEnsureSelfSignedCerts(t *testing.T) {
+	dir := t.TempDir()
+	files, err := ensureSelfSignedCerts(dir, []string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, serving := readCert(t, files.caCert), readCert(t, files.cert)
+	if !ca.IsCA || serving.CheckSignatureFrom(ca) != nil {
+		t.Fatal("the serving certificate is not signed by the generated CA")
+	}
+	for _, host := range []string{"localhost", "127.0.0.1"} {
+		if err := serving.VerifyHostname(host); err != nil {
+			t.Errorf("serving certificate: %v", err)
+		}
+	}
+
+	// A second start keeps both; a new host reissues only the serving
+	// certificate, so clients trusting the CA keep working.
+	if _, err := ensureSelfSignedCerts(dir, []string{"localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	if !readCert(t, files.cert).Equal(serving) {
+		t.Error("a still valid serving certificate was reissued")
+	}
+	if _, err := ensureSelfSignedCerts(dir, []string{"localhost", "api.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	reissued := readCert(t, files.cert)
+	if reissued.Equal(serving) || reissued.VerifyHostname("api.example.com") != nil {
+		t.Error("the serving certificate was not reissued for a new host")
+	}
+	if !readCert(t, files.caCert).Equal(ca) || reissued.CheckSignatureFrom(ca) != nil {
+		t.Error("the CA changed")
+	}
+	if info, err := os.Stat(files.caKey); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("CA key: %v, %v", info.Mode(), err)
+	}
+}
+
+// issueClientCert writes a client certificate for name in groups, signed by
+// the CA in files, and returns it.
+func issueClientCert(t *testing.T, files selfSignedFiles, name string, groups ...string) tls.Certificate {
+	t.Helper()
+	ca, caKey, err := loadCA(files.caCert, files.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: name, Organization: groups},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestServingWithClientCertificates(t *testing.T) {
+	useAuthentication(t, "", "", false)
+	files, err := ensureSelfSignedCerts(t.TempDir(), []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := newCertReloader(files.cert, files.key, files.caCert, files.caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(withAuthentication(http.HandlerFunc(handleWhoAmI)))
+	srv.TLS = certs.tlsConfig()
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certs.bundle())
+	whoami := func(clientCerts ...tls.Certificate) (int, v1.UserInfo) {
+		t.Helper()
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: clientCerts}}}
+		resp, err := client.Get(srv.URL + "/api/v1/whoami")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var info v1.UserInfo
+		json.NewDecoder(resp.Body).Decode(&info)
+		return resp.StatusCode, info
+	}
+
+	code, info := whoami(issueClientCert(t, files, "alice", "dev", "ops"))
+	if code != http.StatusOK || info.Username != "alice" || len(info.Groups) != 3 || info.Groups[0] != "dev" || info.Groups[1] != "ops" {
+		t.Errorf("with a client certificate: %d, %+v", code, info)
+	}
+	// Without one the request is anonymous, which is not allowed here.
+	if code, _ := whoami(); code != http.StatusUnauthorized {
+		t.Errorf("without a client certificate: got %d", code)
+	}
+
+	// A certificate from another CA does not get a TLS session.
+	other, err := ensureSelfSignedCerts(t.TempDir(), []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{issueClientCert(t, other, "mallory", "system:masters")}}}}
+	if resp, err := client.Get(srv.URL + "/api/v1/whoami"); err == nil {
+		resp.Body.Close()
+		t.Errorf("a certificate from another CA got %d", resp.StatusCode)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	files, err := ensureSelfSignedCerts(dir, []string{"localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := newCertReloader(files.cert, files.key, "", files.caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certs.changed() {
+		t.Error("changed right after loading")
+	}
+	first := certs.cert
+
+	// A reissued certificate is picked up.
+	if _, err := ensureSelfSignedCerts(dir, []string{"localhost", "api.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(files.cert, future, future)
+	if !certs.changed() {
+		t.Fatal("a rewritten certificate is not noticed")
+	}
+	if err := certs.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(certs.cert.Certificate[0], first.Certificate[0]) {
+		t.Error("reloading kept the old certificate")
+	}
+
+	// A broken file is refused and the current certificate kept.
+	current := certs.cert
+	if err := os.WriteFile(files.cert, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := certs.reload(); err == nil {
+		t.Error("reloaded a broken certificate")
+	}
+	if certs.cert != current {
+		t.Error("a failed reload replaced the certificate")
+	}
+	if _, err := newCertReloader(filepath.Join(dir, "missing.crt"), files.key, "", ""); err == nil {
+		t.Error("loaded a missing certificate")
+	}
+}
+
+func TestHandleCABundle(t *testing.T) {
+	t.Cleanup(func() { servingCerts = nil })
+	w := httptest.NewRecorder()
+	handleCABundle(w, httptest.NewRequest("GET", caBundlePath, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("without TLS: got %d", w.Code)
+	}
+	if nodeAPIServerURL("localhost", "8080") != "http://localhost:8080" || nodeCABundle() != nil {
+		t.Error("nodes are told to use TLS")
+	}
+
+	files, err := ensureSelfSignedCerts(t.TempDir(), []string{"localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if servingCerts, err = newCertReloader(files.cert, files.key, "", files.caCert); err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := os.ReadFile(files.caCert)
+	w = httptest.NewRecorder()
+	handleCABundle(w, httptest.NewRequest("GET", caBundlePath, nil))
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), ca) {
+		t.Errorf("with TLS: got %d: %s", w.Code, w.Body)
+	}
+	if bundleFingerprint(ca) != certFingerprint(readCert(t, files.caCert)) {
+		t.Error("the bundle's fingerprint is not the CA's")
+	}
+	if nodeAPIServerURL("localhost", "8080") != "https://localhost:8080" || !bytes.Equal(nodeCABundle(), ca) {
+		t.Error("nodes are not told to use TLS")
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "example.com/m/api/v1"
+)
+
+const (
+	EventAdded    = v1.EventAdded
+	EventModified = v1.EventModified
+	EventDeleted  = v1.EventDeleted
+
+	// watchHistorySize is how many events are retained for clients resuming
+	// a watch; anything older has been compacted and yields 410 Gone.
+	watchHistorySize = 1000
+	// watchChannelSize bounds how far a single watcher may fall behind before
+	// it is disconnected and has to resume from its last resourceVersion.
+	watchChannelSize = 100
+	sseKeepalive     = 30 * time.Second
+)
+
+type WatchEvent struct {
+	Type            string      `json:"type"`
+	Kind            string      `json:"kind"`
+	ResourceVersion uint64      `json:"resourceVersion"`
+	Object          interface{} `json:"object"`
+}
+
+type watcher struct {
+	kind string
+	ch   chan WatchEvent
+}
+
+type watchCache struct {
+	mu          sync.Mutex
+	rv          uint64
+	compactedRV uint64
+	history     []WatchEvent
+	watchers    map[*watcher]struct{}
+}
+
+var (
+	watches = &watchCache{watchers: make(map[*watcher]struct{})}
+
+	errResourceVersionTooOld = errors.New("resource version too old")
+)
+
+// objectChange is one object's part in a commit. stamp receives the new
+// version, records it on the stored object and returns the snapshot to
+// publish and store. prevRV is the version the change was made to, which
+// storage must still hold; an added object must be absent from storage, and
+// a change with no prevRV is written unconditionally.
+type objectChange struct {
+	kind, eventType string
+	prevRV          uint64
+	stamp           func(rv uint64) interface{}
+}
+
+// condition is what storage must hold at key for the change to apply.
+func (change objectChange) condition(key string) (Condition, bool) {
+	switch {
+	case change.eventType == EventAdded:
+		return Condition{Key: key}, true
+	case change.prevRV != 0:
+		return Condition{Key: key, ModRevision: change.prevRV}, true
+	}
+	return Condition{}, false
+}
+
+// notify commits a change to a single object at prevRV.
+func (c *watchCache) notify(kind, eventType string, prevRV uint64, stamp func(rv uint64) interface{}) error {
+	_, err := c.commit(objectChange{kind: kind, eventType: eventType, prevRV: prevRV, stamp: stamp})
+	return err
+}
+
+// commit writes changes to storage as one transaction at the next
+// resourceVersion and fans them out to watchers of their kinds. Each change
+// is compared and swapped: the transaction only applies if storage still
+// holds every object at the version the change was made to. Callers must
+// hold the locks guarding the objects' maps so that changes are stored and
+// published in the order they were made.
+//
+// A change that does not commit, because storage moved on without the cache
+// or, with replication, because the log did not take it, returns an error
+// matching errNotCommitted. The cache, which already holds the change, is
+// reloaded from storage once the caller lets go; requests that made it
+// answer 503. Waiting for a majority holds the caller's locks and every
+// other commit, for up to raftProposalTimeout, after which the leader steps
+// down. A single server that cannot write to its storage stops rather than
+// serve changes it would lose.
+func (c *watchCache) commit(changes ...objectChange) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rv := c.rv + 1
+	evs := make([]WatchEvent, 0, len(changes))
+	ops := make([]Op, 0, len(changes))
+	var conditions []Condition
+	for _, change := range changes {
+		ev := WatchEvent{Type: change.eventType, Kind: change.kind, ResourceVersion: rv, Object: change.stamp(rv)}
+		op, err := storageOp(ev)
+		if err != nil {
+			log.Fatalf("Storing %s: %v", change.kind, err)
+		}
+		if cond, ok := change.condition(op.Key); ok {
+			conditions = append(conditions, cond)
+		}
+		evs = append(evs, ev)
+		ops = append(ops, op)
+	}
+	stored, err := store.Txn(conditions, ops)
+	if err == nil && stored != rv {
+		err = fmt.Errorf("storage committed revision %d, expected %d", stored, rv)
+	}
+	if err != nil && (replication != nil || errors.Is(err, errConflict)) {
+		// The change was made as leader but may never commit: leadership
+		// moved on, or other entries got ahead of it. Or the cache no
+		// longer matches storage.
+		log.Printf("Dropped a change that did not commit: %v", err)
+		go resyncStores()
+		return 0, fmt.Errorf("%w: %v", errNotCommitted, err)
+	}
+	if err != nil {
+		log.Fatalf("Writing to storage: %v", err)
+	}
+	c.rv = rv
+	for _, ev := range evs {
+		c.publishLocked(ev)
+	}
+	return rv, nil
+}
+
+// replicated publishes changes another replica committed at rv.
+func (c *watchCache) replicated(rv uint64, evs []WatchEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rv = rv
+	for _, ev := range evs {
+		ev.ResourceVersion = rv
+		c.publishLocked(ev)
+	}
+}
+
+func (c *watchCache) publishLocked(ev WatchEvent) {
+	c.history = append(c.history, ev)
+	if len(c.history) > watchHistorySize {
+		c.compactedRV = c.history[0].ResourceVersion
+		c.history = c.history[1:]
+	}
+	for wt := range c.watchers {
+		if wt.kind != ev.Kind {
+			continue
+		}
+		select {
+		case wt.ch <- ev:
+		default:
+			// Slow consumer: drop it, the client resumes from its last version.
+			delete(c.watchers, wt)
+			close(wt.ch)
+		}
+	}
+}
+
+// currentResourceVersion returns the version of the most recent change.
+func (c *watchCache) currentResourceVersion() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rv
+}
+
+// watch registers a watcher for kind. With fromRV == 0 the backlog is a
+// synthetic ADDED event per object in initial; otherwise it replays every
+// retained event newer than fromRV.
+func (c *watchCache) watch(kind string, fromRV uint64, initial []interface{}) (*watcher, []WatchEvent, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var backlog []WatchEvent
+	if fromRV == 0 {
+		for _, obj := range initial {
+			backlog = append(backlog, WatchEvent{Type: EventAdded, Kind: kind, ResourceVersion: c.rv, Object: obj})
+		}
+	} else {
+		if fromRV < c.compactedRV {
+			return nil, nil, errResourceVersionTooOld
+		}
+		for _, ev := range c.history {
+			if ev.ResourceVersion > fromRV && ev.Kind == kind {
+				backlog = append(backlog, ev)
+			}
+		}
+	}
+
+	wt := &watcher{kind: kind, ch: make(chan WatchEvent, watchChannelSize)}
+	c.watchers[wt] = struct{}{}
+	return wt, backlog, nil
+}
+
+func (c *watchCache) stop(wt *watcher) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.watchers[wt]; ok {
+		delete(c.watchers, wt)
+		close(wt.ch)
+	}
+}
+
+// nodeChanged bumps node's resourceVersion and records the change. Callers
+// must hold nodesMu.
+func nodeChanged(eventType string, node *Node) error {
+	_, err := watches.commit(nodeChange(eventType, node))
+	return err
+}
+
+// nodeChange is nodeChanged as part of a larger commit.
+func nodeChange(eventType string, node *Node) objectChange {
+	return objectChange{kind: "Node", eventType: eventType, prevRV: node.ResourceVersion, stamp: func(rv uint64) interface{} {
+		node.ResourceVersion = rv
+		return copyNode(node)
+	}}
+}
+
+// podChanged bumps pod's resourceVersion and records the change. Callers must
+// hold podsMu.
+func podChanged(eventType string, pod *Pod) error {
+	_, err := watches.commit(podChange(eventType, pod))
+	return err
+}
+
+// podChange is podChanged as part of a larger commit.
+func podChange(eventType string, pod *Pod) objectChange {
+	return objectChange{kind: "Pod", eventType: eventType, prevRV: pod.ResourceVersion, stamp: func(rv uint64) interface{} {
+		pod.ResourceVersion = rv
+		return copyPod(pod)
+	}}
+}
+
+// deploymentChanged bumps d's resourceVersion and records the change. Callers
+// must hold deploymentsMu.
+func deploymentChanged(eventType string, d *Deployment) error {
+	return watches.notify("Deployment", eventType, d.ResourceVersion, func(rv uint64) interface{} {
+		d.ResourceVersion = rv
+		return copyDeployment(d)
+	})
+}
+
+// eventChanged bumps e's resourceVersion and records the change. Callers must
+// hold eventsMu.
+func eventChanged(eventType string, e *Event) error {
+	return watches.notify("Event", eventType, e.ResourceVersion, func(rv uint64) interface{} {
+		e.ResourceVersion = rv
+		return *e
+	})
+}
+
+func copyNode(node *Node) Node {
+	c := *node
+	c.Pods = append([]string{}, node.Pods...)
+	c.Labels = copyLabels(node.Labels)
+	c.Annotations = copyLabels(node.Annotations)
+	c.Conditions = append([]v1.NodeCondition(nil), node.Conditions...)
+	c.Usage = copyUsage(node.Usage)
+	return c
+}
+
+func copyPod(pod *Pod) Pod {
+	c := *pod
+	c.Labels = copyLabels(pod.Labels)
+	c.Annotations = copyLabels(pod.Annotations)
+	c.Command = copyStrings(pod.Command)
+	c.Env = copyEnv(pod.Env)
+	if pod.Process != nil {
+		process := *pod.Process
+		c.Process = &process
+	}
+	return c
+}
+
+func copyDeployment(d *Deployment) Deployment {
+	c := *d
+	c.Selector = copyLabels(d.Selector)
+	c.Template.Labels = copyLabels(d.Template.Labels)
+	c.Labels = copyLabels(d.Labels)
+	c.Annotations = copyLabels(d.Annotations)
+	return c
+}
+
+// nodeSnapshots copies every node for the initial state of a watch. Callers
+// must hold nodesMu.
+func nodeSnapshots() []interface{} {
+	objs := make([]interface{}, 0, len(nodes))
+	for _, node := range nodes {
+		objs = append(objs, copyNode(node))
+	}
+	return objs
+}
+
+// podSnapshots copies every pod. Callers must hold podsMu.
+func podSnapshots() []interface{} {
+	objs := make([]interface{}, 0, len(pods))
+	for _, pod := range pods {
+		objs = append(objs, copyPod(pod))
+	}
+	return objs
+}
+
+// deploymentSnapshots copies every deployment. Callers must hold
+// deploymentsMu.
+func deploymentSnapshots() []interface{} {
+	objs := make([]interface{}, 0, len(deployments))
+	for _, d := range deployments {
+		objs = append(objs, copyDeployment(d))
+	}
+	return objs
+}
+
+// eventSnapshots copies every event. Callers must hold eventsMu.
+func eventSnapshots() []interface{} {
+	objs := make([]interface{}, 0, len(events))
+	for _, e := range events {
+		objs = append(objs, *e)
+	}
+	return objs
+}
+
+func isWatchRequest(r *http.Request) bool {
+	v := r.URL.Query().Get("watch")
+	return v == "true" || v == "1"
+}
+
+// serveWatch streams changes of kind to the client, either as newline
+// delimited JSON or, when the client asks for text/event-stream, as
+// Server-Sent Events. list is called with mu held so the initial state and
+// the live stream line up without gaps; only objects accepted by match are
+// sent. When convert is set, events are written as v1.WatchEvent carrying the
+// converted object instead of the legacy event shape.
+func serveWatch(w http.ResponseWriter, r *http.Request, kind string, mu *sync.Mutex, list func() []interface{}, match func(obj interface{}) bool, convert func(obj interface{}) interface{}) {
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+
+	var fromRV uint64
+	rvParam := r.URL.Query().Get("resourceVersion")
+	if rvParam == "" && sse {
+		rvParam = r.Header.Get("Last-Event-ID")
+	}
+	if rvParam != "" {
+		rv, err := strconv.ParseUint(rvParam, 10, 64)
+		if err != nil {
+			writeError(w, "Invalid resourceVersion", http.StatusBadRequest)
+			return
+		}
+		fromRV = rv
+	}
+
+	var timeout <-chan time.Time
+	if s := r.URL.Query().Get("timeoutSeconds"); s != "" {
+		secs, err := strconv.Atoi(s)
+		if err != nil || secs <= 0 {
+			writeError(w, "Invalid timeoutSeconds", http.StatusBadRequest)
+			return
+		}
+		timeout = time.After(time.Duration(secs) * time.Second)
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	mu.Lock()
+	wt, backlog, err := watches.watch(kind, fromRV, list())
+	mu.Unlock()
+	if errors.Is(err, errResourceVersionTooOld) {
+		writeError(w, fmt.Sprintf("resourceVersion %d is too old, relist and watch again", fromRV), http.StatusGone)
+		return
+	}
+	defer watches.stop(wt)
+
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	send := func(ev WatchEvent) error {
+		if !match(ev.Object) {
+			return nil
+		}
+		var payload interface{} = ev
+		if convert != nil {
+			object, err := json.Marshal(convert(ev.Object))
+			if err != nil {
+				return err
+			}
+			payload = v1.WatchEvent{Type: ev.Type, ResourceVersion: ev.ResourceVersion, Object: object}
+		}
+		if !sse {
+			return enc.Encode(payload)
+		}
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ResourceVersion, ev.Type, data)
+		return err
+	}
+
+	for _, ev := range backlog {
+		if err := send(ev); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case ev, ok := <-wt.ch:
+			if !ok {
+				log.Printf("%s watcher fell behind, closing stream", kind)
+				return
+			}
+			if err := send(ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepalive.C:
+			if sse {
+				fmt.Fprint(w, ": keepalive\n\n")
+				flusher.Flush()
+			}
+		case <-timeout:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
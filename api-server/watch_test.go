@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "example.com/m/api/v1"
+)
+
+// losingStorage is a replicated store whose leader lost its majority: every
+// transaction fails.
+type losingStorage struct {
+	Storage
+	txns atomic.Int32
+}
+
+func (s *losingStorage) Txn(conditions []Condition, ops []Op) (uint64, error) {
+	s.txns.Add(1)
+	return 0, errNotLeader
+}
+
+func TestUncommittedChangeAnswers503(t *testing.T) {
+	resetState(t)
+	lost := &losingStorage{Storage: store}
+	store, replication = lost, &raftNode{}
+	defer func() { replication = nil }()
+
+	w := postJSON(t, handleRegistration, "/api/v1/registration", v1.NodeRegistration{NodeID: "node-0001", Name: "worker-1", CPUCores: 2, AgentVersion: "test"})
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("registering while the change cannot commit: got %d, Retry-After %q: %s", w.Code, w.Header().Get("Retry-After"), w.Body)
+	}
+	if lost.txns.Load() != 1 {
+		t.Errorf("%d transactions proposed, want 1", lost.txns.Load())
+	}
+	if rv := watches.currentResourceVersion(); rv != 0 {
+		t.Errorf("resourceVersion moved to %d for a change that did not commit", rv)
+	}
+
+	// The cache drops the node once it is reloaded from storage.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		nodesMu.Lock()
+		_, cached := nodes["node-0001"]
+		nodesMu.Unlock()
+		if !cached {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the node that did not commit is still cached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCommitComparesResourceVersions(t *testing.T) {
+	resetState(t)
+	node := registerTestNode(t, "node-0001", "worker-1", 4)
+
+	// Storage moves on without the cache: the node is relabeled behind its
+	// back.
+	nodesMu.Lock()
+	stale := copyNode(node)
+	nodesMu.Unlock()
+	key := storageKey("Node", stale)
+	kv, err := store.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.Labels = map[string]string{"tier": "db"}
+	data, _ := json.Marshal(stale)
+	if _, err := store.Update(key, data, kv.ModRevision); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = updateNode("worker-1", 0, func(n *v1.Node) { n.Metadata.Labels = map[string]string{"tier": "web"} })
+	if !errors.Is(err, errNotCommitted) {
+		t.Fatalf("updating a node storage has moved on from: got %v, want errNotCommitted", err)
+	}
+
+	// The cache is reloaded with what storage holds.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		nodesMu.Lock()
+		tier := ""
+		if n := findNodeByName("worker-1"); n != nil {
+			tier = n.Labels["tier"]
+		}
+		nodesMu.Unlock()
+		if tier == "db" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cached node has tier %q, want the stored db", tier)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := updateNode("worker-1", 0, func(n *v1.Node) { n.Metadata.Labels = map[string]string{"tier": "web"} }); err != nil {
+		t.Errorf("updating the reloaded node: %v", err)
+	}
+}
+
+// podLosingStorage loses every transaction that changes a pod, as when the
+// leader loses its majority between two commits.
+type podLosingStorage struct {
+	Storage
+}
+
+func (s *podLosingStorage) Txn(conditions []Condition, ops []Op) (uint64, error) {
+	for _, op := range ops {
+		if strings.HasPrefix(op.Key, "/Pod/") {
+			return 0, errNotLeader
+		}
+	}
+	return s.Storage.Txn(conditions, ops)
+}
+
+func TestFailNodeThatDoesNotCommitIsRetried(t *testing.T) {
+	resetState(t)
+	node := registerTestNode(t, "node-0001", "worker-1", 4)
+	pod, err := createPod(&Pod{Name: "web", Namespace: "default", CPURequired: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	silence := func() {
+		nodesMu.Lock()
+		nodes[node.ID].LastHeartbeat = time.Now().Add(-2 * nodeHeartbeatTimeout)
+		nodesMu.Unlock()
+	}
+
+	committed := store
+	store, replication = &podLosingStorage{Storage: committed}, &raftNode{}
+	silence()
+	failNode(node.ID)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		nodesMu.Lock()
+		reloaded := nodes[node.ID] != node
+		nodesMu.Unlock()
+		if reloaded {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the cache was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	store, replication = committed, nil
+
+	// Neither the node nor its pod moved on.
+	nodesMu.Lock()
+	podsMu.Lock()
+	status, bound, phase := nodes[node.ID].HealthStatus, len(nodes[node.ID].Pods), pods[pod.ID].Status
+	podsMu.Unlock()
+	nodesMu.Unlock()
+	if status == "Failed" || bound != 1 || phase != "Running" {
+		t.Fatalf("after a failure that did not commit: node %s with %d pods, pod %s", status, bound, phase)
+	}
+
+	// The next check fails the node and releases the pod.
+	silence()
+	failNode(node.ID)
+	nodesMu.Lock()
+	podsMu.Lock()
+	status, bound, phase = nodes[node.ID].HealthStatus, len(nodes[node.ID].Pods), pods[pod.ID].Status
+	podsMu.Unlock()
+	nodesMu.Unlock()
+	if status != "Failed" || bound != 0 || phase != "Rescheduling" {
+		t.Errorf("after failing the node: node %s with %d pods, pod %s", status, bound, phase)
+	}
+}
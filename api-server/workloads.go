@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	v1 "example.com/m/api/v1"
+)
+
+// Phases of a pod whose process has exited. Its node no longer runs it
+// until it is restarted.
+const (
+	podSucceeded = "Succeeded"
+	podFailed    = "Failed"
+)
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
+
+func copyEnv(env []v1.EnvVar) []v1.EnvVar {
+	if env == nil {
+		return nil
+	}
+	return append([]v1.EnvVar{}, env...)
+}
+
+func workloadEqual(command []string, env []v1.EnvVar, otherCommand []string, otherEnv []v1.EnvVar) bool {
+	if len(command) != len(otherCommand) || len(env) != len(otherEnv) {
+		return false
+	}
+	for i := range command {
+		if command[i] != otherCommand[i] {
+			return false
+		}
+	}
+	for i := range env {
+		if env[i] != otherEnv[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func validateWorkload(command []string, env []v1.EnvVar) error {
+	if len(command) > 0 && command[0] == "" {
+		return invalidError("spec.command[0] must name a program")
+	}
+	for i, e := range env {
+		if e.Name == "" || strings.ContainsAny(e.Name, "=\x00") {
+			return invalidError("spec.env[%d].name %q is not a valid variable name", i, e.Name)
+		}
+	}
+	return nil
+}
+
+// changeWorkload gives a pod a new command and environment and reports
+// whether they changed. The caller restarts the pod, so its node runs the
+// new process, and records the change. Callers must hold podsMu.
+func changeWorkload(pod *Pod, command []string, env []v1.EnvVar) bool {
+	if workloadEqual(command, env, pod.Command, pod.Env) {
+		return false
+	}
+	pod.Command = copyStrings(command)
+	pod.Env = copyEnv(env)
+	return true
+}
+
+// nodeWorkloads lists what node's agent should run, for the heartbeat
+// response: every pod bound to it whose process has not finished. Callers
+// must hold nodesMu.
+func nodeWorkloads(node *Node) map[string]v1.Workload {
+	podsMu.Lock()
+	defer podsMu.Unlock()
+	workloads := make(map[string]v1.Workload, len(node.Pods))
+	for _, podID := range node.Pods {
+		pod, exists := pods[podID]
+		if !exists || pod.Status == podSucceeded || pod.Status == podFailed {
+			continue
+		}
+		workloads[podID] = v1.Workload{
+			Command:      copyStrings(pod.Command),
+			Env:          copyEnv(pod.Env),
+			RestartCount: pod.RestartCount,
+			Priority:     pod.Priority,
+		}
+	}
+	return workloads
+}
+
+// recordProcesses stores the process statuses node's agent reported. Reports
+// for a restart the pod has since moved past are stale and dropped. A pod
+// whose process exited becomes Succeeded or Failed. Callers must hold
+// nodesMu.
+func recordProcesses(node *Node, processes map[string]v1.ProcessStatus) {
+	if len(processes) == 0 {
+		return
+	}
+	podsMu.Lock()
+	defer podsMu.Unlock()
+	for _, podID := range node.Pods {
+		status, reported := processes[podID]
+		pod, exists := pods[podID]
+		if !reported || !exists || status.RestartCount != pod.RestartCount {
+			continue
+		}
+		changed := pod.Process == nil || !processEqual(*pod.Process, status)
+		if changed {
+			pod.Process = &status
+		}
+		exited := status.State == v1.ProcessExited && pod.Status == "Running"
+		if exited {
+			changed = true
+			pod.Status = podSucceeded
+			if status.ExitCode != 0 {
+				pod.Status = podFailed
+			}
+		}
+		if !changed {
+			continue
+		}
+		if err := podChanged(EventModified, pod); err != nil {
+			// The cache is reloaded, and the agent reports the process
+			// again with its next heartbeat.
+			log.Printf("Recording the process of pod %s: %v", podID, err)
+			return
+		}
+		switch {
+		case !exited:
+		case status.ExitCode == 0:
+			recordEvent(podRef(pod), sourceNodeAgent, v1.EventTypeNormal, "Completed", "Process exited with code 0")
+		default:
+			message := fmt.Sprintf("Process exited with code %d", status.ExitCode)
+			if status.Message != "" {
+				message = status.Message
+			}
+			recordEvent(podRef(pod), sourceNodeAgent, v1.EventTypeWarning, "ProcessFailed", "%s", message)
+		}
+	}
+}
+
+func processEqual(a, b v1.ProcessStatus) bool {
+	timesEqual := a.StartedAt.Equal(b.StartedAt) && (a.FinishedAt == nil) == (b.FinishedAt == nil) &&
+		(a.FinishedAt == nil || a.FinishedAt.Equal(*b.FinishedAt))
+	a.StartedAt, b.StartedAt = time.Time{}, time.Time{}
+	a.FinishedAt, b.FinishedAt = nil, nil
+	return timesEqual && a == b
+}
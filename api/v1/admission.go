@@ -0,0 +1,39 @@
+package v1
+
+import "encoding/json"
+
+// Admission operations.
+const (
+	OperationCreate = "CREATE"
+	OperationUpdate = "UPDATE"
+)
+
+// AdmissionReview is posted to admission webhooks with Request set; the
+// webhook answers with the same UID in Response.
+type AdmissionReview struct {
+	TypeMeta
+	Request  *AdmissionRequest  `json:"request,omitempty"`
+	Response *AdmissionResponse `json:"response,omitempty"`
+}
+
+// AdmissionRequest describes the write under review. Object is the object as
+// it would be stored; OldObject is the current object on updates.
+type AdmissionRequest struct {
+	UID       string          `json:"uid"`
+	Kind      string          `json:"kind"`
+	Namespace string          `json:"namespace,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Operation string          `json:"operation"`
+	Object    json.RawMessage `json:"object"`
+	OldObject json.RawMessage `json:"oldObject,omitempty"`
+}
+
+// AdmissionResponse allows or denies the request. A denial should say why in
+// Status. Mutating webhooks may return Patch, a JSON Patch (RFC 6902) array
+// applied to the request's object.
+type AdmissionResponse struct {
+	UID     string          `json:"uid"`
+	Allowed bool            `json:"allowed"`
+	Status  *Status         `json:"status,omitempty"`
+	Patch   json.RawMessage `json:"patch,omitempty"`
+}
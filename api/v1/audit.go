@@ -0,0 +1,54 @@
+package v1
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Audit levels, from recording nothing to recording the request and
+// response bodies.
+const (
+	AuditLevelNone            = "None"
+	AuditLevelMetadata        = "Metadata"
+	AuditLevelRequest         = "Request"
+	AuditLevelRequestResponse = "RequestResponse"
+)
+
+// AuditEvent records one API request. The audit log holds one per line, and
+// audit webhooks receive them in an AuditEventList.
+type AuditEvent struct {
+	TypeMeta
+	Level      string `json:"level"`
+	AuditID    string `json:"auditID"`
+	RequestURI string `json:"requestURI"`
+	Verb       string `json:"verb"`
+	// User is who the request was authenticated as; it is empty for
+	// requests whose credentials were rejected.
+	User      UserInfo        `json:"user"`
+	SourceIPs []string        `json:"sourceIPs"`
+	UserAgent string          `json:"userAgent,omitempty"`
+	ObjectRef *AuditObjectRef `json:"objectRef,omitempty"`
+	// ResponseCode is the HTTP status the server answered with.
+	ResponseCode int `json:"responseCode"`
+	// RequestObject is the request body at level Request and above, and
+	// ResponseObject the response body at RequestResponse. Bodies that are
+	// not JSON are recorded as strings; watch responses are never recorded.
+	RequestObject            json.RawMessage `json:"requestObject,omitempty"`
+	ResponseObject           json.RawMessage `json:"responseObject,omitempty"`
+	RequestReceivedTimestamp time.Time       `json:"requestReceivedTimestamp"`
+	StageTimestamp           time.Time       `json:"stageTimestamp"`
+	// LatencyMs is how long the server took to answer, in milliseconds.
+	LatencyMs float64 `json:"latencyMs"`
+}
+
+// AuditObjectRef names what a request acted on.
+type AuditObjectRef struct {
+	Resource  string `json:"resource,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+type AuditEventList struct {
+	TypeMeta
+	Items []AuditEvent `json:"items"`
+}
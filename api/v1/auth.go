@@ -0,0 +1,32 @@
+package v1
+
+import "time"
+
+// UserInfo is the identity the server authenticated a request as.
+type UserInfo struct {
+	TypeMeta
+	Username string   `json:"username"`
+	UID      string   `json:"uid,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// TokenRequest asks for a signed token for a service account. The server
+// fills in Status.
+type TokenRequest struct {
+	TypeMeta
+	Metadata ObjectMeta         `json:"metadata"`
+	Spec     TokenRequestSpec   `json:"spec"`
+	Status   TokenRequestStatus `json:"status"`
+}
+
+type TokenRequestSpec struct {
+	// ExpirationSeconds is how long the token is valid; zero means it does
+	// not expire.
+	ExpirationSeconds int64 `json:"expirationSeconds,omitempty"`
+}
+
+type TokenRequestStatus struct {
+	Token string `json:"token"`
+	// ExpirationTimestamp is zero for tokens that do not expire.
+	ExpirationTimestamp time.Time `json:"expirationTimestamp,omitempty"`
+}
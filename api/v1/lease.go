@@ -0,0 +1,31 @@
+package v1
+
+import "time"
+
+// Lease is a lock held by one candidate at a time, such as the API server
+// replica running the controllers. The holder renews it before
+// LeaseDurationSeconds have passed since RenewTime; once they have, another
+// candidate may take it over.
+type Lease struct {
+	TypeMeta
+	Metadata ObjectMeta `json:"metadata"`
+	Spec     LeaseSpec  `json:"spec"`
+}
+
+func (l *Lease) GetObjectMeta() *ObjectMeta { return &l.Metadata }
+
+type LeaseSpec struct {
+	// HolderIdentity is empty when the lease was released.
+	HolderIdentity       string    `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int       `json:"leaseDurationSeconds"`
+	AcquireTime          time.Time `json:"acquireTime"`
+	RenewTime            time.Time `json:"renewTime"`
+	// LeaseTransitions counts the times the lease changed hands.
+	LeaseTransitions int `json:"leaseTransitions"`
+}
+
+type LeaseList struct {
+	TypeMeta
+	Metadata ListMeta `json:"metadata"`
+	Items    []Lease  `json:"items"`
+}
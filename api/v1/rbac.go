@@ -0,0 +1,120 @@
+package v1
+
+// Subject kinds a binding can name.
+const (
+	SubjectKindUser           = "User"
+	SubjectKindGroup          = "Group"
+	SubjectKindServiceAccount = "ServiceAccount"
+)
+
+// PolicyRule allows Verbs on Resources. "*" matches every verb or resource.
+// Subresources such as "pods/restart" are resources of their own. With
+// ResourceNames the rule only covers requests for those objects, so it never
+// allows list, watch or create.
+type PolicyRule struct {
+	Verbs         []string `json:"verbs"`
+	Resources     []string `json:"resources"`
+	ResourceNames []string `json:"resourceNames,omitempty"`
+}
+
+// Role grants its rules within its own namespace.
+type Role struct {
+	TypeMeta
+	Metadata ObjectMeta   `json:"metadata"`
+	Rules    []PolicyRule `json:"rules"`
+}
+
+func (r *Role) GetObjectMeta() *ObjectMeta { return &r.Metadata }
+
+type RoleList struct {
+	TypeMeta
+	Metadata ListMeta `json:"metadata"`
+	Items    []Role   `json:"items"`
+}
+
+// ClusterRole grants its rules cluster-wide when bound by a
+// ClusterRoleBinding, or within one namespace when bound by a RoleBinding.
+type ClusterRole struct {
+	TypeMeta
+	Metadata ObjectMeta   `json:"metadata"`
+	Rules    []PolicyRule `json:"rules"`
+}
+
+func (r *ClusterRole) GetObjectMeta() *ObjectMeta { return &r.Metadata }
+
+type ClusterRoleList struct {
+	TypeMeta
+	Metadata ListMeta      `json:"metadata"`
+	Items    []ClusterRole `json:"items"`
+}
+
+// Subject is who a binding grants a role to. Namespace is only used for
+// service accounts and defaults to the binding's namespace.
+type Subject struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// RoleRef names the Role or ClusterRole a binding grants. It cannot be
+// changed once the binding exists.
+type RoleRef struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// RoleBinding grants a Role in its namespace, or a ClusterRole's rules
+// restricted to its namespace, to its subjects.
+type RoleBinding struct {
+	TypeMeta
+	Metadata ObjectMeta `json:"metadata"`
+	Subjects []Subject  `json:"subjects"`
+	RoleRef  RoleRef    `json:"roleRef"`
+}
+
+func (b *RoleBinding) GetObjectMeta() *ObjectMeta { return &b.Metadata }
+
+type RoleBindingList struct {
+	TypeMeta
+	Metadata ListMeta      `json:"metadata"`
+	Items    []RoleBinding `json:"items"`
+}
+
+// ClusterRoleBinding grants a ClusterRole in every namespace and on
+// cluster-scoped resources.
+type ClusterRoleBinding struct {
+	TypeMeta
+	Metadata ObjectMeta `json:"metadata"`
+	Subjects []Subject  `json:"subjects"`
+	RoleRef  RoleRef    `json:"roleRef"`
+}
+
+func (b *ClusterRoleBinding) GetObjectMeta() *ObjectMeta { return &b.Metadata }
+
+type ClusterRoleBindingList struct {
+	TypeMeta
+	Metadata ListMeta             `json:"metadata"`
+	Items    []ClusterRoleBinding `json:"items"`
+}
+
+// SelfSubjectAccessReview asks whether the caller may perform an action. The
+// server fills in Status.
+type SelfSubjectAccessReview struct {
+	TypeMeta
+	Spec   ResourceAttributes        `json:"spec"`
+	Status SubjectAccessReviewStatus `json:"status"`
+}
+
+// ResourceAttributes describe one request: verb is get, list, watch,
+// create, update or delete.
+type ResourceAttributes struct {
+	Verb      string `json:"verb"`
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+type SubjectAccessReviewStatus struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
@@ -0,0 +1,50 @@
+package v1
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ClusterSnapshot is a backup of the whole cluster as of one
+// resourceVersion: every stored object plus the scheduler configuration.
+// Objects are kept in the server's storage form, so a snapshot restores
+// exactly what was stored, including status.
+type ClusterSnapshot struct {
+	TypeMeta
+	ResourceVersion   uint64           `json:"resourceVersion"`
+	CreationTimestamp time.Time        `json:"creationTimestamp"`
+	SchedulerConfig   SchedulerConfig  `json:"schedulerConfig"`
+	Objects           []SnapshotObject `json:"objects"`
+}
+
+// SnapshotObject is one stored object: its kind, its key within the kind
+// (namespace/name, or the UID for nodes and pods) and its stored JSON.
+type SnapshotObject struct {
+	Kind   string          `json:"kind"`
+	Key    string          `json:"key"`
+	Object json.RawMessage `json:"object"`
+}
+
+// Node agent handling on restore.
+const (
+	// RestoreNodesKeep leaves agents alone: restored nodes are Unknown
+	// until their agents send a heartbeat, and fail if none does.
+	RestoreNodesKeep = "keep"
+	// RestoreNodesRelaunch starts a fresh agent for every restored node
+	// that was not stopped, and removes those of nodes the snapshot lacks.
+	RestoreNodesRelaunch = "relaunch"
+)
+
+// RestoreResult reports what a restore changed.
+type RestoreResult struct {
+	TypeMeta
+	// ResourceVersion is the one the restored objects were written at.
+	ResourceVersion uint64 `json:"resourceVersion"`
+	// SnapshotResourceVersion is the one the snapshot was taken at.
+	SnapshotResourceVersion uint64   `json:"snapshotResourceVersion"`
+	Restored                int      `json:"restored"`
+	Deleted                 int      `json:"deleted"`
+	RelaunchedNodes         []string `json:"relaunchedNodes,omitempty"`
+	// Errors lists node agents that could not be relaunched.
+	Errors []string `json:"errors,omitempty"`
+}
@@ -0,0 +1,89 @@
+package v1
+
+import "fmt"
+
+const (
+	StatusSuccess = "Success"
+	StatusFailure = "Failure"
+)
+
+// StatusReason is a machine-readable explanation of a Status. Clients should
+// branch on the reason rather than on the message.
+type StatusReason string
+
+const (
+	StatusReasonBadRequest         StatusReason = "BadRequest"
+	StatusReasonInvalid            StatusReason = "Invalid"
+	StatusReasonUnauthorized       StatusReason = "Unauthorized"
+	StatusReasonForbidden          StatusReason = "Forbidden"
+	StatusReasonNotFound           StatusReason = "NotFound"
+	StatusReasonAlreadyExists      StatusReason = "AlreadyExists"
+	StatusReasonConflict           StatusReason = "Conflict"
+	StatusReasonMethodNotAllowed   StatusReason = "MethodNotAllowed"
+	StatusReasonExpired            StatusReason = "Expired"
+	StatusReasonRequestTooLarge    StatusReason = "RequestEntityTooLarge"
+	StatusReasonTooManyRequests    StatusReason = "TooManyRequests"
+	StatusReasonInternalError      StatusReason = "InternalError"
+	StatusReasonServiceUnavailable StatusReason = "ServiceUnavailable"
+	StatusReasonUnknown            StatusReason = "Unknown"
+	// StatusReasonNotRegistered answers a heartbeat for a node the server
+	// does not know; the agent should register the node again.
+	StatusReasonNotRegistered StatusReason = "NotRegistered"
+)
+
+// Status is the body of every error response, and of successful deletes.
+type Status struct {
+	TypeMeta
+	Status  string         `json:"status"`
+	Message string         `json:"message,omitempty"`
+	Reason  StatusReason   `json:"reason,omitempty"`
+	Details *StatusDetails `json:"details,omitempty"`
+	Code    int            `json:"code"`
+}
+
+// StatusDetails names the object a Status is about, when there is one.
+type StatusDetails struct {
+	Kind      string `json:"kind,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+// Error makes a failed Status usable as a Go error.
+func (s *Status) Error() string {
+	if s.Message != "" {
+		return s.Message
+	}
+	return fmt.Sprintf("request failed with %d %s", s.Code, s.Reason)
+}
+
+// ReasonForCode returns the reason used for an HTTP status code when the
+// server has nothing more specific to say.
+func ReasonForCode(code int) StatusReason {
+	switch code {
+	case 400:
+		return StatusReasonBadRequest
+	case 401:
+		return StatusReasonUnauthorized
+	case 403:
+		return StatusReasonForbidden
+	case 404:
+		return StatusReasonNotFound
+	case 405:
+		return StatusReasonMethodNotAllowed
+	case 409:
+		return StatusReasonConflict
+	case 410:
+		return StatusReasonExpired
+	case 413:
+		return StatusReasonRequestTooLarge
+	case 422:
+		return StatusReasonInvalid
+	case 429:
+		return StatusReasonTooManyRequests
+	case 500:
+		return StatusReasonInternalError
+	case 503:
+		return StatusReasonServiceUnavailable
+	}
+	return StatusReasonUnknown
+}
@@ -0,0 +1,362 @@
+// Package v1 holds the request and response types of the Kube-Sim
+// /api/v1 REST API. The API server, the node agent and the CLI share these
+// definitions so that every client sees the same shapes.
+package v1
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	APIVersion = "v1"
+
+	// DefaultNamespace is used for namespaced objects that do not name one.
+	DefaultNamespace = "default"
+)
+
+// Watch event types.
+const (
+	EventAdded    = "ADDED"
+	EventModified = "MODIFIED"
+	EventDeleted  = "DELETED"
+)
+
+// TypeMeta identifies the kind of a serialised object.
+type TypeMeta struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+}
+
+// ObjectMeta is the metadata every stored object carries. ResourceVersion
+// changes with every write and must be echoed back on updates; Generation
+// only moves when the spec changes.
+type ObjectMeta struct {
+	Name              string            `json:"name"`
+	Namespace         string            `json:"namespace,omitempty"`
+	UID               string            `json:"uid,omitempty"`
+	ResourceVersion   uint64            `json:"resourceVersion,omitempty"`
+	Generation        int64             `json:"generation,omitempty"`
+	CreationTimestamp time.Time         `json:"creationTimestamp"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	// Owner references the controller that manages the object, such as
+	// "Deployment/default/web".
+	Owner string `json:"owner,omitempty"`
+}
+
+// Object is implemented by the top-level objects Node, Pod, Deployment and
+// Event.
+type Object interface {
+	GetObjectMeta() *ObjectMeta
+}
+
+func (n *Node) GetObjectMeta() *ObjectMeta       { return &n.Metadata }
+func (p *Pod) GetObjectMeta() *ObjectMeta        { return &p.Metadata }
+func (d *Deployment) GetObjectMeta() *ObjectMeta { return &d.Metadata }
+func (e *Event) GetObjectMeta() *ObjectMeta      { return &e.Metadata }
+
+// ListMeta describes a page of a list. Continue is empty on the last page.
+type ListMeta struct {
+	ResourceVersion uint64 `json:"resourceVersion"`
+	Continue        string `json:"continue,omitempty"`
+}
+
+type Node struct {
+	TypeMeta
+	Metadata ObjectMeta `json:"metadata"`
+	Spec     NodeSpec   `json:"spec"`
+	Status   NodeStatus `json:"status"`
+}
+
+type NodeSpec struct {
+	CPUCores int `json:"cpuCores"`
+}
+
+type NodeStatus struct {
+	AvailableCPU       int       `json:"availableCPU"`
+	Pods               []string  `json:"pods"`
+	HealthStatus       string    `json:"healthStatus"`
+	LastHeartbeat      time.Time `json:"lastHeartbeat"`
+	HeartbeatCount     int       `json:"heartbeatCount"`
+	ObservedGeneration int64     `json:"observedGeneration"`
+	// AgentVersion is the version the node's agent registered with; empty
+	// until it has registered.
+	AgentVersion string          `json:"agentVersion,omitempty"`
+	Conditions   []NodeCondition `json:"conditions,omitempty"`
+	// Usage is the node's resource usage as its agent last reported it.
+	Usage *NodeUsage `json:"usage,omitempty"`
+}
+
+// Node condition types. Ready is True while the agent reports; the others
+// are True when the node is short of something, and a node with any of them
+// True gets no new pods.
+const (
+	NodeReady              = "Ready"
+	NodeMemoryPressure     = "MemoryPressure"
+	NodeDiskPressure       = "DiskPressure"
+	NodePIDPressure        = "PIDPressure"
+	NodeNetworkUnavailable = "NetworkUnavailable"
+)
+
+// Condition statuses.
+const (
+	ConditionTrue    = "True"
+	ConditionFalse   = "False"
+	ConditionUnknown = "Unknown"
+)
+
+// NodeCondition is one aspect of a node's state. The agent reports the type,
+// status, reason and message; the API server keeps the times.
+type NodeCondition struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	// LastHeartbeatTime is when the condition was last reported, and
+	// LastTransitionTime when its status last changed.
+	LastHeartbeatTime  time.Time `json:"lastHeartbeatTime"`
+	LastTransitionTime time.Time `json:"lastTransitionTime"`
+	Reason             string    `json:"reason,omitempty"`
+	Message            string    `json:"message,omitempty"`
+}
+
+// NodeUsage is how much of a node's memory, disk and process IDs is in use,
+// in percent, and whether it has a network.
+type NodeUsage struct {
+	MemoryPercent      float64 `json:"memoryPercent"`
+	DiskPercent        float64 `json:"diskPercent"`
+	PIDPercent         float64 `json:"pidPercent"`
+	NetworkUnavailable bool    `json:"networkUnavailable,omitempty"`
+}
+
+// SimulatedUsageAnnotation on a node makes its agent report, and act on, the
+// usage it gives instead of the node's real usage, e.g.
+// "memory=95,disk=20,pids=5,network=down". Resources left out are at zero
+// usage.
+const SimulatedUsageAnnotation = "kube-sim.io/simulated-usage"
+
+type NodeList struct {
+	TypeMeta
+	Metadata ListMeta `json:"metadata"`
+	Items    []Node   `json:"items"`
+}
+
+type Pod struct {
+	TypeMeta
+	Metadata ObjectMeta `json:"metadata"`
+	Spec     PodSpec    `json:"spec"`
+	Status   PodStatus  `json:"status"`
+}
+
+type PodSpec struct {
+	CPURequired int `json:"cpuRequired"`
+	// NodeID is the UID of the node the pod is bound to. It is set by the
+	// scheduler and ignored on create and update.
+	NodeID string `json:"nodeID,omitempty"`
+	// Command is the program and arguments the node agent runs for the pod.
+	// A pod without one, or on a simulated node, gets a stand-in that runs
+	// until the pod is removed.
+	Command []string `json:"command,omitempty"`
+	Env     []EnvVar `json:"env,omitempty"`
+	// Priority orders pods for eviction: a node under pressure evicts its
+	// lowest-priority pods first. It cannot be changed once the pod exists.
+	Priority int `json:"priority,omitempty"`
+}
+
+// EnvVar is an environment variable set for a pod's process.
+type EnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type PodStatus struct {
+	Phase              string `json:"phase"`
+	ObservedGeneration int64  `json:"observedGeneration"`
+	// RestartCount counts restarts of the pod, by request or for a spec
+	// change; each runs the process afresh.
+	RestartCount int `json:"restartCount"`
+	// Process is the pod's process as its node last reported it.
+	Process *ProcessStatus `json:"process,omitempty"`
+}
+
+// Process states.
+const (
+	ProcessRunning = "Running"
+	ProcessExited  = "Exited"
+)
+
+// ProcessStatus reports a pod's process. A pod whose process exits is
+// Succeeded if the exit code is 0 and Failed otherwise; it is not run again
+// until it is restarted.
+type ProcessStatus struct {
+	State string `json:"state"`
+	// PID is 0 for a stand-in.
+	PID        int        `json:"pid,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	ExitCode   int        `json:"exitCode"`
+	// Message says why a process could not be started.
+	Message string `json:"message,omitempty"`
+	// CPUSeconds is the user and system CPU time used so far.
+	CPUSeconds float64 `json:"cpuSeconds"`
+	// MemoryBytes is the resident memory, or its peak once exited.
+	MemoryBytes int64 `json:"memoryBytes"`
+	// RestartCount is the pod's restart the process was started for.
+	RestartCount int `json:"restartCount"`
+}
+
+type PodList struct {
+	TypeMeta
+	Metadata ListMeta `json:"metadata"`
+	Items    []Pod    `json:"items"`
+}
+
+type Deployment struct {
+	TypeMeta
+	Metadata ObjectMeta       `json:"metadata"`
+	Spec     DeploymentSpec   `json:"spec"`
+	Status   DeploymentStatus `json:"status"`
+}
+
+// DeploymentSpec asks for Replicas pods built from Template. Selector must
+// match the template labels and cannot change after creation.
+type DeploymentSpec struct {
+	Replicas int               `json:"replicas"`
+	Selector map[string]string `json:"selector,omitempty"`
+	Template PodTemplateSpec   `json:"template"`
+}
+
+type PodTemplateSpec struct {
+	Metadata TemplateMeta `json:"metadata"`
+	Spec     PodSpec      `json:"spec"`
+}
+
+// TemplateMeta is the metadata stamped onto pods created from a template.
+type TemplateMeta struct {
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type DeploymentStatus struct {
+	ReadyReplicas      int   `json:"readyReplicas"`
+	UpdatedReplicas    int   `json:"updatedReplicas"`
+	ObservedGeneration int64 `json:"observedGeneration"`
+}
+
+type DeploymentList struct {
+	TypeMeta
+	Metadata ListMeta     `json:"metadata"`
+	Items    []Deployment `json:"items"`
+}
+
+// Event types.
+const (
+	EventTypeNormal  = "Normal"
+	EventTypeWarning = "Warning"
+)
+
+// Event records something that happened to an object. Repeats of the same
+// event bump Count and LastTimestamp instead of creating a new one.
+type Event struct {
+	TypeMeta
+	Metadata       ObjectMeta      `json:"metadata"`
+	InvolvedObject ObjectReference `json:"involvedObject"`
+	Reason         string          `json:"reason"`
+	Message        string          `json:"message"`
+	Type           string          `json:"type"`
+	Count          int             `json:"count"`
+	FirstTimestamp time.Time       `json:"firstTimestamp"`
+	LastTimestamp  time.Time       `json:"lastTimestamp"`
+	// Source is the component that reported the event, such as
+	// "health-monitor" or "scheduler".
+	Source string `json:"source,omitempty"`
+}
+
+// ObjectReference points at an object; Namespace is empty for nodes.
+type ObjectReference struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	UID       string `json:"uid,omitempty"`
+}
+
+type EventList struct {
+	TypeMeta
+	Metadata ListMeta `json:"metadata"`
+	Items    []Event  `json:"items"`
+}
+
+// WatchEvent is one change delivered by a watch. Object holds the object of
+// the watched kind as it was after the change, or before it for DELETED.
+// ResourceVersion is where a client should resume after this event.
+type WatchEvent struct {
+	Type            string          `json:"type"`
+	ResourceVersion uint64          `json:"resourceVersion"`
+	Object          json.RawMessage `json:"object"`
+}
+
+// NodeRegistration is what a node agent declares about its node when it
+// starts: who it is, what it has and which agent it runs. The server creates
+// the node, or updates the capacity and labels of the node it already knows
+// by that ID or, when NodeID is empty, by that name.
+type NodeRegistration struct {
+	NodeID       string            `json:"nodeID,omitempty"`
+	Name         string            `json:"name"`
+	CPUCores     int               `json:"cpuCores"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	AgentVersion string            `json:"agentVersion"`
+}
+
+// HeartbeatRequest is what a node agent posts periodically.
+type HeartbeatRequest struct {
+	NodeID string   `json:"nodeID"`
+	Status string   `json:"status"`
+	Pods   []string `json:"pods"`
+	// Processes reports the process of each pod the agent runs, by pod UID.
+	Processes  map[string]ProcessStatus `json:"processes,omitempty"`
+	Conditions []NodeCondition          `json:"conditions,omitempty"`
+	Usage      *NodeUsage               `json:"usage,omitempty"`
+	// Evictions lists the pods the agent evicted under pressure, by pod
+	// UID, with the reason.
+	Evictions map[string]string `json:"evictions,omitempty"`
+}
+
+// HeartbeatResponse tells the agent which pods it should be running.
+type HeartbeatResponse struct {
+	Pods []string `json:"pods"`
+	// Workloads says what to run for each of Pods, by pod UID, leaving out
+	// pods whose process has finished. The agent stops the processes of
+	// pods not in it.
+	Workloads map[string]Workload `json:"workloads,omitempty"`
+	// SimulatedUsage, when set, is reported and acted on instead of the
+	// node's real usage; it comes from the node's
+	// kube-sim.io/simulated-usage annotation.
+	SimulatedUsage *NodeUsage `json:"simulatedUsage,omitempty"`
+}
+
+// Workload is what a node agent runs for a pod. A changed RestartCount
+// means the process is to be started afresh.
+type Workload struct {
+	Command      []string `json:"command,omitempty"`
+	Env          []EnvVar `json:"env,omitempty"`
+	RestartCount int      `json:"restartCount"`
+	Priority     int      `json:"priority,omitempty"`
+}
+
+// SchedulerConfig selects the placement algorithm: first-fit, best-fit,
+// worst-fit or round-robin.
+type SchedulerConfig struct {
+	Algorithm string `json:"algorithm"`
+}
+
+// ApplyResult reports what apply did with one object: created, configured,
+// unchanged or pruned, or why it failed.
+type ApplyResult struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Action    string `json:"action,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type ApplyResponse struct {
+	Results []ApplyResult `json:"results"`
+}
@@ -1,3 +1,7 @@
 module cli
 
 go 1.23.4
+
+require example.com/m v0.0.0-00010101000000-000000000000
+
+replace example.com/m => ../
@@ -2,13 +2,21 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
+
+	v1 "example.com/m/api/v1"
+	"example.com/m/client"
 )
 
 const (
@@ -23,6 +31,12 @@ const (
 	NC          = "\033[0m"
 )
 
+const (
+	// listPageSize is how many objects the CLI asks for per list request.
+	listPageSize   = 500
+	requestTimeout = 10 * time.Second
+)
+
 func main() {
 	if len(os.Args) < 2 {
 		printUsage()
@@ -30,12 +44,19 @@ func main() {
 	}
 
 	command := os.Args[1]
-	client := &http.Client{Timeout: 10 * time.Second}
+	// The server and credentials come from ~/.kube-sim/config, or the file
+	// named by $KUBE_SIM_CONFIG.
+	cfg, err := client.LoadConfig("")
+	exitOnError("Failed to load config", err)
+	c, err := client.NewForConfig(cfg)
+	exitOnError("Failed to load credentials", err)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
 
 	switch command {
 	case "add-node":
-		if len(os.Args) != 3 {
-			fmt.Printf("%s%s[!] %sUsage: cli add-node <cpuCores>%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
+		if len(os.Args) < 3 {
+			fmt.Printf("%s%s[!] %sUsage: cli add-node <cpuCores> [--labels k=v,...]%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
 			os.Exit(1)
 		}
 		cpuCores, err := strconv.Atoi(os.Args[2])
@@ -43,109 +64,51 @@ func main() {
 			fmt.Printf("%s%s[!] %scpuCores must be a positive integer%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
 			os.Exit(1)
 		}
-		req := map[string]int{"cpuCores": cpuCores}
-		jsonData, _ := json.Marshal(req)
-		resp, err := client.Post("http://localhost:8080/nodes", "application/json", bytes.NewBuffer(jsonData))
-		if err != nil {
-			fmt.Printf("%s%s[✗] %sError: %v%s\n", NEON_RED, BOLD, NEON_PINK, err, NC)
-			os.Exit(1)
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusCreated {
-			fmt.Printf("%s%s[✗] %sFailed to add node, status: %s%s\n", NEON_RED, BOLD, NEON_PINK, resp.Status, NC)
-			os.Exit(1)
-		}
-		fmt.Printf("%s%s[✓] %sNode added successfully%s\n", NEON_GREEN, BOLD, NEON_CYAN, NC)
+		node, err := c.Nodes().Create(ctx, &v1.Node{
+			Metadata: v1.ObjectMeta{Labels: parseLabelsFlag("add-node", os.Args[3:])},
+			Spec:     v1.NodeSpec{CPUCores: cpuCores},
+		})
+		exitOnError("Failed to add node", err)
+		fmt.Printf("%s%s[✓] %sNode %s added with %d CPU cores%s\n", NEON_GREEN, BOLD, NEON_CYAN, node.Metadata.Name, cpuCores, NC)
 
 	case "stop-node":
 		if len(os.Args) != 3 {
-			fmt.Printf("%s%s[!] %sUsage: cli stop-node <nodeID>%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
-			os.Exit(1)
-		}
-		nodeID := os.Args[2]
-		resp, err := client.Post(fmt.Sprintf("http://localhost:8080/nodes/%s/stop", nodeID), "application/json", nil)
-		if err != nil {
-			fmt.Printf("%s%s[✗] %sError: %v%s\n", NEON_RED, BOLD, NEON_PINK, err, NC)
-			os.Exit(1)
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			fmt.Printf("%s%s[✗] %sFailed to stop node: %s%s\n", NEON_RED, BOLD, NEON_PINK, string(body), NC)
+			fmt.Printf("%s%s[!] %sUsage: cli stop-node <node>%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
 			os.Exit(1)
 		}
+		_, err := c.Nodes().Stop(ctx, os.Args[2])
+		exitOnError("Failed to stop node", err)
 		fmt.Printf("%s%s[✓] %sNode stopped successfully%s\n", NEON_GREEN, BOLD, NEON_CYAN, NC)
 
 	case "restart-node":
 		if len(os.Args) != 3 {
-			fmt.Printf("%s%s[!] %sUsage: cli restart-node <nodeID>%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
-			os.Exit(1)
-		}
-		nodeID := os.Args[2]
-		resp, err := client.Post(fmt.Sprintf("http://localhost:8080/nodes/%s/restart", nodeID), "application/json", nil)
-		if err != nil {
-			fmt.Printf("%s%s[✗] %sError: %v%s\n", NEON_RED, BOLD, NEON_PINK, err, NC)
-			os.Exit(1)
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			fmt.Printf("%s%s[✗] %sFailed to restart node: %s%s\n", NEON_RED, BOLD, NEON_PINK, string(body), NC)
+			fmt.Printf("%s%s[!] %sUsage: cli restart-node <node>%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
 			os.Exit(1)
 		}
+		_, err := c.Nodes().Restart(ctx, os.Args[2])
+		exitOnError("Failed to restart node", err)
 		fmt.Printf("%s%s[✓] %sNode restarted successfully%s\n", NEON_GREEN, BOLD, NEON_CYAN, NC)
 
 	case "delete-pod":
 		if len(os.Args) != 3 {
-			fmt.Printf("%s%s[!] %sUsage: cli delete-pod <podID>%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
-			os.Exit(1)
-		}
-		podID := os.Args[2]
-		req, err := http.NewRequest("DELETE", fmt.Sprintf("http://localhost:8080/pods/%s", podID), nil)
-		if err != nil {
-			fmt.Printf("%s%s[✗] %sError: %v%s\n", NEON_RED, BOLD, NEON_PINK, err, NC)
-			os.Exit(1)
-		}
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Printf("%s%s[✗] %sError: %v%s\n", NEON_RED, BOLD, NEON_PINK, err, NC)
-			os.Exit(1)
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			fmt.Printf("%s%s[✗] %sFailed to delete pod: %s%s\n", NEON_RED, BOLD, NEON_PINK, string(body), NC)
+			fmt.Printf("%s%s[!] %sUsage: cli delete-pod [namespace/]<pod>%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
 			os.Exit(1)
 		}
+		namespace, name := parseObjectRef(os.Args[2])
+		exitOnError("Failed to delete pod", c.Pods(namespace).Delete(ctx, name))
 		fmt.Printf("%s%s[✓] %sPod deleted successfully%s\n", NEON_GREEN, BOLD, NEON_CYAN, NC)
 
 	case "delete-node":
 		if len(os.Args) != 3 {
-			fmt.Printf("%s%s[!] %sUsage: cli delete-node <nodeID>%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
-			os.Exit(1)
-		}
-		nodeID := os.Args[2]
-		req, err := http.NewRequest("DELETE", fmt.Sprintf("http://localhost:8080/nodes/%s", nodeID), nil)
-		if err != nil {
-			fmt.Printf("%s%s[✗] %sError: %v%s\n", NEON_RED, BOLD, NEON_PINK, err, NC)
-			os.Exit(1)
-		}
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Printf("%s%s[✗] %sError: %v%s\n", NEON_RED, BOLD, NEON_PINK, err, NC)
-			os.Exit(1)
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			fmt.Printf("%s%s[✗] %sFailed to delete node: %s%s\n", NEON_RED, BOLD, NEON_PINK, string(body), NC)
+			fmt.Printf("%s%s[!] %sUsage: cli delete-node <node>%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
 			os.Exit(1)
 		}
+		exitOnError("Failed to delete node", c.Nodes().Delete(ctx, os.Args[2]))
 		fmt.Printf("%s%s[✓] %sNode deleted successfully%s\n", NEON_GREEN, BOLD, NEON_CYAN, NC)
 
 	case "launch-pod":
-		if len(os.Args) != 3 {
-			fmt.Printf("%s%s[!] %sUsage: cli launch-pod <cpuRequired>%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
+		if len(os.Args) < 3 {
+			fmt.Printf("%s%s[!] %sUsage: cli launch-pod <cpuRequired> [--labels k=v,...] [--command \"prog args...\"] [--env K=V,...] [--priority n]%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
 			os.Exit(1)
 		}
 		cpuRequired, err := strconv.Atoi(os.Args[2])
@@ -153,41 +116,22 @@ func main() {
 			fmt.Printf("%s%s[!] %scpuRequired must be a positive integer%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
 			os.Exit(1)
 		}
-		req := map[string]int{"cpuRequired": cpuRequired}
-		jsonData, _ := json.Marshal(req)
-		resp, err := client.Post("http://localhost:8080/pods", "application/json", bytes.NewBuffer(jsonData))
-		if err != nil {
-			fmt.Printf("%s%s[✗] %sError: %v%s\n", NEON_RED, BOLD, NEON_PINK, err, NC)
-			os.Exit(1)
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusCreated {
-			fmt.Printf("%s%s[✗] %sFailed to launch pod, status: %s%s\n", NEON_RED, BOLD, NEON_PINK, resp.Status, NC)
-			os.Exit(1)
-		}
-		fmt.Printf("%s%s[✓] %sPod launched successfully%s\n", NEON_GREEN, BOLD, NEON_CYAN, NC)
+		labels, command, env, priority := parsePodFlags(os.Args[3:])
+		pod, err := c.Pods(v1.DefaultNamespace).Create(ctx, &v1.Pod{
+			Metadata: v1.ObjectMeta{Labels: labels},
+			Spec:     v1.PodSpec{CPURequired: cpuRequired, Command: command, Env: env, Priority: priority},
+		})
+		exitOnError("Failed to launch pod", err)
+		fmt.Printf("%s%s[✓] %sPod %s/%s launched on node %s%s\n", NEON_GREEN, BOLD, NEON_CYAN, pod.Metadata.Namespace, pod.Metadata.Name, shortID(pod.Spec.NodeID), NC)
 
 	case "list-nodes":
-		resp, err := client.Get("http://localhost:8080/nodes")
-		if err != nil {
-			fmt.Printf("%s%s[✗] %sError: %v%s\n", NEON_RED, BOLD, NEON_PINK, err, NC)
-			os.Exit(1)
-		}
-		defer resp.Body.Close()
-		var nodes map[string]struct {
-			ID           string   `json:"ID"`
-			CPUCores     int      `json:"CPUCores"`
-			AvailableCPU int      `json:"AvailableCPU"`
-			Pods         []string `json:"Pods"`
-			HealthStatus string   `json:"HealthStatus"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&nodes); err != nil {
-			fmt.Printf("%s%s[✗] %sFailed to decode response: %v%s\n", NEON_RED, BOLD, NEON_PINK, err, NC)
-			os.Exit(1)
-		}
+		opts := parseListFlags("list-nodes", os.Args[2:])
+		nodes, err := c.Nodes().ListAll(ctx, opts)
+		exitOnError("Failed to list nodes", err)
 		for _, node := range nodes {
-			fmt.Printf("%s%s[*] %sNode %s: CPU %d/%d, Status: %s, Pods: %v%s\n",
-				NEON_BLUE, BOLD, NEON_CYAN, node.ID, node.AvailableCPU, node.CPUCores, node.HealthStatus, node.Pods, NC)
+			fmt.Printf("%s%s[*] %sNode %s: CPU %d/%d, Status: %s%s, Pods: %v%s%s\n",
+				NEON_BLUE, BOLD, NEON_CYAN, node.Metadata.Name, node.Status.AvailableCPU, node.Spec.CPUCores, node.Status.HealthStatus,
+				formatAbnormalConditions(node.Status.Conditions), node.Status.Pods, formatLabels(node.Metadata.Labels), NC)
 		}
 
 	case "set-scheduler":
@@ -201,62 +145,169 @@ func main() {
 			fmt.Printf("%s%s[!] %sInvalid algorithm. Must be one of: first-fit, best-fit, worst-fit, round-robin%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
 			os.Exit(1)
 		}
-		req := map[string]string{"algorithm": algorithm}
-		jsonData, _ := json.Marshal(req)
-		resp, err := client.Post("http://localhost:8080/scheduler", "application/json", bytes.NewBuffer(jsonData))
-		if err != nil {
-			fmt.Printf("%s%s[✗] %sError: %v%s\n", NEON_RED, BOLD, NEON_PINK, err, NC)
+		_, err := c.SetScheduler(ctx, algorithm)
+		exitOnError("Failed to set scheduler", err)
+		fmt.Printf("%s%s[✓] %sScheduler algorithm set to %s%s\n", NEON_GREEN, BOLD, NEON_CYAN, algorithm, NC)
+
+	case "list-pods":
+		opts := parseListFlags("list-pods", os.Args[2:])
+		pods, err := c.Pods("").ListAll(ctx, opts)
+		exitOnError("Failed to list pods", err)
+		if len(pods) == 0 {
+			fmt.Printf("%s%s[*] %sNo pods found%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+			return
+		}
+
+		fmt.Printf("%s%s[*] %sPods:%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+		for _, pod := range pods {
+			fmt.Printf("%s%s[*] %sPod %s/%s: CPU %d, Node %s, Status: %s, Created: %s%s%s\n",
+				NEON_BLUE, BOLD, NEON_CYAN, pod.Metadata.Namespace, pod.Metadata.Name, pod.Spec.CPURequired, shortID(pod.Spec.NodeID),
+				pod.Status.Phase, pod.Metadata.CreationTimestamp.Format(time.RFC3339), formatLabels(pod.Metadata.Labels), NC)
+		}
+
+	case "update-node":
+		if len(os.Args) != 4 {
+			fmt.Printf("%s%s[!] %sUsage: cli update-node <node> <cpuCores>%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
 			os.Exit(1)
 		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			fmt.Printf("%s%s[✗] %sFailed to set scheduler, status: %s%s\n", NEON_RED, BOLD, NEON_PINK, resp.Status, NC)
+		cpuCores, err := strconv.Atoi(os.Args[3])
+		if err != nil || cpuCores <= 0 {
+			fmt.Printf("%s%s[!] %scpuCores must be a positive integer%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
 			os.Exit(1)
 		}
-		fmt.Printf("%s%s[✓] %sScheduler algorithm set to %s%s\n", NEON_GREEN, BOLD, NEON_CYAN, algorithm, NC)
+		// The update carries the resourceVersion we read, so a concurrent
+		// modification is reported as a conflict instead of being overwritten.
+		node, err := c.Nodes().Get(ctx, os.Args[2])
+		exitOnError("Failed to get node", err)
+		node.Spec.CPUCores = cpuCores
+		_, err = c.Nodes().Update(ctx, node)
+		exitOnError("Failed to update node", err)
+		fmt.Printf("%s%s[✓] %sNode updated successfully%s\n", NEON_GREEN, BOLD, NEON_CYAN, NC)
 
-	case "list-pods":
-		resp, err := client.Get("http://localhost:8080/pods")
-		if err != nil {
-			fmt.Printf("%s%s[✗] %sError: %v%s\n", NEON_RED, BOLD, NEON_PINK, err, NC)
-			os.Exit(1)
+	case "simulate-usage":
+		if len(os.Args) != 3 && len(os.Args) != 4 {
+			fmt.Printf("%s%s[!] %sUsage: cli simulate-usage <node> [memory=N,disk=N,pids=N,network=down]%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
+			os.Exit(1)
+		}
+		node, err := c.Nodes().Get(ctx, os.Args[2])
+		exitOnError("Failed to get node", err)
+		if len(os.Args) == 4 {
+			if node.Metadata.Annotations == nil {
+				node.Metadata.Annotations = make(map[string]string)
+			}
+			node.Metadata.Annotations[v1.SimulatedUsageAnnotation] = os.Args[3]
+		} else {
+			delete(node.Metadata.Annotations, v1.SimulatedUsageAnnotation)
+		}
+		_, err = c.Nodes().Update(ctx, node)
+		exitOnError("Failed to update node", err)
+		if len(os.Args) == 4 {
+			fmt.Printf("%s%s[✓] %sNode %s now reports usage %s%s\n", NEON_GREEN, BOLD, NEON_CYAN, node.Metadata.Name, os.Args[3], NC)
+		} else {
+			fmt.Printf("%s%s[✓] %sNode %s reports its real usage again%s\n", NEON_GREEN, BOLD, NEON_CYAN, node.Metadata.Name, NC)
 		}
-		defer resp.Body.Close()
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Printf("%s%s[✗] %sError reading response: %v%s\n", NEON_RED, BOLD, NEON_PINK, err, NC)
+	case "update-pod":
+		if len(os.Args) != 4 {
+			fmt.Printf("%s%s[!] %sUsage: cli update-pod [namespace/]<pod> <cpuRequired>%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
 			os.Exit(1)
 		}
+		cpuRequired, err := strconv.Atoi(os.Args[3])
+		if err != nil || cpuRequired <= 0 {
+			fmt.Printf("%s%s[!] %scpuRequired must be a positive integer%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
+			os.Exit(1)
+		}
+		namespace, name := parseObjectRef(os.Args[2])
+		pod, err := c.Pods(namespace).Get(ctx, name)
+		exitOnError("Failed to get pod", err)
+		pod.Spec.CPURequired = cpuRequired
+		_, err = c.Pods(namespace).Update(ctx, pod)
+		exitOnError("Failed to update pod", err)
+		fmt.Printf("%s%s[✓] %sPod updated successfully%s\n", NEON_GREEN, BOLD, NEON_CYAN, NC)
+
+	case "apply":
+		applyManifests(ctx, c, os.Args[2:])
 
-		if resp.StatusCode != http.StatusOK {
-			fmt.Printf("%s%s[✗] %sError: %s%s\n", NEON_RED, BOLD, NEON_PINK, string(body), NC)
+	case "list-deployments":
+		opts := parseListFlags("list-deployments", os.Args[2:])
+		deployments, err := c.Deployments("").ListAll(ctx, opts)
+		exitOnError("Failed to list deployments", err)
+		for _, d := range deployments {
+			fmt.Printf("%s%s[*] %sDeployment %s/%s: Ready %d/%d, CPU per pod %d%s%s\n",
+				NEON_BLUE, BOLD, NEON_CYAN, d.Metadata.Namespace, d.Metadata.Name, d.Status.ReadyReplicas, d.Spec.Replicas,
+				d.Spec.Template.Spec.CPURequired, formatLabels(d.Metadata.Labels), NC)
+		}
+
+	case "delete-deployment":
+		if len(os.Args) != 3 {
+			fmt.Printf("%s%s[!] %sUsage: cli delete-deployment [namespace/]<name>%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
 			os.Exit(1)
 		}
+		namespace, name := parseObjectRef(os.Args[2])
+		exitOnError("Failed to delete deployment", c.Deployments(namespace).Delete(ctx, name))
+		fmt.Printf("%s%s[✓] %sDeployment deleted successfully%s\n", NEON_GREEN, BOLD, NEON_CYAN, NC)
 
-		var pods map[string]struct {
-			ID          string `json:"ID"`
-			CPURequired int    `json:"CPURequired"`
-			NodeID      string `json:"NodeID"`
-			Status      string `json:"Status"`
-			CreatedAt   string `json:"CreatedAt"`
+	case "get":
+		if len(os.Args) < 3 || os.Args[2] != "events" {
+			fmt.Printf("%s%s[!] %sUsage: cli get events [-n namespace] [--field-selector selector] [-w]%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
+			os.Exit(1)
 		}
+		getEvents(ctx, c, os.Args[3:])
 
-		if err := json.Unmarshal(body, &pods); err != nil {
-			fmt.Printf("%s%s[✗] %sError decoding response: %v%s\n", NEON_RED, BOLD, NEON_PINK, err, NC)
+	case "describe":
+		if len(os.Args) != 4 {
+			fmt.Printf("%s%s[!] %sUsage: cli describe node|pod|deployment [namespace/]<name>%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
 			os.Exit(1)
 		}
+		describe(ctx, c, os.Args[2], os.Args[3])
 
-		if len(pods) == 0 {
-			fmt.Printf("%s%s[*] %sNo pods found%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
-			return
+	case "whoami":
+		user, err := c.WhoAmI(ctx)
+		exitOnError("Failed to get identity", err)
+		fmt.Printf("%s%s[*] %sUser: %s%s\n", NEON_BLUE, BOLD, NEON_CYAN, user.Username, NC)
+		if user.UID != "" {
+			fmt.Printf("%s%s[*] %sUID: %s%s\n", NEON_BLUE, BOLD, NEON_CYAN, user.UID, NC)
+		}
+		fmt.Printf("%s%s[*] %sGroups: %s%s\n", NEON_BLUE, BOLD, NEON_CYAN, strings.Join(user.Groups, ", "), NC)
+
+	case "create-token":
+		if len(os.Args) < 3 {
+			fmt.Printf("%s%s[!] %sUsage: cli create-token [namespace/]<serviceaccount> [--duration 1h]%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
+			os.Exit(1)
+		}
+		fs := flag.NewFlagSet("create-token", flag.ExitOnError)
+		duration := fs.Duration("duration", 0, "how long the token is valid; it never expires when zero")
+		fs.Parse(os.Args[3:])
+		namespace, name := parseObjectRef(os.Args[2])
+		token, err := c.CreateToken(ctx, namespace, name, *duration)
+		exitOnError("Failed to create token", err)
+		fmt.Println(token.Status.Token)
+
+	case "auth":
+		if len(os.Args) < 5 || os.Args[2] != "can-i" {
+			fmt.Printf("%s%s[!] %sUsage: cli auth can-i <verb> <resource> [name] [-n namespace]%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
+			os.Exit(1)
 		}
+		canI(ctx, c, os.Args[3:])
 
-		fmt.Printf("%s%s[*] %sPods:%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
-		for _, pod := range pods {
-			fmt.Printf("%s%s[*] %sPod %s: CPU %d, Node %s, Status: %s, Created: %s%s\n",
-				NEON_BLUE, BOLD, NEON_CYAN, pod.ID[:8], pod.CPURequired, pod.NodeID[:8], pod.Status, pod.CreatedAt, NC)
+	case "trust-ca":
+		trustCA(ctx, cfg, os.Args[2:])
+
+	case "backup":
+		if len(os.Args) != 3 {
+			fmt.Printf("%s%s[!] %sUsage: cli backup <file|->%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
+			os.Exit(1)
 		}
+		backup(ctx, c, os.Args[2])
+
+	case "restore":
+		restore(ctx, c, os.Args[2:])
+
+	case "watch-nodes":
+		watchResource(c.Nodes().Watch)
+
+	case "watch-pods":
+		watchResource(c.Pods("").Watch)
 
 	default:
 		printUsage()
@@ -264,16 +315,575 @@ func main() {
 	}
 }
 
+// applyManifests sends the manifests named by -f to the server-side apply
+// endpoint and prints what happened to each object.
+func applyManifests(ctx context.Context, c *client.Client, args []string) {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	filename := fs.String("f", "", "manifest file or directory, or - for stdin")
+	prune := fs.Bool("prune", false, "delete previously applied objects missing from the manifests")
+	selector := fs.String("l", "", "only prune objects matching this label selector")
+	fs.Parse(args)
+	if *filename == "" {
+		fmt.Printf("%s%s[!] %sUsage: cli apply -f <file|dir|-> [--prune] [-l selector]%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
+		os.Exit(1)
+	}
+
+	body, err := readManifests(*filename)
+	if err != nil {
+		fmt.Printf("%s%s[✗] %sError reading manifests: %v%s\n", NEON_RED, BOLD, NEON_PINK, err, NC)
+		os.Exit(1)
+	}
+
+	result, err := c.Apply(ctx, body, "application/yaml", client.ApplyOptions{Prune: *prune, Selector: *selector})
+	exitOnError("Apply failed", err)
+
+	failed := false
+	for _, r := range result.Results {
+		name := strings.ToLower(r.Kind) + "/" + r.Name
+		if r.Namespace != "" {
+			name = strings.ToLower(r.Kind) + "/" + r.Namespace + "/" + r.Name
+		}
+		if r.Error != "" {
+			failed = true
+			fmt.Printf("%s%s[✗] %s%s: %s%s\n", NEON_RED, BOLD, NEON_PINK, name, r.Error, NC)
+			continue
+		}
+		fmt.Printf("%s%s[✓] %s%s %s%s\n", NEON_GREEN, BOLD, NEON_CYAN, name, r.Action, NC)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// backup saves a snapshot of the cluster to path, or stdout for -.
+func backup(ctx context.Context, c *client.Client, path string) {
+	snap, err := c.Snapshot(ctx)
+	exitOnError("Failed to take snapshot", err)
+	data, err := json.MarshalIndent(snap, "", "  ")
+	exitOnError("Failed to encode snapshot", err)
+	if path == "-" {
+		os.Stdout.Write(append(data, '\n'))
+		return
+	}
+	exitOnError("Failed to write snapshot", os.WriteFile(path, data, 0o600))
+	fmt.Printf("%s%s[✓] %sSaved %d objects at resourceVersion %d to %s%s\n",
+		NEON_GREEN, BOLD, NEON_CYAN, len(snap.Objects), snap.ResourceVersion, path, NC)
+}
+
+// restore replaces the cluster's objects with those of a saved snapshot.
+func restore(ctx context.Context, c *client.Client, args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	relaunch := fs.Bool("relaunch-nodes", false, "start fresh node containers instead of waiting for the existing ones to report")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Printf("%s%s[!] %sUsage: cli restore [--relaunch-nodes] <file|->%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
+		os.Exit(1)
+	}
+
+	var data []byte
+	var err error
+	if path := fs.Arg(0); path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	exitOnError("Failed to read snapshot", err)
+	var snap v1.ClusterSnapshot
+	exitOnError("Failed to decode snapshot", json.Unmarshal(data, &snap))
+
+	opts := client.RestoreOptions{Nodes: v1.RestoreNodesKeep}
+	if *relaunch {
+		opts.Nodes = v1.RestoreNodesRelaunch
+	}
+	result, err := c.Restore(ctx, &snap, opts)
+	exitOnError("Restore failed", err)
+	fmt.Printf("%s%s[✓] %sRestored %d objects from resourceVersion %d (deleted %d) at resourceVersion %d%s\n",
+		NEON_GREEN, BOLD, NEON_CYAN, result.Restored, result.SnapshotResourceVersion, result.Deleted, result.ResourceVersion, NC)
+	for _, name := range result.RelaunchedNodes {
+		fmt.Printf("%s%s[*] %sRelaunched node %s%s\n", NEON_BLUE, BOLD, NEON_CYAN, name, NC)
+	}
+	for _, msg := range result.Errors {
+		fmt.Printf("%s%s[✗] %sFailed to relaunch %s%s\n", NEON_RED, BOLD, NEON_PINK, msg, NC)
+	}
+	if len(result.Errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+// readManifests reads a manifest file, every .yaml, .yml and .json file in a
+// directory, or stdin, joining them into one YAML stream.
+func readManifests(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return os.ReadFile(path)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var docs [][]byte
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(path, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, data)
+	}
+	return bytes.Join(docs, []byte("\n---\n")), nil
+}
+
+// parseListFlags reads the -l and --field-selector flags of a list command.
+func parseListFlags(command string, args []string) client.ListOptions {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	labelSelector := fs.String("l", "", "label selector, e.g. 'tier=web,env in (prod,staging)'")
+	fieldSelector := fs.String("field-selector", "", "field selector, e.g. status.phase=Running")
+	fs.Parse(args)
+	return client.ListOptions{LabelSelector: *labelSelector, FieldSelector: *fieldSelector, Limit: listPageSize}
+}
+
+// exitOnError reports err and exits. Conflicts get their own message since
+// retrying the command is usually all it takes.
+func exitOnError(what string, err error) {
+	if err == nil {
+		return
+	}
+	if client.IsUnauthorized(err) {
+		fmt.Printf("%s%s[✗] %sUnauthorized: %v (check the credentials in %s)%s\n", NEON_RED, BOLD, NEON_PINK, err, client.DefaultConfigPath(), NC)
+		os.Exit(1)
+	}
+	if client.IsConflict(err) {
+		fmt.Printf("%s%s[!] %sConflict: %v%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, err, NC)
+		os.Exit(1)
+	}
+	fmt.Printf("%s%s[✗] %s%s: %v%s\n", NEON_RED, BOLD, NEON_PINK, what, err, NC)
+	os.Exit(1)
+}
+
+// parseObjectRef splits "namespace/name"; a bare name is in the default
+// namespace.
+func parseObjectRef(ref string) (string, string) {
+	if i := strings.Index(ref, "/"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return v1.DefaultNamespace, ref
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// parseLabelsFlag reads an optional --labels k=v,k2=v2 flag.
+func parseLabelsFlag(command string, args []string) map[string]string {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	labelsFlag := fs.String("labels", "", "comma separated key=value labels")
+	fs.Parse(args)
+
+	labels := make(map[string]string)
+	for _, kv := range parseKeyValues("label", *labelsFlag) {
+		labels[kv[0]] = kv[1]
+	}
+	return labels
+}
+
+// parsePodFlags reads the optional --labels, --command, --env and --priority
+// flags of launch-pod. The command is split on whitespace.
+func parsePodFlags(args []string) (map[string]string, []string, []v1.EnvVar, int) {
+	fs := flag.NewFlagSet("launch-pod", flag.ExitOnError)
+	labelsFlag := fs.String("labels", "", "comma separated key=value labels")
+	commandFlag := fs.String("command", "", "program and arguments the pod runs")
+	envFlag := fs.String("env", "", "comma separated NAME=value environment variables")
+	priority := fs.Int("priority", 0, "eviction priority; lower priority pods are evicted first")
+	fs.Parse(args)
+
+	labels := make(map[string]string)
+	for _, kv := range parseKeyValues("label", *labelsFlag) {
+		labels[kv[0]] = kv[1]
+	}
+	var env []v1.EnvVar
+	for _, kv := range parseKeyValues("environment variable", *envFlag) {
+		env = append(env, v1.EnvVar{Name: kv[0], Value: kv[1]})
+	}
+	return labels, strings.Fields(*commandFlag), env, *priority
+}
+
+// parseKeyValues splits a comma separated list of key=value pairs, in order,
+// exiting on a malformed one.
+func parseKeyValues(what, list string) [][2]string {
+	var pairs [][2]string
+	for _, pair := range strings.Split(list, ",") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			fmt.Printf("%s%s[!] %sInvalid %s %q, expected key=value%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, what, pair, NC)
+			os.Exit(1)
+		}
+		pairs = append(pairs, [2]string{kv[0], kv[1]})
+	}
+	return pairs
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return ", Labels: " + strings.Join(pairs, ",")
+}
+
+// watchResource streams changes to nodes or pods until interrupted. The
+// client resumes from the last seen resourceVersion when the connection
+// drops and starts over when the server reports that version as too old.
+func watchResource(watch client.WatchFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err := client.RetryWatch(ctx, watch, client.ListOptions{}, func(ev client.Event) error {
+		var name, status string
+		switch obj := ev.Object.(type) {
+		case *v1.Node:
+			name, status = obj.Metadata.Name, obj.Status.HealthStatus
+		case *v1.Pod:
+			name, status = obj.Metadata.Namespace+"/"+obj.Metadata.Name, obj.Status.Phase
+		}
+		color := NEON_BLUE
+		switch ev.Type {
+		case v1.EventAdded:
+			color = NEON_GREEN
+		case v1.EventDeleted:
+			color = NEON_RED
+		}
+		fmt.Printf("%s%s[%d] %s%-8s %s %s%s\n", color, BOLD, ev.ResourceVersion, NEON_CYAN, ev.Type, name, status, NC)
+		return nil
+	})
+	if err != nil && ctx.Err() == nil {
+		exitOnError("Watch failed", err)
+	}
+}
+
+// getEvents lists events, or streams them with -w until interrupted.
+func getEvents(ctx context.Context, c *client.Client, args []string) {
+	fs := flag.NewFlagSet("get events", flag.ExitOnError)
+	namespace := fs.String("n", "", "namespace; all namespaces when empty")
+	fieldSelector := fs.String("field-selector", "", "field selector, e.g. type=Warning,involvedObject.kind=Pod")
+	watch := fs.Bool("w", false, "watch for new events after listing")
+	fs.Parse(args)
+
+	if !*watch {
+		events, err := c.Events(*namespace).ListAll(ctx, client.ListOptions{FieldSelector: *fieldSelector, Limit: listPageSize})
+		exitOnError("Failed to list events", err)
+		printEvents(events)
+		return
+	}
+
+	// The watch starts by replaying the current events, so no list is needed.
+	watchCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	printEventHeader()
+	err := client.RetryWatch(watchCtx, c.Events(*namespace).Watch, client.ListOptions{FieldSelector: *fieldSelector}, func(ev client.Event) error {
+		if e, ok := ev.Object.(*v1.Event); ok && ev.Type != v1.EventDeleted {
+			printEventRow(*e)
+		}
+		return nil
+	})
+	if err != nil && watchCtx.Err() == nil {
+		exitOnError("Watch failed", err)
+	}
+}
+
+// trustCA fetches the server's CA bundle, checks it against the fingerprint
+// the server printed at startup, and makes the config trust it from then on.
+func trustCA(ctx context.Context, cfg *client.Config, args []string) {
+	fs := flag.NewFlagSet("trust-ca", flag.ExitOnError)
+	fingerprint := fs.String("fingerprint", "", "expected SHA-256 fingerprint of the CA; the bundle is refused if it differs")
+	fs.Parse(args)
+
+	path := client.DefaultConfigPath()
+	if path == "" {
+		exitOnError("Failed to find config", fmt.Errorf("no home directory and $KUBE_SIM_CONFIG is not set"))
+	}
+	// Nothing can be verified before the CA is known; the fingerprint check
+	// below is what makes the bundle trustworthy.
+	bootstrap := *cfg
+	bootstrap.CertificateAuthority, bootstrap.InsecureSkipTLSVerify = "", true
+	c, err := client.NewForConfig(&bootstrap)
+	exitOnError("Failed to load credentials", err)
+	bundle, err := c.CABundle(ctx)
+	exitOnError("Failed to fetch CA bundle", err)
+	got, err := client.BundleFingerprint(bundle)
+	exitOnError("Invalid CA bundle", err)
+	if *fingerprint != "" && !strings.EqualFold(strings.ReplaceAll(*fingerprint, ":", ""), got) {
+		exitOnError("Refusing CA bundle", fmt.Errorf("fingerprint %s does not match the expected %s", got, *fingerprint))
+	}
+
+	caFile := filepath.Join(filepath.Dir(path), "ca.crt")
+	exitOnError("Failed to write CA bundle", os.MkdirAll(filepath.Dir(caFile), 0o700))
+	exitOnError("Failed to write CA bundle", os.WriteFile(caFile, bundle, 0o644))
+	cfg.CertificateAuthority = caFile
+	cfg.InsecureSkipTLSVerify = false
+	exitOnError("Failed to save config", cfg.Save(path))
+	fmt.Printf("%s%s[✓] %sTrusting CA with SHA-256 fingerprint %s (saved to %s)%s\n", NEON_GREEN, BOLD, NEON_CYAN, got, caFile, NC)
+	if *fingerprint == "" {
+		fmt.Printf("%s%s[!] %sCompare this fingerprint with the one the API server printed at startup%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
+	}
+}
+
+// canI asks the server whether the configured user may perform an action,
+// printing yes or no and exiting non-zero for no so scripts can test it.
+func canI(ctx context.Context, c *client.Client, args []string) {
+	var positional []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positional, args = append(positional, args[0]), args[1:]
+	}
+	fs := flag.NewFlagSet("auth can-i", flag.ExitOnError)
+	namespace := fs.String("n", "", "namespace; the cluster scope when empty")
+	fs.Parse(args)
+	if len(positional) < 2 || len(positional) > 3 {
+		fmt.Printf("%s%s[!] %sUsage: cli auth can-i <verb> <resource> [name] [-n namespace]%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, NC)
+		os.Exit(1)
+	}
+
+	attrs := v1.ResourceAttributes{Verb: positional[0], Resource: positional[1], Namespace: *namespace}
+	if len(positional) == 3 {
+		attrs.Name = positional[2]
+	}
+	status, err := c.CanI(ctx, attrs)
+	exitOnError("Failed to check access", err)
+	if !status.Allowed {
+		fmt.Println("no")
+		os.Exit(1)
+	}
+	fmt.Println("yes")
+}
+
+// describe prints one object followed by the events recorded about it.
+func describe(ctx context.Context, c *client.Client, kind, ref string) {
+	var selector string
+	switch kind {
+	case "node":
+		node, err := c.Nodes().Get(ctx, ref)
+		exitOnError("Failed to get node", err)
+		fmt.Printf("%sName:%s           %s\n", BOLD, NC, node.Metadata.Name)
+		fmt.Printf("%sUID:%s            %s\n", BOLD, NC, node.Metadata.UID)
+		fmt.Printf("%sLabels:%s         %s\n", BOLD, NC, strings.TrimPrefix(formatLabels(node.Metadata.Labels), ", Labels: "))
+		fmt.Printf("%sCreated:%s        %s\n", BOLD, NC, node.Metadata.CreationTimestamp.Format(time.RFC3339))
+		fmt.Printf("%sCPU:%s            %d available of %d\n", BOLD, NC, node.Status.AvailableCPU, node.Spec.CPUCores)
+		fmt.Printf("%sStatus:%s         %s\n", BOLD, NC, node.Status.HealthStatus)
+		fmt.Printf("%sLast heartbeat:%s %s (%d received)\n", BOLD, NC, node.Status.LastHeartbeat.Format(time.RFC3339), node.Status.HeartbeatCount)
+		fmt.Printf("%sPods:%s           %d\n", BOLD, NC, len(node.Status.Pods))
+		agentVersion := node.Status.AgentVersion
+		if agentVersion == "" {
+			agentVersion = "<not registered>"
+		}
+		fmt.Printf("%sAgent:%s          %s\n", BOLD, NC, agentVersion)
+		fmt.Printf("%sUsage:%s          %s\n", BOLD, NC, formatUsage(node.Status.Usage))
+		printConditions(node.Status.Conditions)
+		selector = "involvedObject.kind=Node,involvedObject.name=" + node.Metadata.Name
+	case "pod":
+		namespace, name := parseObjectRef(ref)
+		pod, err := c.Pods(namespace).Get(ctx, name)
+		exitOnError("Failed to get pod", err)
+		fmt.Printf("%sName:%s      %s\n", BOLD, NC, pod.Metadata.Name)
+		fmt.Printf("%sNamespace:%s %s\n", BOLD, NC, pod.Metadata.Namespace)
+		fmt.Printf("%sUID:%s       %s\n", BOLD, NC, pod.Metadata.UID)
+		fmt.Printf("%sLabels:%s    %s\n", BOLD, NC, strings.TrimPrefix(formatLabels(pod.Metadata.Labels), ", Labels: "))
+		fmt.Printf("%sOwner:%s     %s\n", BOLD, NC, pod.Metadata.Owner)
+		fmt.Printf("%sCreated:%s   %s\n", BOLD, NC, pod.Metadata.CreationTimestamp.Format(time.RFC3339))
+		fmt.Printf("%sCPU:%s       %d\n", BOLD, NC, pod.Spec.CPURequired)
+		fmt.Printf("%sPriority:%s  %d\n", BOLD, NC, pod.Spec.Priority)
+		fmt.Printf("%sNode:%s      %s\n", BOLD, NC, shortID(pod.Spec.NodeID))
+		fmt.Printf("%sStatus:%s    %s\n", BOLD, NC, pod.Status.Phase)
+		fmt.Printf("%sCommand:%s   %s\n", BOLD, NC, formatCommand(pod.Spec.Command))
+		fmt.Printf("%sRestarts:%s  %d\n", BOLD, NC, pod.Status.RestartCount)
+		fmt.Printf("%sProcess:%s   %s\n", BOLD, NC, formatProcess(pod.Status.Process))
+		selector = "involvedObject.kind=Pod,involvedObject.namespace=" + namespace + ",involvedObject.name=" + name
+	case "deployment":
+		namespace, name := parseObjectRef(ref)
+		d, err := c.Deployments(namespace).Get(ctx, name)
+		exitOnError("Failed to get deployment", err)
+		fmt.Printf("%sName:%s         %s\n", BOLD, NC, d.Metadata.Name)
+		fmt.Printf("%sNamespace:%s    %s\n", BOLD, NC, d.Metadata.Namespace)
+		fmt.Printf("%sLabels:%s       %s\n", BOLD, NC, strings.TrimPrefix(formatLabels(d.Metadata.Labels), ", Labels: "))
+		fmt.Printf("%sCreated:%s      %s\n", BOLD, NC, d.Metadata.CreationTimestamp.Format(time.RFC3339))
+		fmt.Printf("%sSelector:%s     %s\n", BOLD, NC, strings.TrimPrefix(formatLabels(d.Spec.Selector), ", Labels: "))
+		fmt.Printf("%sReplicas:%s     %d desired, %d updated, %d ready\n", BOLD, NC, d.Spec.Replicas, d.Status.UpdatedReplicas, d.Status.ReadyReplicas)
+		fmt.Printf("%sCPU per pod:%s  %d\n", BOLD, NC, d.Spec.Template.Spec.CPURequired)
+		selector = "involvedObject.kind=Deployment,involvedObject.namespace=" + namespace + ",involvedObject.name=" + name
+	default:
+		fmt.Printf("%s%s[!] %sCannot describe %q; expected node, pod or deployment%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, kind, NC)
+		os.Exit(1)
+	}
+
+	events, err := c.Events("").ListAll(ctx, client.ListOptions{FieldSelector: selector, Limit: listPageSize})
+	exitOnError("Failed to list events", err)
+	fmt.Printf("%sEvents:%s\n", BOLD, NC)
+	if len(events) == 0 {
+		fmt.Println("  <none>")
+		return
+	}
+	printEvents(events)
+}
+
+func formatCommand(command []string) string {
+	if len(command) == 0 {
+		return "<stand-in>"
+	}
+	return strings.Join(command, " ")
+}
+
+// formatProcess summarizes a pod's process: its state, PID or exit code,
+// and resource usage.
+func formatProcess(p *v1.ProcessStatus) string {
+	if p == nil {
+		return "<none reported>"
+	}
+	var state string
+	switch {
+	case p.State == v1.ProcessRunning && p.PID == 0:
+		state = "Running (stand-in)"
+	case p.State == v1.ProcessRunning:
+		state = fmt.Sprintf("Running (pid %d)", p.PID)
+	case p.Message != "":
+		state = fmt.Sprintf("Exited (code %d: %s)", p.ExitCode, p.Message)
+	default:
+		state = fmt.Sprintf("Exited (code %d)", p.ExitCode)
+	}
+	since := p.StartedAt
+	if p.FinishedAt != nil {
+		since = *p.FinishedAt
+	}
+	return fmt.Sprintf("%s since %s, CPU %.2fs, memory %.1f MiB",
+		state, since.Format(time.RFC3339), p.CPUSeconds, float64(p.MemoryBytes)/(1<<20))
+}
+
+// formatUsage summarizes the usage a node's agent last reported.
+func formatUsage(u *v1.NodeUsage) string {
+	if u == nil {
+		return "<none reported>"
+	}
+	network := "up"
+	if u.NetworkUnavailable {
+		network = "down"
+	}
+	return fmt.Sprintf("memory %.1f%%, disk %.1f%%, pids %.1f%%, network %s", u.MemoryPercent, u.DiskPercent, u.PIDPercent, network)
+}
+
+// formatAbnormalConditions lists the conditions keeping new pods off a node
+// for list-nodes: Ready when not True, the others when True.
+func formatAbnormalConditions(conditions []v1.NodeCondition) string {
+	var abnormal []string
+	for _, c := range conditions {
+		if c.Type == v1.NodeReady && c.Status != v1.ConditionTrue {
+			abnormal = append(abnormal, "NotReady")
+		} else if c.Type != v1.NodeReady && c.Status == v1.ConditionTrue {
+			abnormal = append(abnormal, c.Type)
+		}
+	}
+	if len(abnormal) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(abnormal, ",") + ")"
+}
+
+// printConditions prints a node's conditions as a table.
+func printConditions(conditions []v1.NodeCondition) {
+	fmt.Printf("%sConditions:%s\n", BOLD, NC)
+	if len(conditions) == 0 {
+		fmt.Println("  <none reported>")
+		return
+	}
+	fmt.Printf("  %s%-18s %-7s %-20s %-28s %s%s\n", BOLD, "TYPE", "STATUS", "LAST TRANSITION", "REASON", "MESSAGE", NC)
+	for _, c := range conditions {
+		fmt.Printf("  %-18s %-7s %-20s %-28s %s\n", c.Type, c.Status, c.LastTransitionTime.Format(time.RFC3339), c.Reason, c.Message)
+	}
+}
+
+// printEvents prints events as a table, oldest last occurrence first.
+func printEvents(events []v1.Event) {
+	if len(events) == 0 {
+		fmt.Printf("%s%s[*] %sNo events found%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+		return
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].LastTimestamp.Before(events[j].LastTimestamp)
+	})
+	printEventHeader()
+	for _, e := range events {
+		printEventRow(e)
+	}
+}
+
+func printEventHeader() {
+	fmt.Printf("%s%-9s %-8s %-18s %-32s %-5s %s%s\n", BOLD, "LAST SEEN", "TYPE", "REASON", "OBJECT", "COUNT", "MESSAGE", NC)
+}
+
+func printEventRow(e v1.Event) {
+	color := NEON_CYAN
+	if e.Type == v1.EventTypeWarning {
+		color = NEON_ORANGE
+	}
+	object := strings.ToLower(e.InvolvedObject.Kind) + "/" + e.InvolvedObject.Name
+	fmt.Printf("%s%-9s %-8s %-18s %-32s %-5d %s%s\n", color, formatAge(time.Since(e.LastTimestamp)), e.Type, e.Reason, object, e.Count, e.Message, NC)
+}
+
+// formatAge renders d the way event ages are usually shown: 45s, 3m, 2h, 4d.
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
+
 func printUsage() {
 	fmt.Printf("%s%s[*] %sUsage: cli <command> [args]%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
 	fmt.Printf("%s%s[*] %sCommands:%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
-	fmt.Printf("%s%s[*] %s  add-node <cpuCores>     Add a new node with specified CPU cores%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
-	fmt.Printf("%s%s[*] %s  stop-node <nodeID>      Stop a node%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
-	fmt.Printf("%s%s[*] %s  restart-node <nodeID>   Restart a node%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
-	fmt.Printf("%s%s[*] %s  delete-pod <podID>      Delete a pod%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
-	fmt.Printf("%s%s[*] %s  delete-node <nodeID>    Delete a stopped node%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
-	fmt.Printf("%s%s[*] %s  launch-pod <cpuRequired> Launch a pod with specified CPU requirements%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
-	fmt.Printf("%s%s[*] %s  list-nodes              List all nodes with their health status%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
-	fmt.Printf("%s%s[*] %s  list-pods               List all pods with their details%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  add-node <cpuCores> [--labels k=v,...]  Add a new node with specified CPU cores%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  stop-node <node>        Stop a node%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  restart-node <node>     Restart a node%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  delete-pod [namespace/]<pod>  Delete a pod%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  delete-node <node>      Delete a stopped node%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  launch-pod <cpuRequired> [--labels k=v,...] [--command \"prog args...\"] [--env K=V,...] [--priority n]  Launch a pod with specified CPU requirements, running command%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  list-nodes [-l selector] [--field-selector selector]  List nodes with their health status%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  list-pods [-l selector] [--field-selector selector]   List pods with their details%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  update-node <node> <cpuCores>     Resize a node%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  update-pod [namespace/]<pod> <cpuRequired>  Change a pod's CPU request%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  simulate-usage <node> [memory=N,disk=N,pids=N,network=down]  Make a node act on simulated usage; no usage clears it%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  apply -f <file|dir|-> [--prune] [-l selector]  Create or update objects from YAML/JSON manifests%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  list-deployments [-l selector]       List deployments and their ready replicas%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  delete-deployment [namespace/]<name>  Delete a deployment and its pods%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  get events [-n namespace] [--field-selector selector] [-w]  List (and watch) events%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  describe node|pod|deployment [namespace/]<name>  Show an object and its events%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  whoami                  Show who the server authenticates you as%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  create-token [namespace/]<serviceaccount> [--duration 1h]  Issue a service account token%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  trust-ca [--fingerprint sha256]  Fetch and trust the server's CA bundle%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  auth can-i <verb> <resource> [name] [-n namespace]  Check whether you may perform an action%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  backup <file|->         Save a snapshot of every object and the scheduler config%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  restore [--relaunch-nodes] <file|->  Replace the cluster's objects with a saved snapshot%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  watch-nodes             Stream node changes as they happen%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
+	fmt.Printf("%s%s[*] %s  watch-pods              Stream pod changes as they happen%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
 	fmt.Printf("%s%s[*] %s  set-scheduler <algorithm> Change the scheduling algorithm (first-fit, best-fit, worst-fit, round-robin)%s\n", NEON_BLUE, BOLD, NEON_CYAN, NC)
 }
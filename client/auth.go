@@ -0,0 +1,44 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	v1 "example.com/m/api/v1"
+)
+
+// WhoAmI returns the identity the server authenticated the client as.
+func (c *Client) WhoAmI(ctx context.Context) (*v1.UserInfo, error) {
+	var user v1.UserInfo
+	if err := c.do(ctx, request{method: "GET", path: "/api/v1/whoami"}, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CreateToken issues a signed token for the service account name in
+// namespace, valid for ttl or forever when ttl is zero.
+func (c *Client) CreateToken(ctx context.Context, namespace, name string, ttl time.Duration) (*v1.TokenRequest, error) {
+	req, err := jsonRequest("POST", "/api/v1/namespaces/"+url.PathEscape(namespace)+"/serviceaccounts/"+url.PathEscape(name)+"/token",
+		v1.TokenRequest{Spec: v1.TokenRequestSpec{ExpirationSeconds: int64(ttl / time.Second)}})
+	if err != nil {
+		return nil, err
+	}
+	var out v1.TokenRequest
+	if err := c.do(ctx, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateNodeToken issues the token the agent of the node name authenticates
+// as. Its Metadata.UID is the ID the node is registered under, or the new
+// one the agent must register it under.
+func (c *Client) CreateNodeToken(ctx context.Context, name string) (*v1.TokenRequest, error) {
+	var out v1.TokenRequest
+	if err := c.do(ctx, request{method: "POST", path: "/api/v1/nodes/" + url.PathEscape(name) + "/token"}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+)
+
+// CABundle returns the PEM bundle the server hands out for verifying it.
+// Fetching it is how a client bootstraps trust, so it is usually called over
+// a connection that skips verification; check the result with
+// BundleFingerprint before relying on it.
+func (c *Client) CABundle(ctx context.Context) ([]byte, error) {
+	resp, err := c.send(ctx, request{method: "GET", path: "/api/v1/cabundle"})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// BundleFingerprint returns the hex SHA-256 fingerprint of the first
+// certificate in a PEM bundle, as the server logs it at startup.
+func BundleFingerprint(bundle []byte) (string, error) {
+	block, _ := pem.Decode(bundle)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("no certificate in CA bundle")
+	}
+	sum := sha256.Sum256(block.Bytes)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,339 @@
+// Package client is a Go client for the Kube-Sim API server's /api/v1
+// surface. It wraps every resource in typed methods, takes a context on each
+// call, retries requests the server could not serve, and turns error
+// responses into *v1.Status values.
+//
+//	c := client.New("http://localhost:8080")
+//	nodes, err := c.Nodes().List(ctx, client.ListOptions{LabelSelector: "zone=a"})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "example.com/m/api/v1"
+)
+
+const (
+	defaultRetries = 3
+	defaultBackoff = 200 * time.Millisecond
+	maxBackoff     = 5 * time.Second
+)
+
+// Client is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	token      string
+	retries    int
+	backoff    time.Duration
+}
+
+type Option func(*Client)
+
+// WithHTTPClient replaces the default HTTP client, e.g. to configure TLS.
+// Its Timeout should be zero, since watches stay open indefinitely; use the
+// context for deadlines instead.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithBearerToken authenticates every request with token.
+func WithBearerToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithRetries sets how many times a failed request is retried. Zero
+// disables retries.
+func WithRetries(n int) Option {
+	return func(c *Client) { c.retries = n }
+}
+
+// WithBackoff sets the delay before the first retry; it doubles with every
+// further attempt.
+func WithBackoff(d time.Duration) Option {
+	return func(c *Client) { c.backoff = d }
+}
+
+// New returns a client for the server at baseURL, e.g.
+// "http://localhost:8080".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{},
+		retries:    defaultRetries,
+		backoff:    defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// With returns a copy of c with opts applied. The copy shares c's HTTP
+// client, so many clients with their own tokens can share connections.
+func (c *Client) With(opts ...Option) *Client {
+	copied := *c
+	for _, opt := range opts {
+		opt(&copied)
+	}
+	return &copied
+}
+
+// ListOptions filters and pages list and watch requests.
+type ListOptions struct {
+	LabelSelector string
+	FieldSelector string
+	// Limit caps the number of items per page; Continue fetches the page
+	// after the one that returned it.
+	Limit    int
+	Continue string
+	// ResourceVersion makes a watch resume after the given version instead
+	// of starting with the current state.
+	ResourceVersion uint64
+	// TimeoutSeconds makes the server end a watch after this long.
+	TimeoutSeconds int
+}
+
+func (o ListOptions) query() url.Values {
+	q := url.Values{}
+	if o.LabelSelector != "" {
+		q.Set("labelSelector", o.LabelSelector)
+	}
+	if o.FieldSelector != "" {
+		q.Set("fieldSelector", o.FieldSelector)
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Continue != "" {
+		q.Set("continue", o.Continue)
+	}
+	if o.ResourceVersion > 0 {
+		q.Set("resourceVersion", strconv.FormatUint(o.ResourceVersion, 10))
+	}
+	if o.TimeoutSeconds > 0 {
+		q.Set("timeoutSeconds", strconv.Itoa(o.TimeoutSeconds))
+	}
+	return q
+}
+
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	body        []byte
+	contentType string
+}
+
+// jsonRequest builds a request whose body is obj encoded as JSON.
+func jsonRequest(method, path string, obj interface{}) (request, error) {
+	req := request{method: method, path: path}
+	if obj != nil {
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return req, err
+		}
+		req.body = data
+		req.contentType = "application/json"
+	}
+	return req, nil
+}
+
+// do sends req and decodes a successful response into out, if given.
+func (c *Client) do(ctx context.Context, req request, out interface{}) error {
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding %s %s response: %w", req.method, req.path, err)
+	}
+	return nil
+}
+
+// send performs req and returns the response if the server answered 2xx.
+// Anything else is returned as a *v1.Status error after retrying where that
+// is safe: requests the server turned away (429, 503) are always retried,
+// and idempotent requests are also retried after network errors and gateway
+// failures.
+func (c *Client) send(ctx context.Context, req request) (*http.Response, error) {
+	target := c.baseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+
+	for attempt := 0; ; attempt++ {
+		httpReq, err := http.NewRequestWithContext(ctx, req.method, target, bytes.NewReader(req.body))
+		if err != nil {
+			return nil, err
+		}
+		if req.contentType != "" {
+			httpReq.Header.Set("Content-Type", req.contentType)
+		}
+		httpReq.Header.Set("Accept", "application/json")
+		if c.token != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+c.token)
+		}
+
+		resp, err := c.httpClient.Do(httpReq)
+		if err == nil && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		retry := false
+		var retryAfter time.Duration
+		if err != nil {
+			retry = idempotent(req.method)
+		} else {
+			retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+			switch resp.StatusCode {
+			case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+				retry = true
+			case http.StatusBadGateway, http.StatusGatewayTimeout:
+				retry = idempotent(req.method)
+			}
+			err = decodeStatus(resp)
+		}
+		if !retry || attempt >= c.retries || ctx.Err() != nil {
+			return nil, err
+		}
+
+		delay := c.backoff << attempt
+		if delay > maxBackoff {
+			delay = maxBackoff
+		}
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func idempotent(method string) bool {
+	return method == "GET" || method == "PUT" || method == "DELETE"
+}
+
+func parseRetryAfter(v string) time.Duration {
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return 0
+}
+
+// decodeStatus reads an error response into a *v1.Status, synthesising one
+// when the body is not a Status. It closes the body.
+func decodeStatus(resp *http.Response) error {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	var status v1.Status
+	if err := json.Unmarshal(body, &status); err == nil && status.Kind == "Status" {
+		if status.Code == 0 {
+			status.Code = resp.StatusCode
+		}
+		return &status
+	}
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		message = resp.Status
+	}
+	return &v1.Status{
+		TypeMeta: v1.TypeMeta{APIVersion: v1.APIVersion, Kind: "Status"},
+		Status:   v1.StatusFailure,
+		Message:  message,
+		Reason:   v1.ReasonForCode(resp.StatusCode),
+		Code:     resp.StatusCode,
+	}
+}
+
+// GetScheduler returns the current scheduling algorithm.
+func (c *Client) GetScheduler(ctx context.Context) (*v1.SchedulerConfig, error) {
+	var config v1.SchedulerConfig
+	if err := c.do(ctx, request{method: "GET", path: "/api/v1/scheduler"}, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// SetScheduler switches the scheduling algorithm.
+func (c *Client) SetScheduler(ctx context.Context, algorithm string) (*v1.SchedulerConfig, error) {
+	req, err := jsonRequest("PUT", "/api/v1/scheduler", v1.SchedulerConfig{Algorithm: algorithm})
+	if err != nil {
+		return nil, err
+	}
+	var config v1.SchedulerConfig
+	if err := c.do(ctx, req, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// RegisterNode declares a node and its capacity, creating the node or
+// updating the one already registered, and returns it.
+func (c *Client) RegisterNode(ctx context.Context, reg v1.NodeRegistration) (*v1.Node, error) {
+	req, err := jsonRequest("POST", "/api/v1/registration", reg)
+	if err != nil {
+		return nil, err
+	}
+	var node v1.Node
+	if err := c.do(ctx, req, &node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// Heartbeat reports a node's liveness and returns the pods it should run.
+func (c *Client) Heartbeat(ctx context.Context, hb v1.HeartbeatRequest) (*v1.HeartbeatResponse, error) {
+	req, err := jsonRequest("POST", "/api/v1/heartbeat", hb)
+	if err != nil {
+		return nil, err
+	}
+	var resp v1.HeartbeatResponse
+	if err := c.do(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ApplyOptions controls pruning during apply.
+type ApplyOptions struct {
+	// Prune deletes previously applied objects missing from the manifests.
+	Prune bool
+	// Selector restricts pruning to objects with matching labels.
+	Selector string
+}
+
+// Apply sends YAML or JSON manifests for server-side apply. contentType is
+// "application/yaml" or "application/json".
+func (c *Client) Apply(ctx context.Context, manifests []byte, contentType string, opts ApplyOptions) (*v1.ApplyResponse, error) {
+	q := url.Values{}
+	if opts.Prune {
+		q.Set("prune", "true")
+	}
+	if opts.Selector != "" {
+		q.Set("selector", opts.Selector)
+	}
+	req := request{method: "POST", path: "/api/v1/apply", query: q, body: manifests, contentType: contentType}
+	var resp v1.ApplyResponse
+	if err := c.do(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
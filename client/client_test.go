@@ -0,0 +1,257 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "example.com/m/api/v1"
+)
+
+// testServer serves handler and returns a client for it that retries
+// without waiting.
+func testServer(t *testing.T, handler http.HandlerFunc, opts ...Option) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return New(srv.URL+"/", append([]Option{WithBackoff(time.Millisecond)}, opts...)...)
+}
+
+func writeStatus(w http.ResponseWriter, code int, reason v1.StatusReason, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v1.Status{
+		TypeMeta: v1.TypeMeta{APIVersion: v1.APIVersion, Kind: "Status"},
+		Status:   v1.StatusFailure,
+		Message:  message,
+		Reason:   reason,
+	})
+}
+
+func TestClientSendsTokenAndDecodesStatus(t *testing.T) {
+	var auth string
+	c := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if r.URL.Path != "/api/v1/namespaces/default/pods/web" {
+			t.Errorf("request for %s", r.URL.Path)
+		}
+		writeStatus(w, http.StatusNotFound, v1.StatusReasonNotFound, `pod "web" not found`)
+	}, WithBearerToken("secret"))
+
+	_, err := c.Pods("").Get(context.Background(), "web")
+	if !IsNotFound(err) {
+		t.Fatalf("got %v, want NotFound", err)
+	}
+	var status *v1.Status
+	if !errors.As(err, &status) || status.Code != http.StatusNotFound || status.Message != `pod "web" not found` {
+		t.Errorf("status is %+v", status)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("Authorization is %q", auth)
+	}
+}
+
+func TestClientSynthesisesStatusForPlainErrors(t *testing.T) {
+	c := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "conflicting write", http.StatusConflict)
+	})
+	_, err := c.Nodes().Get(context.Background(), "worker-1")
+	if !IsConflict(err) {
+		t.Fatalf("got %v, want Conflict", err)
+	}
+	if ReasonForError(fmt.Errorf("dial: refused")) != "" {
+		t.Error("a network error has a reason")
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	for _, tc := range []struct {
+		method   string
+		code     int
+		attempts int32
+	}{
+		// Shed requests are retried whatever the method; gateway
+		// failures only when repeating the request is safe.
+		{"POST", http.StatusServiceUnavailable, 4},
+		{"POST", http.StatusTooManyRequests, 4},
+		{"GET", http.StatusBadGateway, 4},
+		{"POST", http.StatusBadGateway, 1},
+		{"GET", http.StatusBadRequest, 1},
+	} {
+		var attempts atomic.Int32
+		c := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			w.WriteHeader(tc.code)
+		})
+		var err error
+		if tc.method == "GET" {
+			_, err = c.Nodes().Get(context.Background(), "worker-1")
+		} else {
+			_, err = c.Pods("").Create(context.Background(), &v1.Pod{})
+		}
+		if err == nil {
+			t.Errorf("%s answered %d: no error", tc.method, tc.code)
+		}
+		if got := attempts.Load(); got != tc.attempts {
+			t.Errorf("%s answered %d: %d attempts, want %d", tc.method, tc.code, got, tc.attempts)
+		}
+	}
+
+	// A request that succeeds on a retry returns its response.
+	var attempts atomic.Int32
+	c := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			writeStatus(w, http.StatusTooManyRequests, v1.StatusReasonTooManyRequests, "slow down")
+			return
+		}
+		json.NewEncoder(w).Encode(v1.Node{Metadata: v1.ObjectMeta{Name: "worker-1"}})
+	})
+	node, err := c.Nodes().Get(context.Background(), "worker-1")
+	if err != nil || node.Metadata.Name != "worker-1" {
+		t.Fatalf("after two 429s: %+v, %v", node, err)
+	}
+
+	c = testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, http.StatusTooManyRequests, v1.StatusReasonTooManyRequests, "slow down")
+	}, WithRetries(0))
+	if _, err := c.Nodes().Get(context.Background(), "worker-1"); !IsTooManyRequests(err) {
+		t.Errorf("without retries: got %v, want TooManyRequests", err)
+	}
+}
+
+func TestListAllFollowsContinueTokens(t *testing.T) {
+	c := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("labelSelector") != "app=web" || q.Get("limit") != "2" {
+			t.Errorf("query is %s", r.URL.RawQuery)
+		}
+		list := v1.PodList{}
+		switch q.Get("continue") {
+		case "":
+			list.Items = []v1.Pod{{Metadata: v1.ObjectMeta{Name: "a"}}, {Metadata: v1.ObjectMeta{Name: "b"}}}
+			list.Metadata.Continue = "page-2"
+		case "page-2":
+			list.Items = []v1.Pod{{Metadata: v1.ObjectMeta{Name: "c"}}}
+		default:
+			t.Errorf("continue is %q", q.Get("continue"))
+		}
+		json.NewEncoder(w).Encode(list)
+	})
+	pods, err := c.Pods("").ListAll(context.Background(), ListOptions{LabelSelector: "app=web", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, pod := range pods {
+		names = append(names, pod.Metadata.Name)
+	}
+	if fmt.Sprint(names) != "[a b c]" {
+		t.Errorf("listed %v, want [a b c]", names)
+	}
+}
+
+// watchEvents writes events as a watch stream.
+func watchEvents(w http.ResponseWriter, events ...v1.WatchEvent) {
+	enc := json.NewEncoder(w)
+	for _, ev := range events {
+		enc.Encode(ev)
+	}
+}
+
+func podEvent(typ, name string, rv uint64) v1.WatchEvent {
+	obj, _ := json.Marshal(v1.Pod{Metadata: v1.ObjectMeta{Name: name}})
+	return v1.WatchEvent{Type: typ, ResourceVersion: rv, Object: obj}
+}
+
+func TestRetryWatchResumesAndStartsOverWhenExpired(t *testing.T) {
+	var calls atomic.Int32
+	var versions []string
+	c := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") != "true" {
+			t.Errorf("query is %s", r.URL.RawQuery)
+		}
+		versions = append(versions, r.URL.Query().Get("resourceVersion"))
+		switch calls.Add(1) {
+		case 1:
+			watchEvents(w, podEvent("ADDED", "a", 5), podEvent("MODIFIED", "a", 7))
+		case 2:
+			writeStatus(w, http.StatusGone, v1.StatusReasonExpired, "too old")
+		default:
+			watchEvents(w, podEvent("ADDED", "a", 9), podEvent("DELETED", "a", 10))
+		}
+	})
+
+	var seen []string
+	done := errors.New("done")
+	err := RetryWatch(context.Background(), c.Pods("").Watch, ListOptions{}, func(ev Event) error {
+		seen = append(seen, fmt.Sprintf("%s %s %d", ev.Type, ev.Object.(*v1.Pod).Metadata.Name, ev.ResourceVersion))
+		if ev.Type == "DELETED" {
+			return done
+		}
+		return nil
+	})
+	if err != done {
+		t.Fatalf("RetryWatch returned %v", err)
+	}
+	if want := "[ADDED a 5 MODIFIED a 7 ADDED a 9 DELETED a 10]"; fmt.Sprint(seen) != want {
+		t.Errorf("events %v, want %s", seen, want)
+	}
+	// The dropped stream resumes after version 7; once that has expired the
+	// watch starts over.
+	if want := "[ 7 ]"; fmt.Sprint(versions) != want {
+		t.Errorf("watched from versions %q, want %s", versions, want)
+	}
+}
+
+func TestRetryWatchReturnsServerErrors(t *testing.T) {
+	c := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, http.StatusBadRequest, v1.StatusReasonBadRequest, "invalid selector")
+	})
+	err := RetryWatch(context.Background(), c.Pods("").Watch, ListOptions{LabelSelector: "!!"}, func(Event) error { return nil })
+	if ReasonForError(err) != v1.StatusReasonBadRequest {
+		t.Errorf("got %v, want BadRequest", err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config")
+	saved := &Config{Server: "https://api:8443", Token: "secret", CertificateAuthority: "ca.crt"}
+	if err := saved.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server != "https://api:8443" || cfg.Token != "secret" || cfg.CertificateAuthority != filepath.Join(dir, "ca.crt") {
+		t.Errorf("loaded %+v", cfg)
+	}
+
+	// A missing default file means the default server; a missing named
+	// file is an error.
+	t.Setenv("KUBE_SIM_CONFIG", filepath.Join(dir, "missing"))
+	if cfg, err := LoadConfig(""); err != nil || cfg.Server != DefaultServer {
+		t.Errorf("without a config file: %+v, %v", cfg, err)
+	}
+	if _, err := LoadConfig(filepath.Join(dir, "missing")); err == nil {
+		t.Error("loading a missing named config succeeded")
+	}
+	if _, err := NewForConfig(cfg); err == nil {
+		t.Error("a client for a missing CA file was made")
+	}
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("loading a malformed config succeeded")
+	}
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// DefaultServer is used when a config does not name a server.
+const DefaultServer = "http://localhost:8080"
+
+// Config says how to reach and authenticate to a server. It is what the CLI
+// reads from its config file, a JSON document such as
+//
+//	{
+//	  "server": "https://localhost:8080",
+//	  "token": "...",
+//	  "certificateAuthority": "ca.crt"
+//	}
+//
+// Relative file paths are resolved against the config file's directory.
+type Config struct {
+	Server string `json:"server,omitempty"`
+	// Token is sent as a bearer token.
+	Token string `json:"token,omitempty"`
+	// ClientCertificate and ClientKey are PEM files presented for mutual
+	// TLS.
+	ClientCertificate string `json:"clientCertificate,omitempty"`
+	ClientKey         string `json:"clientKey,omitempty"`
+	// CertificateAuthority is a PEM bundle used to verify the server instead
+	// of the system roots.
+	CertificateAuthority  string `json:"certificateAuthority,omitempty"`
+	InsecureSkipTLSVerify bool   `json:"insecureSkipTLSVerify,omitempty"`
+}
+
+// DefaultConfigPath is $KUBE_SIM_CONFIG, or ~/.kube-sim/config.
+func DefaultConfigPath() string {
+	if path := os.Getenv("KUBE_SIM_CONFIG"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".kube-sim", "config")
+}
+
+// LoadConfig reads the config file at path, or at DefaultConfigPath when path
+// is empty. A missing default file is not an error: the result then talks to
+// DefaultServer without credentials.
+func LoadConfig(path string) (*Config, error) {
+	explicit := path != ""
+	if !explicit {
+		path = DefaultConfigPath()
+	}
+	cfg := &Config{}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("parsing config %s: %w", path, err)
+		}
+		dir := filepath.Dir(path)
+		for _, file := range []*string{&cfg.ClientCertificate, &cfg.ClientKey, &cfg.CertificateAuthority} {
+			if *file != "" && !filepath.IsAbs(*file) {
+				*file = filepath.Join(dir, *file)
+			}
+		}
+	case explicit || !errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	if cfg.Server == "" {
+		cfg.Server = DefaultServer
+	}
+	return cfg, nil
+}
+
+// Save writes cfg to path, creating its directory.
+func (cfg *Config) Save(path string) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// NewForConfig returns a client for cfg. opts apply after the config, so
+// they can override it.
+func NewForConfig(cfg *Config, opts ...Option) (*Client, error) {
+	server := cfg.Server
+	if server == "" {
+		server = DefaultServer
+	}
+	var configOpts []Option
+	if cfg.Token != "" {
+		configOpts = append(configOpts, WithBearerToken(cfg.Token))
+	}
+
+	if cfg.ClientCertificate != "" || cfg.CertificateAuthority != "" || cfg.InsecureSkipTLSVerify {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: cfg.InsecureSkipTLSVerify}
+		if cfg.ClientCertificate != "" {
+			cert, err := tls.LoadX509KeyPair(cfg.ClientCertificate, cfg.ClientKey)
+			if err != nil {
+				return nil, fmt.Errorf("loading client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		if cfg.CertificateAuthority != "" {
+			data, err := os.ReadFile(cfg.CertificateAuthority)
+			if err != nil {
+				return nil, fmt.Errorf("reading certificate authority: %w", err)
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("no certificates found in %s", cfg.CertificateAuthority)
+			}
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		configOpts = append(configOpts, WithHTTPClient(&http.Client{Transport: transport}))
+	}
+	return New(server, append(configOpts, opts...)...), nil
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+
+	v1 "example.com/m/api/v1"
+)
+
+// DeploymentClient manages the deployments of one namespace. With an empty
+// namespace, List and Watch cover all namespaces and the other methods use
+// "default".
+type DeploymentClient struct {
+	c         *Client
+	namespace string
+}
+
+func (c *Client) Deployments(namespace string) *DeploymentClient {
+	return &DeploymentClient{c: c, namespace: namespace}
+}
+
+func (d *DeploymentClient) collectionPath() string {
+	if d.namespace == "" {
+		return "/api/v1/deployments"
+	}
+	return "/api/v1/namespaces/" + url.PathEscape(d.namespace) + "/deployments"
+}
+
+func (d *DeploymentClient) deploymentPath(name string) string {
+	namespace := d.namespace
+	if namespace == "" {
+		namespace = v1.DefaultNamespace
+	}
+	return "/api/v1/namespaces/" + url.PathEscape(namespace) + "/deployments/" + url.PathEscape(name)
+}
+
+// List returns one page of deployments.
+func (d *DeploymentClient) List(ctx context.Context, opts ListOptions) (*v1.DeploymentList, error) {
+	var list v1.DeploymentList
+	err := d.c.do(ctx, request{method: "GET", path: d.collectionPath(), query: opts.query()}, &list)
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// ListAll follows continue tokens and returns every matching deployment.
+// opts.Limit sets the page size.
+func (d *DeploymentClient) ListAll(ctx context.Context, opts ListOptions) ([]v1.Deployment, error) {
+	var items []v1.Deployment
+	for {
+		list, err := d.List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, list.Items...)
+		if list.Metadata.Continue == "" {
+			return items, nil
+		}
+		opts.Continue = list.Metadata.Continue
+	}
+}
+
+func (d *DeploymentClient) Get(ctx context.Context, name string) (*v1.Deployment, error) {
+	var deployment v1.Deployment
+	if err := d.c.do(ctx, request{method: "GET", path: d.deploymentPath(name)}, &deployment); err != nil {
+		return nil, err
+	}
+	return &deployment, nil
+}
+
+func (d *DeploymentClient) Create(ctx context.Context, deployment *v1.Deployment) (*v1.Deployment, error) {
+	namespace := d.namespace
+	if namespace == "" {
+		namespace = v1.DefaultNamespace
+	}
+	return d.write(ctx, "POST", "/api/v1/namespaces/"+url.PathEscape(namespace)+"/deployments", deployment)
+}
+
+// Update replaces the replicas, template, labels and annotations of a
+// deployment. It must carry the resourceVersion it was read at.
+func (d *DeploymentClient) Update(ctx context.Context, deployment *v1.Deployment) (*v1.Deployment, error) {
+	return d.write(ctx, "PUT", d.deploymentPath(deployment.Metadata.Name), deployment)
+}
+
+// Delete removes the deployment together with its pods.
+func (d *DeploymentClient) Delete(ctx context.Context, name string) error {
+	return d.c.do(ctx, request{method: "DELETE", path: d.deploymentPath(name)}, nil)
+}
+
+// Watch streams deployment changes. Event objects are *v1.Deployment.
+func (d *DeploymentClient) Watch(ctx context.Context, opts ListOptions) (*Watcher, error) {
+	return d.c.watch(ctx, d.collectionPath(), opts, func(raw json.RawMessage) (interface{}, error) {
+		var deployment v1.Deployment
+		err := json.Unmarshal(raw, &deployment)
+		return &deployment, err
+	})
+}
+
+func (d *DeploymentClient) write(ctx context.Context, method, path string, in *v1.Deployment) (*v1.Deployment, error) {
+	req, err := jsonRequest(method, path, in)
+	if err != nil {
+		return nil, err
+	}
+	var out v1.Deployment
+	if err := d.c.do(ctx, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"errors"
+
+	v1 "example.com/m/api/v1"
+)
+
+// ReasonForError returns the reason of a *v1.Status error, or "" for errors
+// that did not come from the server.
+func ReasonForError(err error) v1.StatusReason {
+	var status *v1.Status
+	if errors.As(err, &status) {
+		return status.Reason
+	}
+	return ""
+}
+
+func IsNotFound(err error) bool {
+	return ReasonForError(err) == v1.StatusReasonNotFound
+}
+
+func IsAlreadyExists(err error) bool {
+	return ReasonForError(err) == v1.StatusReasonAlreadyExists
+}
+
+// IsConflict reports an update rejected because the object changed since it
+// was read; get it again and retry.
+func IsConflict(err error) bool {
+	return ReasonForError(err) == v1.StatusReasonConflict
+}
+
+// IsUnauthorized reports a request whose credentials were missing or not
+// accepted.
+func IsUnauthorized(err error) bool {
+	return ReasonForError(err) == v1.StatusReasonUnauthorized
+}
+
+// IsForbidden reports a request refused by policy, such as an admission
+// plugin or quota.
+func IsForbidden(err error) bool {
+	return ReasonForError(err) == v1.StatusReasonForbidden
+}
+
+// IsTooManyRequests reports a request the server shed because the client
+// exceeded its rate limit or the server was saturated. The client has already
+// retried it, honouring Retry-After.
+func IsTooManyRequests(err error) bool {
+	return ReasonForError(err) == v1.StatusReasonTooManyRequests
+}
+
+func IsInvalid(err error) bool {
+	return ReasonForError(err) == v1.StatusReasonInvalid
+}
+
+// IsNotRegistered reports a heartbeat for a node the server does not know,
+// after it lost or deleted it; register the node again.
+func IsNotRegistered(err error) bool {
+	return ReasonForError(err) == v1.StatusReasonNotRegistered
+}
+
+// IsExpired reports a watch whose resourceVersion has been compacted away;
+// the client has to start again from the current state.
+func IsExpired(err error) bool {
+	return ReasonForError(err) == v1.StatusReasonExpired
+}
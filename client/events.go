@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+
+	v1 "example.com/m/api/v1"
+)
+
+// EventClient reads the events of one namespace, or of all namespaces when
+// the namespace is empty. Events about nodes are in "default". Events are
+// recorded by the server and cannot be written.
+type EventClient struct {
+	c         *Client
+	namespace string
+}
+
+func (c *Client) Events(namespace string) *EventClient {
+	return &EventClient{c: c, namespace: namespace}
+}
+
+func (e *EventClient) collectionPath() string {
+	if e.namespace == "" {
+		return "/api/v1/events"
+	}
+	return "/api/v1/namespaces/" + url.PathEscape(e.namespace) + "/events"
+}
+
+// List returns one page of events, ordered within the page by last
+// occurrence. Use a field selector such as
+// "involvedObject.kind=Pod,involvedObject.name=web" to get the events about
+// one object.
+func (e *EventClient) List(ctx context.Context, opts ListOptions) (*v1.EventList, error) {
+	var list v1.EventList
+	err := e.c.do(ctx, request{method: "GET", path: e.collectionPath(), query: opts.query()}, &list)
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// ListAll follows continue tokens and returns every matching event.
+// opts.Limit sets the page size.
+func (e *EventClient) ListAll(ctx context.Context, opts ListOptions) ([]v1.Event, error) {
+	var items []v1.Event
+	for {
+		list, err := e.List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, list.Items...)
+		if list.Metadata.Continue == "" {
+			return items, nil
+		}
+		opts.Continue = list.Metadata.Continue
+	}
+}
+
+// Watch streams new and repeated events. Event objects are *v1.Event.
+func (e *EventClient) Watch(ctx context.Context, opts ListOptions) (*Watcher, error) {
+	return e.c.watch(ctx, e.collectionPath(), opts, func(raw json.RawMessage) (interface{}, error) {
+		var event v1.Event
+		err := json.Unmarshal(raw, &event)
+		return &event, err
+	})
+}
@@ -0,0 +1,59 @@
+package informer
+
+import v1 "example.com/m/api/v1"
+
+// Names of the indexes every Informer maintains.
+const (
+	NamespaceIndex = "namespace"
+	NodeIndex      = "node"
+	LabelIndex     = "label"
+	OwnerIndex     = "owner"
+)
+
+// IndexFunc returns the values obj is indexed under; nil leaves it out of
+// the index.
+type IndexFunc func(obj v1.Object) []string
+
+// Key identifies an object in the cache: "namespace/name" for namespaced
+// objects and "name" for nodes.
+func Key(obj v1.Object) string {
+	meta := obj.GetObjectMeta()
+	if meta.Namespace == "" {
+		return meta.Name
+	}
+	return meta.Namespace + "/" + meta.Name
+}
+
+func IndexByNamespace(obj v1.Object) []string {
+	if ns := obj.GetObjectMeta().Namespace; ns != "" {
+		return []string{ns}
+	}
+	return nil
+}
+
+// IndexByNode indexes pods by the ID of the node they are bound to.
+func IndexByNode(obj v1.Object) []string {
+	if pod, ok := obj.(*v1.Pod); ok && pod.Spec.NodeID != "" {
+		return []string{pod.Spec.NodeID}
+	}
+	return nil
+}
+
+// IndexByLabel indexes objects under "key=value" for each of their labels.
+func IndexByLabel(obj v1.Object) []string {
+	labels := obj.GetObjectMeta().Labels
+	values := make([]string, 0, len(labels))
+	for k, v := range labels {
+		values = append(values, k+"="+v)
+	}
+	return values
+}
+
+// IndexByOwner indexes objects by their metadata.owner, such as
+// "Deployment/default/web".
+func IndexByOwner(obj v1.Object) []string {
+	if owner := obj.GetObjectMeta().Owner; owner != "" {
+		return []string{owner}
+	}
+	return nil
+}
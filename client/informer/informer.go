@@ -0,0 +1,409 @@
+// Package informer keeps a local, indexed copy of one kind of object in step
+// with the API server. An Informer lists the objects once, then follows a
+// watch from the list's resourceVersion, relisting whenever the watch can no
+// longer be resumed. Registered handlers hear about every add, update and
+// delete, and about every cached object again on each resync.
+//
+//	c := client.New("http://localhost:8080")
+//	pods := informer.New(informer.PodListWatch(c, "", client.ListOptions{}), time.Minute)
+//	pods.AddEventHandler(informer.HandlerFuncs{
+//		OnAdd: func(obj v1.Object) { ... },
+//	})
+//	go pods.Run(ctx)
+//	pods.WaitForSync(ctx)
+//	running := pods.ByIndex(informer.NodeIndex, nodeID)
+package informer
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "example.com/m/api/v1"
+	"example.com/m/client"
+)
+
+// retryDelay is how long Run waits after a failed list or watch.
+const retryDelay = time.Second
+
+// ListerWatcher is the source an Informer mirrors.
+type ListerWatcher interface {
+	// List returns every object and the resourceVersion they were read at.
+	List(ctx context.Context) ([]v1.Object, uint64, error)
+	// Watch calls handle for each change after resourceVersion and blocks
+	// until the stream ends. A nil error means the stream closed cleanly and
+	// can be resumed; an error for which client.IsExpired holds asks for a
+	// relist.
+	Watch(ctx context.Context, resourceVersion uint64, handle func(client.Event)) error
+}
+
+// HandlerFuncs receives the changes an Informer observes. Nil funcs are
+// skipped. Handlers run one at a time on the informer's goroutine and should
+// hand slow work off, for example to a queue. Objects are shared with the
+// cache and must not be modified.
+type HandlerFuncs struct {
+	OnAdd func(obj v1.Object)
+	// OnUpdate is also called with old == new for every object on resync.
+	OnUpdate func(old, new v1.Object)
+	// OnDelete receives the last state of the object the informer knew of.
+	OnDelete func(obj v1.Object)
+}
+
+// Informer is safe for concurrent use.
+type Informer struct {
+	lw     ListerWatcher
+	resync time.Duration
+
+	// dispatchMu serialises cache changes together with the handler calls
+	// they cause, so every handler sees changes in order.
+	dispatchMu sync.Mutex
+	handlers   []HandlerFuncs
+
+	mu       sync.RWMutex
+	items    map[string]v1.Object
+	indexers map[string]IndexFunc
+	// indices maps index name -> indexed value -> set of keys.
+	indices  map[string]map[string]map[string]struct{}
+	synced   bool
+	syncedCh chan struct{}
+}
+
+// New returns an informer for lw that resyncs every resync; zero disables
+// resync. The namespace, node, label and owner indexes are always
+// maintained.
+func New(lw ListerWatcher, resync time.Duration) *Informer {
+	inf := &Informer{
+		lw:       lw,
+		resync:   resync,
+		items:    make(map[string]v1.Object),
+		indexers: make(map[string]IndexFunc),
+		indices:  make(map[string]map[string]map[string]struct{}),
+		syncedCh: make(chan struct{}),
+	}
+	inf.AddIndexer(NamespaceIndex, IndexByNamespace)
+	inf.AddIndexer(NodeIndex, IndexByNode)
+	inf.AddIndexer(LabelIndex, IndexByLabel)
+	inf.AddIndexer(OwnerIndex, IndexByOwner)
+	return inf
+}
+
+// AddIndexer adds or replaces the index called name, indexing the objects
+// already cached.
+func (inf *Informer) AddIndexer(name string, fn IndexFunc) {
+	inf.mu.Lock()
+	defer inf.mu.Unlock()
+	inf.indexers[name] = fn
+	inf.indices[name] = make(map[string]map[string]struct{})
+	for key, obj := range inf.items {
+		inf.addToIndex(name, key, obj)
+	}
+}
+
+// AddEventHandler registers h. When the cache already holds objects, h gets
+// an OnAdd for each of them first.
+func (inf *Informer) AddEventHandler(h HandlerFuncs) {
+	inf.dispatchMu.Lock()
+	defer inf.dispatchMu.Unlock()
+	inf.handlers = append(inf.handlers, h)
+	if h.OnAdd != nil {
+		for _, obj := range inf.List() {
+			h.OnAdd(obj)
+		}
+	}
+}
+
+// Run fills the cache and keeps it current until ctx is cancelled. Failed
+// lists and dropped watches are retried; an error status from the server
+// other than an expired resourceVersion, such as an invalid selector, stops
+// Run and is returned.
+func (inf *Informer) Run(ctx context.Context) error {
+	if inf.resync > 0 {
+		go inf.resyncLoop(ctx)
+	}
+
+	for {
+		rv, err := inf.relist(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if client.ReasonForError(err) != "" {
+				return err
+			}
+			if !sleep(ctx, retryDelay) {
+				return ctx.Err()
+			}
+			continue
+		}
+
+		for {
+			err := inf.lw.Watch(ctx, rv, func(ev client.Event) {
+				rv = ev.ResourceVersion
+				inf.apply(ev)
+			})
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if client.IsExpired(err) {
+				break
+			}
+			if client.ReasonForError(err) != "" {
+				return err
+			}
+			if err != nil && !sleep(ctx, retryDelay) {
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// HasSynced reports whether the first list has been loaded.
+func (inf *Informer) HasSynced() bool {
+	inf.mu.RLock()
+	defer inf.mu.RUnlock()
+	return inf.synced
+}
+
+// WaitForSync blocks until the first list has been loaded and reports false
+// if ctx ended first.
+func (inf *Informer) WaitForSync(ctx context.Context) bool {
+	select {
+	case <-inf.syncedCh:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Get returns the object stored under key, see Key.
+func (inf *Informer) Get(key string) (v1.Object, bool) {
+	inf.mu.RLock()
+	defer inf.mu.RUnlock()
+	obj, ok := inf.items[key]
+	return obj, ok
+}
+
+// List returns every cached object, ordered by key.
+func (inf *Informer) List() []v1.Object {
+	inf.mu.RLock()
+	defer inf.mu.RUnlock()
+	keys := make([]string, 0, len(inf.items))
+	for key := range inf.items {
+		keys = append(keys, key)
+	}
+	return inf.objects(keys)
+}
+
+// ListKeys returns the keys of every cached object in order.
+func (inf *Informer) ListKeys() []string {
+	inf.mu.RLock()
+	defer inf.mu.RUnlock()
+	keys := make([]string, 0, len(inf.items))
+	for key := range inf.items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ByIndex returns the objects whose index called name contains value, ordered
+// by key. For LabelIndex the value is "key=value".
+func (inf *Informer) ByIndex(name, value string) []v1.Object {
+	inf.mu.RLock()
+	defer inf.mu.RUnlock()
+	set := inf.indices[name][value]
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	return inf.objects(keys)
+}
+
+// objects sorts keys and looks them up. Callers must hold mu.
+func (inf *Informer) objects(keys []string) []v1.Object {
+	sort.Strings(keys)
+	objs := make([]v1.Object, 0, len(keys))
+	for _, key := range keys {
+		objs = append(objs, inf.items[key])
+	}
+	return objs
+}
+
+// relist replaces the cache with a fresh list, telling handlers about the
+// differences, and returns the resourceVersion to watch from.
+func (inf *Informer) relist(ctx context.Context) (uint64, error) {
+	list, rv, err := inf.lw.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	inf.dispatchMu.Lock()
+	defer inf.dispatchMu.Unlock()
+
+	inf.mu.Lock()
+	old := inf.items
+	inf.items = make(map[string]v1.Object, len(list))
+	for name := range inf.indices {
+		inf.indices[name] = make(map[string]map[string]struct{})
+	}
+	for _, obj := range list {
+		key := Key(obj)
+		inf.items[key] = obj
+		for name := range inf.indexers {
+			inf.addToIndex(name, key, obj)
+		}
+	}
+	if !inf.synced {
+		inf.synced = true
+		close(inf.syncedCh)
+	}
+	inf.mu.Unlock()
+
+	for _, obj := range list {
+		prev, existed := old[Key(obj)]
+		switch {
+		case !existed:
+			inf.dispatch(func(h HandlerFuncs) {
+				if h.OnAdd != nil {
+					h.OnAdd(obj)
+				}
+			})
+		case prev.GetObjectMeta().ResourceVersion != obj.GetObjectMeta().ResourceVersion:
+			inf.dispatch(func(h HandlerFuncs) {
+				if h.OnUpdate != nil {
+					h.OnUpdate(prev, obj)
+				}
+			})
+		}
+	}
+	for key, prev := range old {
+		if _, ok := inf.items[key]; !ok {
+			inf.dispatch(func(h HandlerFuncs) {
+				if h.OnDelete != nil {
+					h.OnDelete(prev)
+				}
+			})
+		}
+	}
+	return rv, nil
+}
+
+// apply records one watch event in the cache and dispatches it. An ADDED or
+// MODIFIED event for an object already cached at the same resourceVersion,
+// as replayed after a resumed watch, is dropped.
+func (inf *Informer) apply(ev client.Event) {
+	obj, ok := ev.Object.(v1.Object)
+	if !ok {
+		return
+	}
+	key := Key(obj)
+
+	inf.dispatchMu.Lock()
+	defer inf.dispatchMu.Unlock()
+
+	inf.mu.Lock()
+	prev, existed := inf.items[key]
+	if ev.Type == v1.EventDeleted {
+		if !existed {
+			inf.mu.Unlock()
+			return
+		}
+		inf.remove(key, prev)
+		inf.mu.Unlock()
+		inf.dispatch(func(h HandlerFuncs) {
+			if h.OnDelete != nil {
+				h.OnDelete(obj)
+			}
+		})
+		return
+	}
+	if existed && prev.GetObjectMeta().ResourceVersion == obj.GetObjectMeta().ResourceVersion {
+		inf.mu.Unlock()
+		return
+	}
+	if existed {
+		inf.remove(key, prev)
+	}
+	inf.items[key] = obj
+	for name := range inf.indexers {
+		inf.addToIndex(name, key, obj)
+	}
+	inf.mu.Unlock()
+
+	inf.dispatch(func(h HandlerFuncs) {
+		switch {
+		case !existed && h.OnAdd != nil:
+			h.OnAdd(obj)
+		case existed && h.OnUpdate != nil:
+			h.OnUpdate(prev, obj)
+		}
+	})
+}
+
+func (inf *Informer) resyncLoop(ctx context.Context) {
+	if !inf.WaitForSync(ctx) {
+		return
+	}
+	ticker := time.NewTicker(inf.resync)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			inf.dispatchMu.Lock()
+			for _, obj := range inf.List() {
+				inf.dispatch(func(h HandlerFuncs) {
+					if h.OnUpdate != nil {
+						h.OnUpdate(obj, obj)
+					}
+				})
+			}
+			inf.dispatchMu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// dispatch calls fn for every handler. Callers must hold dispatchMu.
+func (inf *Informer) dispatch(fn func(h HandlerFuncs)) {
+	for _, h := range inf.handlers {
+		fn(h)
+	}
+}
+
+// remove drops key from the cache and its indexes. Callers must hold mu.
+func (inf *Informer) remove(key string, obj v1.Object) {
+	delete(inf.items, key)
+	for name, fn := range inf.indexers {
+		for _, value := range fn(obj) {
+			set := inf.indices[name][value]
+			delete(set, key)
+			if len(set) == 0 {
+				delete(inf.indices[name], value)
+			}
+		}
+	}
+}
+
+// addToIndex indexes obj under key in the index called name. Callers must
+// hold mu.
+func (inf *Informer) addToIndex(name, key string, obj v1.Object) {
+	for _, value := range inf.indexers[name](obj) {
+		set, ok := inf.indices[name][value]
+		if !ok {
+			set = make(map[string]struct{})
+			inf.indices[name][value] = set
+		}
+		set[key] = struct{}{}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
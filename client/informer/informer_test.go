@@ -0,0 +1,266 @@
+package informer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "example.com/m/api/v1"
+	"example.com/m/client"
+)
+
+// watchStep is what one call to fakeSource.Watch delivers before it
+// returns err.
+type watchStep struct {
+	events []client.Event
+	err    error
+}
+
+// fakeSource lists objects and hands each watch the next step sent on
+// steps, blocking until there is one.
+type fakeSource struct {
+	mu      sync.Mutex
+	objects []v1.Object
+	rv      uint64
+	watched []uint64
+
+	steps chan watchStep
+}
+
+func newFakeSource(rv uint64, objects ...v1.Object) *fakeSource {
+	return &fakeSource{objects: objects, rv: rv, steps: make(chan watchStep)}
+}
+
+func (s *fakeSource) List(ctx context.Context) ([]v1.Object, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]v1.Object(nil), s.objects...), s.rv, nil
+}
+
+func (s *fakeSource) Watch(ctx context.Context, resourceVersion uint64, handle func(client.Event)) error {
+	s.mu.Lock()
+	s.watched = append(s.watched, resourceVersion)
+	s.mu.Unlock()
+	select {
+	case step := <-s.steps:
+		for _, ev := range step.events {
+			handle(ev)
+		}
+		return step.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *fakeSource) set(rv uint64, objects ...v1.Object) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects, s.rv = objects, rv
+}
+
+func pod(name, node string, rv uint64, labels map[string]string) *v1.Pod {
+	return &v1.Pod{
+		Metadata: v1.ObjectMeta{Name: name, Namespace: "default", ResourceVersion: rv, Labels: labels},
+		Spec:     v1.PodSpec{NodeID: node},
+	}
+}
+
+func event(typ string, obj v1.Object) client.Event {
+	return client.Event{Type: typ, ResourceVersion: obj.GetObjectMeta().ResourceVersion, Object: obj}
+}
+
+// recorder logs handler calls as "add name@rv", "update name@rv->rv" and
+// "delete name@rv".
+type recorder struct {
+	calls chan string
+}
+
+func newRecorder(inf *Informer) *recorder {
+	r := &recorder{calls: make(chan string, 100)}
+	at := func(obj v1.Object) string {
+		return fmt.Sprintf("%s@%d", obj.GetObjectMeta().Name, obj.GetObjectMeta().ResourceVersion)
+	}
+	inf.AddEventHandler(HandlerFuncs{
+		OnAdd: func(obj v1.Object) { r.calls <- "add " + at(obj) },
+		OnUpdate: func(old, new v1.Object) {
+			r.calls <- fmt.Sprintf("update %s->%d", at(old), new.GetObjectMeta().ResourceVersion)
+		},
+		OnDelete: func(obj v1.Object) { r.calls <- "delete " + at(obj) },
+	})
+	return r
+}
+
+// expect waits for the next calls, in order.
+func (r *recorder) expect(t *testing.T, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-r.calls:
+			if got != w {
+				t.Fatalf("handler got %q, want %q", got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("handler did not get %q", w)
+		}
+	}
+}
+
+func run(t *testing.T, inf *Informer) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		inf.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	if !inf.WaitForSync(ctx) {
+		t.Fatal("informer did not sync")
+	}
+}
+
+func names(objs []v1.Object) string {
+	var names []string
+	for _, obj := range objs {
+		names = append(names, obj.GetObjectMeta().Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestInformerFollowsWatchAndIndexes(t *testing.T) {
+	src := newFakeSource(10,
+		pod("a", "n1", 5, map[string]string{"app": "web"}),
+		pod("b", "n2", 6, map[string]string{"app": "db"}))
+	inf := New(src, 0)
+	rec := newRecorder(inf)
+	run(t, inf)
+	rec.expect(t, "add a@5", "add b@6")
+	if got := names(inf.ByIndex(NodeIndex, "n1")); got != "a" {
+		t.Errorf("pods on n1: %s", got)
+	}
+	if got := inf.ListKeys(); fmt.Sprint(got) != "[default/a default/b]" {
+		t.Errorf("keys are %v", got)
+	}
+
+	// a moves to n2 and changes label, c arrives, b goes; a replay of
+	// what is already cached changes nothing.
+	src.steps <- watchStep{events: []client.Event{
+		event(v1.EventModified, pod("a", "n2", 11, map[string]string{"app": "api"})),
+		event(v1.EventAdded, pod("c", "n1", 12, nil)),
+		event(v1.EventAdded, pod("c", "n1", 12, nil)),
+		event(v1.EventDeleted, pod("b", "n2", 13, map[string]string{"app": "db"})),
+	}}
+	rec.expect(t, "update a@5->11", "add c@12", "delete b@13")
+	for _, tc := range []struct{ index, value, want string }{
+		{NodeIndex, "n1", "c"},
+		{NodeIndex, "n2", "a"},
+		{LabelIndex, "app=web", ""},
+		{LabelIndex, "app=api", "a"},
+		{LabelIndex, "app=db", ""},
+		{NamespaceIndex, "default", "a,c"},
+	} {
+		if got := names(inf.ByIndex(tc.index, tc.value)); got != tc.want {
+			t.Errorf("%s %s: %q, want %q", tc.index, tc.value, got, tc.want)
+		}
+	}
+	if _, ok := inf.Get("default/b"); ok {
+		t.Error("deleted pod b is still cached")
+	}
+
+	// A closed watch resumes from the last event seen, and so does the
+	// one after it.
+	src.steps <- watchStep{}
+	src.steps <- watchStep{events: []client.Event{event(v1.EventModified, pod("c", "n1", 14, nil))}}
+	rec.expect(t, "update c@12->14")
+	src.mu.Lock()
+	watched := fmt.Sprint(src.watched)
+	src.mu.Unlock()
+	if watched != "[10 13 13 14]" {
+		t.Errorf("watched from %s, want [10 13 13 14]", watched)
+	}
+}
+
+func TestInformerRelistsWhenWatchExpires(t *testing.T) {
+	src := newFakeSource(10, pod("a", "n1", 5, nil), pod("b", "n1", 6, nil))
+	inf := New(src, 0)
+	rec := newRecorder(inf)
+	run(t, inf)
+	rec.expect(t, "add a@5", "add b@6")
+
+	// Meanwhile a changed, b went and c came: the relist tells handlers
+	// the difference.
+	src.set(20, pod("a", "n1", 15, nil), pod("c", "n2", 16, nil))
+	src.steps <- watchStep{err: &v1.Status{Reason: v1.StatusReasonExpired, Code: http.StatusGone}}
+	rec.expect(t, "update a@5->15", "add c@16", "delete b@6")
+	if got := names(inf.ByIndex(NodeIndex, "n1")); got != "a" {
+		t.Errorf("pods on n1 after the relist: %q", got)
+	}
+
+	// Other errors from the server stop the informer.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stopped := New(&ListWatch{
+		ListFunc: src.List,
+		WatchFunc: func(context.Context, uint64, func(client.Event)) error {
+			return &v1.Status{Reason: v1.StatusReasonForbidden, Code: http.StatusForbidden}
+		},
+	}, 0)
+	if err := stopped.Run(ctx); !client.IsForbidden(err) {
+		t.Errorf("Run returned %v, want Forbidden", err)
+	}
+}
+
+func TestInformerResyncs(t *testing.T) {
+	src := newFakeSource(10, pod("a", "n1", 5, nil))
+	inf := New(src, 20*time.Millisecond)
+	rec := newRecorder(inf)
+	run(t, inf)
+	rec.expect(t, "add a@5", "update a@5->5", "update a@5->5")
+
+	// A handler added later hears about what is cached first.
+	late := newRecorder(inf)
+	late.expect(t, "add a@5")
+}
+
+func TestAddIndexerIndexesCachedObjects(t *testing.T) {
+	src := newFakeSource(10, pod("a", "n1", 5, nil), pod("b", "n2", 6, nil))
+	inf := New(src, 0)
+	run(t, inf)
+	inf.AddIndexer("name", func(obj v1.Object) []string {
+		return []string{obj.GetObjectMeta().Name}
+	})
+	if got := names(inf.ByIndex("name", "b")); got != "b" {
+		t.Errorf("by name b: %q", got)
+	}
+}
+
+func TestPodListWatchPagesFromFirstVersion(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list := v1.PodList{}
+		if r.URL.Query().Get("continue") == "" {
+			list.Items = []v1.Pod{*pod("a", "n1", 5, nil)}
+			list.Metadata = v1.ListMeta{ResourceVersion: 7, Continue: "next"}
+		} else {
+			list.Items = []v1.Pod{*pod("b", "n1", 8, nil)}
+			list.Metadata = v1.ListMeta{ResourceVersion: 9}
+		}
+		json.NewEncoder(w).Encode(list)
+	}))
+	defer srv.Close()
+	objs, rv, err := PodListWatch(client.New(srv.URL), "", client.ListOptions{Limit: 1}).List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names(objs) != "a,b" || rv != 7 {
+		t.Errorf("listed %s at %d, want a,b at 7", names(objs), rv)
+	}
+}
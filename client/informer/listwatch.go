@@ -0,0 +1,128 @@
+package informer
+
+import (
+	"context"
+
+	v1 "example.com/m/api/v1"
+	"example.com/m/client"
+)
+
+// ListWatch adapts a pair of functions to ListerWatcher.
+type ListWatch struct {
+	ListFunc  func(ctx context.Context) ([]v1.Object, uint64, error)
+	WatchFunc func(ctx context.Context, resourceVersion uint64, handle func(client.Event)) error
+}
+
+func (lw *ListWatch) List(ctx context.Context) ([]v1.Object, uint64, error) {
+	return lw.ListFunc(ctx)
+}
+
+func (lw *ListWatch) Watch(ctx context.Context, resourceVersion uint64, handle func(client.Event)) error {
+	return lw.WatchFunc(ctx, resourceVersion, handle)
+}
+
+// NodeListWatch mirrors the nodes matching opts' selectors.
+func NodeListWatch(c *client.Client, opts client.ListOptions) *ListWatch {
+	return &ListWatch{
+		ListFunc: func(ctx context.Context) ([]v1.Object, uint64, error) {
+			return listPages(ctx, opts, func(opts client.ListOptions) ([]v1.Object, v1.ListMeta, error) {
+				list, err := c.Nodes().List(ctx, opts)
+				if err != nil {
+					return nil, v1.ListMeta{}, err
+				}
+				objs := make([]v1.Object, len(list.Items))
+				for i := range list.Items {
+					objs[i] = &list.Items[i]
+				}
+				return objs, list.Metadata, nil
+			})
+		},
+		WatchFunc: watchFrom(c.Nodes().Watch, opts),
+	}
+}
+
+// PodListWatch mirrors the pods of namespace, or of all namespaces when it
+// is empty, that match opts' selectors.
+func PodListWatch(c *client.Client, namespace string, opts client.ListOptions) *ListWatch {
+	pods := c.Pods(namespace)
+	return &ListWatch{
+		ListFunc: func(ctx context.Context) ([]v1.Object, uint64, error) {
+			return listPages(ctx, opts, func(opts client.ListOptions) ([]v1.Object, v1.ListMeta, error) {
+				list, err := pods.List(ctx, opts)
+				if err != nil {
+					return nil, v1.ListMeta{}, err
+				}
+				objs := make([]v1.Object, len(list.Items))
+				for i := range list.Items {
+					objs[i] = &list.Items[i]
+				}
+				return objs, list.Metadata, nil
+			})
+		},
+		WatchFunc: watchFrom(pods.Watch, opts),
+	}
+}
+
+// DeploymentListWatch mirrors the deployments of namespace, or of all
+// namespaces when it is empty, that match opts' selectors.
+func DeploymentListWatch(c *client.Client, namespace string, opts client.ListOptions) *ListWatch {
+	deployments := c.Deployments(namespace)
+	return &ListWatch{
+		ListFunc: func(ctx context.Context) ([]v1.Object, uint64, error) {
+			return listPages(ctx, opts, func(opts client.ListOptions) ([]v1.Object, v1.ListMeta, error) {
+				list, err := deployments.List(ctx, opts)
+				if err != nil {
+					return nil, v1.ListMeta{}, err
+				}
+				objs := make([]v1.Object, len(list.Items))
+				for i := range list.Items {
+					objs[i] = &list.Items[i]
+				}
+				return objs, list.Metadata, nil
+			})
+		},
+		WatchFunc: watchFrom(deployments.Watch, opts),
+	}
+}
+
+// listPages follows continue tokens. It returns the resourceVersion of the
+// first page: watching from there may replay changes already seen on later
+// pages, which the informer drops, but never misses one.
+func listPages(ctx context.Context, opts client.ListOptions, page func(client.ListOptions) ([]v1.Object, v1.ListMeta, error)) ([]v1.Object, uint64, error) {
+	opts.Continue = ""
+	opts.ResourceVersion = 0
+	var all []v1.Object
+	var rv uint64
+	for first := true; ; first = false {
+		objs, meta, err := page(opts)
+		if err != nil {
+			return nil, 0, err
+		}
+		if first {
+			rv = meta.ResourceVersion
+		}
+		all = append(all, objs...)
+		if meta.Continue == "" {
+			return all, rv, nil
+		}
+		opts.Continue = meta.Continue
+	}
+}
+
+func watchFrom(watch client.WatchFunc, opts client.ListOptions) func(context.Context, uint64, func(client.Event)) error {
+	return func(ctx context.Context, resourceVersion uint64, handle func(client.Event)) error {
+		opts := opts
+		opts.Continue = ""
+		opts.Limit = 0
+		opts.ResourceVersion = resourceVersion
+		w, err := watch(ctx, opts)
+		if err != nil {
+			return err
+		}
+		defer w.Stop()
+		for ev := range w.ResultChan() {
+			handle(ev)
+		}
+		return w.Err()
+	}
+}
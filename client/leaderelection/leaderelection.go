@@ -0,0 +1,291 @@
+// Package leaderelection lets one of several candidates act at a time by
+// holding a Lease. The holder renews the lease every RetryPeriod; the others
+// keep trying to take it, and succeed once LeaseDuration has passed without
+// it changing. A leader that cannot renew within RenewDeadline stops
+// leading, before anyone else may start.
+//
+//	le, err := leaderelection.New(leaderelection.Config{
+//		Lock:     &leaderelection.LeaseLock{Client: c, Namespace: "kube-system", Name: "my-controller"},
+//		Identity: hostname,
+//		Callbacks: leaderelection.Callbacks{
+//			OnStartedLeading: func(ctx context.Context) { runController(ctx) },
+//			OnStoppedLeading: func() { log.Print("lost the lease") },
+//		},
+//	})
+//	le.Run(ctx)
+package leaderelection
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	v1 "example.com/m/api/v1"
+	"example.com/m/client"
+)
+
+// Defaults for the timings Config leaves zero.
+const (
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+// Lock is where the lease is kept.
+type Lock interface {
+	// Get returns the lease, or an error for which client.IsNotFound holds
+	// when there is none yet.
+	Get(ctx context.Context) (*v1.Lease, error)
+	Create(ctx context.Context, lease *v1.Lease) (*v1.Lease, error)
+	// Update must fail if the lease changed since lease was read.
+	Update(ctx context.Context, lease *v1.Lease) (*v1.Lease, error)
+}
+
+// LeaseLock keeps the lease in the API server.
+type LeaseLock struct {
+	Client    *client.Client
+	Namespace string
+	Name      string
+}
+
+func (l *LeaseLock) Get(ctx context.Context) (*v1.Lease, error) {
+	return l.Client.Leases(l.Namespace).Get(ctx, l.Name)
+}
+
+func (l *LeaseLock) Create(ctx context.Context, lease *v1.Lease) (*v1.Lease, error) {
+	lease.Metadata.Name, lease.Metadata.Namespace = l.Name, l.Namespace
+	return l.Client.Leases(l.Namespace).Create(ctx, lease)
+}
+
+func (l *LeaseLock) Update(ctx context.Context, lease *v1.Lease) (*v1.Lease, error) {
+	return l.Client.Leases(l.Namespace).Update(ctx, lease)
+}
+
+// Callbacks are told when leadership changes. Nil funcs are skipped.
+type Callbacks struct {
+	// OnStartedLeading runs in its own goroutine once the lease is held;
+	// ctx is cancelled when it is lost.
+	OnStartedLeading func(ctx context.Context)
+	// OnStoppedLeading runs when a candidate that led stops leading.
+	OnStoppedLeading func()
+	// OnNewLeader hears of every new holder observed, this candidate
+	// included.
+	OnNewLeader func(identity string)
+}
+
+type Config struct {
+	Lock Lock
+	// Identity is recorded as the holder and must be unique to the
+	// candidate.
+	Identity string
+	// LeaseDuration is how long the others wait, from the last change to
+	// the lease they saw, before taking it over.
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader keeps trying to renew before it
+	// gives up leading. It must be shorter than LeaseDuration.
+	RenewDeadline time.Duration
+	// RetryPeriod is the time between attempts to acquire or renew.
+	RetryPeriod time.Duration
+	// ReleaseOnCancel clears the holder when Run's context ends, so that a
+	// successor need not wait out the lease.
+	ReleaseOnCancel bool
+	Callbacks       Callbacks
+}
+
+// LeaderElector campaigns for one lease. It is safe for concurrent use.
+type LeaderElector struct {
+	cfg Config
+
+	mu sync.Mutex
+	// observed is the last version of the lease seen, at observedTime by
+	// the local clock; expiry is judged by it, not by the holder's clock.
+	observed     *v1.Lease
+	observedTime time.Time
+	// renewedAt is when this candidate last acquired or renewed the lease.
+	renewedAt      time.Time
+	reportedLeader string
+}
+
+func New(cfg Config) (*LeaderElector, error) {
+	if cfg.Lock == nil || cfg.Identity == "" {
+		return nil, errors.New("leaderelection: Lock and Identity are required")
+	}
+	if cfg.LeaseDuration == 0 {
+		cfg.LeaseDuration = DefaultLeaseDuration
+	}
+	if cfg.RenewDeadline == 0 {
+		cfg.RenewDeadline = DefaultRenewDeadline
+	}
+	if cfg.RetryPeriod == 0 {
+		cfg.RetryPeriod = DefaultRetryPeriod
+	}
+	if cfg.RenewDeadline >= cfg.LeaseDuration || cfg.RetryPeriod >= cfg.RenewDeadline {
+		return nil, errors.New("leaderelection: need RetryPeriod < RenewDeadline < LeaseDuration")
+	}
+	return &LeaderElector{cfg: cfg}, nil
+}
+
+// Run campaigns until ctx ends: it acquires the lease, leads while it
+// renews it, and campaigns again after losing it.
+func (le *LeaderElector) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if !le.acquire(ctx) {
+			return
+		}
+		leadCtx, cancel := context.WithCancel(ctx)
+		if fn := le.cfg.Callbacks.OnStartedLeading; fn != nil {
+			go fn(leadCtx)
+		}
+		le.renew(leadCtx)
+		cancel()
+		if ctx.Err() != nil && le.cfg.ReleaseOnCancel {
+			le.release()
+		}
+		if fn := le.cfg.Callbacks.OnStoppedLeading; fn != nil {
+			fn()
+		}
+	}
+}
+
+// IsLeader reports whether this candidate holds the lease and renewed it
+// recently enough that nobody else can have taken it over.
+func (le *LeaderElector) IsLeader() bool {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	return le.observed != nil && le.observed.Spec.HolderIdentity == le.cfg.Identity &&
+		time.Since(le.renewedAt) < le.cfg.RenewDeadline
+}
+
+// GetLeader returns the holder last observed, or "".
+func (le *LeaderElector) GetLeader() string {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	if le.observed == nil {
+		return ""
+	}
+	return le.observed.Spec.HolderIdentity
+}
+
+// acquire retries until the lease is held, or ctx ends.
+func (le *LeaderElector) acquire(ctx context.Context) bool {
+	for {
+		if le.tryAcquireOrRenew(ctx) {
+			return true
+		}
+		// Jitter keeps candidates from retrying in step.
+		wait := le.cfg.RetryPeriod + time.Duration(rand.Int63n(int64(le.cfg.RetryPeriod)/5+1))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// renew keeps the lease until ctx ends, a renewal has not succeeded for
+// RenewDeadline, or another candidate took it.
+func (le *LeaderElector) renew(ctx context.Context) {
+	ticker := time.NewTicker(le.cfg.RetryPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		attemptCtx, cancel := context.WithTimeout(ctx, le.cfg.RenewDeadline)
+		renewed := le.tryAcquireOrRenew(attemptCtx)
+		cancel()
+		if !renewed && (le.GetLeader() != le.cfg.Identity || !le.IsLeader()) {
+			return
+		}
+	}
+}
+
+// tryAcquireOrRenew takes the lease if it is free or expired, or renews it
+// if held.
+func (le *LeaderElector) tryAcquireOrRenew(ctx context.Context) bool {
+	now := time.Now()
+	lease, err := le.cfg.Lock.Get(ctx)
+	if client.IsNotFound(err) {
+		created, err := le.cfg.Lock.Create(ctx, &v1.Lease{Spec: v1.LeaseSpec{
+			HolderIdentity:       le.cfg.Identity,
+			LeaseDurationSeconds: int(le.cfg.LeaseDuration / time.Second),
+			AcquireTime:          now,
+			RenewTime:            now,
+		}})
+		if err != nil {
+			return false
+		}
+		le.observe(created, now, true)
+		return true
+	}
+	if err != nil {
+		return false
+	}
+
+	le.mu.Lock()
+	if le.observed == nil || le.observed.Metadata.ResourceVersion != lease.Metadata.ResourceVersion {
+		le.observed, le.observedTime = lease, now
+	}
+	observedTime := le.observedTime
+	le.mu.Unlock()
+
+	holder := lease.Spec.HolderIdentity
+	duration := time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second
+	if holder != "" && holder != le.cfg.Identity && now.Before(observedTime.Add(duration)) {
+		le.observe(lease, observedTime, false)
+		return false
+	}
+
+	update := *lease
+	update.Spec.LeaseDurationSeconds = int(le.cfg.LeaseDuration / time.Second)
+	update.Spec.RenewTime = now
+	if holder != le.cfg.Identity {
+		update.Spec.HolderIdentity = le.cfg.Identity
+		update.Spec.AcquireTime = now
+		update.Spec.LeaseTransitions++
+	}
+	updated, err := le.cfg.Lock.Update(ctx, &update)
+	if err != nil {
+		return false
+	}
+	le.observe(updated, now, true)
+	return true
+}
+
+// observe records lease as seen at t, and announces a new holder.
+func (le *LeaderElector) observe(lease *v1.Lease, t time.Time, renewed bool) {
+	le.mu.Lock()
+	le.observed, le.observedTime = lease, t
+	if renewed {
+		le.renewedAt = t
+	}
+	holder := lease.Spec.HolderIdentity
+	announce := holder != "" && holder != le.reportedLeader
+	le.reportedLeader = holder
+	le.mu.Unlock()
+	if fn := le.cfg.Callbacks.OnNewLeader; announce && fn != nil {
+		go fn(holder)
+	}
+}
+
+// release gives the lease up, if still held, by clearing its holder.
+func (le *LeaderElector) release() {
+	le.mu.Lock()
+	lease := le.observed
+	le.mu.Unlock()
+	if lease == nil || lease.Spec.HolderIdentity != le.cfg.Identity {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), le.cfg.RenewDeadline)
+	defer cancel()
+	update := *lease
+	update.Spec.HolderIdentity = ""
+	update.Spec.LeaseDurationSeconds = 1
+	if updated, err := le.cfg.Lock.Update(ctx, &update); err == nil {
+		le.observe(updated, time.Now(), false)
+	}
+}
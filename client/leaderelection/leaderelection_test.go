@@ -0,0 +1,243 @@
+package leaderelection
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "example.com/m/api/v1"
+)
+
+// memoryLock keeps the lease in memory, refusing stale updates as the API
+// server does.
+type memoryLock struct {
+	mu    sync.Mutex
+	lease *v1.Lease
+	rv    uint64
+}
+
+var errStale = errors.New("lease changed since it was read")
+
+func (l *memoryLock) Get(ctx context.Context) (*v1.Lease, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lease == nil {
+		return nil, &v1.Status{Reason: v1.StatusReasonNotFound, Code: http.StatusNotFound}
+	}
+	c := *l.lease
+	return &c, nil
+}
+
+func (l *memoryLock) Create(ctx context.Context, lease *v1.Lease) (*v1.Lease, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lease != nil {
+		return nil, &v1.Status{Reason: v1.StatusReasonAlreadyExists, Code: http.StatusConflict}
+	}
+	return l.store(lease), nil
+}
+
+func (l *memoryLock) Update(ctx context.Context, lease *v1.Lease) (*v1.Lease, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lease.Metadata.ResourceVersion != l.rv {
+		return nil, errStale
+	}
+	return l.store(lease), nil
+}
+
+func (l *memoryLock) store(lease *v1.Lease) *v1.Lease {
+	l.rv++
+	c := *lease
+	c.Metadata.ResourceVersion = l.rv
+	l.lease = &c
+	out := c
+	return &out
+}
+
+func (l *memoryLock) holder() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lease == nil {
+		return ""
+	}
+	return l.lease.Spec.HolderIdentity
+}
+
+func newElector(t *testing.T, lock Lock, identity string, callbacks Callbacks) *LeaderElector {
+	t.Helper()
+	le, err := New(Config{
+		Lock:            lock,
+		Identity:        identity,
+		LeaseDuration:   time.Second,
+		RenewDeadline:   500 * time.Millisecond,
+		RetryPeriod:     20 * time.Millisecond,
+		ReleaseOnCancel: true,
+		Callbacks:       callbacks,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return le
+}
+
+func TestNewChecksConfig(t *testing.T) {
+	lock := &memoryLock{}
+	for name, cfg := range map[string]Config{
+		"no lock":                      {Identity: "a"},
+		"no identity":                  {Lock: lock},
+		"renew deadline past the term": {Lock: lock, Identity: "a", LeaseDuration: time.Second, RenewDeadline: 2 * time.Second},
+		"retry period past the renew":  {Lock: lock, Identity: "a", RenewDeadline: time.Second, RetryPeriod: time.Second},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	le, err := New(Config{Lock: lock, Identity: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if le.cfg.LeaseDuration != DefaultLeaseDuration || le.cfg.RenewDeadline != DefaultRenewDeadline || le.cfg.RetryPeriod != DefaultRetryPeriod {
+		t.Errorf("defaults: %+v", le.cfg)
+	}
+}
+
+func TestAcquireAndRenew(t *testing.T) {
+	lock := &memoryLock{}
+	a := newElector(t, lock, "a", Callbacks{})
+	if a.IsLeader() || a.GetLeader() != "" {
+		t.Fatal("leading before campaigning")
+	}
+	if !a.tryAcquireOrRenew(context.Background()) {
+		t.Fatal("could not create the lease")
+	}
+	if !a.IsLeader() || lock.holder() != "a" {
+		t.Fatalf("after acquiring: leader %v, holder %q", a.IsLeader(), lock.holder())
+	}
+	acquired := lock.lease.Spec.AcquireTime
+
+	time.Sleep(time.Millisecond)
+	if !a.tryAcquireOrRenew(context.Background()) {
+		t.Fatal("could not renew")
+	}
+	l, _ := lock.Get(context.Background())
+	if !l.Spec.AcquireTime.Equal(acquired) || !l.Spec.RenewTime.After(acquired) || l.Spec.LeaseTransitions != 0 {
+		t.Errorf("renewal changed more than the renew time: %+v", l.Spec)
+	}
+}
+
+func TestLeaseIsTakenOverOnlyOnceExpired(t *testing.T) {
+	lock := &memoryLock{}
+	a := newElector(t, lock, "a", Callbacks{})
+	b := newElector(t, lock, "b", Callbacks{})
+	a.tryAcquireOrRenew(context.Background())
+
+	if b.tryAcquireOrRenew(context.Background()) || b.IsLeader() {
+		t.Fatal("b took a lease a holds")
+	}
+	if b.GetLeader() != "a" {
+		t.Errorf("b sees %q leading", b.GetLeader())
+	}
+
+	// a stops renewing. Expiry is judged by when b saw the lease last
+	// change, so move that back a lease duration.
+	b.mu.Lock()
+	b.observedTime = b.observedTime.Add(-time.Second)
+	b.mu.Unlock()
+	if !b.tryAcquireOrRenew(context.Background()) || !b.IsLeader() {
+		t.Fatal("b could not take over an expired lease")
+	}
+	if l, _ := lock.Get(context.Background()); l.Spec.HolderIdentity != "b" || l.Spec.LeaseTransitions != 1 {
+		t.Errorf("after the takeover: %+v", l.Spec)
+	}
+
+	// a finds out on its next renewal.
+	if a.tryAcquireOrRenew(context.Background()) || a.IsLeader() || a.GetLeader() != "b" {
+		t.Errorf("a still leads after losing the lease")
+	}
+}
+
+func TestRacingCandidatesOneWins(t *testing.T) {
+	lock := &memoryLock{}
+	lock.store(&v1.Lease{Spec: v1.LeaseSpec{HolderIdentity: "gone", LeaseDurationSeconds: 1}})
+	b := newElector(t, lock, "b", Callbacks{})
+	c := newElector(t, lock, "c", Callbacks{})
+	for _, le := range []*LeaderElector{b, c} {
+		le.tryAcquireOrRenew(context.Background())
+		le.mu.Lock()
+		le.observedTime = le.observedTime.Add(-time.Second)
+		le.mu.Unlock()
+	}
+
+	// Both read the expired lease before either writes it.
+	stale, _ := lock.Get(context.Background())
+	if !b.tryAcquireOrRenew(context.Background()) {
+		t.Fatal("b could not take over")
+	}
+	update := *stale
+	update.Spec.HolderIdentity = "c"
+	if _, err := lock.Update(context.Background(), &update); !errors.Is(err, errStale) {
+		t.Fatalf("a stale takeover: got %v", err)
+	}
+	if c.tryAcquireOrRenew(context.Background()) || c.IsLeader() || lock.holder() != "b" {
+		t.Errorf("c took the lease b just acquired")
+	}
+}
+
+func TestRunLeadsAndReleases(t *testing.T) {
+	lock := &memoryLock{}
+	started, stopped := make(chan struct{}), make(chan struct{})
+	leaders := make(chan string, 10)
+	a := newElector(t, lock, "a", Callbacks{
+		OnStartedLeading: func(ctx context.Context) {
+			close(started)
+			<-ctx.Done()
+		},
+		OnStoppedLeading: func() { close(stopped) },
+		OnNewLeader:      func(identity string) { leaders <- identity },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("never started leading")
+	}
+
+	cancel()
+	<-done
+	select {
+	case <-stopped:
+	default:
+		t.Error("OnStoppedLeading was not called")
+	}
+	// Releasing clears the holder so a successor need not wait.
+	if lock.holder() != "" {
+		t.Errorf("lease still held by %q after release", lock.holder())
+	}
+	b := newElector(t, lock, "b", Callbacks{})
+	if !b.tryAcquireOrRenew(context.Background()) {
+		t.Error("a successor had to wait out a released lease")
+	}
+	// Only a holder is announced, not the release.
+	select {
+	case leader := <-leaders:
+		if leader != "a" {
+			t.Errorf("announced %q leading", leader)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the new leader was not announced")
+	}
+	select {
+	case leader := <-leaders:
+		t.Errorf("announced %q leading after the release", leader)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+
+	v1 "example.com/m/api/v1"
+)
+
+// LeaseClient manages the leases of one namespace. With an empty namespace,
+// List and Watch cover all namespaces and the other methods use "default".
+type LeaseClient struct {
+	c         *Client
+	namespace string
+}
+
+func (c *Client) Leases(namespace string) *LeaseClient {
+	return &LeaseClient{c: c, namespace: namespace}
+}
+
+func (l *LeaseClient) List(ctx context.Context, opts ListOptions) (*v1.LeaseList, error) {
+	var list v1.LeaseList
+	if err := l.c.do(ctx, request{method: "GET", path: rbacPath(l.namespace, "leases", ""), query: opts.query()}, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (l *LeaseClient) Get(ctx context.Context, name string) (*v1.Lease, error) {
+	var lease v1.Lease
+	if err := l.c.do(ctx, request{method: "GET", path: rbacPath(namespaceOrDefault(l.namespace), "leases", name)}, &lease); err != nil {
+		return nil, err
+	}
+	return &lease, nil
+}
+
+func (l *LeaseClient) Create(ctx context.Context, lease *v1.Lease) (*v1.Lease, error) {
+	var out v1.Lease
+	if err := l.c.writeObject(ctx, "POST", rbacPath(namespaceOrDefault(l.namespace), "leases", ""), lease, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Update replaces the spec and metadata of a lease. It must carry the
+// resourceVersion it was read at, so of two candidates racing for a lease
+// only one succeeds.
+func (l *LeaseClient) Update(ctx context.Context, lease *v1.Lease) (*v1.Lease, error) {
+	var out v1.Lease
+	if err := l.c.writeObject(ctx, "PUT", rbacPath(namespaceOrDefault(l.namespace), "leases", lease.Metadata.Name), lease, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (l *LeaseClient) Delete(ctx context.Context, name string) error {
+	return l.c.do(ctx, request{method: "DELETE", path: rbacPath(namespaceOrDefault(l.namespace), "leases", name)}, nil)
+}
+
+// Watch streams lease changes. Event objects are *v1.Lease.
+func (l *LeaseClient) Watch(ctx context.Context, opts ListOptions) (*Watcher, error) {
+	return l.c.watch(ctx, rbacPath(l.namespace, "leases", ""), opts, func(raw json.RawMessage) (interface{}, error) {
+		var lease v1.Lease
+		err := json.Unmarshal(raw, &lease)
+		return &lease, err
+	})
+}
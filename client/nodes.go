@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+
+	v1 "example.com/m/api/v1"
+)
+
+// NodeClient manages nodes, which are cluster scoped and addressed by name.
+type NodeClient struct {
+	c *Client
+}
+
+func (c *Client) Nodes() *NodeClient {
+	return &NodeClient{c: c}
+}
+
+func nodePath(name string) string {
+	return "/api/v1/nodes/" + url.PathEscape(name)
+}
+
+// List returns one page of nodes.
+func (n *NodeClient) List(ctx context.Context, opts ListOptions) (*v1.NodeList, error) {
+	var list v1.NodeList
+	err := n.c.do(ctx, request{method: "GET", path: "/api/v1/nodes", query: opts.query()}, &list)
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// ListAll follows continue tokens and returns every matching node.
+// opts.Limit sets the page size.
+func (n *NodeClient) ListAll(ctx context.Context, opts ListOptions) ([]v1.Node, error) {
+	var items []v1.Node
+	for {
+		list, err := n.List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, list.Items...)
+		if list.Metadata.Continue == "" {
+			return items, nil
+		}
+		opts.Continue = list.Metadata.Continue
+	}
+}
+
+func (n *NodeClient) Get(ctx context.Context, name string) (*v1.Node, error) {
+	var node v1.Node
+	if err := n.c.do(ctx, request{method: "GET", path: nodePath(name)}, &node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// Create launches a node. An empty metadata.name defaults to the node's UID.
+func (n *NodeClient) Create(ctx context.Context, node *v1.Node) (*v1.Node, error) {
+	return n.write(ctx, "POST", "/api/v1/nodes", node)
+}
+
+// Update replaces the spec, labels and annotations of a node. node must
+// carry the resourceVersion it was read at; IsConflict reports a lost race.
+func (n *NodeClient) Update(ctx context.Context, node *v1.Node) (*v1.Node, error) {
+	return n.write(ctx, "PUT", nodePath(node.Metadata.Name), node)
+}
+
+func (n *NodeClient) Delete(ctx context.Context, name string) error {
+	return n.c.do(ctx, request{method: "DELETE", path: nodePath(name)}, nil)
+}
+
+// Stop stops the node's container.
+func (n *NodeClient) Stop(ctx context.Context, name string) (*v1.Node, error) {
+	return n.write(ctx, "POST", nodePath(name)+"/stop", nil)
+}
+
+// Restart restarts the node's container.
+func (n *NodeClient) Restart(ctx context.Context, name string) (*v1.Node, error) {
+	return n.write(ctx, "POST", nodePath(name)+"/restart", nil)
+}
+
+// Watch streams node changes. Event objects are *v1.Node.
+func (n *NodeClient) Watch(ctx context.Context, opts ListOptions) (*Watcher, error) {
+	return n.c.watch(ctx, "/api/v1/nodes", opts, func(raw json.RawMessage) (interface{}, error) {
+		var node v1.Node
+		err := json.Unmarshal(raw, &node)
+		return &node, err
+	})
+}
+
+func (n *NodeClient) write(ctx context.Context, method, path string, in *v1.Node) (*v1.Node, error) {
+	var body interface{}
+	if in != nil {
+		body = in
+	}
+	req, err := jsonRequest(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	var out v1.Node
+	if err := n.c.do(ctx, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
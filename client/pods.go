@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+
+	v1 "example.com/m/api/v1"
+)
+
+// PodClient manages the pods of one namespace. With an empty namespace, List
+// and Watch cover all namespaces and the other methods use "default".
+type PodClient struct {
+	c         *Client
+	namespace string
+}
+
+func (c *Client) Pods(namespace string) *PodClient {
+	return &PodClient{c: c, namespace: namespace}
+}
+
+func (p *PodClient) collectionPath() string {
+	if p.namespace == "" {
+		return "/api/v1/pods"
+	}
+	return "/api/v1/namespaces/" + url.PathEscape(p.namespace) + "/pods"
+}
+
+func (p *PodClient) podPath(name string) string {
+	namespace := p.namespace
+	if namespace == "" {
+		namespace = v1.DefaultNamespace
+	}
+	return "/api/v1/namespaces/" + url.PathEscape(namespace) + "/pods/" + url.PathEscape(name)
+}
+
+// List returns one page of pods.
+func (p *PodClient) List(ctx context.Context, opts ListOptions) (*v1.PodList, error) {
+	var list v1.PodList
+	err := p.c.do(ctx, request{method: "GET", path: p.collectionPath(), query: opts.query()}, &list)
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// ListAll follows continue tokens and returns every matching pod.
+// opts.Limit sets the page size.
+func (p *PodClient) ListAll(ctx context.Context, opts ListOptions) ([]v1.Pod, error) {
+	var items []v1.Pod
+	for {
+		list, err := p.List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, list.Items...)
+		if list.Metadata.Continue == "" {
+			return items, nil
+		}
+		opts.Continue = list.Metadata.Continue
+	}
+}
+
+func (p *PodClient) Get(ctx context.Context, name string) (*v1.Pod, error) {
+	var pod v1.Pod
+	if err := p.c.do(ctx, request{method: "GET", path: p.podPath(name)}, &pod); err != nil {
+		return nil, err
+	}
+	return &pod, nil
+}
+
+// Create schedules a pod. An empty metadata.name defaults to the pod's UID.
+func (p *PodClient) Create(ctx context.Context, pod *v1.Pod) (*v1.Pod, error) {
+	namespace := p.namespace
+	if namespace == "" {
+		namespace = v1.DefaultNamespace
+	}
+	return p.write(ctx, "POST", "/api/v1/namespaces/"+url.PathEscape(namespace)+"/pods", pod)
+}
+
+// Update replaces the spec, labels and annotations of a pod. pod must carry
+// the resourceVersion it was read at; IsConflict reports a lost race.
+func (p *PodClient) Update(ctx context.Context, pod *v1.Pod) (*v1.Pod, error) {
+	return p.write(ctx, "PUT", p.podPath(pod.Metadata.Name), pod)
+}
+
+func (p *PodClient) Delete(ctx context.Context, name string) error {
+	return p.c.do(ctx, request{method: "DELETE", path: p.podPath(name)}, nil)
+}
+
+// Restart restarts the pod in place.
+func (p *PodClient) Restart(ctx context.Context, name string) (*v1.Pod, error) {
+	return p.write(ctx, "POST", p.podPath(name)+"/restart", nil)
+}
+
+// Watch streams pod changes. Event objects are *v1.Pod.
+func (p *PodClient) Watch(ctx context.Context, opts ListOptions) (*Watcher, error) {
+	return p.c.watch(ctx, p.collectionPath(), opts, func(raw json.RawMessage) (interface{}, error) {
+		var pod v1.Pod
+		err := json.Unmarshal(raw, &pod)
+		return &pod, err
+	})
+}
+
+func (p *PodClient) write(ctx context.Context, method, path string, in *v1.Pod) (*v1.Pod, error) {
+	var body interface{}
+	if in != nil {
+		body = in
+	}
+	req, err := jsonRequest(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	var out v1.Pod
+	if err := p.c.do(ctx, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,245 @@
+package client
+
+import (
+	"context"
+	"net/url"
+
+	v1 "example.com/m/api/v1"
+)
+
+// CanI asks the server whether the client may perform the action described
+// by attrs.
+func (c *Client) CanI(ctx context.Context, attrs v1.ResourceAttributes) (*v1.SubjectAccessReviewStatus, error) {
+	req, err := jsonRequest("POST", "/api/v1/selfsubjectaccessreviews", v1.SelfSubjectAccessReview{Spec: attrs})
+	if err != nil {
+		return nil, err
+	}
+	var review v1.SelfSubjectAccessReview
+	if err := c.do(ctx, req, &review); err != nil {
+		return nil, err
+	}
+	return &review.Status, nil
+}
+
+// rbacPath returns the path of resource, namespaced unless namespace is
+// empty, and of the named object when name is set.
+func rbacPath(namespace, resource, name string) string {
+	path := "/api/v1/" + resource
+	if namespace != "" {
+		path = "/api/v1/namespaces/" + url.PathEscape(namespace) + "/" + resource
+	}
+	if name != "" {
+		path += "/" + url.PathEscape(name)
+	}
+	return path
+}
+
+// namespaceOrDefault is where namespaced objects are written when the client
+// covers all namespaces.
+func namespaceOrDefault(namespace string) string {
+	if namespace == "" {
+		return v1.DefaultNamespace
+	}
+	return namespace
+}
+
+func (c *Client) writeObject(ctx context.Context, method, path string, in, out interface{}) error {
+	req, err := jsonRequest(method, path, in)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, req, out)
+}
+
+// RoleClient manages the roles of one namespace. With an empty namespace,
+// List covers all namespaces and the other methods use "default".
+type RoleClient struct {
+	c         *Client
+	namespace string
+}
+
+func (c *Client) Roles(namespace string) *RoleClient {
+	return &RoleClient{c: c, namespace: namespace}
+}
+
+func (r *RoleClient) List(ctx context.Context, opts ListOptions) (*v1.RoleList, error) {
+	var list v1.RoleList
+	if err := r.c.do(ctx, request{method: "GET", path: rbacPath(r.namespace, "roles", ""), query: opts.query()}, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (r *RoleClient) Get(ctx context.Context, name string) (*v1.Role, error) {
+	var role v1.Role
+	if err := r.c.do(ctx, request{method: "GET", path: rbacPath(namespaceOrDefault(r.namespace), "roles", name)}, &role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (r *RoleClient) Create(ctx context.Context, role *v1.Role) (*v1.Role, error) {
+	var out v1.Role
+	if err := r.c.writeObject(ctx, "POST", rbacPath(namespaceOrDefault(r.namespace), "roles", ""), role, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Update replaces the rules and metadata of a role. It must carry the
+// resourceVersion it was read at.
+func (r *RoleClient) Update(ctx context.Context, role *v1.Role) (*v1.Role, error) {
+	var out v1.Role
+	if err := r.c.writeObject(ctx, "PUT", rbacPath(namespaceOrDefault(r.namespace), "roles", role.Metadata.Name), role, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (r *RoleClient) Delete(ctx context.Context, name string) error {
+	return r.c.do(ctx, request{method: "DELETE", path: rbacPath(namespaceOrDefault(r.namespace), "roles", name)}, nil)
+}
+
+// ClusterRoleClient manages cluster roles.
+type ClusterRoleClient struct {
+	c *Client
+}
+
+func (c *Client) ClusterRoles() *ClusterRoleClient {
+	return &ClusterRoleClient{c: c}
+}
+
+func (r *ClusterRoleClient) List(ctx context.Context, opts ListOptions) (*v1.ClusterRoleList, error) {
+	var list v1.ClusterRoleList
+	if err := r.c.do(ctx, request{method: "GET", path: rbacPath("", "clusterroles", ""), query: opts.query()}, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (r *ClusterRoleClient) Get(ctx context.Context, name string) (*v1.ClusterRole, error) {
+	var role v1.ClusterRole
+	if err := r.c.do(ctx, request{method: "GET", path: rbacPath("", "clusterroles", name)}, &role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (r *ClusterRoleClient) Create(ctx context.Context, role *v1.ClusterRole) (*v1.ClusterRole, error) {
+	var out v1.ClusterRole
+	if err := r.c.writeObject(ctx, "POST", rbacPath("", "clusterroles", ""), role, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Update replaces the rules and metadata of a cluster role. It must carry
+// the resourceVersion it was read at.
+func (r *ClusterRoleClient) Update(ctx context.Context, role *v1.ClusterRole) (*v1.ClusterRole, error) {
+	var out v1.ClusterRole
+	if err := r.c.writeObject(ctx, "PUT", rbacPath("", "clusterroles", role.Metadata.Name), role, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (r *ClusterRoleClient) Delete(ctx context.Context, name string) error {
+	return r.c.do(ctx, request{method: "DELETE", path: rbacPath("", "clusterroles", name)}, nil)
+}
+
+// RoleBindingClient manages the role bindings of one namespace. With an
+// empty namespace, List covers all namespaces and the other methods use
+// "default".
+type RoleBindingClient struct {
+	c         *Client
+	namespace string
+}
+
+func (c *Client) RoleBindings(namespace string) *RoleBindingClient {
+	return &RoleBindingClient{c: c, namespace: namespace}
+}
+
+func (b *RoleBindingClient) List(ctx context.Context, opts ListOptions) (*v1.RoleBindingList, error) {
+	var list v1.RoleBindingList
+	if err := b.c.do(ctx, request{method: "GET", path: rbacPath(b.namespace, "rolebindings", ""), query: opts.query()}, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (b *RoleBindingClient) Get(ctx context.Context, name string) (*v1.RoleBinding, error) {
+	var binding v1.RoleBinding
+	if err := b.c.do(ctx, request{method: "GET", path: rbacPath(namespaceOrDefault(b.namespace), "rolebindings", name)}, &binding); err != nil {
+		return nil, err
+	}
+	return &binding, nil
+}
+
+func (b *RoleBindingClient) Create(ctx context.Context, binding *v1.RoleBinding) (*v1.RoleBinding, error) {
+	var out v1.RoleBinding
+	if err := b.c.writeObject(ctx, "POST", rbacPath(namespaceOrDefault(b.namespace), "rolebindings", ""), binding, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Update replaces the subjects and metadata of a binding; its roleRef cannot
+// change. It must carry the resourceVersion it was read at.
+func (b *RoleBindingClient) Update(ctx context.Context, binding *v1.RoleBinding) (*v1.RoleBinding, error) {
+	var out v1.RoleBinding
+	if err := b.c.writeObject(ctx, "PUT", rbacPath(namespaceOrDefault(b.namespace), "rolebindings", binding.Metadata.Name), binding, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (b *RoleBindingClient) Delete(ctx context.Context, name string) error {
+	return b.c.do(ctx, request{method: "DELETE", path: rbacPath(namespaceOrDefault(b.namespace), "rolebindings", name)}, nil)
+}
+
+// ClusterRoleBindingClient manages cluster role bindings.
+type ClusterRoleBindingClient struct {
+	c *Client
+}
+
+func (c *Client) ClusterRoleBindings() *ClusterRoleBindingClient {
+	return &ClusterRoleBindingClient{c: c}
+}
+
+func (b *ClusterRoleBindingClient) List(ctx context.Context, opts ListOptions) (*v1.ClusterRoleBindingList, error) {
+	var list v1.ClusterRoleBindingList
+	if err := b.c.do(ctx, request{method: "GET", path: rbacPath("", "clusterrolebindings", ""), query: opts.query()}, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (b *ClusterRoleBindingClient) Get(ctx context.Context, name string) (*v1.ClusterRoleBinding, error) {
+	var binding v1.ClusterRoleBinding
+	if err := b.c.do(ctx, request{method: "GET", path: rbacPath("", "clusterrolebindings", name)}, &binding); err != nil {
+		return nil, err
+	}
+	return &binding, nil
+}
+
+func (b *ClusterRoleBindingClient) Create(ctx context.Context, binding *v1.ClusterRoleBinding) (*v1.ClusterRoleBinding, error) {
+	var out v1.ClusterRoleBinding
+	if err := b.c.writeObject(ctx, "POST", rbacPath("", "clusterrolebindings", ""), binding, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Update replaces the subjects and metadata of a binding; its roleRef cannot
+// change. It must carry the resourceVersion it was read at.
+func (b *ClusterRoleBindingClient) Update(ctx context.Context, binding *v1.ClusterRoleBinding) (*v1.ClusterRoleBinding, error) {
+	var out v1.ClusterRoleBinding
+	if err := b.c.writeObject(ctx, "PUT", rbacPath("", "clusterrolebindings", binding.Metadata.Name), binding, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (b *ClusterRoleBindingClient) Delete(ctx context.Context, name string) error {
+	return b.c.do(ctx, request{method: "DELETE", path: rbacPath("", "clusterrolebindings", name)}, nil)
+}
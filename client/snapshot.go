@@ -0,0 +1,41 @@
+package client
+
+import (
+	"context"
+	"net/url"
+
+	v1 "example.com/m/api/v1"
+)
+
+// Snapshot returns a consistent backup of every object in the cluster and
+// the scheduler configuration.
+func (c *Client) Snapshot(ctx context.Context) (*v1.ClusterSnapshot, error) {
+	var snap v1.ClusterSnapshot
+	if err := c.do(ctx, request{method: "GET", path: "/admin/snapshot"}, &snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// RestoreOptions controls what happens to node containers on restore.
+type RestoreOptions struct {
+	// Nodes is v1.RestoreNodesKeep (the default) or v1.RestoreNodesRelaunch.
+	Nodes string
+}
+
+// Restore replaces every object in the cluster, leases aside, with those in
+// snap.
+func (c *Client) Restore(ctx context.Context, snap *v1.ClusterSnapshot, opts RestoreOptions) (*v1.RestoreResult, error) {
+	req, err := jsonRequest("POST", "/admin/restore", snap)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Nodes != "" {
+		req.query = url.Values{"nodes": {opts.Nodes}}
+	}
+	var result v1.RestoreResult
+	if err := c.do(ctx, req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	v1 "example.com/m/api/v1"
+)
+
+// watchRetryDelay is how long RetryWatch waits before reconnecting after a
+// failed attempt.
+const watchRetryDelay = time.Second
+
+// Event is one decoded watch event. Object is a *v1.Node, *v1.Pod or
+// *v1.Deployment, matching the resource being watched.
+type Event struct {
+	Type            string
+	ResourceVersion uint64
+	Object          interface{}
+}
+
+// Watcher delivers the events of one watch stream.
+type Watcher struct {
+	events chan Event
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// ResultChan is closed when the stream ends: the server closed it, Stop was
+// called or the context was cancelled.
+func (w *Watcher) ResultChan() <-chan Event {
+	return w.events
+}
+
+// Stop closes the stream and waits for the watcher to finish.
+func (w *Watcher) Stop() {
+	w.cancel()
+	<-w.done
+}
+
+// Err returns why the stream ended once ResultChan is closed. It is nil when
+// the server ended the watch cleanly or Stop was called.
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// watch opens a watch on path; decode turns each event's object into its
+// typed form.
+func (c *Client) watch(ctx context.Context, path string, opts ListOptions, decode func(json.RawMessage) (interface{}, error)) (*Watcher, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	q := opts.query()
+	q.Set("watch", "true")
+	resp, err := c.send(ctx, request{method: "GET", path: path, query: q})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	w := &Watcher{events: make(chan Event), cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		defer close(w.events)
+		defer resp.Body.Close()
+
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var raw v1.WatchEvent
+			if err := dec.Decode(&raw); err != nil {
+				if err != io.EOF && ctx.Err() == nil {
+					w.setErr(err)
+				}
+				return
+			}
+			obj, err := decode(raw.Object)
+			if err != nil {
+				w.setErr(fmt.Errorf("decoding %s event: %w", raw.Type, err))
+				return
+			}
+			select {
+			case w.events <- Event{Type: raw.Type, ResourceVersion: raw.ResourceVersion, Object: obj}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return w, nil
+}
+
+func (w *Watcher) setErr(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.err = err
+}
+
+// WatchFunc opens a watch, like NodeClient.Watch or PodClient.Watch.
+type WatchFunc func(ctx context.Context, opts ListOptions) (*Watcher, error)
+
+// RetryWatch keeps a watch running until ctx is cancelled or handle returns
+// an error, calling handle for every event. A dropped connection is resumed
+// from the last event's resourceVersion. If that version is too old the
+// watch starts over from the current state, which the server replays as
+// ADDED events, so handlers must treat ADDED for a known object as an update.
+// Errors other than network failures and expiry, such as an invalid
+// selector, are returned.
+func RetryWatch(ctx context.Context, watch WatchFunc, opts ListOptions, handle func(Event) error) error {
+	for {
+		w, err := watch(ctx, opts)
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case IsExpired(err):
+			opts.ResourceVersion = 0
+			continue
+		case err != nil && ReasonForError(err) != "":
+			return err
+		case err != nil:
+			if !sleep(ctx, watchRetryDelay) {
+				return ctx.Err()
+			}
+			continue
+		}
+
+		for ev := range w.ResultChan() {
+			opts.ResourceVersion = ev.ResourceVersion
+			if err := handle(ev); err != nil {
+				w.Stop()
+				return err
+			}
+		}
+		if w.Err() != nil && !sleep(ctx, watchRetryDelay) {
+			return ctx.Err()
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -43,6 +43,10 @@ axiosInstance.interceptors.response.use(undefined, async (err: AxiosError) => {
   }
 });
 
+// Errors come back as a JSON Status object; older servers sent plain text
+const errorMessage = (data: any): string =>
+  typeof data === 'object' && data !== null && data.message ? data.message : String(data);
+
 // Error handler helper
 const handleApiError = (error: any, customMessage: string): never => {
   console.error(customMessage, error);
@@ -56,11 +60,11 @@ const handleApiError = (error: any, customMessage: string): never => {
   }
   
   if (error.response?.status === 400) {
-    throw new Error(error.response.data || 'Invalid request. Please check your input.');
+    throw new Error(error.response.data ? errorMessage(error.response.data) : 'Invalid request. Please check your input.');
   }
   
   if (error.response?.data) {
-    throw new Error(error.response.data);
+    throw new Error(errorMessage(error.response.data));
   }
   
   if (!error.response) {
@@ -0,0 +1,267 @@
+// Package agent is the node agent: it registers its node with the API server
+// on startup, declaring the node's capacity, then reports its liveness and
+// conditions with periodic heartbeats, learns from the answers which pods
+// the node should run, and runs them as local processes, reporting how they
+// fare with the next heartbeat. When the node runs short of memory, disk or
+// process IDs it evicts its lowest-priority pods. The node binary runs one
+// agent; the API server can run agents in-process instead.
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+
+	v1 "example.com/m/api/v1"
+	"example.com/m/client"
+)
+
+const (
+	NEON_PINK   = "\033[38;5;198m"
+	NEON_BLUE   = "\033[38;5;51m"
+	NEON_GREEN  = "\033[38;5;46m"
+	NEON_YELLOW = "\033[38;5;226m"
+	NEON_CYAN   = "\033[38;5;87m"
+	NEON_RED    = "\033[38;5;196m"
+	NEON_ORANGE = "\033[38;5;214m"
+	BOLD        = "\033[1m"
+	NC          = "\033[0m"
+)
+
+// DefaultHeartbeatInterval is how often an agent reports by default.
+const DefaultHeartbeatInterval = 5 * time.Second
+
+// Version is the agent version nodes register with.
+const Version = "1.1.0"
+
+type Config struct {
+	// NodeID is the ID the node is registered under. When empty, the node
+	// is looked up by Name, or the API server assigns an ID to a new one.
+	NodeID string
+	// Name is the node's name; it cannot change once registered.
+	Name string
+	// CPUCores is the capacity the node declares; runtime.NumCPU() when 0.
+	CPUCores int
+	// Labels are declared with the node, adding to or overwriting those it
+	// has.
+	Labels    map[string]string
+	APIServer string
+	// Token is the bearer token the API server issued the node; it may be
+	// empty when Transport presents a client certificate.
+	Token string
+	// Transport carries requests to the API server; the default transport
+	// when nil.
+	Transport http.RoundTripper
+	// Client, when set, is used instead of APIServer, Token and Transport,
+	// so that many agents can share one.
+	Client            *client.Client
+	HeartbeatInterval time.Duration
+	// HeartbeatJitter spreads heartbeats out: each wait is lengthened by a
+	// random part of the interval, up to this fraction of it, and the first
+	// heartbeat comes after a random part of the interval. Agents started
+	// together then do not report in step.
+	HeartbeatJitter float64
+	// Out receives the agent's log; os.Stdout when nil.
+	Out io.Writer
+	// OnRegister and OnHeartbeat, when set, are told the outcome of every
+	// registration and heartbeat.
+	OnRegister  func(err error)
+	OnHeartbeat func(err error)
+	// RunPodCommands runs each pod's command on the host. Without it every
+	// pod runs as a stand-in, since whoever can create a pod would otherwise
+	// run commands on the node.
+	RunPodCommands bool
+	// PodLogDir receives <pod UID>.log with the output of each pod's
+	// process; the output is discarded when empty.
+	PodLogDir string
+	// EvictionThresholds are the usage percentages at which the node is
+	// under pressure and evicts pods; DefaultThresholds for those left at
+	// zero. Usage is measured on the host, or on the file system holding
+	// PodLogDir for disk, unless the API server simulates it; nodes without
+	// RunPodCommands report no usage unless simulated.
+	EvictionThresholds Thresholds
+}
+
+// Run registers the node and sends heartbeats until ctx ends. It registers
+// the node again whenever the API server answers a heartbeat saying it does
+// not know the node.
+func Run(ctx context.Context, cfg Config) error {
+	if cfg.Name == "" || (cfg.APIServer == "" && cfg.Client == nil) {
+		return fmt.Errorf("a node name and an API server are required")
+	}
+	if cfg.HeartbeatJitter < 0 || cfg.HeartbeatJitter > 1 {
+		return fmt.Errorf("heartbeat jitter must be between 0 and 1")
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if cfg.CPUCores <= 0 {
+		cfg.CPUCores = runtime.NumCPU()
+	}
+	cfg.EvictionThresholds = cfg.EvictionThresholds.withDefaults()
+	out := cfg.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	c := cfg.Client
+	if c == nil {
+		// A heartbeat that fails is replaced by the next one, not retried.
+		c = client.New(cfg.APIServer,
+			client.WithBearerToken(cfg.Token),
+			client.WithHTTPClient(&http.Client{Transport: cfg.Transport}),
+			client.WithRetries(0))
+	}
+
+	pods := []string{}
+	registered := false
+	// simulated is the usage the API server last told the agent to act on.
+	var simulated *v1.NodeUsage
+	// evictions are kept until a heartbeat reports them.
+	evictions := make(map[string]string)
+	r := newRunner(cfg, out)
+	defer r.stopAll()
+	// Register right away, or after a random part of the interval when
+	// spreading agents out.
+	var wait time.Duration
+	if cfg.HeartbeatJitter > 0 {
+		wait = time.Duration(rand.Int63n(int64(cfg.HeartbeatInterval)) + 1)
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil
+		}
+		timer.Reset(jittered(cfg.HeartbeatInterval, cfg.HeartbeatJitter))
+		if !registered {
+			if err := register(ctx, c, &cfg, out); err != nil {
+				continue
+			}
+			registered = true
+		}
+		usage, usageErr := currentUsage(cfg, simulated)
+		if p, ok := underPressure(usage, cfg.EvictionThresholds); ok {
+			if podID, reason := r.evict(p); podID != "" {
+				evictions[podID] = reason
+			}
+		}
+		reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		res, err := c.Heartbeat(reqCtx, v1.HeartbeatRequest{
+			NodeID:     cfg.NodeID,
+			Status:     "Healthy",
+			Pods:       pods,
+			Processes:  r.statuses(),
+			Conditions: nodeConditions(usage, usageErr, cfg.EvictionThresholds),
+			Usage:      usage,
+			Evictions:  evictions,
+		})
+		cancel()
+		if cfg.OnHeartbeat != nil {
+			cfg.OnHeartbeat(err)
+		}
+		switch {
+		case client.IsNotRegistered(err):
+			fmt.Fprintf(out, "%s%s[!] %sAPI server does not know node %s; registering it again%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, cfg.Name, NC)
+			registered = register(ctx, c, &cfg, out) == nil
+			continue
+		case client.IsUnauthorized(err):
+			fmt.Fprintf(out, "%s%s[✗] %sHeartbeat rejected: node credentials not accepted%s\n", NEON_RED, BOLD, NEON_PINK, NC)
+			continue
+		case err != nil:
+			fmt.Fprintf(out, "%s%s[!] %sFailed to send heartbeat: %v%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, err, NC)
+			continue
+		}
+		clear(evictions)
+		simulated = res.SimulatedUsage
+		pods = res.Pods
+		r.sync(res.Workloads)
+		fmt.Fprintf(out, "%s%s[*] %sNode %s pods updated: %v%s\n", NEON_BLUE, BOLD, NEON_CYAN, cfg.NodeID, pods, NC)
+	}
+}
+
+// register declares cfg's node to the API server and records the ID it is
+// registered under in cfg.
+func register(ctx context.Context, c *client.Client, cfg *Config, out io.Writer) error {
+	reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	node, err := c.RegisterNode(reqCtx, v1.NodeRegistration{
+		NodeID:       cfg.NodeID,
+		Name:         cfg.Name,
+		CPUCores:     cfg.CPUCores,
+		Labels:       cfg.Labels,
+		AgentVersion: Version,
+	})
+	if cfg.OnRegister != nil {
+		cfg.OnRegister(err)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "%s%s[!] %sFailed to register node %s: %v%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, cfg.Name, err, NC)
+		return err
+	}
+	cfg.NodeID = node.Metadata.UID
+	fmt.Fprintf(out, "%s%s[✓] %sNode %s registered as %s with %d CPU cores%s\n", NEON_GREEN, BOLD, NEON_CYAN, cfg.Name, cfg.NodeID, cfg.CPUCores, NC)
+	return nil
+}
+
+// currentUsage is the usage the node acts on: the simulated usage when the
+// API server set one, none for stand-in pods, and otherwise the host's.
+func currentUsage(cfg Config, simulated *v1.NodeUsage) (*v1.NodeUsage, error) {
+	switch {
+	case simulated != nil:
+		return simulated, nil
+	case !cfg.RunPodCommands:
+		return &v1.NodeUsage{}, nil
+	}
+	return nodeUsage(cfg.PodLogDir)
+}
+
+// jittered lengthens interval by a random part of it, up to the fraction
+// jitter.
+func jittered(interval time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration(rand.Float64()*jitter*float64(interval))
+}
+
+// TLSTransport returns a transport presenting the client certificate in
+// certFile and trusting the CAs in caFile or the PEM caData, or nil for the
+// default transport when none is set.
+func TLSTransport(certFile, keyFile, caFile string, caData []byte) (http.RoundTripper, error) {
+	if certFile == "" && caFile == "" && len(caData) == 0 {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	} else if len(caData) > 0 {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificates found in the CA data")
+		}
+	}
+	return &http.Transport{TLSClientConfig: config}, nil
+}
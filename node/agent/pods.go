@@ -0,0 +1,228 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	v1 "example.com/m/api/v1"
+)
+
+// runner starts, watches and kills the processes of a node's pods.
+type runner struct {
+	// simulate runs every pod as a stand-in.
+	simulate bool
+	logDir   string
+	out      io.Writer
+
+	mu sync.Mutex
+	// procs is keyed by pod UID.
+	procs map[string]*podProcess
+}
+
+// podProcess is one run of a pod's workload. cmd is nil for a stand-in,
+// which has no process and runs until stopped.
+type podProcess struct {
+	workload v1.Workload
+	cmd      *exec.Cmd
+	// status is guarded by runner.mu.
+	status v1.ProcessStatus
+}
+
+func newRunner(cfg Config, out io.Writer) *runner {
+	return &runner{simulate: !cfg.RunPodCommands, logDir: cfg.PodLogDir, out: out, procs: make(map[string]*podProcess)}
+}
+
+// sync makes the running processes match workloads: processes of pods no
+// longer listed, or listed with a changed workload, are killed, and those
+// of new pods started. A process that exited stays as it is, to be
+// reported, until its workload changes.
+func (r *runner) sync(workloads map[string]v1.Workload) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for podID, p := range r.procs {
+		if w, ok := workloads[podID]; !ok || !sameWorkload(w, p.workload) {
+			r.kill(podID, p)
+			delete(r.procs, podID)
+		}
+	}
+	for podID, w := range workloads {
+		if _, running := r.procs[podID]; !running {
+			r.procs[podID] = r.start(podID, w)
+		}
+	}
+}
+
+// statuses reports every process, with the resource usage of running ones
+// read afresh.
+func (r *runner) statuses() map[string]v1.ProcessStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.procs) == 0 {
+		return nil
+	}
+	statuses := make(map[string]v1.ProcessStatus, len(r.procs))
+	for podID, p := range r.procs {
+		if p.cmd != nil && p.status.State == v1.ProcessRunning {
+			if cpu, memory, err := processUsage(p.status.PID); err == nil {
+				p.status.CPUSeconds, p.status.MemoryBytes = cpu, memory
+			}
+		}
+		statuses[podID] = p.status
+	}
+	return statuses
+}
+
+// evict kills the running process of the lowest-priority pod to relieve p,
+// and returns its pod UID and the reason, or "" when nothing runs. Among
+// pods of equal priority, memory pressure evicts the one using the most
+// memory and other pressure the one started last. The process is reported
+// as exited until the pod leaves the node.
+func (r *runner) evict(p pressure) (podID, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var victim *podProcess
+	for id, proc := range r.procs {
+		if proc.status.State != v1.ProcessRunning {
+			continue
+		}
+		if victim == nil || evictsBefore(proc, victim, p.memoryBased) {
+			podID, victim = id, proc
+		}
+	}
+	if victim == nil {
+		return "", ""
+	}
+	reason = fmt.Sprintf("%s; pod priority %d", p.evictMessage, victim.workload.Priority)
+	r.kill(podID, victim)
+	finished := time.Now()
+	victim.status.State = v1.ProcessExited
+	victim.status.FinishedAt = &finished
+	victim.status.ExitCode = -1
+	victim.status.Message = "Evicted: " + reason
+	fmt.Fprintf(r.out, "%s%s[!] %sEvicted pod %s: %s%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, podID, reason, NC)
+	return podID, reason
+}
+
+func evictsBefore(a, b *podProcess, memoryBased bool) bool {
+	if a.workload.Priority != b.workload.Priority {
+		return a.workload.Priority < b.workload.Priority
+	}
+	if memoryBased && a.status.MemoryBytes != b.status.MemoryBytes {
+		return a.status.MemoryBytes > b.status.MemoryBytes
+	}
+	return a.status.StartedAt.After(b.status.StartedAt)
+}
+
+// stopAll kills every process, when the agent stops.
+func (r *runner) stopAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for podID, p := range r.procs {
+		r.kill(podID, p)
+	}
+	r.procs = make(map[string]*podProcess)
+}
+
+// start runs w for the pod. A command that cannot be started is reported
+// as exited with code -1. Callers must hold r.mu.
+func (r *runner) start(podID string, w v1.Workload) *podProcess {
+	p := &podProcess{workload: w, status: v1.ProcessStatus{
+		State:        v1.ProcessRunning,
+		StartedAt:    time.Now(),
+		RestartCount: w.RestartCount,
+	}}
+	if r.simulate || len(w.Command) == 0 {
+		fmt.Fprintf(r.out, "%s%s[*] %sPod %s running as a stand-in%s\n", NEON_BLUE, BOLD, NEON_CYAN, podID, NC)
+		return p
+	}
+
+	cmd := exec.Command(w.Command[0], w.Command[1:]...)
+	cmd.Env = append(os.Environ(), "POD_UID="+podID)
+	for _, e := range w.Env {
+		cmd.Env = append(cmd.Env, e.Name+"="+e.Value)
+	}
+	var logFile *os.File
+	if r.logDir != "" {
+		var err error
+		if logFile, err = os.OpenFile(filepath.Join(r.logDir, podID+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+			return r.failed(podID, p, err)
+		}
+		cmd.Stdout, cmd.Stderr = logFile, logFile
+	}
+	prepareCommand(cmd)
+	if err := cmd.Start(); err != nil {
+		if logFile != nil {
+			logFile.Close()
+		}
+		return r.failed(podID, p, err)
+	}
+	p.cmd = cmd
+	p.status.PID = cmd.Process.Pid
+	fmt.Fprintf(r.out, "%s%s[✓] %sPod %s started: %v (pid %d)%s\n", NEON_GREEN, BOLD, NEON_CYAN, podID, w.Command, p.status.PID, NC)
+
+	go func() {
+		cmd.Wait()
+		if logFile != nil {
+			logFile.Close()
+		}
+		state := cmd.ProcessState
+		finished := time.Now()
+		r.mu.Lock()
+		p.status.State = v1.ProcessExited
+		p.status.FinishedAt = &finished
+		p.status.ExitCode = state.ExitCode()
+		p.status.CPUSeconds = (state.UserTime() + state.SystemTime()).Seconds()
+		if peak := peakMemory(state); peak > 0 {
+			p.status.MemoryBytes = peak
+		}
+		r.mu.Unlock()
+		fmt.Fprintf(r.out, "%s%s[*] %sPod %s exited: %s%s\n", NEON_BLUE, BOLD, NEON_CYAN, podID, state, NC)
+	}()
+	return p
+}
+
+// failed records that p's process could not be started.
+func (r *runner) failed(podID string, p *podProcess, err error) *podProcess {
+	finished := time.Now()
+	p.status.State = v1.ProcessExited
+	p.status.FinishedAt = &finished
+	p.status.ExitCode = -1
+	p.status.Message = fmt.Sprintf("starting %v: %v", p.workload.Command, err)
+	fmt.Fprintf(r.out, "%s%s[✗] %sPod %s: %s%s\n", NEON_RED, BOLD, NEON_PINK, podID, p.status.Message, NC)
+	return p
+}
+
+// kill stops p's process, if it still runs. It does not wait for it to
+// exit. Callers must hold r.mu.
+func (r *runner) kill(podID string, p *podProcess) {
+	if p.cmd == nil || p.status.State != v1.ProcessRunning {
+		return
+	}
+	if err := killProcess(p.cmd); err != nil {
+		fmt.Fprintf(r.out, "%s%s[!] %sKilling pod %s: %v%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, podID, err, NC)
+		return
+	}
+	fmt.Fprintf(r.out, "%s%s[*] %sPod %s killed%s\n", NEON_BLUE, BOLD, NEON_CYAN, podID, NC)
+}
+
+func sameWorkload(a, b v1.Workload) bool {
+	if a.RestartCount != b.RestartCount || len(a.Command) != len(b.Command) || len(a.Env) != len(b.Env) {
+		return false
+	}
+	for i := range a.Command {
+		if a.Command[i] != b.Command[i] {
+			return false
+		}
+	}
+	for i := range a.Env {
+		if a.Env[i] != b.Env[i] {
+			return false
+		}
+	}
+	return true
+}
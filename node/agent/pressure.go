@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	v1 "example.com/m/api/v1"
+)
+
+// Thresholds are the usage percentages at or above which a node is under
+// memory, disk or process ID pressure.
+type Thresholds struct {
+	MemoryPercent float64
+	DiskPercent   float64
+	PIDPercent    float64
+}
+
+// DefaultThresholds apply to the resources a Config leaves at zero.
+var DefaultThresholds = Thresholds{MemoryPercent: 90, DiskPercent: 90, PIDPercent: 90}
+
+// ParseThresholds parses thresholds written as "memory=90,disk=85,pids=90".
+// Resources left out keep their default.
+func ParseThresholds(s string) (Thresholds, error) {
+	t := DefaultThresholds
+	for _, field := range strings.Split(s, ",") {
+		if field == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return t, fmt.Errorf("%q is not key=value", field)
+		}
+		percent, err := strconv.ParseFloat(val, 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return t, fmt.Errorf("%s must be a percentage above 0 and at most 100, not %q", key, val)
+		}
+		switch key {
+		case "memory":
+			t.MemoryPercent = percent
+		case "disk":
+			t.DiskPercent = percent
+		case "pids":
+			t.PIDPercent = percent
+		default:
+			return t, fmt.Errorf("unknown resource %q; want memory, disk or pids", key)
+		}
+	}
+	return t, nil
+}
+
+func (t Thresholds) withDefaults() Thresholds {
+	if t.MemoryPercent <= 0 {
+		t.MemoryPercent = DefaultThresholds.MemoryPercent
+	}
+	if t.DiskPercent <= 0 {
+		t.DiskPercent = DefaultThresholds.DiskPercent
+	}
+	if t.PIDPercent <= 0 {
+		t.PIDPercent = DefaultThresholds.PIDPercent
+	}
+	return t
+}
+
+// pressure is one resource a node can run short of, with the reasons its
+// condition gives either way.
+type pressure struct {
+	condition    string
+	resource     string
+	trueReason   string
+	falseReason  string
+	usage        func(u *v1.NodeUsage) float64
+	threshold    func(t Thresholds) float64
+	memoryBased  bool
+	evictMessage string
+}
+
+var pressures = []pressure{
+	{
+		condition: v1.NodeMemoryPressure, resource: "Memory",
+		trueReason: "AgentHasInsufficientMemory", falseReason: "AgentHasSufficientMemory",
+		usage:       func(u *v1.NodeUsage) float64 { return u.MemoryPercent },
+		threshold:   func(t Thresholds) float64 { return t.MemoryPercent },
+		memoryBased: true, evictMessage: "the node was low on memory",
+	},
+	{
+		condition: v1.NodeDiskPressure, resource: "Disk",
+		trueReason: "AgentHasDiskPressure", falseReason: "AgentHasNoDiskPressure",
+		usage:        func(u *v1.NodeUsage) float64 { return u.DiskPercent },
+		threshold:    func(t Thresholds) float64 { return t.DiskPercent },
+		evictMessage: "the node was low on disk",
+	},
+	{
+		condition: v1.NodePIDPressure, resource: "PID",
+		trueReason: "AgentHasInsufficientPID", falseReason: "AgentHasSufficientPID",
+		usage:        func(u *v1.NodeUsage) float64 { return u.PIDPercent },
+		threshold:    func(t Thresholds) float64 { return t.PIDPercent },
+		evictMessage: "the node was low on process IDs",
+	},
+}
+
+// nodeConditions describes a node with the given usage. When the usage
+// could not be read, err says why and all but Ready are Unknown.
+func nodeConditions(usage *v1.NodeUsage, err error, t Thresholds) []v1.NodeCondition {
+	conditions := []v1.NodeCondition{{
+		Type: v1.NodeReady, Status: v1.ConditionTrue, Reason: "AgentReady", Message: "Agent is posting ready status",
+	}}
+	if err != nil {
+		for _, p := range pressures {
+			conditions = append(conditions, v1.NodeCondition{
+				Type: p.condition, Status: v1.ConditionUnknown, Reason: "UsageUnavailable", Message: err.Error(),
+			})
+		}
+		return append(conditions, v1.NodeCondition{
+			Type: v1.NodeNetworkUnavailable, Status: v1.ConditionUnknown, Reason: "UsageUnavailable", Message: err.Error(),
+		})
+	}
+	for _, p := range pressures {
+		used, threshold := p.usage(usage), p.threshold(t)
+		c := v1.NodeCondition{Type: p.condition, Status: v1.ConditionFalse, Reason: p.falseReason,
+			Message: fmt.Sprintf("%s usage %.1f%% is below the %g%% threshold", p.resource, used, threshold)}
+		if used >= threshold {
+			c.Status, c.Reason = v1.ConditionTrue, p.trueReason
+			c.Message = fmt.Sprintf("%s usage %.1f%% is at or above the %g%% threshold", p.resource, used, threshold)
+		}
+		conditions = append(conditions, c)
+	}
+	network := v1.NodeCondition{Type: v1.NodeNetworkUnavailable, Status: v1.ConditionFalse, Reason: "NetworkReady", Message: "A network interface is up"}
+	if usage.NetworkUnavailable {
+		network.Status, network.Reason, network.Message = v1.ConditionTrue, "NetworkDown", "No network interface is up"
+	}
+	return append(conditions, network)
+}
+
+// underPressure returns the first resource the node is short of under t, if
+// any.
+func underPressure(usage *v1.NodeUsage, t Thresholds) (pressure, bool) {
+	if usage == nil {
+		return pressure{}, false
+	}
+	for _, p := range pressures {
+		if p.usage(usage) >= p.threshold(t) {
+			return p, true
+		}
+	}
+	return pressure{}, false
+}
+
+// networkUp tells whether an interface other than loopback is up.
+func networkUp() (bool, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return false, err
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagLoopback == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	v1 "example.com/m/api/v1"
+)
+
+// clockTicks is the kernel's USER_HZ, the unit of CPU times in /proc; it is
+// 100 on every common Linux platform.
+const clockTicks = 100
+
+// prepareCommand puts the process in a process group of its own, so that
+// killing the pod kills its children too, and has it killed if the agent
+// dies first.
+func prepareCommand(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+}
+
+func killProcess(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// processUsage reads the CPU time and resident memory of a running process
+// from /proc.
+func processUsage(pid int) (cpuSeconds float64, memoryBytes int64, err error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+	// The command name in parentheses may hold spaces; fields follow it.
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	// utime, stime and rss are fields 14, 15 and 24 of the whole line.
+	if len(fields) < 22 {
+		return 0, 0, fmt.Errorf("short /proc/%d/stat", pid)
+	}
+	utime, _ := strconv.ParseInt(fields[11], 10, 64)
+	stime, _ := strconv.ParseInt(fields[12], 10, 64)
+	rss, _ := strconv.ParseInt(fields[21], 10, 64)
+	return float64(utime+stime) / clockTicks, rss * int64(os.Getpagesize()), nil
+}
+
+// peakMemory is the peak resident memory of an exited process.
+func peakMemory(state *os.ProcessState) int64 {
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		return usage.Maxrss * 1024
+	}
+	return 0
+}
+
+// nodeUsage measures the host's memory from /proc/meminfo, the file system
+// holding dir, or the root one when dir is empty, and the processes running
+// against the kernel's pid_max.
+func nodeUsage(dir string) (*v1.NodeUsage, error) {
+	usage := &v1.NodeUsage{}
+	meminfo, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	var total, available float64
+	for _, line := range strings.Split(string(meminfo), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total, _ = strconv.ParseFloat(fields[1], 64)
+		case "MemAvailable:":
+			available, _ = strconv.ParseFloat(fields[1], 64)
+		}
+	}
+	if total <= 0 {
+		return nil, fmt.Errorf("no MemTotal in /proc/meminfo")
+	}
+	usage.MemoryPercent = 100 * (total - available) / total
+
+	if dir == "" {
+		dir = "/"
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err != nil {
+		return nil, err
+	}
+	// Like df, count the blocks reserved for root as neither used nor free.
+	if used := float64(fs.Blocks - fs.Bfree); used+float64(fs.Bavail) > 0 {
+		usage.DiskPercent = 100 * used / (used + float64(fs.Bavail))
+	}
+
+	data, err := os.ReadFile("/proc/sys/kernel/pid_max")
+	if err != nil {
+		return nil, err
+	}
+	pidMax, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+	if err != nil || pidMax <= 0 {
+		return nil, fmt.Errorf("invalid /proc/sys/kernel/pid_max %q", data)
+	}
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	processes := 0
+	for _, e := range entries {
+		if _, err := strconv.Atoi(e.Name()); err == nil {
+			processes++
+		}
+	}
+	usage.PIDPercent = 100 * float64(processes) / pidMax
+
+	up, err := networkUp()
+	if err != nil {
+		return nil, err
+	}
+	usage.NetworkUnavailable = !up
+	return usage, nil
+}
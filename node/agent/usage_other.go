@@ -0,0 +1,32 @@
+//go:build !linux
+
+package agent
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+
+	v1 "example.com/m/api/v1"
+)
+
+func prepareCommand(cmd *exec.Cmd) {}
+
+func killProcess(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// processUsage is only implemented on Linux.
+func processUsage(pid int) (cpuSeconds float64, memoryBytes int64, err error) {
+	return 0, 0, errors.New("process usage is not available on this platform")
+}
+
+func peakMemory(state *os.ProcessState) int64 {
+	return 0
+}
+
+// nodeUsage is only implemented on Linux; nodes elsewhere report their
+// pressure conditions Unknown.
+func nodeUsage(dir string) (*v1.NodeUsage, error) {
+	return nil, errors.New("node usage is not available on this platform")
+}
@@ -0,0 +1,162 @@
+// Command hollow runs many node agents as goroutines of one process, for
+// loading an API server with thousands of nodes. With the credentials from
+// the CLI config it requests a node token for each agent, which registers
+// its node and heartbeats for it as that node, as the real agent would,
+// reusing nodes of the same name left from an earlier run. Run the API
+// server with -node-provider=none so that it launches no agents of its own.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	v1 "example.com/m/api/v1"
+	"example.com/m/client"
+	"example.com/m/node/agent"
+)
+
+func main() {
+	kubeconfig := flag.String("kubeconfig", "", "CLI config naming the API server and credentials; $KUBE_SIM_CONFIG or ~/.kube-sim/config when unset")
+	count := flag.Int("nodes", 100, "how many hollow nodes to run")
+	cpuCores := flag.Int("cpu", 4, "CPU cores of each hollow node")
+	prefix := flag.String("name-prefix", "hollow-node-", "hollow nodes are named <prefix><n>")
+	jitter := flag.Float64("heartbeat-jitter", 0.2, "spread each agent's heartbeats by up to this fraction of the interval, between 0 and 1")
+	deleteOnExit := flag.Bool("delete-on-exit", false, "delete the hollow nodes on SIGINT or SIGTERM")
+	flag.Parse()
+
+	fail := func(format string, args ...interface{}) {
+		fmt.Printf("%s%s[✗] %s%s%s\n", agent.NEON_RED, agent.BOLD, agent.NEON_PINK, fmt.Sprintf(format, args...), agent.NC)
+		os.Exit(1)
+	}
+	if *count <= 0 || *cpuCores <= 0 {
+		fail("-nodes and -cpu must be positive")
+	}
+	if *jitter < 0 || *jitter > 1 {
+		fail("-heartbeat-jitter must be between 0 and 1")
+	}
+	cfg, err := client.LoadConfig(*kubeconfig)
+	if err != nil {
+		fail("%v", err)
+	}
+	// Every agent goes through the default transport; keep enough idle
+	// connections for them to reuse between heartbeats.
+	http.DefaultTransport.(*http.Transport).MaxIdleConnsPerHost = 256
+	c, err := client.NewForConfig(cfg)
+	if err != nil {
+		fail("%v", err)
+	}
+	// A registration or heartbeat that fails is replaced by the next one,
+	// not retried. Agents authenticate with their node token alone, so they
+	// present no client certificate.
+	agentCfg := *cfg
+	agentCfg.Token, agentCfg.ClientCertificate, agentCfg.ClientKey = "", "", ""
+	agents, err := client.NewForConfig(&agentCfg, client.WithRetries(0))
+	if err != nil {
+		fail("%v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("%s%s[*] %sRegistering %d hollow nodes with %s%s\n", agent.NEON_BLUE, agent.BOLD, agent.NEON_CYAN, *count, cfg.Server, agent.NC)
+	start := time.Now()
+	names := make([]string, *count)
+	var registered, succeeded, failed atomic.Int64
+	ready := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range names {
+		names[i] = fmt.Sprintf("%s%05d", *prefix, i+1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, ok := nodeToken(ctx, c, names[i])
+			if !ok {
+				return
+			}
+			first := true
+			agent.Run(ctx, agent.Config{
+				NodeID:          token.Metadata.UID,
+				Name:            names[i],
+				CPUCores:        *cpuCores,
+				Labels:          map[string]string{"kube-sim.io/hollow": "true"},
+				Client:          agents.With(client.WithBearerToken(token.Status.Token)),
+				HeartbeatJitter: *jitter,
+				Out:             io.Discard,
+				OnRegister: func(err error) {
+					if err == nil && first {
+						first = false
+						if registered.Add(1) == int64(len(names)) {
+							close(ready)
+						}
+					}
+				},
+				OnHeartbeat: func(err error) {
+					if err != nil {
+						failed.Add(1)
+					} else {
+						succeeded.Add(1)
+					}
+				},
+			})
+		}()
+	}
+
+	const reportEvery = 10 * time.Second
+	ticker := time.NewTicker(reportEvery)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case <-ready:
+			fmt.Printf("%s%s[✓] %s%d hollow nodes registered in %s%s\n", agent.NEON_GREEN, agent.BOLD, agent.NEON_CYAN, len(names), time.Since(start).Round(time.Millisecond), agent.NC)
+			ready = nil
+		case <-ticker.C:
+			ok, bad := succeeded.Swap(0), failed.Swap(0)
+			color := agent.NEON_BLUE
+			if bad > 0 {
+				color = agent.NEON_YELLOW
+			}
+			fmt.Printf("%s%s[*] %s%d of %d nodes registered: %d heartbeats sent, %d failed in the last %s%s\n",
+				color, agent.BOLD, agent.NEON_CYAN, registered.Load(), len(names), ok, bad, reportEvery, agent.NC)
+		case <-ctx.Done():
+			running = false
+		}
+	}
+	wg.Wait()
+
+	if *deleteOnExit {
+		deleted := 0
+		for _, name := range names {
+			delCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := c.Nodes().Delete(delCtx, name); err == nil || client.IsNotFound(err) {
+				deleted++
+			}
+			cancel()
+		}
+		fmt.Printf("%s%s[✓] %sDeleted %d hollow nodes%s\n", agent.NEON_GREEN, agent.BOLD, agent.NEON_CYAN, deleted, agent.NC)
+	}
+}
+
+// nodeToken requests the token of the node name until the server issues it,
+// so that each agent is a client of its own, or ctx is done.
+func nodeToken(ctx context.Context, c *client.Client, name string) (*v1.TokenRequest, bool) {
+	for {
+		token, err := c.CreateNodeToken(ctx, name)
+		if err == nil {
+			return token, true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-time.After(time.Second):
+		}
+	}
+}
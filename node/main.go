@@ -1,60 +1,101 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"net/http"
 	"os"
-	"time"
-)
+	"strconv"
+	"strings"
 
-const (
-	NEON_PINK   = "\033[38;5;198m"
-	NEON_BLUE   = "\033[38;5;51m"
-	NEON_GREEN  = "\033[38;5;46m"
-	NEON_YELLOW = "\033[38;5;226m"
-	NEON_CYAN   = "\033[38;5;87m"
-	NEON_RED    = "\033[38;5;196m"
-	NEON_ORANGE = "\033[38;5;214m"
-	BOLD        = "\033[1m"
-	NC          = "\033[0m"
+	"example.com/m/node/agent"
 )
 
 func main() {
-	nodeID := os.Getenv("NODE_ID")
 	apiServer := os.Getenv("API_SERVER")
-	if nodeID == "" || apiServer == "" {
-		fmt.Printf("%s%s[✗] %sNODE_ID and API_SERVER environment variables must be set%s\n", NEON_RED, BOLD, NEON_PINK, NC)
+	if apiServer == "" {
+		fmt.Printf("%s%s[✗] %sAPI_SERVER environment variable must be set%s\n", agent.NEON_RED, agent.BOLD, agent.NEON_PINK, agent.NC)
+		os.Exit(1)
+	}
+	fail := func(format string, args ...interface{}) {
+		fmt.Printf("%s%s[✗] %s%s%s\n", agent.NEON_RED, agent.BOLD, agent.NEON_PINK, fmt.Sprintf(format, args...), agent.NC)
 		os.Exit(1)
 	}
 
-	pods := []string{}
-	client := &http.Client{Timeout: 10 * time.Second}
-
-	for {
-		time.Sleep(5 * time.Second)
-		hb := map[string]interface{}{
-			"nodeID": nodeID,
-			"status": "Healthy",
-			"pods":   pods,
+	// The node registers under NODE_NAME, the host name by default, with
+	// the capacity and labels it declares. An agent the API server launched
+	// is also told the ID of the node it was created for.
+	name := os.Getenv("NODE_NAME")
+	if name == "" {
+		var err error
+		if name, err = os.Hostname(); err != nil {
+			fail("NODE_NAME is not set and the host name is unknown: %v", err)
 		}
-		jsonData, _ := json.Marshal(hb)
-		resp, err := client.Post(apiServer+"/heartbeat", "application/json", bytes.NewBuffer(jsonData))
-		if err != nil {
-			fmt.Printf("%s%s[!] %sFailed to send heartbeat: %v%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, err, NC)
+	}
+	var cpuCores int
+	if v := os.Getenv("NODE_CPU_CORES"); v != "" {
+		var err error
+		if cpuCores, err = strconv.Atoi(v); err != nil || cpuCores <= 0 {
+			fail("Invalid NODE_CPU_CORES %q", v)
+		}
+	}
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv("NODE_LABELS"), ",") {
+		if pair == "" {
 			continue
 		}
-		defer resp.Body.Close()
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || k == "" {
+			fail("Invalid NODE_LABELS entry %q, expected key=value", pair)
+		}
+		labels[k] = v
+	}
 
-		var res struct {
-			Pods []string `json:"pods"`
+	// The node authenticates as itself with the token the API server issued
+	// it, or with a client certificate when NODE_CERT_FILE is set. It
+	// verifies an HTTPS server with the CA bundle the server passed in
+	// API_CA_DATA, or the one in API_CA_FILE.
+	transport, err := agent.TLSTransport(os.Getenv("NODE_CERT_FILE"), os.Getenv("NODE_KEY_FILE"), os.Getenv("API_CA_FILE"), []byte(os.Getenv("API_CA_DATA")))
+	if err != nil {
+		fail("Loading TLS credentials: %v", err)
+	}
+
+	var jitter float64
+	if v := os.Getenv("HEARTBEAT_JITTER"); v != "" {
+		if jitter, err = strconv.ParseFloat(v, 64); err != nil {
+			fail("Invalid HEARTBEAT_JITTER %q", v)
 		}
-		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
-			fmt.Printf("%s%s[!] %sFailed to decode heartbeat response: %v%s\n", NEON_YELLOW, BOLD, NEON_ORANGE, err, NC)
-			continue
+	}
+
+	// EVICTION_THRESHOLDS overrides the usage percentages at which the node
+	// is under pressure, e.g. "memory=85,disk=95,pids=90".
+	thresholds, err := agent.ParseThresholds(os.Getenv("EVICTION_THRESHOLDS"))
+	if err != nil {
+		fail("Invalid EVICTION_THRESHOLDS: %v", err)
+	}
+
+	// ALLOW_POD_COMMANDS=true runs each pod's command on this host; pods
+	// are stand-ins otherwise.
+	var runPodCommands bool
+	if v := os.Getenv("ALLOW_POD_COMMANDS"); v != "" {
+		if runPodCommands, err = strconv.ParseBool(v); err != nil {
+			fail("Invalid ALLOW_POD_COMMANDS %q", v)
 		}
-		pods = res.Pods
-		fmt.Printf("%s%s[*] %sNode %s pods updated: %v%s\n", NEON_BLUE, BOLD, NEON_CYAN, nodeID, pods, NC)
+	}
+
+	err = agent.Run(context.Background(), agent.Config{
+		NodeID:             os.Getenv("NODE_ID"),
+		Name:               name,
+		CPUCores:           cpuCores,
+		Labels:             labels,
+		APIServer:          apiServer,
+		Token:              os.Getenv("NODE_TOKEN"),
+		Transport:          transport,
+		HeartbeatJitter:    jitter,
+		RunPodCommands:     runPodCommands,
+		PodLogDir:          os.Getenv("POD_LOG_DIR"),
+		EvictionThresholds: thresholds,
+	})
+	if err != nil {
+		fail("%v", err)
 	}
 }
@@ -253,6 +253,7 @@ cleanup() {
     docker ps -a --filter "name=node-" -q | xargs -r docker stop
     docker ps -a --filter "name=node-" -q | xargs -r docker rm
     pkill -f "./api-server" || true
+    [ -n "$TEST_DIR" ] && rm -rf "$TEST_DIR"
     print_success "Cleanup completed"
 }
 
@@ -371,7 +372,12 @@ kill_existing_api
 print_status "Launching API server..."
 cd "$PROJECT_ROOT/api-server" || { print_error "Failed to change to api-server directory"; exit 1; }
 go build -o api-server || { print_error "Failed to build api-server"; exit 1; }
-./api-server &
+TEST_DIR=$(mktemp -d)
+ADMIN_TOKEN="system-test-admin-token"
+VIEWER_TOKEN="system-test-viewer-token"
+echo "$ADMIN_TOKEN,admin,1,system:masters" > "$TEST_DIR/tokens.csv"
+echo "$VIEWER_TOKEN,viewer,2" >> "$TEST_DIR/tokens.csv"
+./api-server -token-auth-file "$TEST_DIR/tokens.csv" &
 API_PID=$!
 
 for i in {1..10}; do
@@ -499,6 +505,110 @@ else
 fi
 print_divider
 
+print_gradient_box "AUTHENTICATION TEST"
+print_status "Checking static and signed tokens..."
+AUTH_RESULT=0
+WHOAMI=$(curl -s -H "Authorization: Bearer $ADMIN_TOKEN" http://localhost:8080/api/v1/whoami | jq -r '.username')
+[ "$WHOAMI" = "admin" ] || { print_error "Static token authenticated as '$WHOAMI'"; AUTH_RESULT=1; }
+SA_TOKEN=$(curl -s -X POST -H "Authorization: Bearer $ADMIN_TOKEN" -H "Content-Type: application/json" \
+    -d '{"spec":{"expirationSeconds":600}}' \
+    http://localhost:8080/api/v1/namespaces/default/serviceaccounts/system-test/token | jq -r '.status.token')
+WHOAMI=$(curl -s -H "Authorization: Bearer $SA_TOKEN" http://localhost:8080/api/v1/whoami | jq -r '.username')
+[ "$WHOAMI" = "system:serviceaccount:default:system-test" ] || { print_error "Service account token authenticated as '$WHOAMI'"; AUTH_RESULT=1; }
+STATUS=$(curl -s -o /dev/null -w "%{http_code}" -H "Authorization: Bearer ${SA_TOKEN}x" http://localhost:8080/api/v1/whoami)
+[ "$STATUS" = "401" ] || { print_error "Tampered token answered $STATUS"; AUTH_RESULT=1; }
+STATUS=$(curl -s -o /dev/null -w "%{http_code}" -X POST http://localhost:8080/api/v1/namespaces/default/serviceaccounts/system-test/token)
+[ "$STATUS" = "403" ] || { print_error "Anonymous token request answered $STATUS"; AUTH_RESULT=1; }
+print_result $AUTH_RESULT "Token authentication"
+print_divider
+
+print_gradient_box "AUTHORIZATION TEST"
+print_status "Launching a second API server with RBAC..."
+cd "$PROJECT_ROOT/api-server" || { print_error "Failed to change to api-server directory"; exit 1; }
+./api-server -bind-address :8081 -node-provider none -authorization-mode RBAC -anonymous-auth=false \
+    -token-auth-file "$TEST_DIR/tokens.csv" > "$TEST_DIR/rbac-server.log" 2>&1 &
+RBAC_PID=$!
+for i in {1..20}; do
+    curl -s http://localhost:8081/health > /dev/null && break
+    sleep 1
+done
+rbac_call() {
+    local token=$1 method=$2 path=$3 body=$4
+    curl -s -o /dev/null -w "%{http_code}" -X "$method" -H "Authorization: Bearer $token" \
+        -H "Content-Type: application/json" ${body:+-d "$body"} "http://localhost:8081$path"
+}
+RBAC_RESULT=0
+expect_status() {
+    local want=$1 got=$2 what=$3
+    if [ "$got" != "$want" ]; then
+        print_error "$what answered $got, want $want"
+        RBAC_RESULT=1
+    fi
+}
+expect_status 401 "$(rbac_call "" GET /api/v1/namespaces/default/pods)" "Anonymous pod list"
+expect_status 403 "$(rbac_call "$VIEWER_TOKEN" GET /api/v1/namespaces/default/pods)" "Unbound pod list"
+expect_status 201 "$(rbac_call "$ADMIN_TOKEN" POST /api/v1/namespaces/default/rolebindings \
+    '{"metadata":{"name":"viewer-view"},"subjects":[{"kind":"User","name":"viewer"}],"roleRef":{"kind":"ClusterRole","name":"view"}}')" "Admin role binding"
+expect_status 200 "$(rbac_call "$VIEWER_TOKEN" GET /api/v1/namespaces/default/pods)" "Bound pod list"
+expect_status 403 "$(rbac_call "$VIEWER_TOKEN" POST /api/v1/namespaces/default/pods '{"spec":{"cpuRequired":1}}')" "Viewer pod creation"
+expect_status 403 "$(rbac_call "$VIEWER_TOKEN" POST /api/v1/namespaces/default/rolebindings \
+    '{"metadata":{"name":"viewer-root"},"subjects":[{"kind":"User","name":"viewer"}],"roleRef":{"kind":"ClusterRole","name":"cluster-admin"}}')" "Viewer escalation"
+kill $RBAC_PID 2>/dev/null
+wait $RBAC_PID 2>/dev/null
+cd "$PROJECT_ROOT/cli" || { print_error "Failed to change to cli directory"; exit 1; }
+print_result $RBAC_RESULT "RBAC authorization"
+print_divider
+
+print_gradient_box "LEASE TEST"
+print_status "Taking over a lease with a stale version..."
+LEASE_RESULT=0
+lease_call() {
+    local method=$1 path=$2 body=$3
+    curl -s -X "$method" -H "Authorization: Bearer $ADMIN_TOKEN" -H "Content-Type: application/json" \
+        ${body:+-d "$body"} "http://localhost:8080/api/v1/namespaces/default/leases$path"
+}
+LEASE_RV=$(lease_call POST "" '{"metadata":{"name":"system-test"},"spec":{"holderIdentity":"first","leaseDurationSeconds":15}}' | jq -r '.metadata.resourceVersion')
+RENEWED=$(lease_call PUT /system-test "{\"metadata\":{\"resourceVersion\":$LEASE_RV},\"spec\":{\"holderIdentity\":\"first\",\"leaseDurationSeconds\":15}}")
+[ "$(jq -r '.spec.holderIdentity' <<< "$RENEWED")" = "first" ] || { print_error "Renewal failed: $RENEWED"; LEASE_RESULT=1; }
+STALE=$(lease_call PUT /system-test "{\"metadata\":{\"resourceVersion\":$LEASE_RV},\"spec\":{\"holderIdentity\":\"second\",\"leaseDurationSeconds\":15}}")
+[ "$(jq -r '.reason' <<< "$STALE")" = "Conflict" ] || { print_error "Stale takeover answered $STALE"; LEASE_RESULT=1; }
+HOLDER=$(lease_call GET /system-test | jq -r '.spec.holderIdentity')
+[ "$HOLDER" = "first" ] || { print_error "Lease held by '$HOLDER'"; LEASE_RESULT=1; }
+lease_call DELETE /system-test > /dev/null
+print_result $LEASE_RESULT "Lease optimistic concurrency"
+print_divider
+
+print_gradient_box "METRICS TEST"
+print_status "Scraping /metrics..."
+METRICS_RESULT=0
+METRICS=$(curl -s -H "Authorization: Bearer $ADMIN_TOKEN" http://localhost:8080/metrics)
+for series in 'kubesim_apiserver_requests_total{handler="/api/v1/whoami",verb="GET",code="200"}' \
+    'kubesim_scheduler_attempts_total{algorithm="first-fit",result="scheduled"}' \
+    'kubesim_node_heartbeat_interval_seconds_count' \
+    'kubesim_nodes{status="Healthy"}'; do
+    grep -qF "$series" <<< "$METRICS" || { print_error "Missing $series"; METRICS_RESULT=1; }
+done
+print_result $METRICS_RESULT "Prometheus metrics"
+print_divider
+
+print_gradient_box "BACKUP AND RESTORE TEST"
+print_status "Saving a snapshot, changing the cluster and restoring it..."
+BACKUP_RESULT=0
+scheduler_algorithm() {
+    curl -s -H "Authorization: Bearer $ADMIN_TOKEN" http://localhost:8080/api/v1/scheduler | jq -r '.algorithm'
+}
+BACKUP_ALGORITHM=$(scheduler_algorithm)
+./cli backup "$TEST_DIR/backup.json" || BACKUP_RESULT=1
+BACKUP_PODS=$(jq '[.objects[] | select(.kind == "Pod")] | length' "$TEST_DIR/backup.json")
+if [ "$BACKUP_ALGORITHM" = "round-robin" ]; then OTHER_ALGORITHM=best-fit; else OTHER_ALGORITHM=round-robin; fi
+./cli set-scheduler "$OTHER_ALGORITHM" > /dev/null
+./cli restore "$TEST_DIR/backup.json" || BACKUP_RESULT=1
+[ "$(scheduler_algorithm)" = "$BACKUP_ALGORITHM" ] || { print_error "Scheduler is $(scheduler_algorithm) after the restore, want $BACKUP_ALGORITHM"; BACKUP_RESULT=1; }
+RESTORED_PODS=$(./cli backup - | jq '[.objects[] | select(.kind == "Pod")] | length')
+[ "$RESTORED_PODS" = "$BACKUP_PODS" ] || { print_error "$RESTORED_PODS pods after the restore, want $BACKUP_PODS"; BACKUP_RESULT=1; }
+print_result $BACKUP_RESULT "Backup and restore"
+print_divider
+
 print_gradient_box "NODE AND POD DELETION TEST"
 print_gradient_box " TESTING RESOURCE CLEANUP "
 
@@ -529,6 +639,11 @@ print_result $LIST_PODS_RESULT "Pod status retrieval"
 print_result $NODE_FAILURE_RESULT "Node failure simulation"
 print_result $NODE_STOP_RESULT "Node stop operation"
 print_result $NODE_RESTART_RESULT "Node restart operation"
+print_result $AUTH_RESULT "Token authentication"
+print_result $RBAC_RESULT "RBAC authorization"
+print_result $LEASE_RESULT "Lease optimistic concurrency"
+print_result $METRICS_RESULT "Prometheus metrics"
+print_result $BACKUP_RESULT "Backup and restore"
 
 print_gradient_box " CLEANUP SEQUENCE "
 echo